@@ -0,0 +1,63 @@
+package sshego
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh/agent"
+)
+
+// WithSSHAgentSocket returns a copy of dc that logs in
+// with the keys held by the ssh-agent listening on
+// socketPath, so no private key file is needed. An
+// empty socketPath means $SSH_AUTH_SOCK.
+//
+// If the agent cannot be reached, or holds no keys,
+// we fall back to the key file at dc.RsaPath: the copy
+// is returned unchanged, without error. Only when there
+// is no RsaPath to fall back to do we return the error.
+//
+// The agent connection is kept open, as the agent
+// signs for us again on every reconnect. A Tricorder
+// made from the copy closes it when the Tricorder
+// halts; after a plain Dial, call CloseAgent once the
+// session is over.
+func (dc *DialConfig) WithSSHAgentSocket(socketPath string) (*DialConfig, error) {
+	c := *dc
+	if socketPath == "" {
+		socketPath = os.Getenv("SSH_AUTH_SOCK")
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return c.agentFallback(fmt.Errorf("WithSSHAgentSocket: could not reach ssh-agent at '%s': '%v'", socketPath, err))
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err == nil && len(signers) == 0 {
+		err = fmt.Errorf("no keys")
+	}
+	if err != nil {
+		conn.Close()
+		return c.agentFallback(fmt.Errorf("WithSSHAgentSocket: no signers from ssh-agent at '%s': '%v'", socketPath, err))
+	}
+	c.AgentSigners = signers
+	c.agentConn = conn
+	return &c, nil
+}
+
+// CloseAgent closes the ssh-agent connection opened by
+// WithSSHAgentSocket, if any. AgentSigners can no
+// longer sign afterwards.
+func (dc *DialConfig) CloseAgent() error {
+	if dc.agentConn == nil {
+		return nil
+	}
+	return dc.agentConn.Close()
+}
+
+func (dc *DialConfig) agentFallback(err error) (*DialConfig, error) {
+	if dc.RsaPath == "" {
+		return nil, err
+	}
+	return dc, nil
+}
@@ -0,0 +1,109 @@
+package sshego
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh/agent"
+)
+
+// startTestAgent serves keyring on a unix socket in dir,
+// as ssh-agent would, and returns the socket's path.
+func startTestAgent(halt *ssh.Halter, dir string, keyring agent.Agent) string {
+	path := filepath.Join(dir, "agent.sock")
+	lsn, err := net.Listen("unix", path)
+	panicOn(err)
+	go func() {
+		<-halt.ReqStopChan()
+		lsn.Close()
+	}()
+	go func() {
+		for {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return path
+}
+
+func Test094DialConfigWithSSHAgentSocket(t *testing.T) {
+	cv.Convey("DialConfig.WithSSHAgentSocket should let us log in with a key held only by an ssh-agent, and fall back to RsaPath when the agent is unreachable.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		pemBytes, err := ioutil.ReadFile(s.RsaPath)
+		panicOn(err)
+		key, err := ssh.ParseRawPrivateKey(pemBytes)
+		panicOn(err)
+		keyring := agent.NewKeyring()
+		panicOn(keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+		agentHalt := ssh.NewHalter()
+		defer agentHalt.RequestStop()
+		sock := startTestAgent(agentHalt, s.SrvCfg.Tempdir, keyring)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test094",
+		}
+
+		// without the key, the login is refused.
+		_, _, _, err = dc.Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		// no RsaPath, so nothing to fall back to.
+		_, err = dc.WithSSHAgentSocket(filepath.Join(s.SrvCfg.Tempdir, "no-such.sock"))
+		cv.So(err, cv.ShouldNotBeNil)
+
+		adc, err := dc.WithSSHAgentSocket(sock)
+		panicOn(err)
+		cv.So(adc.RsaPath, cv.ShouldEqual, "")
+		cv.So(len(adc.AgentSigners), cv.ShouldEqual, 1)
+
+		tri, err := NewTricorder(adc, s.CliCfg.Halt, "test094")
+		panicOn(err)
+		_, err = tri.Cli()
+		cv.So(err, cv.ShouldBeNil)
+		tri.Halt.RequestStop()
+
+		// the Tricorder closes the agent connection on halt.
+		<-tri.Halt.DoneChan()
+		_, err = adc.agentConn.Write([]byte{0})
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(adc.CloseAgent(), cv.ShouldNotBeNil)
+
+		// unreachable agent: fall back to the key file.
+		fdc := *dc
+		fdc.RsaPath = s.RsaPath
+		rdc, err := fdc.WithSSHAgentSocket(filepath.Join(s.SrvCfg.Tempdir, "no-such.sock"))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(rdc.RsaPath, cv.ShouldEqual, s.RsaPath)
+		cv.So(len(rdc.AgentSigners), cv.ShouldEqual, 0)
+		tri2, err := NewTricorder(rdc, s.CliCfg.Halt, "test094b")
+		panicOn(err)
+		_, err = tri2.Cli()
+		cv.So(err, cv.ShouldBeNil)
+		tri2.Halt.RequestStop()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
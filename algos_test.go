@@ -0,0 +1,206 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test076CipherPreference(t *testing.T) {
+	cv.Convey("DialConfig.Ciphers should reject unknown ciphers, and we should connect only when a cipher the sshd accepts is listed.", t, func() {
+
+		_, err := (&DialConfig{Ciphers: []string{"chacha20-poly1305@openssh.com"}}).DeriveNewConfig()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "chacha20-poly1305@openssh.com")
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		// the embedded sshd accepts only getCiphers().
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		newDC := func(ciphers ...string) *DialConfig {
+			return &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+				TofuAddIfNotKnown:    true,
+				Ciphers:              ciphers,
+				LocalNickname:        "test076",
+			}
+		}
+
+		tri, err := NewTricorder(newDC("aes256-ctr", "aes128-gcm@openssh.com"), s.CliCfg.Halt, "test076")
+		panicOn(err)
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		_, _, _, err = newDC("aes256-ctr").Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "no common algorithm")
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test077NegotiatedAlgorithms(t *testing.T) {
+	cv.Convey("Client.NegotiatedAlgorithms should report what the key exchange settled on, given what each side offered.", t, func() {
+
+		s := MakeTestSshClientAndServer(false)
+		// the esshd only allows compression when told to.
+		s.SrvCfg.CompressionLevel = 1
+		s.SrvCfg.Esshd.Start(context.Background())
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			CompressionLevel:     1,
+			LocalNickname:        "test077",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test077")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+
+		algs := cli.NegotiatedAlgorithms()
+		pp("Test077: algs = %#v", algs)
+
+		// the embedded sshd offers only this kex and getCiphers().
+		cv.So(algs.KeyExchange, cv.ShouldEqual, kexAlgoCurve25519SHA256)
+		cv.So(algs.HostKey, cv.ShouldEqual, "ssh-rsa")
+		cv.So(algs.CipherClientServer, cv.ShouldEqual, getCiphers()[0])
+		cv.So(algs.CipherServerClient, cv.ShouldEqual, getCiphers()[0])
+		cv.So(algs.MACClientServer, cv.ShouldNotBeEmpty)
+		cv.So(algs.MACServerClient, cv.ShouldEqual, algs.MACClientServer)
+		cv.So(algs.CompressionClientServer, cv.ShouldEqual, "zlib@openssh.com")
+		cv.So(algs.CompressionServerClient, cv.ShouldEqual, "zlib@openssh.com")
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test078MACPreference(t *testing.T) {
+	cv.Convey("DialConfig.MACs should reject unknown MACs, and against an sshd requiring hmac-sha2-256-etm@openssh.com we should connect only when it is listed.", t, func() {
+
+		_, err := (&DialConfig{MACs: []string{"hmac-md5"}}).DeriveNewConfig()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "hmac-md5")
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, func(c *ssh.ServerConfig) {
+			c.MACs = []string{"hmac-sha2-256-etm@openssh.com"}
+		})
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		newDC := func(macs ...string) *DialConfig {
+			return &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				Sshdhost:             sshdHost,
+				Sshdport:             sshdPort,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				MACs:                 macs,
+				LocalNickname:        "test078",
+			}
+		}
+
+		_, _, _, err = newDC("hmac-sha1", "hmac-sha1-96").Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "no common algorithm")
+
+		tri, err := NewTricorder(newDC("hmac-sha1", "hmac-sha2-256-etm@openssh.com"), s.CliCfg.Halt, "test078")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli.NegotiatedAlgorithms().MACClientServer, cv.ShouldEqual, "hmac-sha2-256-etm@openssh.com")
+
+		tri.Halt.RequestStop()
+	})
+}
+
+func Test080KexPreference(t *testing.T) {
+	cv.Convey("DialConfig.KexAlgorithms should reject unknown key exchanges, and against an sshd that refuses diffie-hellman-group1-sha1 we should connect when only ModernKexAlgorithms are offered.", t, func() {
+
+		_, err := (&DialConfig{KexAlgorithms: []string{"diffie-hellman-group-exchange-md5"}}).DeriveNewConfig()
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "diffie-hellman-group-exchange-md5")
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, func(c *ssh.ServerConfig) {
+			c.KeyExchanges = []string{KexECDHP256, KexCurve25519SHA256}
+		})
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		newDC := func(kex ...string) *DialConfig {
+			return &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				Sshdhost:             sshdHost,
+				Sshdport:             sshdPort,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				KexAlgorithms:        kex,
+				LocalNickname:        "test080",
+			}
+		}
+
+		_, _, _, err = newDC(KexDHGroup1SHA1).Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "no common algorithm")
+
+		tri, err := NewTricorder(newDC(ModernKexAlgorithms...), s.CliCfg.Halt, "test080")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli.NegotiatedAlgorithms().KeyExchange, cv.ShouldEqual, KexCurve25519SHA256)
+
+		tri.Halt.RequestStop()
+	})
+}
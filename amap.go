@@ -1,7 +1,9 @@
 package sshego
 
 import (
+	"container/list"
 	"fmt"
+	"reflect"
 	"sync"
 )
 
@@ -12,6 +14,17 @@ import (
 type AtomicUserMap struct {
 	U   map[string]*User
 	tex sync.RWMutex
+
+	// see EnableJournal. Guarded by tex.
+	journaling bool
+	journal    []UserMapOp
+
+	// see NewAtomicUserMapLRU. capacity is fixed at
+	// construction; the rest is guarded by tex.
+	capacity int
+	lru      *list.List // of keys, most recently used first.
+	lruElem  map[string]*list.Element
+	onEvict  func(key string, val *User)
 }
 
 func NewAtomicUserMap() *AtomicUserMap {
@@ -21,14 +34,20 @@ func NewAtomicUserMap() *AtomicUserMap {
 }
 
 func (m *AtomicUserMap) Get(key string) *User {
-	m.tex.RLock()
-	defer m.tex.RUnlock()
-	return m.U[key]
+	v, _ := m.Get2(key)
+	return v
 }
 
 func (m *AtomicUserMap) Get2(key string) (*User, bool) {
-	m.tex.RLock()
-	defer m.tex.RUnlock()
+	if m.capacity > 0 {
+		// a hit reorders the LRU list.
+		m.tex.Lock()
+		defer m.tex.Unlock()
+		m.touch(key)
+	} else {
+		m.tex.RLock()
+		defer m.tex.RUnlock()
+	}
 	v, ok := m.U[key]
 	return v, ok
 }
@@ -36,13 +55,116 @@ func (m *AtomicUserMap) Get2(key string) (*User, bool) {
 func (m *AtomicUserMap) Set(key string, val *User) {
 	m.tex.Lock()
 	defer m.tex.Unlock()
+	_, had := m.U[key]
 	m.U[key] = val
+	m.record(UserMapSet, key, val)
+	m.stored(key, had)
+}
+
+// Compute atomically replaces the value under key with
+// fn(existing, found), holding the write lock throughout,
+// and returns the new value. If fn returns nil, key is
+// deleted. fn must not call back into m.
+func (m *AtomicUserMap) Compute(key string, fn func(existing *User, found bool) *User) *User {
+	m.tex.Lock()
+	defer m.tex.Unlock()
+	existing, found := m.U[key]
+	v := fn(existing, found)
+	if v == nil {
+		if found {
+			delete(m.U, key)
+			m.forget(key)
+			m.record(UserMapDel, key, nil)
+		}
+	} else {
+		m.U[key] = v
+		m.record(UserMapSet, key, v)
+		m.stored(key, found)
+	}
+	return v
+}
+
+// GetOrCreate returns the value under key if there is
+// one. Otherwise it stores and returns factory(). The
+// write lock is held throughout, so factory is called at
+// most once per missing key; factory must not call any
+// AtomicUserMap methods.
+func (m *AtomicUserMap) GetOrCreate(key string, factory func() *User) *User {
+	m.tex.Lock()
+	defer m.tex.Unlock()
+	if v, ok := m.U[key]; ok {
+		m.touch(key)
+		return v
+	}
+	v := factory()
+	m.U[key] = v
+	m.record(UserMapSet, key, v)
+	m.stored(key, false)
+	return v
+}
+
+// IncrCounter atomically adds delta to the int64 field
+// named field of the user under key, and returns the
+// new value. It is an error if there is no such user,
+// or the User has no exported int64 field of that name.
+func (m *AtomicUserMap) IncrCounter(key, field string, delta int64) (int64, error) {
+	m.tex.Lock()
+	defer m.tex.Unlock()
+	u, ok := m.U[key]
+	if !ok || u == nil {
+		return 0, fmt.Errorf("AtomicUserMap.IncrCounter: no user '%s'", key)
+	}
+	f := reflect.ValueOf(u).Elem().FieldByName(field)
+	if !f.IsValid() || !f.CanSet() || f.Kind() != reflect.Int64 {
+		return 0, fmt.Errorf("AtomicUserMap.IncrCounter: User has no int64 field '%s'", field)
+	}
+	f.SetInt(f.Int() + delta)
+	m.touch(key)
+	return f.Int(), nil
 }
 
 func (m *AtomicUserMap) Del(key string) {
 	m.tex.Lock()
 	defer m.tex.Unlock()
 	delete(m.U, key)
+	m.forget(key)
+	m.record(UserMapDel, key, nil)
+}
+
+// Len returns the number of users in m.
+func (m *AtomicUserMap) Len() int {
+	m.tex.RLock()
+	defer m.tex.RUnlock()
+	return len(m.U)
+}
+
+// Range calls fn on each key and value in m, in no
+// particular order, until fn returns false. The read
+// lock is held throughout; fn must not modify m.
+func (m *AtomicUserMap) Range(fn func(key string, val *User) bool) {
+	m.tex.RLock()
+	defer m.tex.RUnlock()
+	for k, v := range m.U {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// ForEachWithError calls fn on each key and value in
+// m, in no particular order, and stops at the first
+// error fn returns, which it returns. The write lock
+// is held throughout, so fn may update the *User it is
+// given, but must not call any AtomicUserMap methods.
+func (m *AtomicUserMap) ForEachWithError(fn func(key string, u *User) error) error {
+	m.tex.Lock()
+	defer m.tex.Unlock()
+	for k, v := range m.U {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m *AtomicUserMap) String() string {
@@ -0,0 +1,250 @@
+package sshego
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test130ComputeIsAtomic(t *testing.T) {
+	cv.Convey("AtomicUserMap.Compute called from many goroutines at once should lose no updates, and returning nil from fn should delete the key.", t, func() {
+		m := NewAtomicUserMap()
+		m.Set("alice", &User{MyLogin: "alice"})
+
+		incr := func(u *User, found bool) *User {
+			if !found {
+				u = &User{MyLogin: "alice"}
+			}
+			u.LoginCount++
+			return u
+		}
+
+		const goroutines = 10
+		const perG = 100
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < perG; j++ {
+					m.Compute("alice", incr)
+				}
+			}()
+		}
+		wg.Wait()
+
+		u, ok := m.Get2("alice")
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(u.LoginCount, cv.ShouldEqual, int64(goroutines*perG))
+
+		got := m.Compute("alice", func(u *User, found bool) *User {
+			return nil
+		})
+		cv.So(got, cv.ShouldBeNil)
+		_, ok = m.Get2("alice")
+		cv.So(ok, cv.ShouldBeFalse)
+	})
+}
+
+func Test132GetOrCreateCallsFactoryOnce(t *testing.T) {
+	cv.Convey("AtomicUserMap.GetOrCreate should call the factory exactly once when 100 goroutines race to create the same key, and all should get the same *User.", t, func() {
+		m := NewAtomicUserMap()
+
+		var calls int32
+		factory := func() *User {
+			atomic.AddInt32(&calls, 1)
+			return &User{MyLogin: "bob"}
+		}
+
+		const goroutines = 100
+		got := make([]*User, goroutines)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				got[i] = m.GetOrCreate("bob", factory)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		cv.So(atomic.LoadInt32(&calls), cv.ShouldEqual, 1)
+		for i := range got {
+			cv.So(got[i], cv.ShouldEqual, got[0])
+		}
+		cv.So(m.Get("bob"), cv.ShouldEqual, got[0])
+	})
+}
+
+func Test136UserMapDiff(t *testing.T) {
+	cv.Convey("AtomicUserMap.Diff should report keys only in the newer map as Added, only in the older as Removed, and in both but different as Changed.", t, func() {
+		old := NewAtomicUserMap()
+		cur := NewAtomicUserMap()
+
+		same := &User{MyLogin: "same", MyEmail: "same@example.com"}
+		old.Set("same", same)
+		cur.Set("same", &User{MyLogin: "same", MyEmail: "same@example.com"})
+
+		gone := &User{MyLogin: "gone"}
+		old.Set("gone", gone)
+
+		added := &User{MyLogin: "added"}
+		cur.Set("added", added)
+
+		before := &User{MyLogin: "moved", LastLoginAddr: "10.0.0.1:22"}
+		after := &User{MyLogin: "moved", LastLoginAddr: "10.0.0.2:22"}
+		old.Set("moved", before)
+		cur.Set("moved", after)
+
+		d := old.Diff(cur)
+		cv.So(d.Added, cv.ShouldResemble, map[string]*User{"added": added})
+		cv.So(d.Removed, cv.ShouldResemble, map[string]*User{"gone": gone})
+		cv.So(len(d.Changed), cv.ShouldEqual, 1)
+		cv.So(d.Changed["moved"][0], cv.ShouldEqual, before)
+		cv.So(d.Changed["moved"][1], cv.ShouldEqual, after)
+
+		// and no difference between a map and itself.
+		d = cur.Diff(cur)
+		cv.So(len(d.Added)+len(d.Removed)+len(d.Changed), cv.ShouldEqual, 0)
+	})
+}
+
+func Test137UserMapJournal(t *testing.T) {
+	cv.Convey("After EnableJournal, DrainJournal should return every Set and Del in the order made, and then start afresh.", t, func() {
+		m := NewAtomicUserMap()
+		m.Set("before", &User{MyLogin: "before"})
+		m.EnableJournal()
+
+		var want []UserMapOp
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("user%v", i%10)
+			if i%5 == 4 {
+				m.Del(key)
+				want = append(want, UserMapOp{Op: UserMapDel, Key: key})
+			} else {
+				u := &User{MyLogin: key, LoginCount: int64(i)}
+				m.Set(key, u)
+				want = append(want, UserMapOp{Op: UserMapSet, Key: key, Val: u})
+			}
+		}
+
+		ops := m.DrainJournal()
+		cv.So(len(ops), cv.ShouldEqual, 50)
+		for i := range ops {
+			cv.So(ops[i].Op, cv.ShouldEqual, want[i].Op)
+			cv.So(ops[i].Key, cv.ShouldEqual, want[i].Key)
+			cv.So(ops[i].Val, cv.ShouldEqual, want[i].Val)
+			if i > 0 {
+				cv.So(ops[i].Timestamp.Before(ops[i-1].Timestamp), cv.ShouldBeFalse)
+			}
+		}
+
+		cv.So(m.DrainJournal(), cv.ShouldBeEmpty)
+		m.Del("before")
+		ops = m.DrainJournal()
+		cv.So(len(ops), cv.ShouldEqual, 1)
+		cv.So(ops[0].Key, cv.ShouldEqual, "before")
+	})
+}
+
+func Test140ForEachWithErrorStopsAtFirstError(t *testing.T) {
+	cv.Convey("AtomicUserMap.ForEachWithError should stop as soon as fn returns an error, and return that error.", t, func() {
+		m := NewAtomicUserMap()
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("user%v", i)
+			m.Set(key, &User{MyLogin: key})
+		}
+
+		calls := 0
+		errFifth := fmt.Errorf("fifth user failed validation")
+		err := m.ForEachWithError(func(key string, u *User) error {
+			calls++
+			if calls == 5 {
+				return errFifth
+			}
+			return nil
+		})
+		cv.So(err, cv.ShouldEqual, errFifth)
+		cv.So(calls, cv.ShouldEqual, 5)
+
+		calls = 0
+		err = m.ForEachWithError(func(key string, u *User) error {
+			calls++
+			return nil
+		})
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(calls, cv.ShouldEqual, 20)
+	})
+}
+
+func Test142AtomicUserMapLRU(t *testing.T) {
+	cv.Convey("An AtomicUserMap from NewAtomicUserMapLRU(3) should evict the least recently used user when a 4th is stored, telling OnEvict, and keep the rest.", t, func() {
+		m := NewAtomicUserMapLRU(3)
+		var evicted []string
+		m.OnEvict(func(key string, val *User) {
+			cv.So(val.MyLogin, cv.ShouldEqual, key)
+			evicted = append(evicted, key)
+		})
+
+		for _, k := range []string{"a", "b", "c", "d"} {
+			m.Set(k, &User{MyLogin: k})
+		}
+		cv.So(evicted, cv.ShouldResemble, []string{"a"})
+		cv.So(m.Len(), cv.ShouldEqual, 3)
+		_, ok := m.Get2("a")
+		cv.So(ok, cv.ShouldBeFalse)
+		for _, k := range []string{"b", "c", "d"} {
+			cv.So(m.Get(k).MyLogin, cv.ShouldEqual, k)
+		}
+
+		// reading b makes c the least recently used.
+		m.Get("b")
+		m.Set("e", &User{MyLogin: "e"})
+		cv.So(evicted, cv.ShouldResemble, []string{"a", "c"})
+
+		// an unbounded map never evicts.
+		u := NewAtomicUserMap()
+		for i := 0; i < 100; i++ {
+			u.Set(fmt.Sprintf("user%v", i), &User{})
+		}
+		cv.So(u.Len(), cv.ShouldEqual, 100)
+	})
+}
+
+func Test143IncrCounter(t *testing.T) {
+	cv.Convey("AtomicUserMap.IncrCounter from 100 racing goroutines should lose no increments, and should refuse unknown users and fields that are not int64.", t, func() {
+		m := NewAtomicUserMap()
+		m.Set("alice", &User{MyLogin: "alice"})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := m.IncrCounter("alice", "LoginCount", 1)
+				panicOn(err)
+			}()
+		}
+		wg.Wait()
+		cv.So(m.Get("alice").LoginCount, cv.ShouldEqual, int64(100))
+
+		n, err := m.IncrCounter("alice", "LoginCount", -10)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(n, cv.ShouldEqual, int64(90))
+
+		_, err = m.IncrCounter("bob", "LoginCount", 1)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = m.IncrCounter("alice", "NoSuchField", 1)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = m.IncrCounter("alice", "MyLogin", 1)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = m.IncrCounter("alice", "activeConns", 1)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
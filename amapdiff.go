@@ -0,0 +1,57 @@
+package sshego
+
+import (
+	"reflect"
+)
+
+// UserMapDiff is what it takes to turn one
+// AtomicUserMap into another. See AtomicUserMap.Diff.
+type UserMapDiff struct {
+	// Added holds the users only in the newer map.
+	Added map[string]*User
+
+	// Removed holds the users only in the older map.
+	Removed map[string]*User
+
+	// Changed holds, for keys in both maps whose users
+	// differ, the old user then the new one.
+	Changed map[string][2]*User
+}
+
+// Diff compares m, as the older map, with other, as the
+// newer one. Users are compared with reflect.DeepEqual.
+// Each map is copied under its own lock in turn, so the
+// two are not one atomic snapshot.
+func (m *AtomicUserMap) Diff(other *AtomicUserMap) UserMapDiff {
+	d := UserMapDiff{
+		Added:   make(map[string]*User),
+		Removed: make(map[string]*User),
+		Changed: make(map[string][2]*User),
+	}
+	old := m.snapshot()
+	cur := other.snapshot()
+	for k, o := range old {
+		n, ok := cur[k]
+		if !ok {
+			d.Removed[k] = o
+		} else if !reflect.DeepEqual(o, n) {
+			d.Changed[k] = [2]*User{o, n}
+		}
+	}
+	for k, n := range cur {
+		if _, ok := old[k]; !ok {
+			d.Added[k] = n
+		}
+	}
+	return d
+}
+
+func (m *AtomicUserMap) snapshot() map[string]*User {
+	m.tex.RLock()
+	defer m.tex.RUnlock()
+	cp := make(map[string]*User, len(m.U))
+	for k, v := range m.U {
+		cp[k] = v
+	}
+	return cp
+}
@@ -0,0 +1,54 @@
+package sshego
+
+import (
+	"time"
+)
+
+// UserMapOpType says whether a UserMapOp set or deleted.
+type UserMapOpType string
+
+const (
+	UserMapSet UserMapOpType = "set"
+	UserMapDel UserMapOpType = "del"
+)
+
+// UserMapOp is one change to an AtomicUserMap, as
+// recorded by its journal. Val is nil for a UserMapDel.
+type UserMapOp struct {
+	Op        UserMapOpType
+	Key       string
+	Val       *User
+	Timestamp time.Time
+}
+
+// EnableJournal starts recording every change to m,
+// whether by Set, Del, Compute, or GetOrCreate, so a
+// replica can replay them. See DrainJournal.
+func (m *AtomicUserMap) EnableJournal() {
+	m.tex.Lock()
+	m.journaling = true
+	m.tex.Unlock()
+}
+
+// DrainJournal returns the changes recorded since
+// the last DrainJournal, oldest first, and forgets them.
+func (m *AtomicUserMap) DrainJournal() []UserMapOp {
+	m.tex.Lock()
+	defer m.tex.Unlock()
+	ops := m.journal
+	m.journal = nil
+	return ops
+}
+
+// record must be called with m.tex held for writing.
+func (m *AtomicUserMap) record(op UserMapOpType, key string, val *User) {
+	if !m.journaling {
+		return
+	}
+	m.journal = append(m.journal, UserMapOp{
+		Op:        op,
+		Key:       key,
+		Val:       val,
+		Timestamp: time.Now(),
+	})
+}
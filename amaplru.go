@@ -0,0 +1,79 @@
+package sshego
+
+import (
+	"container/list"
+)
+
+// NewAtomicUserMapLRU returns an AtomicUserMap that holds
+// at most capacity users. Storing a new user when full
+// evicts the least recently used one, where Get, Get2,
+// Set, Compute and GetOrCreate all count as use. See
+// OnEvict. capacity <= 0 means no limit, as with
+// NewAtomicUserMap.
+func NewAtomicUserMapLRU(capacity int) *AtomicUserMap {
+	m := NewAtomicUserMap()
+	if capacity > 0 {
+		m.capacity = capacity
+		m.lru = list.New()
+		m.lruElem = make(map[string]*list.Element)
+	}
+	return m
+}
+
+// OnEvict registers fn to be called with each user an
+// LRU map evicts. fn runs with m locked, so it must
+// not call any AtomicUserMap methods on m.
+func (m *AtomicUserMap) OnEvict(fn func(key string, val *User)) {
+	m.tex.Lock()
+	m.onEvict = fn
+	m.tex.Unlock()
+}
+
+// The lru helpers below must be called with m.tex held
+// for writing. They do nothing unless m has a capacity.
+
+// touch marks key, if present, as most recently used.
+func (m *AtomicUserMap) touch(key string) {
+	if m.capacity <= 0 {
+		return
+	}
+	if e, ok := m.lruElem[key]; ok {
+		m.lru.MoveToFront(e)
+	}
+}
+
+// stored notes that key was just stored in m.U; had
+// says it was there before. A new key may push the
+// least recently used one out.
+func (m *AtomicUserMap) stored(key string, had bool) {
+	if m.capacity <= 0 {
+		return
+	}
+	if had {
+		m.touch(key)
+		return
+	}
+	m.lruElem[key] = m.lru.PushFront(key)
+	for len(m.U) > m.capacity {
+		oldest := m.lru.Back()
+		k := oldest.Value.(string)
+		v := m.U[k]
+		delete(m.U, k)
+		m.forget(k)
+		m.record(UserMapDel, k, nil)
+		if m.onEvict != nil {
+			m.onEvict(k, v)
+		}
+	}
+}
+
+// forget notes that key was just deleted from m.U.
+func (m *AtomicUserMap) forget(key string) {
+	if m.capacity <= 0 {
+		return
+	}
+	if e, ok := m.lruElem[key]; ok {
+		m.lru.Remove(e)
+		delete(m.lruElem, key)
+	}
+}
@@ -0,0 +1,112 @@
+package sshego
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// AuditLogger is told of the security relevant events
+// on the esshd's connections. See SetAuditLog. Its
+// methods may be called from many goroutines at once.
+type AuditLogger interface {
+	// AuthSuccess is called once a connection has
+	// passed every required authentication method and
+	// been admitted under the per-user connection limit.
+	AuthSuccess(conn ssh.ConnMetadata)
+
+	// AuthFailure is called each time the client's
+	// attempt at an authentication method is refused;
+	// err is the reason.
+	AuthFailure(conn ssh.ConnMetadata, err error)
+
+	// ChannelOpen is called for every channel the
+	// client asks to open, whether or not we accept it.
+	ChannelOpen(conn ssh.ConnMetadata, chanType string)
+
+	// Disconnect is called when an authenticated
+	// connection closes.
+	Disconnect(conn ssh.ConnMetadata)
+}
+
+// SetAuditLog has the esshd report to l from now on.
+// A nil l turns audit logging off.
+func (cfg *SshegoConfig) SetAuditLog(l AuditLogger) {
+	cfg.Mut.Lock()
+	cfg.auditLog = l
+	cfg.Mut.Unlock()
+}
+
+func (cfg *SshegoConfig) auditLogger() AuditLogger {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	return cfg.auditLog
+}
+
+// AuditEvent is one line written by a JSONAuditLogger.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_addr"`
+	SessionID  string    `json:"session_id,omitempty"`
+	ChanType   string    `json:"chan_type,omitempty"`
+	Err        string    `json:"error,omitempty"`
+}
+
+// The AuditEvent.Event names.
+const (
+	AuditAuthSuccess = "auth-success"
+	AuditAuthFailure = "auth-failure"
+	AuditChannelOpen = "channel-open"
+	AuditDisconnect  = "disconnect"
+)
+
+type jsonAuditLogger struct {
+	mut sync.Mutex
+	enc *json.Encoder
+}
+
+// JSONAuditLogger returns an AuditLogger that writes
+// each event to w as an AuditEvent, one JSON object
+// per line. Write errors are ignored.
+func JSONAuditLogger(w io.Writer) AuditLogger {
+	return &jsonAuditLogger{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonAuditLogger) write(event string, conn ssh.ConnMetadata, chanType string, err error) {
+	ev := &AuditEvent{
+		Time:       time.Now().UTC(),
+		Event:      event,
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		SessionID:  hex.EncodeToString(conn.SessionID()),
+		ChanType:   chanType,
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	j.mut.Lock()
+	j.enc.Encode(ev)
+	j.mut.Unlock()
+}
+
+func (j *jsonAuditLogger) AuthSuccess(conn ssh.ConnMetadata) {
+	j.write(AuditAuthSuccess, conn, "", nil)
+}
+
+func (j *jsonAuditLogger) AuthFailure(conn ssh.ConnMetadata, err error) {
+	j.write(AuditAuthFailure, conn, "", err)
+}
+
+func (j *jsonAuditLogger) ChannelOpen(conn ssh.ConnMetadata, chanType string) {
+	j.write(AuditChannelOpen, conn, chanType, nil)
+}
+
+func (j *jsonAuditLogger) Disconnect(conn ssh.ConnMetadata) {
+	j.write(AuditDisconnect, conn, "", nil)
+}
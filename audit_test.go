@@ -0,0 +1,79 @@
+package sshego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test111JSONAuditLogger(t *testing.T) {
+	cv.Convey("With a JSONAuditLogger set, the esshd should record each login, each channel open (accepted or not), each disconnect, and each failed login, one JSON object per line.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		var out syncBuffer
+		s.SrvCfg.SetAuditLog(JSONAuditLogger(&out))
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test111",
+		}
+		ctx := context.Background()
+		_, _, _, err := dc.Dial(ctx, nil, true)
+		cv.So(errors.Is(err, ErrRerunWithoutNew), cv.ShouldBeTrue)
+		dc.TofuAddIfNotKnown = false
+		_, cli, _, err := dc.Dial(ctx, nil, true)
+		panicOn(err)
+
+		sess, err := cli.NewSession(ctx)
+		panicOn(err)
+		sess.Close()
+		_, _, err = cli.OpenChannel(ctx, "no-such-type", nil, nil)
+		cv.So(err, cv.ShouldNotBeNil)
+		cli.Close()
+		cv.So(out.waitFor(`"event":"disconnect"`, 10*time.Second), cv.ShouldBeTrue)
+
+		bad := *dc
+		bad.Pw = "not-the-password"
+		_, _, _, err = bad.Dial(ctx, nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(out.waitFor(`"event":"auth-failure"`, 10*time.Second), cv.ShouldBeTrue)
+
+		var events []AuditEvent
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var ev AuditEvent
+			panicOn(json.Unmarshal([]byte(line), &ev))
+			cv.So(ev.User, cv.ShouldEqual, s.Mylogin)
+			cv.So(ev.RemoteAddr, cv.ShouldStartWith, "127.0.0.1:")
+			cv.So(ev.Time.IsZero(), cv.ShouldBeFalse)
+			events = append(events, ev)
+		}
+		cv.So(len(events), cv.ShouldEqual, 5)
+		cv.So(events[0].Event, cv.ShouldEqual, AuditAuthSuccess)
+		cv.So(events[0].SessionID, cv.ShouldNotEqual, "")
+		cv.So(events[1].Event, cv.ShouldEqual, AuditChannelOpen)
+		cv.So(events[1].ChanType, cv.ShouldEqual, "session")
+		cv.So(events[2].Event, cv.ShouldEqual, AuditChannelOpen)
+		cv.So(events[2].ChanType, cv.ShouldEqual, "no-such-type")
+		cv.So(events[3].Event, cv.ShouldEqual, AuditDisconnect)
+		cv.So(events[3].SessionID, cv.ShouldEqual, events[0].SessionID)
+		cv.So(events[4].Event, cv.ShouldEqual, AuditAuthFailure)
+		cv.So(events[4].Err, cv.ShouldNotEqual, "")
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,87 @@
+package sshego
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test105AuthorizedKeysCallback(t *testing.T) {
+	cv.Convey("With an AuthorizedKeysCallback, the esshd should accept exactly the keys the callback currently returns for the user, asking it only once per connection.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		userKey, err := LoadRSAPublicKey(s.RsaPath + ".pub")
+		panicOn(err)
+		otherKey, err := LoadRSAPublicKey(s.SrvCfg.Origdir + "/id_rsa_test.pub")
+		panicOn(err)
+
+		var mut sync.Mutex
+		calls := 0
+		keys := map[string][]ssh.PublicKey{}
+		setKeys := func(k ...ssh.PublicKey) {
+			mut.Lock()
+			keys[s.Mylogin] = k
+			calls = 0
+			mut.Unlock()
+		}
+		getCalls := func() int {
+			mut.Lock()
+			defer mut.Unlock()
+			return calls
+		}
+		s.SrvCfg.AuthorizedKeysCallback = func(conn ssh.ConnMetadata) ([]ssh.PublicKey, error) {
+			mut.Lock()
+			defer mut.Unlock()
+			calls++
+			return keys[conn.User()], nil
+		}
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test105",
+		}
+		ctx := context.Background()
+
+		// the first dial only learns the host key.
+		_, _, _, err = dc.Dial(ctx, nil, true)
+		cv.So(errors.Is(err, ErrRerunWithoutNew), cv.ShouldBeTrue)
+		dc.TofuAddIfNotKnown = false
+
+		setKeys(otherKey, userKey)
+		_, cli, _, err := dc.Dial(ctx, nil, true)
+		cv.So(err, cv.ShouldBeNil)
+		cli.Close()
+		cv.So(getCalls(), cv.ShouldEqual, 1)
+
+		setKeys(otherKey)
+		_, _, _, err = dc.Dial(ctx, nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(getCalls(), cv.ShouldBeGreaterThan, 0)
+
+		setKeys()
+		_, _, _, err = dc.Dial(ctx, nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		setKeys(userKey)
+		_, cli, _, err = dc.Dial(ctx, nil, true)
+		cv.So(err, cv.ShouldBeNil)
+		cli.Close()
+		cv.So(getCalls(), cv.ShouldEqual, 1)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
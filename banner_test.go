@@ -0,0 +1,85 @@
+package sshego
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// versionAuditLogger notes the client version of each login.
+type versionAuditLogger struct {
+	mut      sync.Mutex
+	versions []string
+}
+
+func (v *versionAuditLogger) AuthSuccess(conn ssh.ConnMetadata) {
+	v.mut.Lock()
+	v.versions = append(v.versions, string(conn.ClientVersion()))
+	v.mut.Unlock()
+}
+func (v *versionAuditLogger) AuthFailure(conn ssh.ConnMetadata, err error)       {}
+func (v *versionAuditLogger) ChannelOpen(conn ssh.ConnMetadata, chanType string) {}
+func (v *versionAuditLogger) Disconnect(conn ssh.ConnMetadata)                   {}
+
+func (v *versionAuditLogger) seen() []string {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	return append([]string(nil), v.versions...)
+}
+
+func Test126DialConfigClientVersion(t *testing.T) {
+	cv.Convey("DialConfig.ClientVersion should be the banner the sshd sees from us, and a banner not of the SSH-2.0-* form should be refused.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		audit := &versionAuditLogger{}
+		s.SrvCfg.SetAuditLog(audit)
+
+		const banner = "SSH-2.0-AcmeTunnel_3.1 build42"
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			ClientVersion:        banner,
+			LocalNickname:        "test126",
+		}
+		// the first dial only learns the host key.
+		_, _, _, err := dc.Dial(context.Background(), nil, true)
+		cv.So(errors.Is(err, ErrRerunWithoutNew), cv.ShouldBeTrue)
+		dc.TofuAddIfNotKnown = false
+		_, cli, _, err := dc.Dial(context.Background(), nil, true)
+		panicOn(err)
+		cv.So(string(cli.ClientVersion()), cv.ShouldEqual, banner)
+
+		var seen []string
+		for i := 0; i < 100 && len(seen) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			seen = audit.seen()
+		}
+		cv.So(seen, cv.ShouldResemble, []string{banner})
+		cli.Close()
+
+		for _, bad := range []string{"SSH-1.99-old", "SSH-2.0-", "AcmeTunnel", "SSH-2.0-x\r\nSSH-2.0-y", "SSH-2.0-" + strings.Repeat("x", 250)} {
+			dc2 := *dc
+			dc2.ClientVersion = bad
+			cv.So(dc2.Validate(), cv.ShouldNotBeNil)
+			_, err = dc2.DeriveNewConfig()
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
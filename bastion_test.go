@@ -0,0 +1,67 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test099BastionMode(t *testing.T) {
+	cv.Convey("With BastionMode set, the esshd should refuse session channels as Prohibited, and still forward direct-tcpip.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		defer tcpServerMgr.RequestStop()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		s.SrvCfg.BastionMode = true
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test099",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test099")
+		panicOn(err)
+
+		cli, err := tri.Cli()
+		panicOn(err)
+		_, err = cli.NewSession(context.Background())
+		cv.So(err, cv.ShouldNotBeNil)
+		oce, ok := err.(*ssh.OpenChannelError)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(oce.Reason, cv.ShouldEqual, ssh.Prohibited)
+
+		_, err = tri.OpenSubsystem(context.Background(), "sftp")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", fmt.Sprintf("127.0.0.1:%v", tcpSrvPort))
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
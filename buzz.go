@@ -1,6 +1,7 @@
 package sshego
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -130,6 +131,67 @@ func (b *UHPTower) Broadcast(val *UHP) error {
 	return nil
 }
 
+// Publish is a non-blocking Broadcast that reports
+// how many subscribers it reached. As with Broadcast,
+// size 1 buffered subscribers have any stale value
+// replaced, so they are always reached. Other
+// subscribers are reached only if they have room, or,
+// when unbuffered, are waiting in a receive right now.
+// Publish returns 0 when no one is subscribed, so
+// callers can notice a signal that went nowhere.
+func (b *UHPTower) Publish(val *UHP) int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.closed {
+		return 0
+	}
+	reached := 0
+	for i := range b.subs {
+		if cap(b.subs[i]) == 1 {
+			select {
+			case <-b.subs[i]:
+			default:
+			}
+		}
+		select {
+		case b.subs[i] <- val:
+			reached++
+		default:
+		}
+	}
+	return reached
+}
+
+// publishPollEvery is how often PublishCtx retries
+// while no subscriber is reachable.
+const publishPollEvery = 10 * time.Millisecond
+
+// PublishCtx is like Publish, but blocks until at least
+// one subscriber has received val, or ctx is done. A
+// subscriber that arrives while we wait will be sent val.
+// It returns the count reached, and ctx.Err() if we gave
+// up, or ErrClosed if the tower is closed.
+func (b *UHPTower) PublishCtx(ctx context.Context, val *UHP) (int, error) {
+	for {
+		if n := b.Publish(val); n > 0 {
+			return n, nil
+		}
+		b.mut.Lock()
+		closed := b.closed
+		b.mut.Unlock()
+		if closed {
+			return 0, ErrClosed
+		}
+		select {
+		case <-time.After(publishPollEvery):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-b.halt.ReqStopChan():
+			return 0, ErrShutdown
+		}
+	}
+}
+
 func (b *UHPTower) Signal(val *UHP) error {
 	b.mut.Lock()
 	defer b.mut.Unlock()
@@ -0,0 +1,70 @@
+package sshego
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test092UHPTowerPublish(t *testing.T) {
+	cv.Convey("UHPTower.Publish should report how many subscribers it reached, and PublishCtx should wait for a subscriber to receive.", t, func() {
+
+		halt := ssh.NewHalter()
+		defer halt.RequestStop()
+		tower := NewUHPTower(halt)
+		uhp := &UHP{User: "u", HostPort: "127.0.0.1:22", Nickname: "n"}
+
+		// no one is listening.
+		cv.So(tower.Publish(uhp), cv.ShouldEqual, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		n, err := tower.PublishCtx(ctx, uhp)
+		cancel()
+		cv.So(n, cv.ShouldEqual, 0)
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+
+		// buffered subscribers are always reached.
+		a := tower.Subscribe(nil)
+		b := tower.Subscribe(nil)
+		cv.So(tower.Publish(uhp), cv.ShouldEqual, 2)
+		cv.So(<-a, cv.ShouldEqual, uhp)
+		cv.So(<-b, cv.ShouldEqual, uhp)
+		tower.Unsub(a)
+		tower.Unsub(b)
+
+		// an unbuffered subscriber that is not yet
+		// reading holds PublishCtx until it does.
+		c := tower.Subscribe(make(chan *UHP))
+		cv.So(tower.Publish(uhp), cv.ShouldEqual, 0)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			<-c
+		}()
+		t0 := time.Now()
+		n, err = tower.PublishCtx(context.Background(), uhp)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(n, cv.ShouldEqual, 1)
+		cv.So(time.Since(t0), cv.ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+		tower.Unsub(c)
+
+		// a subscriber who arrives late still gets it.
+		got := make(chan *UHP, 1)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			got <- <-tower.Subscribe(nil)
+		}()
+		n, err = tower.PublishCtx(context.Background(), uhp)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(n, cv.ShouldEqual, 1)
+		cv.So(<-got, cv.ShouldEqual, uhp)
+
+		tower.Close()
+		cv.So(tower.Publish(uhp), cv.ShouldEqual, 0)
+		_, err = tower.PublishCtx(context.Background(), uhp)
+		cv.So(err, cv.ShouldEqual, ErrClosed)
+	})
+}
@@ -0,0 +1,61 @@
+package sshego
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// Direction markers used in a ChannelOpts.CaptureWriter
+// capture. Each captured chunk is written as a header
+// line "<marker> <byte count>\n", then the raw bytes,
+// then a "\n".
+const (
+	CaptureRead  = "<<"
+	CaptureWrite = ">>"
+)
+
+// capturedChannel copies all traffic on an ssh.Channel
+// to a capture writer. See ChannelOpts.CaptureWriter.
+type capturedChannel struct {
+	ssh.Channel
+	mut sync.Mutex
+	w   io.Writer
+}
+
+func newCapturedChannel(ch ssh.Channel, w io.Writer) *capturedChannel {
+	return &capturedChannel{
+		Channel: ch,
+		w:       w,
+	}
+}
+
+func (c *capturedChannel) Read(data []byte) (n int, err error) {
+	n, err = c.Channel.Read(data)
+	if n > 0 {
+		c.capture(CaptureRead, data[:n])
+	}
+	return
+}
+
+func (c *capturedChannel) Write(data []byte) (n int, err error) {
+	n, err = c.Channel.Write(data)
+	if n > 0 {
+		c.capture(CaptureWrite, data[:n])
+	}
+	return
+}
+
+// capture holds c.mut so that concurrent reads and
+// writes don't interleave within a chunk. Errors from
+// the capture writer are ignored; they must not
+// disturb the channel itself.
+func (c *capturedChannel) capture(marker string, data []byte) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	fmt.Fprintf(c.w, "%s %d\n", marker, len(data))
+	c.w.Write(data)
+	io.WriteString(c.w, "\n")
+}
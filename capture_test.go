@@ -0,0 +1,97 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test073ChannelCaptureWriter(t *testing.T) {
+	cv.Convey("With ChannelOpts.CaptureWriter set, both directions of channel traffic should show up in the capture, each with its direction marker.", t, func() {
+
+		question := "ping-" + RandomString(10)
+		answer := "pong-" + RandomString(10)
+
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, len(question))
+			_, err = io.ReadFull(conn, buf)
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(answer))
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test073",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test073")
+		panicOn(err)
+
+		var capture bytes.Buffer
+		ch, err := tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest,
+			&ChannelOpts{CaptureWriter: &capture})
+		panicOn(err)
+
+		_, err = ch.Write([]byte(question))
+		panicOn(err)
+		back := make([]byte, len(answer))
+		_, err = io.ReadFull(ch, back)
+		panicOn(err)
+		cv.So(string(back), cv.ShouldEqual, answer)
+
+		pp("Test073: capture = '%s'", capture.String())
+		wrote, read := splitCapture(capture.Bytes())
+		cv.So(wrote, cv.ShouldEqual, question)
+		cv.So(read, cv.ShouldEqual, answer)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+// splitCapture reassembles the written and read
+// streams from a CaptureWriter capture.
+func splitCapture(b []byte) (wrote, read string) {
+	r := bytes.NewReader(b)
+	for {
+		var marker string
+		var n int
+		_, err := fmt.Fscanf(r, "%s %d\n", &marker, &n)
+		if err != nil {
+			return
+		}
+		chunk := make([]byte, n+1)
+		_, err = io.ReadFull(r, chunk)
+		panicOn(err)
+		switch marker {
+		case CaptureWrite:
+			wrote += string(chunk[:n])
+		case CaptureRead:
+			read += string(chunk[:n])
+		}
+	}
+}
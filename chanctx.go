@@ -0,0 +1,56 @@
+package sshego
+
+import (
+	"context"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// WrapChannelContext ties ch's Reads and Writes to ctx.
+// Once ctx is done, by its deadline or by cancel, ch's
+// read and write deadlines are set to now, so any Read or
+// Write blocked on ch returns, and it and all later ones
+// fail with ctx.Err(). ch itself stays open; close it as
+// usual, or clear its deadlines to use it further.
+func WrapChannelContext(ctx context.Context, ch ssh.Channel) ssh.Channel {
+	go func() {
+		select {
+		case <-ctx.Done():
+			now := time.Now()
+			ch.SetReadDeadline(now)
+			ch.SetWriteDeadline(now)
+		case <-ch.GetHalter().ReqStopChan():
+		}
+	}()
+	return &ctxChannel{Channel: ch, ctx: ctx}
+}
+
+// ctxChannel is an ssh.Channel whose I/O stops
+// when ctx is done. See WrapChannelContext.
+type ctxChannel struct {
+	ssh.Channel
+	ctx context.Context
+}
+
+func (c *ctxChannel) Read(data []byte) (n int, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err = c.Channel.Read(data)
+	if err != nil && c.ctx.Err() != nil {
+		err = c.ctx.Err()
+	}
+	return
+}
+
+func (c *ctxChannel) Write(data []byte) (n int, err error) {
+	if err = c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err = c.Channel.Write(data)
+	if err != nil && c.ctx.Err() != nil {
+		err = c.ctx.Err()
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test123WrapChannelContextDeadline(t *testing.T) {
+	cv.Convey("A Read with no data on a channel wrapped by WrapChannelContext should fail with the context's deadline error once the context expires, leaving the channel beneath open.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		// this server says nothing until it hears from us.
+		tcpServerMgr := ssh.NewHalter()
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test123",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test123")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		wch := WrapChannelContext(ctx, ch)
+
+		t0 := time.Now()
+		buf := make([]byte, 10)
+		n, err := wch.Read(buf)
+		elapsed := time.Since(t0)
+		cv.So(n, cv.ShouldEqual, 0)
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+		cv.So(elapsed, cv.ShouldBeGreaterThanOrEqualTo, 90*time.Millisecond)
+		cv.So(elapsed, cv.ShouldBeLessThan, 5*time.Second)
+
+		_, err = wch.Write([]byte("late"))
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+
+		// ch itself stays open, and works again
+		// once its deadlines are cleared.
+		ch.SetReadDeadline(time.Time{})
+		ch.SetWriteDeadline(time.Time{})
+		_, err = ch.Write([]byte(confirmationPayload))
+		cv.So(err, cv.ShouldBeNil)
+		reply := make([]byte, payloadByteCount)
+		_, err = io.ReadFull(ch, reply)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(reply), cv.ShouldEqual, confirmationReply)
+
+		ch.Close()
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
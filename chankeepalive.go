@@ -0,0 +1,73 @@
+package sshego
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ChannelKeepAliveReqName is the channel request
+// type sent for ChannelOpts.KeepAliveEvery. A peer that
+// does not know it replies false, which shows the
+// channel is alive just as well as true does.
+const ChannelKeepAliveReqName = "keepalive@sshego.glycerine.github.com"
+
+// keepAliveTimeout defaults to three missed intervals.
+func (opts *ChannelOpts) keepAliveTimeout() time.Duration {
+	if opts.KeepAliveTimeout > 0 {
+		return opts.KeepAliveTimeout
+	}
+	return 3 * opts.KeepAliveEvery
+}
+
+// startKeepalives pings ch until ctx is done, halt
+// is stopped, or ch fails. If a ping goes unanswered
+// for keepAliveTimeout we close ch, so that its
+// reader sees an error rather than hanging forever
+// on a connection some middlebox has dropped.
+func (opts *ChannelOpts) startKeepalives(ctx context.Context, ch ssh.Channel, halt *ssh.Halter, warn func(msg string, keyvals ...interface{})) {
+	if opts == nil || opts.KeepAliveEvery <= 0 {
+		return
+	}
+	every := opts.KeepAliveEvery
+	timeout := opts.keepAliveTimeout()
+	payload := opts.KeepAlivePayload
+
+	go func() {
+		for {
+			// up to 20% jitter, so many channels
+			// opened together don't ping in lockstep.
+			wait := every - time.Duration(rand.Int63n(int64(every)/5+1))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			case <-halt.ReqStopChan():
+				return
+			}
+
+			replied := make(chan error, 1)
+			go func() {
+				_, err := ch.SendRequest(ChannelKeepAliveReqName, true, payload)
+				replied <- err
+			}()
+			select {
+			case err := <-replied:
+				if err != nil {
+					// ch is closed already.
+					return
+				}
+			case <-time.After(timeout):
+				warn("no reply to channel keepalive, closing channel", "timeout", timeout)
+				ch.Close()
+				return
+			case <-ctx.Done():
+				return
+			case <-halt.ReqStopChan():
+				return
+			}
+		}
+	}()
+}
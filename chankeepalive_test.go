@@ -0,0 +1,218 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// startIdleKillingProxy forwards connections to target,
+// and like a stingy NAT box, cuts any that go
+// idle, in both directions, for longer than idle.
+// Cutting starts once arm is called, since our
+// sshd's login can itself pause for longer than that.
+// cut is closed when the proxy first cuts a connection.
+func startIdleKillingProxy(halt *ssh.Halter, target string, idle time.Duration) (addr string, arm func(), cut <-chan struct{}) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	panicOn(err)
+	armed := make(chan struct{})
+	var armOnce sync.Once
+	arm = func() {
+		armOnce.Do(func() { close(armed) })
+	}
+	cutCh := make(chan struct{})
+	var cutOnce sync.Once
+	go func() {
+		<-halt.ReqStopChan()
+		lsn.Close()
+	}()
+	go func() {
+		for {
+			a, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			// the sshd may still be starting up.
+			var b net.Conn
+			for i := 0; i < 50; i++ {
+				b, err = net.Dial("tcp", target)
+				if err == nil {
+					break
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			if err != nil {
+				a.Close()
+				continue
+			}
+			// ended is closed once either direction stops,
+			// so a connection the client hung up on itself
+			// isn't reported as cut.
+			ended := make(chan struct{})
+			var endOnce sync.Once
+			var mut sync.Mutex
+			last := time.Now()
+			touch := func() {
+				mut.Lock()
+				last = time.Now()
+				mut.Unlock()
+			}
+			copyTouching := func(dst, src net.Conn) {
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := src.Read(buf)
+					if n > 0 {
+						touch()
+						if _, werr := dst.Write(buf[:n]); werr != nil {
+							break
+						}
+					}
+					if err != nil {
+						break
+					}
+				}
+				a.Close()
+				b.Close()
+				endOnce.Do(func() { close(ended) })
+			}
+			go copyTouching(a, b)
+			go copyTouching(b, a)
+			go func() {
+				for {
+					select {
+					case <-time.After(idle / 10):
+					case <-ended:
+						return
+					case <-halt.ReqStopChan():
+						a.Close()
+						b.Close()
+						return
+					}
+					select {
+					case <-armed:
+					default:
+						touch()
+						continue
+					}
+					mut.Lock()
+					quiet := time.Since(last)
+					mut.Unlock()
+					if quiet > idle {
+						a.Close()
+						b.Close()
+						cutOnce.Do(func() { close(cutCh) })
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return lsn.Addr().String(), arm, cutCh
+}
+
+func Test083ChannelKeepAliveSurvivesIdleKillingProxy(t *testing.T) {
+	cv.Convey("With ChannelOpts.KeepAliveEvery set, an idle channel should survive a proxy that cuts connections after a second of silence; without it, the channel should not.", t, func() {
+
+		payload := RandomString(50)
+
+		// an echo server, which doesn't panic when cut
+		// off, as StartBackgroundTestTcpServer would.
+		echoLsn, echoPort := GetAvailPort()
+		defer echoLsn.Close()
+		go func() {
+			for {
+				c, err := echoLsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(c, c)
+					c.Close()
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", echoPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		idle := time.Second
+		proxyHalt := ssh.NewHalter()
+		defer proxyHalt.RequestStop()
+		sshdAddr := fmt.Sprintf("%v:%v", s.SrvCfg.EmbeddedSSHd.Host, s.SrvCfg.EmbeddedSSHd.Port)
+
+		// each Tricorder gets its own proxy, armed
+		// once its channel is open.
+		newTri := func(name string) (*Tricorder, func(), <-chan struct{}) {
+			proxyAddr, arm, cut := startIdleKillingProxy(proxyHalt, sshdAddr, idle)
+			proxyHost, proxyPort, err := SplitHostPort(proxyAddr)
+			panicOn(err)
+			dc := &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             proxyHost,
+				Sshdport:             proxyPort,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				LocalNickname:        name,
+			}
+			tri, err := NewTricorder(dc, s.CliCfg.Halt, name)
+			panicOn(err)
+			return tri, arm, cut
+		}
+		echoed := func(ch io.ReadWriter) (string, error) {
+			if _, err := ch.Write([]byte(payload)); err != nil {
+				return "", err
+			}
+			back := make([]byte, len(payload))
+			_, err := io.ReadFull(ch, back)
+			return string(back), err
+		}
+
+		// with channel keepalives, nothing is cut
+		// for three times the proxy's patience.
+		tri, arm, cut := newTri("test083-keepalive")
+		ch, err := tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest, &ChannelOpts{
+			KeepAliveEvery: idle / 5,
+		})
+		panicOn(err)
+		arm()
+		wasCut := false
+		select {
+		case <-cut:
+			wasCut = true
+		case <-time.After(3 * idle):
+		}
+		cv.So(wasCut, cv.ShouldBeFalse)
+		got, err := echoed(ch)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(got, cv.ShouldEqual, payload)
+		tri.Halt.RequestStop()
+
+		// and without, the proxy cuts us off.
+		tri, arm, cut = newTri("test083-none")
+		ch, err = tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		arm()
+		select {
+		case <-cut:
+		case <-time.After(10 * idle):
+			panic("proxy never cut the idle connection")
+		}
+		_, err = echoed(ch)
+		cv.So(err, cv.ShouldNotBeNil)
+		tri.Halt.RequestStop()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,117 @@
+package sshego
+
+import (
+	"io"
+	"net"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ChannelOpts adjusts one channel opened with
+// Tricorder.SSHChannelOpts. A nil *ChannelOpts changes
+// nothing. Neither does the zero value.
+type ChannelOpts struct {
+
+	// LocalResolve, if true, has us resolve a
+	// direct-tcpip target's host name ourselves and
+	// send the sshd the IP address. By default the
+	// sshd resolves it, as ssh does, so names known
+	// only on the sshd's side work.
+	LocalResolve bool
+
+	// MaxBytesPerSec, if > 0, caps the channel's
+	// throughput. Reads and writes are limited
+	// independently, each to MaxBytesPerSec.
+	MaxBytesPerSec int
+
+	// CaptureWriter, if set, gets a copy of every
+	// byte read from and written to the channel,
+	// marked with its direction. See CaptureRead and
+	// CaptureWrite for the format. For debugging only.
+	CaptureWriter io.Writer
+
+	// Priority, if > 0, puts our request ahead of
+	// normal priority SSHChannel requests still waiting,
+	// say while the Tricorder reconnects.
+	Priority int
+
+	// KeepAliveEvery, if > 0, has us send a
+	// ChannelKeepAliveReqName request on the channel
+	// about that often, so that middleboxes see traffic
+	// on an otherwise idle channel. If no reply comes
+	// within KeepAliveTimeout, 3 * KeepAliveEvery by
+	// default, we close the channel.
+	KeepAliveEvery   time.Duration
+	KeepAliveTimeout time.Duration
+
+	// KeepAlivePayload is sent with each keepalive
+	// request, and may be empty.
+	KeepAlivePayload []byte
+
+	// FallbackType, if set, is a channel type to try
+	// once more with, to the same target, should the
+	// sshd refuse the type we asked for for a permanent
+	// reason, such as Prohibited or UnknownChannelType.
+	FallbackType string
+
+	// ProxyProtocol, if 1 or 2, has a direct-tcpip channel
+	// begin with a PROXY protocol header of that version,
+	// so a backend behind the sshd learns who the client
+	// really is. The header names ProxyOrigin as source,
+	// and the target as destination; a target given by
+	// host name rather than IP makes the addresses unknown.
+	ProxyProtocol int
+
+	// ProxyOrigin is the originating connection's remote
+	// address, for ProxyProtocol. If nil, the header
+	// says the addresses are unknown.
+	ProxyOrigin net.Addr
+
+	// OpenData, if set, is sent as the type-specific data
+	// of the channel open request, for channel types that
+	// expect some. Not allowed for "direct-tcpip" and
+	// "direct-streamlocal", which make their own.
+	OpenData []byte
+}
+
+// openData returns the OpenData to open a channel
+// with, or nil.
+func (opts *ChannelOpts) openData() []byte {
+	if opts == nil {
+		return nil
+	}
+	return opts.OpenData
+}
+
+// fallbackType returns the channel type to retry with
+// after opening one of type typ failed with err, or "".
+func (opts *ChannelOpts) fallbackType(typ string, err error) string {
+	if opts == nil || opts.FallbackType == "" || opts.FallbackType == typ {
+		return ""
+	}
+	oce, ok := err.(*ssh.OpenChannelError)
+	if !ok || !oce.Reason.IsPermanent() {
+		return ""
+	}
+	return opts.FallbackType
+}
+
+// wrap applies opts to a freshly opened ch. It
+// is called on the reconnect loop's goroutine, before
+// ch is recorded in t.sshChannels, so that the caller
+// gets back the same value we track.
+func (opts *ChannelOpts) wrap(ch ssh.Channel) ssh.Channel {
+	if opts == nil {
+		return ch
+	}
+	// capture innermost, so it records the bytes
+	// actually moved, not those still being throttled.
+	if opts.CaptureWriter != nil {
+		ch = newCapturedChannel(ch, opts.CaptureWriter)
+	}
+	if opts.MaxBytesPerSec > 0 {
+		ch = newThrottledChannel(ch, opts.MaxBytesPerSec)
+	}
+	return ch
+}
@@ -0,0 +1,177 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ErrUnknownChannelType is returned by SSHChannel for
+// a channel type that has no registered ChannelHandler.
+var ErrUnknownChannelType = fmt.Errorf("unknown channel type")
+
+// ChannelHandler opens a channel of one type on cli,
+// as asked for by req, and leaves the result in req
+// with SetChannel. It runs on the Tricorder's reconnect
+// loop goroutine, so it must not call back into the
+// Tricorder. ctx is done when the channel is closed by
+// the Tricorder; any goroutine serving the channel
+// should stop then. A handler must either set a channel
+// or return an error; returning nil without a channel
+// is reported to the caller as an error.
+type ChannelHandler func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error
+
+// ChannelRequest is what a ChannelHandler is asked
+// to open, as given to SSHChannel or SSHChannelOpts.
+type ChannelRequest struct {
+	tk *getChannelTicket
+}
+
+// Type returns the channel type asked for.
+func (r *ChannelRequest) Type() string {
+	return r.tk.typ
+}
+
+// Target returns the targetHostPort given to SSHChannel,
+// or "" if there was none.
+func (r *ChannelRequest) Target() string {
+	return r.tk.targetHostPort
+}
+
+// SocketPath returns the remote Unix socket for a
+// DirectStreamLocalChanName channel, or "".
+func (r *ChannelRequest) SocketPath() string {
+	return r.tk.socketPath
+}
+
+// Opts returns the ChannelOpts given, or nil if there
+// were none. Handlers should not modify them.
+func (r *ChannelRequest) Opts() *ChannelOpts {
+	return r.tk.opts
+}
+
+// Channel returns the channel set by SetChannel, or nil.
+func (r *ChannelRequest) Channel() ssh.Channel {
+	return r.tk.sshChannel
+}
+
+// SetChannel hands ch, the channel opened, back to
+// the Tricorder, which tracks it from then on.
+func (r *ChannelRequest) SetChannel(ch ssh.Channel) {
+	r.tk.sshChannel = ch
+}
+
+// RegisterChannelType makes SSHChannel open channels of
+// type typ with handler, replacing any earlier handler
+// for typ, the built-in ones included. A nil handler
+// removes typ.
+func (t *Tricorder) RegisterChannelType(typ string, handler ChannelHandler) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if handler == nil {
+		delete(t.channelTypes, typ)
+		return
+	}
+	t.channelTypes[typ] = handler
+}
+
+func (t *Tricorder) channelHandler(typ string) ChannelHandler {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.channelTypes[typ]
+}
+
+// registerBuiltinChannelTypes is called by NewTricorder.
+func (t *Tricorder) registerBuiltinChannelTypes() {
+	t.channelTypes = map[string]ChannelHandler{
+		"direct-tcpip":            t.openDirectTcp,
+		DirectStreamLocalChanName: t.openDirectStreamLocal,
+		InprocStreamChanName:      t.openPlainChannel,
+	}
+}
+
+func (t *Tricorder) openDirectTcp(ctx context.Context, req *ChannelRequest, cli *ssh.Client) (err error) {
+	hp := strings.Trim(req.Target(), "\n\r\t ")
+	opts := req.Opts()
+
+	if opts != nil && opts.LocalResolve {
+		hp, err = resolveHostPort(ctx, hp)
+		if err != nil {
+			return err
+		}
+	}
+	t.debug("dialing", "type", req.Type(), "target", hp)
+	ch, err := cli.DialWithContext(ctx, "tcp", hp)
+	req.SetChannel(ch)
+	if err != nil || opts == nil || opts.ProxyProtocol == 0 {
+		return err
+	}
+	hdr, err := proxyHeader(opts.ProxyProtocol, opts.ProxyOrigin, tcpAddrOf(hp))
+	if err == nil {
+		_, err = ch.Write(hdr)
+	}
+	return err
+}
+
+// resolveHostPort returns hostport with its host name,
+// if it has one rather than an IP, looked up here.
+func resolveHostPort(ctx context.Context, hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return hostport, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("could not resolve '%s'", host)
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), port), nil
+}
+
+func (t *Tricorder) openDirectStreamLocal(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error {
+	t.debug("dialing", "type", req.Type(), "socket", req.SocketPath())
+	ch, err := cli.DialWithContext(ctx, "unix", req.SocketPath())
+	req.SetChannel(ch)
+	return err
+}
+
+// openPlainChannel opens a channel of type req.Type(),
+// with ChannelOpts.OpenData as its extra data, answering
+// only keepalive requests on it, unless the ticket wants
+// the requests for itself.
+func (t *Tricorder) openPlainChannel(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error {
+	tk := req.tk
+	ch, in, err := cli.OpenChannel(tk.ctx, tk.typ, tk.opts.openData(), t.channelsHalt)
+	if err != nil {
+		return err
+	}
+	if tk.wantRequests {
+		tk.requests = in
+	} else {
+		go DiscardRequestsExceptKeepalives(ctx, in, t.channelsHalt.ReqStopChan())
+	}
+	tk.sshChannel = ch
+	return nil
+}
+
+// OpenChannelRaw opens a channel of type typ, with data
+// as its type-specific extra data, connecting first if
+// need be. Unlike SSHChannel, it sends typ and data as
+// they are, bypassing any ChannelHandler registered for
+// typ, and it hands back the channel's incoming requests,
+// such as "exit-status" or "window-change", for the
+// caller to answer; the caller must service them, or the
+// channel will stall. Otherwise it is SSHChannelAndRequests
+// with ChannelOpts.OpenData: the Tricorder tracks the
+// channel, and closes it on reconnect.
+func (t *Tricorder) OpenChannelRaw(ctx context.Context, typ string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	return t.getChannel(ctx, typ, "", &ChannelOpts{OpenData: data}, true, true)
+}
@@ -0,0 +1,111 @@
+package sshego
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test148ChannelWindowSize(t *testing.T) {
+	cv.Convey("The sshd should be let send, on a channel we never read, exactly the window we offered: 2MB by default, or the ChannelWindowSize we set.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// a consumer that writes to each stream until it
+		// blocks, counting what got through.
+		written := make(chan *int64, 1)
+		s.SrvCfg.ServeInprocStreams(func(ch ssh.Channel, sshconn ssh.Conn) {
+			defer ch.Close()
+			var n int64
+			written <- &n
+			buf := make([]byte, 1024)
+			for {
+				if _, err := ch.Write(buf); err != nil {
+					return
+				}
+				atomic.AddInt64(&n, int64(len(buf)))
+			}
+		})
+
+		sendable := func(window uint32) int64 {
+			dc := &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				LocalNickname:        "test148",
+			}
+			dc.SetChannelWindowSize(window)
+			tri, err := NewTricorder(dc, s.CliCfg.Halt, "test148")
+			panicOn(err)
+			defer tri.Halt.RequestStop()
+
+			ch, err := tri.InprocStream(context.Background())
+			panicOn(err)
+			defer ch.Close()
+
+			var n *int64
+			select {
+			case n = <-written:
+			case <-time.After(10 * time.Second):
+				panic("sshd never got our stream")
+			}
+			// wait for the sshd's writes to stall.
+			last := int64(-1)
+			for {
+				time.Sleep(200 * time.Millisecond)
+				cur := atomic.LoadInt64(n)
+				if cur == last {
+					return cur
+				}
+				last = cur
+			}
+		}
+
+		cv.So(sendable(0), cv.ShouldEqual, 2<<20)
+		cv.So(sendable(16<<20), cv.ShouldEqual, 16<<20)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test149ChannelSizesAreChecked(t *testing.T) {
+	cv.Convey("DialConfig.Validate should refuse an out of range ChannelMaxPacket, or a ChannelWindowSize smaller than a packet.", t, func() {
+		keyFile, err := ioutil.TempFile("", "test149.id_rsa")
+		panicOn(err)
+		keyFile.Close()
+		defer os.Remove(keyFile.Name())
+
+		dc := &DialConfig{
+			Mylogin:  "user",
+			Sshdhost: "127.0.0.1",
+			Sshdport: 22,
+			RsaPath:  keyFile.Name(),
+		}
+		cv.So(dc.Validate(), cv.ShouldBeNil)
+
+		dc.SetChannelMaxPacket(MaxChannelMaxPacket + 1)
+		cv.So(dc.Validate(), cv.ShouldNotBeNil)
+
+		dc.SetChannelMaxPacket(64 << 10)
+		cv.So(dc.Validate(), cv.ShouldBeNil)
+
+		dc.SetChannelWindowSize(32 << 10)
+		cv.So(dc.Validate(), cv.ShouldNotBeNil)
+
+		dc.SetChannelWindowSize(16 << 20)
+		cv.So(dc.Validate(), cv.ShouldBeNil)
+	})
+}
@@ -3,8 +3,10 @@ package sshego
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -40,6 +42,21 @@ type DialConfig struct {
 	// which to read the client's RSA private key.
 	RsaPath string
 
+	// AgentSigners, if not empty, are offered for
+	// public key authentication instead of the key
+	// at RsaPath. WithSSHAgentSocket fills them in
+	// from a running ssh-agent.
+	AgentSigners []ssh.Signer
+
+	// agentConn is the ssh-agent connection behind
+	// AgentSigners. See CloseAgent.
+	agentConn net.Conn
+
+	// FallbackAuth are further auth methods, tried in
+	// order after those made from RsaPath, AgentSigners,
+	// Pw, and TotpUrl. See WithFallbackAuth.
+	FallbackAuth []ssh.AuthMethod
+
 	// the time-based one-time password configuration
 	TotpUrl string
 
@@ -88,6 +105,110 @@ type DialConfig struct {
 
 	KeepAliveEvery time.Duration // default 1 second
 
+	// ConnIdleTimeout is passed through to
+	// SshegoConfig.ConnIdleTimeout.
+	ConnIdleTimeout time.Duration
+
+	// DialTimeout, if > 0, bounds each of a Tricorder's
+	// connection attempts, from the TCP dial through
+	// authentication, so that a black-holed sshd costs
+	// one timeout per retry rather than hanging.
+	DialTimeout time.Duration
+
+	// MaxConnLifetime, if > 0, has a Tricorder replace
+	// its connection once it is this old, busy or not.
+	// The new connection is made before the old one is
+	// closed; channels on the old one are closed with it.
+	MaxConnLifetime time.Duration
+
+	// MaxChannels, if > 0, caps how many channels a
+	// Tricorder has open at once. SSHChannel fails with
+	// ErrTooManyChannels while the cap is reached.
+	MaxChannels int
+
+	// ProxyProtocol, if 1 or 2, has the channels a
+	// Tricorder opens for ListenAndForward, Splice and
+	// StartLocalSOCKS5/ServeSOCKS5 begin with a PROXY
+	// protocol header of that version, naming the
+	// connection accepted as the source. See
+	// ChannelOpts.ProxyProtocol.
+	ProxyProtocol int
+
+	// ReconnectDebounce is how long, after a successful
+	// connect, a Tricorder ignores further requests to
+	// reconnect. Defaults to 1 second.
+	ReconnectDebounce time.Duration
+
+	// CompressionLevel is passed through to
+	// SshegoConfig.CompressionLevel.
+	CompressionLevel int
+
+	// Ciphers is passed through to SshegoConfig.Ciphers.
+	// DeriveNewConfig rejects names that xcryptossh
+	// does not know.
+	Ciphers []string
+
+	// MACs is passed through to SshegoConfig.MACs,
+	// and checked like Ciphers.
+	MACs []string
+
+	// KexAlgorithms is passed through to
+	// SshegoConfig.KexAlgorithms, and checked like
+	// Ciphers. ModernKexAlgorithms is a safe choice.
+	KexAlgorithms []string
+
+	// ClientVersion, if set, replaces the version string
+	// (banner) we send the sshd. It must look like
+	// "SSH-2.0-softwareversion", optionally followed by
+	// a space and comments, all printable ASCII.
+	ClientVersion string
+
+	// RekeyBytesThreshold is passed through to
+	// SshegoConfig.RekeyBytesThreshold.
+	RekeyBytesThreshold uint64
+
+	// RekeyTimeThreshold is passed through to
+	// SshegoConfig.RekeyTimeThreshold.
+	RekeyTimeThreshold time.Duration
+
+	// ChannelWindowSize is passed through to
+	// SshegoConfig.ChannelWindowSize. See also
+	// SetChannelWindowSize.
+	ChannelWindowSize uint32
+
+	// ChannelMaxPacket is passed through to
+	// SshegoConfig.ChannelMaxPacket. See also
+	// SetChannelMaxPacket.
+	ChannelMaxPacket uint32
+
+	// TCPNoDelay is passed through to SshegoConfig.TCPNoDelay.
+	TCPNoDelay bool
+
+	// TCPKeepAlive is passed through to
+	// SshegoConfig.TCPKeepAlive.
+	TCPKeepAlive time.Duration
+
+	// LocalBindIP, if set, is the source IP our TCP
+	// connection to the sshd originates from, for
+	// multi-homed hosts. It must be one of this host's
+	// addresses. A custom Dialer ignores it.
+	LocalBindIP string
+
+	// Dialer is passed through to SshegoConfig.Dialer.
+	// Custom dialers are responsible for their own
+	// sockets: the TCP options above are applied only
+	// when the Dialer returns a *net.TCPConn.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// LazyConnect has NewTricorder skip its initial
+	// dial, so construction succeeds even when the
+	// sshd is down. The first Cli or SSHChannel
+	// call connects instead.
+	LazyConnect bool
+
+	// Logger is passed through to SshegoConfig.Logger.
+	Logger Logger
+
 	// identify who is calling.
 	LocalNickname string
 
@@ -114,16 +235,58 @@ type DialConfig struct {
 // authentication.
 //
 
+// DeriveNewConfig makes a new SshegoConfig from dc. It
+// leaves dc alone, and the result shares nothing mutable
+// with dc or with any other config derived from it:
+// dc.KnownHosts is cloned, or if nil a fresh KnownHosts is
+// read from ClientKnownHostsPath, and slices are copied.
 func (dc *DialConfig) DeriveNewConfig() (cfg *SshegoConfig, err error) {
 
 	cfg = NewSshegoConfig()
 	cfg.Nickname = dc.LocalNickname
+	cfg.DestNickname = dc.DestNickname
 	cfg.BitLenRSAkeys = 4096
 	cfg.DirectTcp = true
 	cfg.AddIfNotKnown = dc.TofuAddIfNotKnown
 	cfg.Debug = dc.Verbose
 	cfg.TestAllowOneshotConnect = dc.TestAllowOneshotConnect
 	cfg.IdleTimeoutDur = 5 * time.Second
+	cfg.ConnIdleTimeout = dc.ConnIdleTimeout
+	cfg.CompressionLevel = dc.CompressionLevel
+	err = dc.checkAlgorithms()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Ciphers = append([]string(nil), dc.Ciphers...)
+	cfg.MACs = append([]string(nil), dc.MACs...)
+	cfg.KexAlgorithms = append([]string(nil), dc.KexAlgorithms...)
+	err = checkClientVersion(dc.ClientVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientVersion = dc.ClientVersion
+	err = checkRekeyThresholds(dc.RekeyBytesThreshold, dc.RekeyTimeThreshold)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RekeyBytesThreshold = dc.RekeyBytesThreshold
+	cfg.RekeyTimeThreshold = dc.RekeyTimeThreshold
+	err = checkChannelSizes(dc.ChannelWindowSize, dc.ChannelMaxPacket)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ChannelWindowSize = dc.ChannelWindowSize
+	cfg.ChannelMaxPacket = dc.ChannelMaxPacket
+	cfg.TCPNoDelay = dc.TCPNoDelay
+	cfg.TCPKeepAlive = dc.TCPKeepAlive
+	err = checkLocalBindIP(dc.LocalBindIP)
+	if err != nil {
+		return nil, err
+	}
+	cfg.LocalBindIP = dc.LocalBindIP
+	cfg.Dialer = dc.Dialer
+	cfg.Logger = dc.Logger
+	cfg.SkipKeepAlive = dc.SkipKeepAlive
 	if !dc.SkipKeepAlive {
 		if dc.KeepAliveEvery <= 0 {
 			cfg.KeepAliveEvery = time.Second // default to 1 sec.
@@ -134,18 +297,299 @@ func (dc *DialConfig) DeriveNewConfig() (cfg *SshegoConfig, err error) {
 
 	p("DialConfig.Dial: dc= %#v\n", dc)
 	if dc.KnownHosts == nil {
-		dc.KnownHosts, err = NewKnownHosts(dc.ClientKnownHostsPath, KHSsh)
+		cfg.KnownHosts, err = NewKnownHosts(dc.ClientKnownHostsPath, KHSsh)
 		if err != nil {
 			return nil, err
 		}
-		p("after NewKnownHosts: DialConfig.Dial: dc.KnownHosts = %#v\n", dc.KnownHosts)
-		dc.KnownHosts.NoSave = dc.DoNotUpdateSshKnownHosts
+		p("after NewKnownHosts: DialConfig.Dial: cfg.KnownHosts = %#v\n", cfg.KnownHosts)
+		cfg.KnownHosts.NoSave = dc.DoNotUpdateSshKnownHosts
+	} else {
+		cfg.KnownHosts = dc.KnownHosts.Clone()
 	}
-	cfg.KnownHosts = dc.KnownHosts
 	cfg.PrivateKeyPath = dc.RsaPath
+	cfg.AgentSigners = append([]ssh.Signer(nil), dc.AgentSigners...)
+	cfg.FallbackAuth = append([]ssh.AuthMethod(nil), dc.FallbackAuth...)
 	return cfg, nil
 }
 
+// Key exchange algorithm names, for DialConfig.KexAlgorithms.
+const (
+	KexCurve25519SHA256       = "curve25519-sha256"
+	KexCurve25519SHA256LibSSH = "curve25519-sha256@libssh.org"
+	KexECDHP256               = "ecdh-sha2-nistp256"
+	KexECDHP384               = "ecdh-sha2-nistp384"
+	KexECDHP521               = "ecdh-sha2-nistp521"
+	KexDHGroup14SHA1          = "diffie-hellman-group14-sha1"
+	KexDHGroup1SHA1           = "diffie-hellman-group1-sha1"
+)
+
+// ModernKexAlgorithms leaves out the finite field
+// Diffie-Hellman groups; group1 in particular
+// is too small to be safe.
+var ModernKexAlgorithms = []string{
+	KexCurve25519SHA256,
+	KexCurve25519SHA256LibSSH,
+	KexECDHP256,
+	KexECDHP384,
+	KexECDHP521,
+}
+
+// checkAlgorithms returns an error if dc asks
+// for any algorithm xcryptossh cannot negotiate.
+func (dc *DialConfig) checkAlgorithms() error {
+	for _, c := range dc.Ciphers {
+		if !ssh.IsKnownCipher(c) {
+			return fmt.Errorf("DialConfig.Ciphers: unknown cipher '%s'", c)
+		}
+	}
+	for _, m := range dc.MACs {
+		if !ssh.IsKnownMAC(m) {
+			return fmt.Errorf("DialConfig.MACs: unknown MAC '%s'", m)
+		}
+	}
+	for _, k := range dc.KexAlgorithms {
+		if !ssh.IsKnownKeyExchange(k) {
+			return fmt.Errorf("DialConfig.KexAlgorithms: unknown key exchange '%s'", k)
+		}
+	}
+	return nil
+}
+
+// checkClientVersion returns an error unless v is empty
+// or a valid RFC 4253 identification string, less the
+// trailing CR LF.
+func checkClientVersion(v string) error {
+	if v == "" {
+		return nil
+	}
+	const prefix = "SSH-2.0-"
+	if !strings.HasPrefix(v, prefix) || len(v) == len(prefix) || v[len(prefix)] == ' ' {
+		return fmt.Errorf("DialConfig.ClientVersion '%s' does not look like 'SSH-2.0-softwareversion'", v)
+	}
+	if len(v) > 253 {
+		return fmt.Errorf("DialConfig.ClientVersion is %d bytes long; at most 253 allowed", len(v))
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < ' ' || v[i] > '~' {
+			return fmt.Errorf("DialConfig.ClientVersion %q has a byte that is not printable ASCII", v)
+		}
+	}
+	return nil
+}
+
+// MinRekeyBytesThreshold and MinRekeyTimeThreshold are
+// the least RekeyBytesThreshold and RekeyTimeThreshold
+// we accept. Below them the connection would spend more
+// time rekeying than moving data.
+const (
+	MinRekeyBytesThreshold = 64 << 10
+	MinRekeyTimeThreshold  = time.Second
+)
+
+// checkRekeyThresholds returns an error if either
+// threshold is set but below its minimum.
+func checkRekeyThresholds(bytes uint64, dur time.Duration) error {
+	if bytes > 0 && bytes < MinRekeyBytesThreshold {
+		return fmt.Errorf("RekeyBytesThreshold %d is below the minimum of %d", bytes, MinRekeyBytesThreshold)
+	}
+	if dur < 0 || (dur > 0 && dur < MinRekeyTimeThreshold) {
+		return fmt.Errorf("RekeyTimeThreshold %v is below the minimum of %v", dur, MinRekeyTimeThreshold)
+	}
+	return nil
+}
+
+// MinChannelMaxPacket and MaxChannelMaxPacket bound
+// ChannelMaxPacket. The largest packet must fit, with
+// its header, in one transport packet.
+const (
+	MinChannelMaxPacket = 1 << 10
+	MaxChannelMaxPacket = ssh.MaxChannelMaxPacket
+)
+
+// checkChannelSizes returns an error if maxPacket is set
+// but out of bounds, or if window is set but smaller
+// than the packets it must hold.
+func checkChannelSizes(window, maxPacket uint32) error {
+	if maxPacket > 0 && (maxPacket < MinChannelMaxPacket || maxPacket > MaxChannelMaxPacket) {
+		return fmt.Errorf("ChannelMaxPacket %d is outside [%d, %d]", maxPacket, MinChannelMaxPacket, MaxChannelMaxPacket)
+	}
+	least := maxPacket
+	if least == 0 {
+		least = 32 << 10
+	}
+	if window > 0 && window < least {
+		return fmt.Errorf("ChannelWindowSize %d is below the max packet size of %d", window, least)
+	}
+	return nil
+}
+
+// SetChannelWindowSize sets ChannelWindowSize, the
+// flow-control window we offer the sshd on each
+// channel. Big windows, say 16MB, keep high-latency
+// links from stalling while a window adjustment
+// makes its round trip.
+func (dc *DialConfig) SetChannelWindowSize(bytes uint32) {
+	dc.ChannelWindowSize = bytes
+}
+
+// SetChannelMaxPacket sets ChannelMaxPacket, the largest
+// data packet the sshd may send us on a channel.
+func (dc *DialConfig) SetChannelMaxPacket(bytes uint32) {
+	dc.ChannelMaxPacket = bytes
+}
+
+// WithFallbackAuth returns a copy of dc that, should the
+// sshd turn down our key, goes on to offer methods, in
+// order; say ssh.Password or ssh.KeyboardInteractive for
+// a server that takes only passwords. Each call adds to
+// the methods from earlier calls.
+func (dc *DialConfig) WithFallbackAuth(methods ...ssh.AuthMethod) *DialConfig {
+	c := *dc
+	c.FallbackAuth = append(append([]ssh.AuthMethod(nil), dc.FallbackAuth...), methods...)
+	return &c
+}
+
+// checkLocalBindIP returns an error unless ip is empty,
+// or a loopback address, or an address of one of
+// our network interfaces.
+func checkLocalBindIP(ip string) error {
+	if ip == "" {
+		return nil
+	}
+	want := net.ParseIP(ip)
+	if want == nil {
+		return fmt.Errorf("DialConfig.LocalBindIP '%s' is not an IP address", ip)
+	}
+	if want.IsLoopback() {
+		return nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("DialConfig.LocalBindIP: could not list interface addresses: %v", err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("DialConfig.LocalBindIP '%s' is not an address of this host", ip)
+}
+
+// DialConfigError lists everything Validate
+// found wrong with a DialConfig.
+type DialConfigError struct {
+	Problems []string
+}
+
+func (e *DialConfigError) Error() string {
+	return "invalid DialConfig: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks, before any dialing, that dc has what
+// a connection needs: a login, a plausible host, a port
+// in range, a key from RsaPath or AgentSigners, a
+// readable key file if one is named, and algorithms we
+// know. Pw, TotpUrl, and FallbackAuth are offered on top
+// of the key, never instead of it. Validate returns a
+// *DialConfigError listing every problem found, or nil.
+func (dc *DialConfig) Validate() error {
+	var probs []string
+	if dc.Mylogin == "" {
+		probs = append(probs, "Mylogin is empty")
+	}
+	switch {
+	case dc.Sshdhost == "":
+		probs = append(probs, "Sshdhost is empty")
+	case strings.ContainsAny(dc.Sshdhost, " \t\r\n/@"):
+		probs = append(probs, fmt.Sprintf("Sshdhost '%s' is not a valid host", dc.Sshdhost))
+	}
+	if dc.Sshdport < 1 || dc.Sshdport > 65535 {
+		probs = append(probs, fmt.Sprintf("Sshdport %d is out of range 1-65535", dc.Sshdport))
+	}
+	if dc.RsaPath == "" && len(dc.AgentSigners) == 0 {
+		probs = append(probs, "no key: set RsaPath or AgentSigners")
+	}
+	if dc.RsaPath != "" {
+		f, err := os.Open(dc.RsaPath)
+		if err != nil {
+			probs = append(probs, fmt.Sprintf("RsaPath '%s' is not readable: %v", dc.RsaPath, err))
+		} else {
+			f.Close()
+		}
+	}
+	if dc.ProxyProtocol < 0 || dc.ProxyProtocol > 2 {
+		probs = append(probs, fmt.Sprintf("ProxyProtocol %d; want 0, 1 or 2", dc.ProxyProtocol))
+	}
+	if err := dc.checkAlgorithms(); err != nil {
+		probs = append(probs, strings.TrimPrefix(err.Error(), "DialConfig."))
+	}
+	if err := checkClientVersion(dc.ClientVersion); err != nil {
+		probs = append(probs, strings.TrimPrefix(err.Error(), "DialConfig."))
+	}
+	if err := checkLocalBindIP(dc.LocalBindIP); err != nil {
+		probs = append(probs, strings.TrimPrefix(err.Error(), "DialConfig."))
+	}
+	if err := checkRekeyThresholds(dc.RekeyBytesThreshold, dc.RekeyTimeThreshold); err != nil {
+		probs = append(probs, err.Error())
+	}
+	if err := checkChannelSizes(dc.ChannelWindowSize, dc.ChannelMaxPacket); err != nil {
+		probs = append(probs, err.Error())
+	}
+	if len(probs) > 0 {
+		return &DialConfigError{Problems: probs}
+	}
+	return nil
+}
+
+// inheritFrom returns a copy of dc with its empty
+// credential and timing fields filled in from parent.
+// The copy's KnownHosts and slices are its own, so
+// changing them leaves dc and parent untouched.
+func (dc *DialConfig) inheritFrom(parent *DialConfig) *DialConfig {
+	c := *dc
+	if c.KnownHosts != nil {
+		c.KnownHosts = c.KnownHosts.Clone()
+	}
+	c.AgentSigners = append([]ssh.Signer(nil), c.AgentSigners...)
+	// dc's owner, not the copy, closes the agent.
+	c.agentConn = nil
+	c.FallbackAuth = append([]ssh.AuthMethod(nil), c.FallbackAuth...)
+	c.Ciphers = append([]string(nil), c.Ciphers...)
+	c.MACs = append([]string(nil), c.MACs...)
+	c.KexAlgorithms = append([]string(nil), c.KexAlgorithms...)
+	if c.ClientKnownHostsPath == "" {
+		c.ClientKnownHostsPath = parent.ClientKnownHostsPath
+	}
+	if c.Mylogin == "" {
+		c.Mylogin = parent.Mylogin
+	}
+	if c.RsaPath == "" {
+		c.RsaPath = parent.RsaPath
+	}
+	if c.TotpUrl == "" {
+		c.TotpUrl = parent.TotpUrl
+	}
+	if c.Pw == "" {
+		c.Pw = parent.Pw
+	}
+	if c.Sshdhost == "" {
+		c.Sshdhost = parent.Sshdhost
+	}
+	if c.Sshdport == 0 {
+		c.Sshdport = parent.Sshdport
+	}
+	if c.KeepAliveEvery == 0 {
+		c.KeepAliveEvery = parent.KeepAliveEvery
+	}
+	if c.ConnIdleTimeout == 0 {
+		c.ConnIdleTimeout = parent.ConnIdleTimeout
+	}
+	if c.ReconnectDebounce == 0 {
+		c.ReconnectDebounce = parent.ReconnectDebounce
+	}
+	return &c
+}
+
 // cfg0 can be nil, in which case we will make
 // a new SshegoConfig and return it in cfg. If
 // cfg0 is not nil, then we use it and return
@@ -157,9 +601,18 @@ func (dc *DialConfig) Dial(parCtx context.Context, cfg0 *SshegoConfig, skipDowns
 		if err != nil {
 			return
 		}
+		if dc.KnownHosts != nil {
+			// a one-off Dial learns new hosts into the
+			// caller's KnownHosts, not into a clone.
+			cfg.KnownHosts = dc.KnownHosts
+		}
 	} else {
 		cfg = cfg0
 	}
+	kh := cfg.KnownHosts
+	if kh == nil {
+		kh = dc.KnownHosts
+	}
 	p("about to SSHConnect to dc.Sshdhost='%s'", dc.Sshdhost)
 	p("  ...and SSHConnect called on cfg = '%#v'\n", cfg)
 
@@ -176,7 +629,7 @@ func (dc *DialConfig) Dial(parCtx context.Context, cfg0 *SshegoConfig, skipDowns
 		// the 2nd argument is the underlying most-basic
 		// TCP net.Conn. We don't need to retrieve here since
 		// ctx or cfg.Halt will close it for us if need be.
-		sshClient, _, err = cfg.SSHConnect(ctx, dc.KnownHosts,
+		sshClient, _, err = cfg.SSHConnect(ctx, kh,
 			dc.Mylogin, dc.RsaPath, dc.Sshdhost, dc.Sshdport,
 			dc.Pw, dc.TotpUrl, childHalt)
 		if err == nil {
@@ -189,7 +642,7 @@ func (dc *DialConfig) Dial(parCtx context.Context, cfg0 *SshegoConfig, skipDowns
 			cancelctx()
 			childHalt.RequestStop()
 			childHalt.MarkDone()
-			if strings.Contains(err.Error(), "getsockopt: connection refused") {
+			if isConnRefused(err) {
 				// simple connection error, just try again in a bit
 				time.Sleep(10 * time.Millisecond)
 				continue
@@ -276,6 +729,16 @@ func (cfg *SshegoConfig) startKeepalives(ctx context.Context, dur time.Duration,
 
 	responseStatus, responsePayload, err := sshClientConn.SendRequest(ctx, "keepalive@sshego.glycerine.github.com", true, pingBy)
 	if err != nil {
+		if err == io.EOF {
+			// if the sshd hung up on us, its reason, such
+			// as a connection limit, says more than EOF.
+			waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+			werr := sshClientConn.WaitContext(waitCtx)
+			if waitCtx.Err() == nil && werr != nil {
+				err = werr
+			}
+			cancel()
+		}
 		return err
 	}
 	//pp("startKeepalives: have responseStatus: '%v'", responseStatus)
@@ -303,9 +766,16 @@ func (cfg *SshegoConfig) startKeepalives(ctx context.Context, dur time.Duration,
 				responseStatus, responsePayload, err := sshClientConn.SendRequest(
 					ctx, "keepalive@sshego.glycerine.github.com", true, pingBy)
 				if err != nil {
+					if sshClientConn.Halt.IsStopRequested() {
+						// closed on purpose, e.g. for being
+						// idle; don't ask for a reconnect.
+						return
+					}
 					log.Printf("%s startKeepalives: keepalive send error: '%v', notifying reconnect needed to '%#v'", cfg.Nickname, err, uhp)
 					// notify here
-					cfg.ClientReconnectNeededTower.Broadcast(uhp)
+					if cfg.ClientReconnectNeededTower.Publish(uhp) == 0 {
+						log.Printf("%s startKeepalives: warning: no one is subscribed to hear that reconnect is needed to '%#v'", cfg.Nickname, uhp)
+					}
 					//pp("SshegoConfig.startKeepalives() goroutine exiting!")
 					return
 				}
@@ -377,7 +847,11 @@ func customHandleGlobalRequests(ctx context.Context, sshCli *ssh.Client, incomin
 
 	for {
 		select {
-		case r := <-incoming:
+		case r, ok := <-incoming:
+			if !ok {
+				// the connection's read loop is gone.
+				return
+			}
 			if r == nil {
 				continue
 			}
@@ -3,7 +3,9 @@ package sshego
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 	"testing"
 
@@ -102,3 +104,134 @@ func Test201ClientDirectSSH(t *testing.T) {
 		cv.So(true, cv.ShouldEqual, true) // we should get here.
 	})
 }
+
+func Test202DialConfigValidate(t *testing.T) {
+	cv.Convey("DialConfig.Validate should name every missing or malformed field, and NewTricorder should return its error rather than panic later.", t, func() {
+
+		keyFile, err := ioutil.TempFile("", "test202.id_rsa")
+		panicOn(err)
+		keyFile.Close()
+		defer os.Remove(keyFile.Name())
+
+		good := func() *DialConfig {
+			return &DialConfig{
+				Mylogin:  "bob",
+				RsaPath:  keyFile.Name(),
+				Pw:       "pw",
+				Sshdhost: "127.0.0.1",
+				Sshdport: 22,
+			}
+		}
+		cv.So(good().Validate(), cv.ShouldBeNil)
+
+		problems := func(dc *DialConfig) []string {
+			err := dc.Validate()
+			if err == nil {
+				return nil
+			}
+			return err.(*DialConfigError).Problems
+		}
+
+		dc := good()
+		dc.Mylogin = ""
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Mylogin is empty"})
+
+		dc = good()
+		dc.Sshdhost = ""
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Sshdhost is empty"})
+
+		dc = good()
+		dc.Sshdhost = "bob@example.com"
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Sshdhost 'bob@example.com' is not a valid host"})
+
+		dc = good()
+		dc.Sshdport = 0
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Sshdport 0 is out of range 1-65535"})
+		dc.Sshdport = 70000
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Sshdport 70000 is out of range 1-65535"})
+
+		// a password alone won't do; NewTricorder always
+		// offers a key.
+		dc = good()
+		dc.RsaPath = ""
+		cv.So(problems(dc), cv.ShouldResemble, []string{"no key: set RsaPath or AgentSigners"})
+		dc.TotpUrl = "otpauth://totp/bob?secret=JBSWY3DPEHPK3PXP"
+		dc.FallbackAuth = []ssh.AuthMethod{ssh.Password("pw")}
+		cv.So(problems(dc), cv.ShouldResemble, []string{"no key: set RsaPath or AgentSigners"})
+
+		dc = good()
+		dc.RsaPath = "/no/such/id_rsa"
+		probs := problems(dc)
+		cv.So(len(probs), cv.ShouldEqual, 1)
+		cv.So(probs[0], cv.ShouldStartWith, "RsaPath '/no/such/id_rsa' is not readable: ")
+
+		dc = good()
+		dc.Ciphers = []string{"rot13"}
+		cv.So(problems(dc), cv.ShouldResemble, []string{"Ciphers: unknown cipher 'rot13'"})
+
+		// everything at once, in field order.
+		dc = &DialConfig{Sshdport: -1}
+		err = dc.Validate()
+		cv.So(err.Error(), cv.ShouldEqual, "invalid DialConfig: Mylogin is empty; "+
+			"Sshdhost is empty; Sshdport -1 is out of range 1-65535; "+
+			"no key: set RsaPath or AgentSigners")
+
+		tri, err := NewTricorder(dc, nil, "test202")
+		cv.So(tri, cv.ShouldBeNil)
+		_, ok := err.(*DialConfigError)
+		cv.So(ok, cv.ShouldBeTrue)
+	})
+}
+
+func Test153WithFallbackAuth(t *testing.T) {
+	cv.Convey("DialConfig.WithFallbackAuth should let a key-first client log in to an esshd that takes only a password, by falling back to the password.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// password only: the esshd asks for it by
+		// keyboard-interactive, and takes no keys.
+		s.SrvCfg.Mut.Lock()
+		s.SrvCfg.SkipRSA = true
+		s.SrvCfg.SkipTOTP = true
+		s.SrvCfg.Mut.Unlock()
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test153",
+		}
+
+		// the key alone is refused.
+		_, _, _, err := dc.Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		pw := func(answer string) ssh.AuthMethod {
+			return ssh.KeyboardInteractive(func(ctx context.Context, user, instruction string, questions []string, echos []bool) ([]string, error) {
+				ans := make([]string, len(questions))
+				for i := range ans {
+					ans[i] = answer
+				}
+				return ans, nil
+			})
+		}
+		fdc := dc.WithFallbackAuth(pw("wrong"))
+		cv.So(len(dc.FallbackAuth), cv.ShouldEqual, 0)
+		_, _, _, err = fdc.Dial(context.Background(), nil, true)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		fdc = dc.WithFallbackAuth(pw(s.Pw))
+		tri, err := NewTricorder(fdc, s.CliCfg.Halt, "test153")
+		panicOn(err)
+		_, err = tri.Cli()
+		cv.So(err, cv.ShouldBeNil)
+		tri.Halt.RequestStop()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
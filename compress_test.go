@@ -0,0 +1,155 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test075CompressionLevel(t *testing.T) {
+	cv.Convey("With DialConfig.CompressionLevel > 0 our client should prefer zlib@openssh.com, and channels should still work end to end. An esshd without CompressionLevel should not compress.", t, func() {
+
+		cfg := NewSshegoConfig()
+		c := cfg.clientSSHConfig(nil)
+		cv.So(c.Compressions, cv.ShouldBeNil)
+
+		dc := &DialConfig{CompressionLevel: 6}
+		cfg, err := dc.DeriveNewConfig()
+		panicOn(err)
+		c = cfg.clientSSHConfig(nil)
+		cv.So(c.Compressions, cv.ShouldResemble, []string{"zlib@openssh.com", "none"})
+		cv.So(c.CompressionLevel, cv.ShouldEqual, 6)
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc = &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			CompressionLevel:     6,
+			LocalNickname:        "test075",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test075")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+		algs := cli.NegotiatedAlgorithms()
+		cv.So(algs.CompressionClientServer, cv.ShouldEqual, "none")
+		cv.So(algs.CompressionServerClient, cv.ShouldEqual, "none")
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+// BenchmarkCompression sends 10 MB of compressible
+// data through the sshd with and without compression,
+// and reports the ratio of the two throughputs.
+func BenchmarkCompression(b *testing.B) {
+	const size = 10 << 20
+	payload := bytes.Repeat([]byte("sshego compressible payload. "), size/29+1)[:size]
+
+	// the sink reads the whole payload, then acks with one byte.
+	lsn, port := GetAvailPort()
+	defer lsn.Close()
+	go func() {
+		for {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, err := io.CopyN(io.Discard, conn, size)
+				if err == nil {
+					conn.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+	dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+	s := MakeTestSshClientAndServer(false)
+	s.SrvCfg.CompressionLevel = 6
+	s.SrvCfg.Esshd.Start(context.Background())
+	defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+	defer s.SrvCfg.Esshd.Stop()
+
+	newTri := func(level int) *Tricorder {
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			CompressionLevel:     level,
+			LocalNickname:        fmt.Sprintf("bench-compress-%v", level),
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, dc.LocalNickname)
+		panicOn(err)
+		return tri
+	}
+	plain := newTri(0)
+	defer plain.Halt.RequestStop()
+	zipped := newTri(6)
+	defer zipped.Halt.RequestStop()
+
+	send := func(tri *Tricorder) time.Duration {
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		defer ch.Close()
+		t0 := time.Now()
+		_, err = ch.Write(payload)
+		panicOn(err)
+		ack := make([]byte, 1)
+		_, err = io.ReadFull(ch, ack)
+		panicOn(err)
+		return time.Since(t0)
+	}
+
+	var plainTot, zippedTot time.Duration
+	b.SetBytes(2 * size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		plainTot += send(plain)
+		zippedTot += send(zipped)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(plainTot)/float64(zippedTot), "zlib/none-throughput")
+}
@@ -2,6 +2,7 @@ package sshego
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -21,17 +22,103 @@ type SshegoConfig struct {
 	Nickname string
 	Halt     *ssh.Halter
 
+	// DestNickname names the sshd we log into, and is
+	// carried in the UHP of reconnect notices.
+	DestNickname string
+
 	KeepAliveEvery time.Duration // default 1 second.
 	SkipKeepAlive  bool
 
 	IdleTimeoutDur time.Duration
 
+	// ConnIdleTimeout, if > 0, has a Tricorder close its
+	// whole client connection once none of its channels
+	// has seen traffic for this long. The connection is
+	// re-established on the next SSHChannel request.
+	ConnIdleTimeout time.Duration
+
+	// CompressionLevel, if 1-9, has the client ask for
+	// zlib@openssh.com compression at that zlib level,
+	// and lets the esshd's clients ask for it too.
+	// The default, 0, means no compression either way.
+	CompressionLevel int
+
+	// Ciphers, if not empty, replaces our default
+	// client cipher list, in preference order.
+	Ciphers []string
+
+	// MACs, if not empty, replaces the default
+	// client MAC list, in preference order.
+	MACs []string
+
+	// KexAlgorithms, if not empty, replaces the default
+	// client key exchange list, in preference order.
+	KexAlgorithms []string
+
+	// ClientVersion, if set, is the version string
+	// our ssh client sends. See DialConfig.ClientVersion.
+	ClientVersion string
+
+	// RekeyBytesThreshold, if > 0, is how many bytes may
+	// pass in either direction before a new key is
+	// negotiated; at least MinRekeyBytesThreshold. The
+	// default, 0, lets xcryptossh pick per cipher.
+	RekeyBytesThreshold uint64
+
+	// RekeyTimeThreshold, if > 0, is how long a key is
+	// used before a new one is negotiated, busy or not;
+	// at least MinRekeyTimeThreshold.
+	RekeyTimeThreshold time.Duration
+
+	// ChannelWindowSize, if > 0, is the flow-control window
+	// offered the peer on each channel, in bytes, by our
+	// client and by our esshd. The default is 2MB, which
+	// stalls high-latency links; try 16MB there.
+	ChannelWindowSize uint32
+
+	// ChannelMaxPacket, if > 0, is the largest data packet
+	// the peer may send on a channel; between
+	// MinChannelMaxPacket and MaxChannelMaxPacket. The
+	// default is 32KB.
+	ChannelMaxPacket uint32
+
+	// TCPNoDelay, if true, disables Nagle's algorithm on
+	// the socket to the sshd and on the TCP connections
+	// we forward, for lower latency.
+	TCPNoDelay bool
+
+	// TCPKeepAlive, if > 0, turns on TCP keepalive at this
+	// period on the same sockets as TCPNoDelay. It is
+	// separate from KeepAliveEvery, which is ssh level.
+	TCPKeepAlive time.Duration
+
+	// LocalBindIP, if set, is the local IP we dial
+	// the sshd from. See DialConfig.LocalBindIP.
+	LocalBindIP string
+
+	// Dialer, if set, replaces net.Dialer for reaching
+	// the sshd. TCPNoDelay and TCPKeepAlive are applied
+	// to what it returns only if that is a *net.TCPConn;
+	// a Dialer returning anything else is responsible
+	// for its own socket options.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Logger, if set, receives a Tricorder's log
+	// events. The default, nil, discards them.
+	Logger Logger
+
 	ConfigPath string
 
 	SSHdServer    AddrHostPort // the sshd host we are logging into remotely.
 	LocalToRemote TunnelSpec
 	RemoteToLocal TunnelSpec
 
+	// ProxyProtocol, if 1 or 2, has each -listen
+	// connection we forward begin with a PROXY protocol
+	// header of that version, naming the connection's
+	// remote end as the source, for -remote to read.
+	ProxyProtocol int
+
 	Debug bool
 
 	AddIfNotKnown bool
@@ -44,6 +131,14 @@ type SshegoConfig struct {
 	TotpUrl string
 	Pw      string
 
+	// AgentSigners, if not empty, are used for public
+	// key authentication in place of PrivateKeyPath.
+	AgentSigners []ssh.Signer
+
+	// FallbackAuth are offered, in order, after our
+	// other auth methods. See DialConfig.WithFallbackAuth.
+	FallbackAuth []ssh.AuthMethod
+
 	KnownHosts *KnownHosts
 
 	WriteConfigOut string
@@ -73,6 +168,67 @@ type SshegoConfig struct {
 	SkipPassphrase bool
 	SkipRSA        bool
 
+	// MaxAuthFailures, if > 0, locks out a source IP
+	// or a user after that many failed logins to the
+	// embedded sshd. The lockout lasts for LockoutDuration,
+	// which defaults to DefaultLockoutDuration if zero.
+	MaxAuthFailures int
+	LockoutDuration time.Duration
+
+	// MaxConnectionsPerUser, if > 0, caps how many
+	// connections each user may hold open to the
+	// embedded sshd at once. A login beyond the cap
+	// is disconnected right after authenticating.
+	MaxConnectionsPerUser int
+
+	// BastionMode, if true, has the embedded sshd act
+	// only as a jump host: it refuses every channel but
+	// "direct-tcpip" and "forwarded-tcpip", so no shell,
+	// exec, or subsystem can be started on it.
+	BastionMode bool
+
+	// AllowForwardTo, if set, is asked about the
+	// destination of each "direct-tcpip" channel the
+	// embedded sshd is asked to open, as "host:port", or
+	// the socket path for a Unix domain socket. Those it
+	// returns false for are rejected as ssh.Prohibited.
+	AllowForwardTo func(remoteAddr string) bool
+
+	// ForwardDialer, if set, replaces net.Dial for the
+	// embedded sshd's TCP connections to "direct-tcpip"
+	// targets, and so also decides how their host names
+	// resolve.
+	ForwardDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// GlobalRequestRateLimit, if > 0, is how many global
+	// requests per second the embedded sshd takes from
+	// each client connection, with bursts of up to
+	// GlobalRequestBurst (at least 1). Requests beyond
+	// that are refused unread. Keepalives, ours and
+	// OpenSSH's, are not counted and never refused.
+	GlobalRequestRateLimit float64
+	GlobalRequestBurst     int
+
+	// AuthorizedKeysCallback, if set, supplies the public
+	// keys a user may log into the embedded sshd with, in
+	// place of the user's PublicKeyPath file. It is called
+	// at most once per connection; the keys it returns
+	// are reused for the rest of that connection's login.
+	AuthorizedKeysCallback func(conn ssh.ConnMetadata) ([]ssh.PublicKey, error)
+
+	// KeyboardInteractiveCallback, if set, replaces the
+	// passphrase and TOTP challenge of the embedded sshd's
+	// keyboard-interactive auth with a flow of our own.
+	// It may put any questions it likes to the client
+	// through challenge, and returns true to let the
+	// client in. A public key is still required as well,
+	// unless SkipRSA.
+	KeyboardInteractiveCallback func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (bool, error)
+
+	// SessionLimits bounds the bandwidth, lifetime, and
+	// idle time of each session on the embedded sshd.
+	SessionLimits SessionLimits
+
 	BitLenRSAkeys int
 
 	DirectTcp   bool
@@ -100,6 +256,18 @@ type SshegoConfig struct {
 	// for "custom-inproc-stream", etc.
 	CustomChannelHandlers map[string]CustomChannelHandlerCB
 
+	// see SetSessionRecorder. Guarded by Mut.
+	sessionRecorder *sessionRecorder
+
+	// see RegisterGlobalRequestHandler. Guarded by Mut.
+	globalRequestHandlers map[string]GlobalRequestHandler
+
+	// see RegisterSubsystem. Guarded by Mut.
+	subsystems map[string]SubsystemHandler
+
+	// see SetAuditLog. Guarded by Mut.
+	auditLog AuditLogger
+
 	// SkipCommandRecv if true, says don't
 	// start up the CommandRecv goroutine
 	// on the SshegoSystemMutexPort port.
@@ -211,6 +379,8 @@ func (c *SshegoConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.LocalToRemote.Listen.Addr, "listen", "", "(forward tunnel) We listen on this host:port locally, securely tunnel that traffic to sshd, then send it cleartext to -remote. The forward tunnel is active if and only if -listen is given. If host starts with a '/' then we treat it as the path to a unix-domain socket to listen on, and the port can be omitted.")
 	fs.StringVar(&c.LocalToRemote.Remote.Addr, "remote", "", "(forward tunnel) After traversing the secured forward tunnel, -listen traffic flows in cleartext from the sshd to this host:port. The foward tunnel is active only if -listen is given too.  If host starts with a '/' then we treat it as the path to a unix-domain socket to forward to, and the port can be omitted.")
 
+	fs.IntVar(&c.ProxyProtocol, "proxy-protocol", 0, "(forward tunnel) if 1 or 2, start each connection forwarded to -remote with a PROXY protocol header of that version, so -remote learns who the client really is.")
+
 	fs.StringVar(&c.RemoteToLocal.Listen.Addr, "revlisten", "", "(reverse tunnel) The sshd will listen on this host:port, securely tunnel those connections to the gosshtun application, whence they will cleartext connect to the -revfwd address. The reverse tunnel is active if and only if -revlisten is given.")
 	fs.StringVar(&c.RemoteToLocal.Remote.Addr, "revfwd", "127.0.0.1:22", "(reverse tunnel) The gosshtun application will receive securely tunneled connections from -revlisten on the sshd side, and cleartext forward them to this host:port. For security, it is recommended that this be 127.0.0.1:22, so that the sshd service on your gosshtun host authenticates all remotely initiated traffic. See also the -esshd option which can be used to secure the -revfwd connection as well. The reverse tunnel is active only if -revlisten is given too.")
 
@@ -276,6 +446,9 @@ func (c *SshegoConfig) ValidateConfig() error {
 	if c.LocalToRemote.Listen.Addr != "" && c.LocalToRemote.Remote.Addr == "" {
 		return fmt.Errorf("incomplete config: have -listen but not -remote")
 	}
+	if c.ProxyProtocol < 0 || c.ProxyProtocol > 2 {
+		return fmt.Errorf("bad -proxy-protocol %d; want 0, 1 or 2", c.ProxyProtocol)
+	}
 
 	err = c.RemoteToLocal.Listen.ParseAddr()
 	if err != nil {
@@ -309,6 +482,16 @@ func (c *SshegoConfig) ValidateConfig() error {
 		return err
 	}
 
+	err = checkRekeyThresholds(c.RekeyBytesThreshold, c.RekeyTimeThreshold)
+	if err != nil {
+		return err
+	}
+
+	err = checkChannelSizes(c.ChannelWindowSize, c.ChannelMaxPacket)
+	if err != nil {
+		return err
+	}
+
 	// MailgunConfig
 	err = c.MailCfg.ValidateConfig()
 	if err != nil {
@@ -0,0 +1,44 @@
+package sshego
+
+import (
+	"fmt"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ErrUserConnectionLimitExceeded is returned by
+// PerAttempt.PerConnection when a user who already
+// holds SshegoConfig.MaxConnectionsPerUser connections
+// logs in again. The new connection is disconnected,
+// with this error's text as the reason given.
+var ErrUserConnectionLimitExceeded = fmt.Errorf("per-user connection limit exceeded")
+
+// admitConnection counts sshConn against its user's
+// MaxConnectionsPerUser, until sshConn closes. If the
+// user is at the limit already, we count nothing and
+// return ErrUserConnectionLimitExceeded.
+func (a *PerAttempt) admitConnection(sshConn *ssh.ServerConn) error {
+	max := a.cfg.MaxConnectionsPerUser
+	if max <= 0 || a.cfg.HostDb == nil {
+		return nil
+	}
+	user := a.cfg.HostDb.Persist.Users.Get(sshConn.User())
+	if user == nil {
+		return nil
+	}
+	user.mut.Lock()
+	if user.activeConns >= max {
+		user.mut.Unlock()
+		return ErrUserConnectionLimitExceeded
+	}
+	user.activeConns++
+	user.mut.Unlock()
+
+	go func() {
+		sshConn.Wait()
+		user.mut.Lock()
+		user.activeConns--
+		user.mut.Unlock()
+	}()
+	return nil
+}
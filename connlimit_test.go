@@ -0,0 +1,93 @@
+package sshego
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test097MaxConnectionsPerUser(t *testing.T) {
+	cv.Convey("With MaxConnectionsPerUser 2, the esshd should disconnect a user's third connection, and leave the first two open.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		s.SrvCfg.MaxConnectionsPerUser = 2
+		var audit syncBuffer
+		s.SrvCfg.SetAuditLog(JSONAuditLogger(&audit))
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test097",
+		}
+		tri1, err := NewTricorder(dc, s.CliCfg.Halt, "test097a")
+		panicOn(err)
+		tri2, err := NewTricorder(dc, s.CliCfg.Halt, "test097b")
+		panicOn(err)
+
+		// the host key is known by now.
+		dc3 := *dc
+		dc3.TofuAddIfNotKnown = false
+		// the esshd disconnects as soon as the handshake is
+		// done, so the client may or may not see it finish;
+		// either way we should be told why.
+		_, cli3, _, err := dc3.Dial(context.Background(), nil, true)
+		if err == nil {
+			closed := make(chan error, 1)
+			go func() {
+				closed <- cli3.Wait()
+			}()
+			select {
+			case err = <-closed:
+			case <-time.After(10 * time.Second):
+				panic("third connection was not disconnected")
+			}
+		}
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, ErrUserConnectionLimitExceeded.Error())
+
+		for _, tri := range []*Tricorder{tri1, tri2} {
+			cli, err := tri.Cli()
+			panicOn(err)
+			sess, err := cli.NewSession(context.Background())
+			cv.So(err, cv.ShouldBeNil)
+			sess.Close()
+		}
+		// the connection turned away was never logged in.
+		cv.So(strings.Count(audit.String(), `"event":"auth-success"`), cv.ShouldEqual, 2)
+
+		// closing one frees a slot.
+		cli1, err := tri1.Cli()
+		panicOn(err)
+		tri1.Halt.RequestStop()
+		cli1.Close()
+		var cli4Err error
+		for i := 0; i < 50; i++ {
+			_, cli4, _, err := dc3.Dial(context.Background(), nil, true)
+			cli4Err = err
+			if err == nil {
+				_, err = cli4.NewSession(context.Background())
+				cli4Err = err
+				cli4.Close()
+			}
+			if cli4Err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		cv.So(cli4Err, cv.ShouldBeNil)
+
+		tri2.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,9 @@
+// +build !windows
+
+package sshego
+
+import "syscall"
+
+func isConnRefusedErrno(errno syscall.Errno) bool {
+	return errno == syscall.ECONNREFUSED
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package sshego
+
+import "syscall"
+
+// wsaeconnrefused is winsock's WSAECONNREFUSED, which
+// the syscall package does not name.
+const wsaeconnrefused syscall.Errno = 10061
+
+func isConnRefusedErrno(errno syscall.Errno) bool {
+	return errno == wsaeconnrefused || errno == syscall.ECONNREFUSED
+}
@@ -0,0 +1,51 @@
+package sshego
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test120ConnectionStringRoundTrip(t *testing.T) {
+	cv.Convey("ParseConnectionString should fill in DialConfig from a URI, and ConnectionString should give back the very same URI, less the password.", t, func() {
+
+		uri := "ssh://alice@[::1]:2222?key=/home/alice/.ssh/id_rsa" +
+			"&known_hosts=/home/alice/.ssh/.sshego.cli.known.hosts" +
+			"&totp=otpauth://totp/sshego:alice%3Fsecret%3DABC%26issuer%3Dsshego" +
+			"&downstream=10.0.0.5:80&tofu=true&no_update_known_hosts=true" +
+			"&verbose=true&skip_keepalive=true&keepalive=2s&idle_timeout=1m0s" +
+			"&dial_timeout=5s&reconnect_debounce=100ms&compression=6" +
+			"&ciphers=aes128-gcm@openssh.com,aes256-ctr&macs=hmac-sha2-256" +
+			"&kex=curve25519-sha256@libssh.org&lazy=true&proxy_protocol=2&nickname=ops&dest_nickname=db"
+
+		dc, err := ParseConnectionString(strings.Replace(uri, "alice@", "alice:s%40cret@", 1))
+		panicOn(err)
+		cv.So(dc.Mylogin, cv.ShouldEqual, "alice")
+		cv.So(dc.Pw, cv.ShouldEqual, "s@cret")
+		cv.So(dc.Sshdhost, cv.ShouldEqual, "::1")
+		cv.So(dc.Sshdport, cv.ShouldEqual, 2222)
+		cv.So(dc.RsaPath, cv.ShouldEqual, "/home/alice/.ssh/id_rsa")
+		cv.So(dc.TotpUrl, cv.ShouldEqual, "otpauth://totp/sshego:alice?secret=ABC&issuer=sshego")
+		cv.So(dc.TofuAddIfNotKnown, cv.ShouldBeTrue)
+		cv.So(dc.ConnIdleTimeout, cv.ShouldEqual, time.Minute)
+		cv.So(dc.CompressionLevel, cv.ShouldEqual, 6)
+		cv.So(dc.Ciphers, cv.ShouldResemble, []string{"aes128-gcm@openssh.com", "aes256-ctr"})
+		cv.So(dc.DestNickname, cv.ShouldEqual, "db")
+		cv.So(dc.ProxyProtocol, cv.ShouldEqual, 2)
+
+		cv.So(dc.ConnectionString(), cv.ShouldEqual, uri)
+
+		// the port defaults to 22.
+		dc, err = ParseConnectionString("ssh://bob@example.com")
+		panicOn(err)
+		cv.So(dc.Sshdport, cv.ShouldEqual, 22)
+		cv.So(dc.ConnectionString(), cv.ShouldEqual, "ssh://bob@example.com:22")
+
+		_, err = ParseConnectionString("ssh://bob@example.com?tofoo=true")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseConnectionString("http://bob@example.com")
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
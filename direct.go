@@ -25,55 +25,81 @@ const minus2_uint32 uint32 = 0xFFFFFFFE
 const minus10_uint32 uint32 = 0xFFFFFFF6
 
 // server side: handle channel type "direct-tcpip"  - RFC 4254 7.2
-// ca can be nil.
-func handleDirectTcp(ctx context.Context, parentHalt *ssh.Halter, newChannel ssh.NewChannel, ca *ConnectionAlert) {
+// ca can be nil. allow, if not nil, vets the destination;
+// see SshegoConfig.AllowForwardTo. dial, if not nil, makes
+// TCP connections; see SshegoConfig.ForwardDialer.
+func handleDirectTcp(ctx context.Context, parentHalt *ssh.Halter, newChannel ssh.NewChannel, ca *ConnectionAlert, allow func(remoteAddr string) bool, dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
 	pp("handleDirectTcp called!")
 
 	p := &channelOpenDirectMsg{}
 	ssh.Unmarshal(newChannel.ExtraData(), p)
-	targetAddr := fmt.Sprintf("%s:%d", p.Rhost, p.Rport)
+	// vet and dial the same address, bracketing
+	// an IPv6 Rhost.
+	targetAddr := JoinHostPort(p.Rhost, int64(p.Rport))
+	if p.Rport == minus2_uint32 {
+		targetAddr = p.Rhost // a unix domain socket path
+	}
 	log.Printf("direct-tcpip got channelOpenDirectMsg request to destination %s",
 		targetAddr)
 
-	channel, req, err := newChannel.Accept() // (Channel, <-chan *Request, error)
-	panicOn(err)
-	go ssh.DiscardRequests(ctx, req, parentHalt)
-
-	go func(ch ssh.Channel, host string, port uint32) {
+	if allow != nil && !allow(targetAddr) {
+		log.Printf("direct-tcpip to destination %s denied by AllowForwardTo", targetAddr)
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("forwarding to %s is not allowed", targetAddr))
+		return
+	}
 
-		var targetConn net.Conn
-		var err error
-		addr := fmt.Sprintf("%s:%d", p.Rhost, p.Rport)
-		switch port {
-		case minus2_uint32:
-			// unix domain request
-			//pp("direct.go has unix domain forwarding request")
-			targetConn, err = net.Dial("unix", host)
-		case 1:
-			//pp("direct.go has port 1 forwarding request. ca = %#v", ca)
-			if ca != nil && ca.PortOne != nil {
-				//pp("handleDirectTcp sees a port one request with a live ca.PortOne")
+	// dial before accepting, so a target we can't reach
+	// is a refused channel, with the dial error as its
+	// message, rather than an accepted channel that
+	// goes nowhere.
+	var targetConn net.Conn
+	var err error
+	switch p.Rport {
+	case minus2_uint32:
+		// unix domain request
+		//pp("direct.go has unix domain forwarding request")
+		targetConn, err = net.Dial("unix", p.Rhost)
+	case 1:
+		//pp("direct.go has port 1 forwarding request. ca = %#v", ca)
+		if ca != nil && ca.PortOne != nil {
+			channel, req, err := newChannel.Accept()
+			panicOn(err)
+			go ssh.DiscardRequests(ctx, req, parentHalt)
+			//pp("handleDirectTcp sees a port one request with a live ca.PortOne")
+			go func(ch ssh.Channel) {
 				select {
 				case ca.PortOne <- ch:
 				case <-ca.ShutDown:
 				}
-				return
-			}
-			panic("wat?")
-			fallthrough
-		default:
-			targetConn, err = net.Dial("tcp", targetAddr)
-		}
-		if err != nil {
-			log.Printf("sshd direct.go could not forward connection to addr: '%s'", addr)
+			}(channel)
 			return
 		}
-		log.Printf("sshd direct.go forwarding direct connection to addr: '%s'", addr)
+		panic("wat?")
+	default:
+		if dial != nil {
+			targetConn, err = dial(ctx, "tcp", targetAddr)
+		} else {
+			targetConn, err = net.Dial("tcp", targetAddr)
+		}
+	}
+	if err != nil {
+		log.Printf("sshd direct.go could not forward connection to addr: '%s': '%v'", targetAddr, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, req, err := newChannel.Accept() // (Channel, <-chan *Request, error)
+	if err != nil {
+		targetConn.Close()
+		return
+	}
+	go ssh.DiscardRequests(ctx, req, parentHalt)
+
+	log.Printf("sshd direct.go forwarding direct connection to addr: '%s'", targetAddr)
 
-		sp := newShovelPair(false)
-		parentHalt.AddDownstream(sp.Halt)
-		sp.Start(targetConn, ch, "targetBehindSshd<-fromDirectClient", "fromDirectClient<-targetBehindSshd")
-	}(channel, p.Rhost, p.Rport)
+	sp := newShovelPair(false)
+	parentHalt.AddDownstream(sp.Halt)
+	sp.Start(targetConn, channel, "targetBehindSshd<-fromDirectClient", "fromDirectClient<-targetBehindSshd")
 }
 
 // client side
@@ -0,0 +1,66 @@
+package sshego
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// GracefulShutdown stops the esshd without cutting off
+// its clients mid-session. It stops accepting new
+// connections at once, then waits for the open ones,
+// those still handshaking included, to close. If ctx
+// is done first, it closes those that remain and
+// returns ctx.Err(). Either way the esshd is then
+// stopped, as by Stop.
+func (e *Esshd) GracefulShutdown(ctx context.Context) error {
+	e.drainOnce.Do(func() { close(e.drainReq) })
+	select {
+	case <-e.stoppedAccepting:
+	case <-e.Halt.DoneChan():
+	case <-ctx.Done():
+	}
+
+	var err error
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for err == nil && e.activeConns() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		e.mut.Lock()
+		for nConn := range e.conns {
+			nConn.Close()
+		}
+		e.mut.Unlock()
+	}
+
+	if stopErr := e.Stop(); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// trackConn counts nConn as open from the time it is
+// accepted, handshake included, until untrackConn.
+func (e *Esshd) trackConn(nConn net.Conn) {
+	e.mut.Lock()
+	e.conns[nConn] = true
+	e.mut.Unlock()
+}
+
+func (e *Esshd) untrackConn(nConn net.Conn) {
+	e.mut.Lock()
+	delete(e.conns, nConn)
+	e.mut.Unlock()
+}
+
+func (e *Esshd) activeConns() int {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return len(e.conns)
+}
@@ -0,0 +1,112 @@
+package sshego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test113EsshdGracefulShutdown(t *testing.T) {
+	cv.Convey("Esshd.GracefulShutdown should wait for open connections to close, and force closed the one still open at its deadline.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test113",
+		}
+		ctx := context.Background()
+		var clis []*ssh.Client
+		_, _, _, err := dc.Dial(ctx, nil, true)
+		cv.So(errors.Is(err, ErrRerunWithoutNew), cv.ShouldBeTrue)
+		dc.TofuAddIfNotKnown = false
+		for i := 0; i < 3; i++ {
+			_, cli, _, err := dc.Dial(ctx, nil, true)
+			panicOn(err)
+			clis = append(clis, cli)
+		}
+		for s.SrvCfg.Esshd.activeConns() < 3 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			clis[0].Close()
+			clis[1].Close()
+		}()
+
+		thirdClosed := make(chan struct{})
+		go func() {
+			clis[2].Wait()
+			close(thirdClosed)
+		}()
+
+		shutCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		t0 := time.Now()
+		err = s.SrvCfg.Esshd.GracefulShutdown(shutCtx)
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+		cv.So(time.Since(t0), cv.ShouldBeGreaterThanOrEqualTo, 2*time.Second)
+
+		select {
+		case <-thirdClosed:
+		case <-time.After(10 * time.Second):
+			panic("third connection was not forcibly closed")
+		}
+		for i := 0; i < 100 && s.SrvCfg.Esshd.activeConns() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cv.So(s.SrvCfg.Esshd.activeConns(), cv.ShouldEqual, 0)
+	})
+}
+
+func Test161GracefulShutdownWaitsOnHandshake(t *testing.T) {
+	cv.Convey("Esshd.GracefulShutdown should count a connection still in its handshake as open, and close it at the deadline.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// a client that connects but never says hello.
+		addr := fmt.Sprintf("%v:%v", s.SrvCfg.EmbeddedSSHd.Host, s.SrvCfg.EmbeddedSSHd.Port)
+		var conn net.Conn
+		var err error
+		for i := 0; i < 100; i++ {
+			// the esshd starts listening in the background.
+			if conn, err = net.Dial("tcp", addr); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		panicOn(err)
+		defer conn.Close()
+		for s.SrvCfg.Esshd.activeConns() < 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		shutCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err = s.SrvCfg.Esshd.GracefulShutdown(shutCtx)
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+
+		// the server's version line, then EOF.
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		_, err = ioutil.ReadAll(conn)
+		cv.So(err, cv.ShouldBeNil)
+	})
+}
@@ -0,0 +1,169 @@
+package sshego
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MetricsSnapshot holds a Tricorder's counters at one
+// moment, as MetricsRegistry exports them.
+type MetricsSnapshot struct {
+	Name         string
+	Reconnects   int64
+	OpenChannels int64
+	BytesIn      int64
+	BytesOut     int64
+}
+
+// MetricsSnapshot returns the Tricorder's current counters.
+func (t *Tricorder) MetricsSnapshot() MetricsSnapshot {
+	m := t.metrics
+	return MetricsSnapshot{
+		Name:         t.GetName(),
+		Reconnects:   atomic.LoadInt64(&m.nReconnects),
+		OpenChannels: atomic.LoadInt64(&m.nChannels),
+		BytesIn:      atomic.LoadInt64(&m.bytesIn),
+		BytesOut:     atomic.LoadInt64(&m.bytesOut),
+	}
+}
+
+// MetricsRegistry gathers the metrics of a fleet of
+// Tricorders, and writes them out in the Prometheus
+// text exposition format, for those who would rather
+// not run a prometheus.Registry. Each series carries a
+// "name" label holding the Tricorder's current Name.
+type MetricsRegistry struct {
+	mut  sync.Mutex
+	tris map[*Tricorder]bool
+}
+
+// NewMetricsRegistry makes a new, empty MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		tris: make(map[*Tricorder]bool),
+	}
+}
+
+// Register adds t to r. It is an error to register two
+// Tricorders with the same name. Entries are keyed by
+// GetName when read, so they follow a later SetName.
+func (r *MetricsRegistry) Register(t *Tricorder) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	name := t.GetName()
+	for t2 := range r.tris {
+		if t2.GetName() == name {
+			return fmt.Errorf("MetricsRegistry: a Tricorder named '%s' is already registered", name)
+		}
+	}
+	r.tris[t] = true
+	return nil
+}
+
+// Unregister removes t from r.
+func (r *MetricsRegistry) Unregister(t *Tricorder) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.tris, t)
+}
+
+// Snapshot returns the counters of every registered
+// Tricorder, in Name order.
+func (r *MetricsRegistry) Snapshot() []MetricsSnapshot {
+	r.mut.Lock()
+	snaps := make([]MetricsSnapshot, 0, len(r.tris))
+	for t := range r.tris {
+		snaps = append(snaps, t.MetricsSnapshot())
+	}
+	r.mut.Unlock()
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Name < snaps[j].Name
+	})
+	return snaps
+}
+
+// fleetMetric is one metric family of WriteProm's.
+type fleetMetric struct {
+	name string
+	help string
+	typ  string
+	// series gives the label pairs (after name)
+	// and value of each series for one Tricorder.
+	series func(s *MetricsSnapshot) []fleetSeries
+}
+
+type fleetSeries struct {
+	labels []string
+	value  int64
+}
+
+var fleetMetrics = []fleetMetric{
+	{
+		name: "sshego_reconnects_total",
+		help: "Number of times the Tricorder has reconnected to its sshd.",
+		typ:  "counter",
+		series: func(s *MetricsSnapshot) []fleetSeries {
+			return []fleetSeries{{value: s.Reconnects}}
+		},
+	},
+	{
+		name: "sshego_open_channels",
+		help: "Number of ssh channels currently held by the Tricorder.",
+		typ:  "gauge",
+		series: func(s *MetricsSnapshot) []fleetSeries {
+			return []fleetSeries{{value: s.OpenChannels}}
+		},
+	},
+	{
+		name: "sshego_bytes_transferred_total",
+		help: "Bytes moved over the Tricorder's ssh channels.",
+		typ:  "counter",
+		series: func(s *MetricsSnapshot) []fleetSeries {
+			return []fleetSeries{
+				{labels: []string{"direction", "in"}, value: s.BytesIn},
+				{labels: []string{"direction", "out"}, value: s.BytesOut},
+			}
+		},
+	},
+}
+
+// WriteProm writes the current metrics of every
+// registered Tricorder to w, in the Prometheus text
+// exposition format, with Tricorders in Name order.
+func (r *MetricsRegistry) WriteProm(w io.Writer) {
+	snaps := r.Snapshot()
+	for _, fm := range fleetMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", fm.name, fm.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", fm.name, fm.typ)
+		for i := range snaps {
+			for _, s := range fm.series(&snaps[i]) {
+				labels := append([]string{"name", snaps[i].Name}, s.labels...)
+				fmt.Fprintf(w, "%s%s %d\n", fm.name, promLabels(labels), s.value)
+			}
+		}
+	}
+}
+
+// promLabels renders alternating label names and
+// values as {a="1",b="2"}.
+func promLabels(kv []string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(promLabelEscaper.Replace(kv[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
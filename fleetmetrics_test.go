@@ -0,0 +1,118 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test098MetricsRegistryWriteProm(t *testing.T) {
+	cv.Convey("MetricsRegistry.WriteProm should export each registered Tricorder's reconnects, open channels, and bytes transferred, labeled with its current name.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			ReconnectDebounce:    time.Millisecond,
+			LocalNickname:        "test098",
+		}
+		busy, err := NewTricorder(dc, s.CliCfg.Halt, "busy")
+		panicOn(err)
+		quiet, err := NewTricorder(dc, s.CliCfg.Halt, "quiet")
+		panicOn(err)
+
+		reg := NewMetricsRegistry()
+		panicOn(reg.Register(busy))
+		panicOn(reg.Register(quiet))
+		cv.So(reg.Register(busy), cv.ShouldNotBeNil)
+
+		time.Sleep(10 * time.Millisecond)
+		busy.reconnectNeededCh <- busy.uhp
+		for i := 0; i < 100 && busy.MetricsSnapshot().Reconnects < 1; i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		ch, err := busy.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		var buf bytes.Buffer
+		reg.WriteProm(&buf)
+		out := buf.String()
+
+		cv.So(out, cv.ShouldContainSubstring, "# TYPE sshego_reconnects_total counter\n")
+		cv.So(out, cv.ShouldContainSubstring, "# TYPE sshego_open_channels gauge\n")
+		cv.So(out, cv.ShouldContainSubstring, "# TYPE sshego_bytes_transferred_total counter\n")
+
+		cv.So(out, cv.ShouldContainSubstring, "\nsshego_reconnects_total{name=\"busy\"} 1\n")
+		cv.So(out, cv.ShouldContainSubstring, "\nsshego_reconnects_total{name=\"quiet\"} 0\n")
+		cv.So(out, cv.ShouldContainSubstring, "\nsshego_open_channels{name=\"busy\"} 1\n")
+		cv.So(out, cv.ShouldContainSubstring, "\nsshego_open_channels{name=\"quiet\"} 0\n")
+		cv.So(out, cv.ShouldContainSubstring, fmt.Sprintf("\nsshego_bytes_transferred_total{name=\"busy\",direction=\"in\"} %d\n", payloadByteCount))
+		cv.So(out, cv.ShouldContainSubstring, fmt.Sprintf("\nsshego_bytes_transferred_total{name=\"busy\",direction=\"out\"} %d\n", payloadByteCount))
+		cv.So(out, cv.ShouldContainSubstring, "\nsshego_bytes_transferred_total{name=\"quiet\",direction=\"in\"} 0\n")
+
+		snaps := reg.Snapshot()
+		cv.So(len(snaps), cv.ShouldEqual, 2)
+		cv.So(snaps[0], cv.ShouldResemble, MetricsSnapshot{
+			Name:         "busy",
+			Reconnects:   1,
+			OpenChannels: 1,
+			BytesIn:      int64(payloadByteCount),
+			BytesOut:     int64(payloadByteCount),
+		})
+		cv.So(snaps[1], cv.ShouldResemble, MetricsSnapshot{Name: "quiet"})
+
+		// entries follow SetName, and the old name is free.
+		quiet.SetName("hushed")
+		buf.Reset()
+		reg.WriteProm(&buf)
+		cv.So(buf.String(), cv.ShouldContainSubstring, "\nsshego_reconnects_total{name=\"hushed\"} 0\n")
+		cv.So(buf.String(), cv.ShouldNotContainSubstring, "quiet")
+		cv.So(reg.Register(busy), cv.ShouldNotBeNil)
+		other, err := NewTricorder(dc, s.CliCfg.Halt, "quiet")
+		panicOn(err)
+		cv.So(reg.Register(other), cv.ShouldBeNil)
+		cv.So(reg.Register(other), cv.ShouldNotBeNil)
+		other.Halt.RequestStop()
+
+		reg.Unregister(quiet)
+		buf.Reset()
+		reg.WriteProm(&buf)
+		cv.So(buf.String(), cv.ShouldNotContainSubstring, "hushed")
+
+		busy.Halt.RequestStop()
+		quiet.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+		tcpServerMgr.RequestStop()
+	})
+}
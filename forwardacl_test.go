@@ -0,0 +1,80 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test117AllowForwardTo(t *testing.T) {
+	cv.Convey("With AllowForwardTo set, the esshd should forward direct-tcpip channels only to the destinations it allows, and reject the rest as Prohibited.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		defer tcpServerMgr.RequestStop()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		allowed := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+		allowedV6 := fmt.Sprintf("[::1]:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		s.SrvCfg.AllowForwardTo = func(remoteAddr string) bool {
+			return remoteAddr == allowed || remoteAddr == allowedV6
+		}
+		// the esshd dials what AllowForwardTo vetted;
+		// we send it on to our IPv4 server.
+		dialed := make(chan string, 10)
+		s.SrvCfg.ForwardDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed <- addr
+			return net.Dial(network, allowed)
+		}
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test117",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test117")
+		panicOn(err)
+		ctx := context.Background()
+
+		_, err = tri.SSHChannel(ctx, "direct-tcpip", fmt.Sprintf("127.0.0.1:%v", tcpSrvPort+1))
+		oce, ok := err.(*ssh.OpenChannelError)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(oce.Reason, cv.ShouldEqual, ssh.Prohibited)
+
+		ch, err := tri.SSHChannel(ctx, "direct-tcpip", allowed)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+		cv.So(<-dialed, cv.ShouldEqual, allowed)
+
+		// an IPv6 destination is vetted and dialed bracketed.
+		_, err = tri.SSHChannel(ctx, "direct-tcpip", allowedV6)
+		panicOn(err)
+		cv.So(<-dialed, cv.ShouldEqual, allowedV6)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,62 @@
+package sshego
+
+import (
+	"net"
+	"sync"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// forwardListener is the listener lifecycle shared by
+// ListenAndForward, StartLocalSOCKS5, and OpenRemoteForward:
+// a listener, and the Halter of the goroutine accepting
+// on it, downstream of the Tricorder's.
+type forwardListener struct {
+	net.Listener
+	tri  *Tricorder
+	Halt *ssh.Halter
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (t *Tricorder) newForwardListener(ln net.Listener) *forwardListener {
+	f := &forwardListener{
+		Listener: ln,
+		tri:      t,
+		Halt:     ssh.NewHalter(),
+	}
+	t.Halt.AddDownstream(f.Halt)
+	return f
+}
+
+// Close stops the listener, and waits for the accepting
+// goroutine to finish. It is safe to call more than once,
+// and races with shutdown; only the first call closes
+// the listener.
+func (f *forwardListener) Close() error {
+	f.closeListener()
+	<-f.Halt.DoneChan()
+	f.tri.Halt.RemoveDownstream(f.Halt)
+	return f.closeErr
+}
+
+func (f *forwardListener) closeListener() {
+	f.closeOnce.Do(func() {
+		f.Halt.RequestStop()
+		f.closeErr = f.Listener.Close()
+	})
+}
+
+// closeOnStop closes the listener, so unblocking Accept,
+// once f.Halt is asked to stop, as on Tricorder shutdown,
+// or done is closed. done may be nil.
+func (f *forwardListener) closeOnStop(done <-chan struct{}) {
+	go func() {
+		select {
+		case <-f.Halt.ReqStopChan():
+		case <-done:
+		}
+		f.closeListener()
+	}()
+}
@@ -0,0 +1,87 @@
+package sshego
+
+import (
+	"context"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// GlobalRequestHandler answers a global (connection
+// level) request of the type it was registered for.
+// If req.WantReply, ok and payload are sent back to
+// the client as the reply.
+type GlobalRequestHandler func(conn ssh.Conn, req *ssh.Request) (ok bool, payload []byte)
+
+// RegisterGlobalRequestHandler has the esshd hand global
+// requests of type name to fn, on every connection from
+// now on. A nil fn removes the handler. Requests with no
+// handler are still discarded, apart from our keepalives.
+// Handlers are called one at a time, in the order the
+// requests arrive on each connection, so fn should not block.
+func (cfg *SshegoConfig) RegisterGlobalRequestHandler(name string, fn GlobalRequestHandler) {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	if fn == nil {
+		delete(cfg.globalRequestHandlers, name)
+		return
+	}
+	if cfg.globalRequestHandlers == nil {
+		cfg.globalRequestHandlers = make(map[string]GlobalRequestHandler)
+	}
+	cfg.globalRequestHandlers[name] = fn
+}
+
+// isKeepaliveRequest reports whether typ is a keepalive,
+// ours or OpenSSH's, which GlobalRequestRateLimit spares.
+func isKeepaliveRequest(typ string) bool {
+	return typ == "keepalive@sshego.glycerine.github.com" || typ == "keepalive@openssh.com"
+}
+
+func (cfg *SshegoConfig) globalRequestHandler(name string) GlobalRequestHandler {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	return cfg.globalRequestHandlers[name]
+}
+
+// handleGlobalRequests services the global requests
+// arriving on conn: registered types go to their
+// GlobalRequestHandler, and the rest are treated as by
+// DiscardRequestsExceptKeepalives. Requests over the
+// GlobalRequestRateLimit are refused.
+func (cfg *SshegoConfig) handleGlobalRequests(ctx context.Context, conn ssh.Conn, in <-chan *ssh.Request, reqStop chan struct{}) {
+
+	var lim *tokenBucket
+	if cfg.GlobalRequestRateLimit > 0 {
+		lim = newTokenBucketBurst(cfg.GlobalRequestRateLimit, cfg.GlobalRequestBurst)
+	}
+
+	for {
+		select {
+		case req, stillOpen := <-in:
+			if !stillOpen {
+				return
+			}
+			if req == nil {
+				continue
+			}
+			if lim != nil && !isKeepaliveRequest(req.Type) && !lim.allow() {
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+			if fn := cfg.globalRequestHandler(req.Type); fn != nil {
+				ok, payload := fn(conn, req)
+				if req.WantReply {
+					req.Reply(ok, payload)
+				}
+				continue
+			}
+			discardRequestExceptKeepalive(req)
+		case <-reqStop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
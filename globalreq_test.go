@@ -0,0 +1,143 @@
+package sshego
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test107GlobalRequestHandler(t *testing.T) {
+	cv.Convey("A GlobalRequestHandler registered for \"test-request\" should see that request, and its reply should reach the client; unregistered requests are still refused.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		seen := make(chan string, 1)
+		s.SrvCfg.RegisterGlobalRequestHandler("test-request", func(conn ssh.Conn, req *ssh.Request) (bool, []byte) {
+			seen <- conn.User() + ":" + string(req.Payload)
+			return true, []byte("pong")
+		})
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test107",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test107")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+
+		ok, reply, err := cli.SendRequest(context.Background(), "test-request", true, []byte("ping"))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(string(reply), cv.ShouldEqual, "pong")
+		select {
+		case got := <-seen:
+			cv.So(got, cv.ShouldEqual, s.Mylogin+":ping")
+		case <-time.After(10 * time.Second):
+			panic("handler never called")
+		}
+
+		ok, _, err = cli.SendRequest(context.Background(), "no-such-request", true, nil)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(ok, cv.ShouldBeFalse)
+
+		// unregistering restores the default refusal.
+		s.SrvCfg.RegisterGlobalRequestHandler("test-request", nil)
+		ok, _, err = cli.SendRequest(context.Background(), "test-request", true, []byte("ping"))
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(ok, cv.ShouldBeFalse)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test157GlobalRequestRateLimit(t *testing.T) {
+	cv.Convey("With GlobalRequestRateLimit at 10/sec, the esshd should refuse most of a burst of 100 global requests, without handing the refused ones to their handler, and let keepalives through uncounted.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		s.SrvCfg.GlobalRequestRateLimit = 10
+		s.SrvCfg.GlobalRequestBurst = 10
+		var mut sync.Mutex
+		handled := 0
+		s.SrvCfg.RegisterGlobalRequestHandler("test-request", func(conn ssh.Conn, req *ssh.Request) (bool, []byte) {
+			mut.Lock()
+			handled++
+			mut.Unlock()
+			return true, nil
+		})
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test157",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test157")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+
+		accepted := 0
+		t0 := time.Now()
+		for i := 0; i < 100; i++ {
+			ok, _, err := cli.SendRequest(context.Background(), "test-request", true, nil)
+			panicOn(err)
+			if ok {
+				accepted++
+			}
+		}
+		elap := time.Since(t0)
+
+		// the burst, plus 10/sec for as long as we took.
+		most := 10 + int(elap.Seconds()*10) + 1
+		cv.So(accepted, cv.ShouldBeGreaterThan, 0)
+		cv.So(accepted, cv.ShouldBeLessThanOrEqualTo, most)
+		cv.So(100-accepted, cv.ShouldBeGreaterThan, 50)
+		mut.Lock()
+		cv.So(handled, cv.ShouldEqual, accepted)
+		mut.Unlock()
+
+		// keepalives do not count against the limit: once
+		// the bucket has refilled, a burst of them should
+		// leave room for a full burst of requests.
+		time.Sleep(1100 * time.Millisecond)
+		for i := 0; i < 50; i++ {
+			_, _, err := cli.SendRequest(context.Background(), "keepalive@openssh.com", true, nil)
+			panicOn(err)
+		}
+		accepted = 0
+		for i := 0; i < 10; i++ {
+			ok, _, err := cli.SendRequest(context.Background(), "test-request", true, nil)
+			panicOn(err)
+			if ok {
+				accepted++
+			}
+		}
+		cv.So(accepted, cv.ShouldEqual, 10)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
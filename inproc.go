@@ -0,0 +1,71 @@
+package sshego
+
+import (
+	"context"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// InprocStreamConsumer is handed each in-process stream
+// the esshd accepts, on its own goroutine. It owns ch,
+// and should close it when done.
+type InprocStreamConsumer func(ch ssh.Channel, sshconn ssh.Conn)
+
+// ServeInprocStreams has the esshd accept
+// InprocStreamChanName channels and pass them
+// to consumer, by way of CustomChannelHandlers. Call it
+// before the esshd starts. Channel requests on the
+// streams, other than our keepalives, are refused.
+func (cfg *SshegoConfig) ServeInprocStreams(consumer InprocStreamConsumer) {
+	if cfg.CustomChannelHandlers == nil {
+		cfg.CustomChannelHandlers = make(map[string]CustomChannelHandlerCB)
+	}
+	cfg.CustomChannelHandlers[InprocStreamChanName] = func(nc ssh.NewChannel, sshconn ssh.Conn, ca *ConnectionAlert) {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go DiscardRequestsExceptKeepalives(context.Background(), reqs, nil)
+		consumer(ch, sshconn)
+	}
+}
+
+// InprocStream opens a InprocStreamChanName channel
+// to the in-process consumer of the sshd we are logged
+// into; see SshegoConfig.ServeInprocStreams. It is
+// SSHChannel for that type, and is closed with the
+// Tricorder's other channels.
+func (t *Tricorder) InprocStream(ctx context.Context) (ssh.Channel, error) {
+	return t.SSHChannel(ctx, InprocStreamChanName, "")
+}
+
+// SharedChannel returns our one shared InprocStream, for
+// request/response use without a channel open per call.
+// Every caller gets the same channel while it is alive;
+// once it has closed, as on a reconnect, the next call
+// opens a new one. Callers must do their own framing, and
+// must not interleave requests from several goroutines
+// unless that framing allows it.
+func (t *Tricorder) SharedChannel(ctx context.Context) (ssh.Channel, error) {
+	t.sharedMut.Lock()
+	defer t.sharedMut.Unlock()
+	if t.shared != nil && channelAlive(t.shared) {
+		return t.shared, nil
+	}
+	ch, err := t.InprocStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.shared = ch
+	return ch, nil
+}
+
+// channelAlive returns false once ch has been closed.
+func channelAlive(ch ssh.Channel) bool {
+	select {
+	case <-ch.GetHalter().ReqStopChan():
+		return false
+	default:
+		return true
+	}
+}
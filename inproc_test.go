@@ -0,0 +1,168 @@
+package sshego
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test114InprocStreamEcho(t *testing.T) {
+	cv.Convey("Tricorder.InprocStream should reach the esshd's in-process consumer set by ServeInprocStreams; an echoing consumer should send our data straight back.", t, func() {
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		gotUser := make(chan string, 1)
+		s.SrvCfg.ServeInprocStreams(func(ch ssh.Channel, sshconn ssh.Conn) {
+			gotUser <- sshconn.User()
+			io.Copy(ch, ch)
+			ch.Close()
+		})
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test114",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test114")
+		panicOn(err)
+
+		ch, err := tri.InprocStream(context.Background())
+		panicOn(err)
+		cv.So(<-gotUser, cv.ShouldEqual, s.Mylogin)
+
+		msg := RandomString(1000)
+		_, err = ch.Write([]byte(msg))
+		panicOn(err)
+		reply := make([]byte, len(msg))
+		_, err = io.ReadFull(ch, reply)
+		panicOn(err)
+		cv.So(string(reply), cv.ShouldEqual, msg)
+		ch.Close()
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test129TricorderSharedChannel(t *testing.T) {
+	cv.Convey("Tricorder.SharedChannel should hand out the same channel while it is alive, and a fresh one after a reconnect.", t, func() {
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		s.SrvCfg.ServeInprocStreams(func(ch ssh.Channel, sshconn ssh.Conn) {
+			io.Copy(ch, ch)
+			ch.Close()
+		})
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test129",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test129")
+		panicOn(err)
+		ctx := context.Background()
+
+		echo := func(ch ssh.Channel) {
+			msg := RandomString(100)
+			_, err := ch.Write([]byte(msg))
+			panicOn(err)
+			reply := make([]byte, len(msg))
+			_, err = io.ReadFull(ch, reply)
+			panicOn(err)
+			cv.So(string(reply), cv.ShouldEqual, msg)
+		}
+
+		ch1, err := tri.SharedChannel(ctx)
+		panicOn(err)
+		echo(ch1)
+		ch2, err := tri.SharedChannel(ctx)
+		panicOn(err)
+		cv.So(ch2, cv.ShouldEqual, ch1)
+		echo(ch2)
+
+		panicOn(tri.Reset(ctx))
+
+		ch3, err := tri.SharedChannel(ctx)
+		panicOn(err)
+		cv.So(ch3, cv.ShouldNotEqual, ch1)
+		echo(ch3)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test159SSHChannelAndRequests(t *testing.T) {
+	cv.Convey("Tricorder.SSHChannelAndRequests should hand us the requests the sshd sends on an InprocStream, such as exit-status, which SSHChannel would have refused.", t, func() {
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		s.SrvCfg.ServeInprocStreams(func(ch ssh.Channel, sshconn ssh.Conn) {
+			status := ssh.Marshal(&struct{ Status uint32 }{42})
+			ch.SendRequest("exit-status", false, status)
+			<-ch.Done()
+		})
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test159",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test159")
+		panicOn(err)
+
+		ch, reqs, err := tri.SSHChannelAndRequests(context.Background(), InprocStreamChanName, "")
+		panicOn(err)
+		cv.So(reqs, cv.ShouldNotBeNil)
+		select {
+		case req := <-reqs:
+			cv.So(req.Type, cv.ShouldEqual, "exit-status")
+			var st struct{ Status uint32 }
+			panicOn(ssh.Unmarshal(req.Payload, &st))
+			cv.So(st.Status, cv.ShouldEqual, 42)
+		case <-time.After(10 * time.Second):
+			panic("exit-status never arrived")
+		}
+		ch.Close()
+
+		// SSHChannel still works, and gives no requests.
+		ch, err = tri.SSHChannel(context.Background(), InprocStreamChanName, "")
+		panicOn(err)
+		ch.Close()
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -61,6 +61,17 @@ func GetExternalIP() string {
 	}
 }
 
+// JoinHostPort is the inverse of SplitHostPort. It
+// brackets IPv6 hosts, zoned ones like "fe80::1%eth0"
+// included, so that "::1" and 22 give "[::1]:22". A
+// host that is already bracketed is left as is.
+func JoinHostPort(host string, port int64) string {
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, strconv.FormatInt(port, 10))
+}
+
 func SplitHostPort(hostport string) (host string, port int64, err error) {
 	sPort := ""
 	host, sPort, err = net.SplitHostPort(hostport)
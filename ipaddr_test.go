@@ -0,0 +1,47 @@
+package sshego
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test108JoinHostPortBracketsIPv6(t *testing.T) {
+	cv.Convey("JoinHostPort should bracket IPv6 hosts, zoned or not, and a Tricorder's UHP.HostPort for an IPv6 Sshdhost should round-trip through SplitHostPort.", t, func() {
+
+		cv.So(JoinHostPort("127.0.0.1", 22), cv.ShouldEqual, "127.0.0.1:22")
+		cv.So(JoinHostPort("example.com", 2222), cv.ShouldEqual, "example.com:2222")
+		cv.So(JoinHostPort("::1", 22), cv.ShouldEqual, "[::1]:22")
+		cv.So(JoinHostPort("[::1]", 22), cv.ShouldEqual, "[::1]:22")
+		cv.So(JoinHostPort("fe80::1%eth0", 22), cv.ShouldEqual, "[fe80::1%eth0]:22")
+
+		for _, host := range []string{"127.0.0.1", "::1", "fe80::1%eth0", "2001:db8::7"} {
+			h, port, err := SplitHostPort(JoinHostPort(host, 2222))
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(h, cv.ShouldEqual, host)
+			cv.So(port, cv.ShouldEqual, 2222)
+		}
+
+		origdir, tempdir := MakeAndMoveToTempDir()
+		defer TempDirCleanup(origdir, tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: tempdir + "/known_hosts",
+			Mylogin:              "bob",
+			RsaPath:              origdir + "/id_rsa_test",
+			Sshdhost:             "2001:db8::7",
+			Sshdport:             2222,
+			LazyConnect:          true,
+			LocalNickname:        "test108",
+		}
+		tri, err := NewTricorder(dc, nil, "test108")
+		panicOn(err)
+		defer tri.Halt.RequestStop()
+
+		cv.So(tri.uhp.HostPort, cv.ShouldEqual, "[2001:db8::7]:2222")
+		h, port, err := SplitHostPort(tri.uhp.HostPort)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(h, cv.ShouldEqual, "2001:db8::7")
+		cv.So(port, cv.ShouldEqual, 2222)
+	})
+}
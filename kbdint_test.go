@@ -0,0 +1,78 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test118KeyboardInteractiveCallback(t *testing.T) {
+	cv.Convey("With SshegoConfig.KeyboardInteractiveCallback set, the esshd should put our own challenge to the client, and let in only a client that gives the expected answer.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		s.SrvCfg.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (bool, error) {
+			ans, err := challenge(context.Background(), conn.User(), "", []string{"favorite project? "}, []bool{true})
+			if err != nil {
+				return false, err
+			}
+			return len(ans) == 1 && ans[0] == "sshego", nil
+		}
+
+		pemBytes, err := ioutil.ReadFile(s.RsaPath)
+		panicOn(err)
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		panicOn(err)
+
+		addr := fmt.Sprintf("%v:%v", s.SrvCfg.EmbeddedSSHd.Host, s.SrvCfg.EmbeddedSSHd.Port)
+		dial := func(answer string) error {
+			cfg := &ssh.ClientConfig{
+				User: s.Mylogin,
+				Auth: []ssh.AuthMethod{
+					ssh.PublicKeys(signer),
+					ssh.KeyboardInteractive(func(ctx context.Context, user, instruction string, questions []string, echos []bool) ([]string, error) {
+						ans := make([]string, len(questions))
+						for i := range ans {
+							ans[i] = answer
+						}
+						return ans, nil
+					}),
+				},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				HostPort:        addr,
+				Config: ssh.Config{
+					Ciphers: getCiphers(),
+					Halt:    ssh.NewHalter(),
+				},
+			}
+			defer cfg.Config.Halt.RequestStop()
+			cli, err := ssh.Dial(context.Background(), "tcp", addr, cfg)
+			if err != nil {
+				return err
+			}
+			cli.Close()
+			return nil
+		}
+
+		// the esshd starts listening in the background.
+		for i := 0; i < 100; i++ {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		cv.So(dial("wrong"), cv.ShouldNotBeNil)
+		cv.So(dial("sshego"), cv.ShouldBeNil)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -190,6 +190,58 @@ func (h *KnownHosts) Sync() (err error) {
 	return
 }
 
+// Clone returns a deep copy of h, so that
+// the copy's Hosts can be changed without
+// affecting h.
+func (h *KnownHosts) Clone() *KnownHosts {
+	h.Mut.Lock()
+	defer h.Mut.Unlock()
+
+	c := &KnownHosts{
+		Hosts:               make(map[string]*ServerPubKey, len(h.Hosts)),
+		curStatus:           h.curStatus,
+		FilepathPrefix:      h.FilepathPrefix,
+		PersistFormatSuffix: h.PersistFormatSuffix,
+		PersistFormat:       h.PersistFormat,
+		NoSave:              h.NoSave,
+	}
+	for k, s := range h.Hosts {
+		cs := s.clone()
+		c.Hosts[k] = cs
+		if s == h.curHost {
+			c.curHost = cs
+		}
+	}
+	return c
+}
+
+func (s *ServerPubKey) clone() *ServerPubKey {
+	s.Mut.Lock()
+	defer s.Mut.Unlock()
+
+	c := &ServerPubKey{
+		Hostname:                 s.Hostname,
+		HumanKey:                 s.HumanKey,
+		ServerBanned:             s.ServerBanned,
+		remote:                   s.remote,
+		Markers:                  s.Markers,
+		Hostnames:                s.Hostnames,
+		Keytype:                  s.Keytype,
+		Base64EncodededPublicKey: s.Base64EncodededPublicKey,
+		Comment:                  s.Comment,
+		Port:                     s.Port,
+		LineInFileOneBased:       s.LineInFileOneBased,
+		AlreadySaved:             s.AlreadySaved,
+	}
+	if s.SplitHostnames != nil {
+		c.SplitHostnames = make(map[string]bool, len(s.SplitHostnames))
+		for k, v := range s.SplitHostnames {
+			c.SplitHostnames[k] = v
+		}
+	}
+	return c
+}
+
 // Close cleans up and prepares for shutdown. It calls h.Sync() to write
 // the state to disk.
 func (h *KnownHosts) Close() {
@@ -0,0 +1,56 @@
+package sshego
+
+import (
+	"net"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test133LocalBindIP(t *testing.T) {
+	// 127.0.0.2 is on lo by default under linux; on
+	// darwin it needs an alias (ifconfig lo0 alias 127.0.0.2).
+	lsn, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("no 127.0.0.2 loopback alias here: %v", err)
+	}
+	lsn.Close()
+
+	cv.Convey("With DialConfig.LocalBindIP set, our connection to the sshd should originate from that address; an address not on this host should be refused.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalBindIP:          "127.0.0.2",
+			LocalNickname:        "test133",
+		}
+		cv.So(dc.Validate(), cv.ShouldBeNil)
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test133")
+		panicOn(err)
+
+		nc, err := tri.Nc()
+		panicOn(err)
+		cv.So(nc.(net.Conn).LocalAddr().(*net.TCPAddr).IP.String(), cv.ShouldEqual, "127.0.0.2")
+
+		// 192.0.2.1 is TEST-NET-1, never assigned to a host.
+		bad := *dc
+		bad.LocalBindIP = "192.0.2.1"
+		cv.So(bad.Validate().(*DialConfigError).Problems, cv.ShouldResemble,
+			[]string{"LocalBindIP '192.0.2.1' is not an address of this host"})
+		_, err = bad.DeriveNewConfig()
+		cv.So(err, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,63 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// localForwarder is what ListenAndForward returns: the
+// listener, with the goroutine accepting on it.
+type localForwarder struct {
+	*forwardListener
+}
+
+// ListenAndForward is ssh -L: it listens on localAddr, and
+// splices each connection accepted there to targetHostPort,
+// as reached from the sshd, over a new direct-tcpip
+// channel. A connection is closed straight away if the
+// channel cannot be had, as when DialConfig.MaxChannels
+// are already open.
+//
+// Use Addr and Close on the returned listener; the
+// accepting is done for you. Closing it, cancelling
+// ctx, or shutting down the Tricorder stops the
+// accepting; connections already forwarded are left
+// to finish on their own, unless ctx was cancelled.
+func (t *Tricorder) ListenAndForward(ctx context.Context, localAddr, targetHostPort string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("ListenAndForward: could not listen on '%s': %v", localAddr, err)
+	}
+	f := &localForwarder{t.newForwardListener(ln)}
+	go f.serve(ctx, targetHostPort)
+	return f, nil
+}
+
+func (f *localForwarder) serve(ctx context.Context, targetHostPort string) {
+	defer f.Halt.MarkDone()
+
+	// also close on ctx or Tricorder shutdown.
+	f.closeOnStop(ctx.Done())
+
+	for {
+		conn, err := f.Listener.Accept()
+		if err != nil {
+			if f.Halt.IsStopRequested() {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			f.tri.warn("ListenAndForward: Accept error, stopping", "addr", f.Addr(), "err", err)
+			return
+		}
+		f.tri.applyTCPOptions(conn)
+		go func() {
+			err := f.tri.Splice(ctx, conn, targetHostPort)
+			if err != nil && err != ctx.Err() {
+				f.tri.info("ListenAndForward: splice ended", "target", targetHostPort, "err", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,107 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test150ListenAndForward(t *testing.T) {
+	cv.Convey("ListenAndForward should carry local connections through the sshd to the target, turn away connections beyond DialConfig.MaxChannels, and stop accepting once closed.", t, func() {
+
+		// an echo backend.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test150",
+			MaxChannels:          1,
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test150")
+		panicOn(err)
+
+		ln, err := tri.ListenAndForward(context.Background(), "127.0.0.1:0", dest)
+		panicOn(err)
+		addr := ln.Addr().String()
+
+		echo := func(c net.Conn, msg string) string {
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+			_, err := c.Write([]byte(msg))
+			if err != nil {
+				return err.Error()
+			}
+			back := make([]byte, len(msg))
+			_, err = io.ReadFull(c, back)
+			if err != nil {
+				return err.Error()
+			}
+			return string(back)
+		}
+
+		c1, err := net.Dial("tcp", addr)
+		panicOn(err)
+		cv.So(echo(c1, "through the tunnel"), cv.ShouldEqual, "through the tunnel")
+
+		// MaxChannels is 1, so a second connection is turned away.
+		c2, err := net.Dial("tcp", addr)
+		panicOn(err)
+		c2.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err = c2.Read(make([]byte, 1))
+		cv.So(err, cv.ShouldEqual, io.EOF)
+		c2.Close()
+
+		// once the first is gone, there is room again.
+		c1.Close()
+		for i := 0; i < 100; i++ {
+			chans, err := tri.ListChannels()
+			panicOn(err)
+			if len(chans) == 0 {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		c3, err := net.Dial("tcp", addr)
+		panicOn(err)
+		cv.So(echo(c3, "again"), cv.ShouldEqual, "again")
+		c3.Close()
+
+		panicOn(ln.Close())
+		// closing again is harmless.
+		panicOn(ln.Close())
+		_, err = net.Dial("tcp", addr)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,165 @@
+package sshego
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultLockoutDuration is used when
+// SshegoConfig.MaxAuthFailures is set but
+// LockoutDuration is left at zero.
+const DefaultLockoutDuration = 5 * time.Minute
+
+// authLockout counts failed login attempts per
+// source IP and per user. Once either reaches
+// maxFailures, further attempts from that IP or for
+// that user are refused until lockoutDur has passed.
+// This is independent of the ssh protocol's own
+// per-connection auth-tries limit, so brute force
+// guessing across many connections is slowed too.
+//
+// Failures are forgotten once lockoutDur passes
+// without another, and records no longer needed
+// are pruned every lockoutDur, so neither map
+// grows without bound.
+type authLockout struct {
+	mut         sync.Mutex
+	maxFailures int
+	lockoutDur  time.Duration
+	lastPrune   time.Time
+
+	byIP   map[string]*failureRecord
+	byUser map[string]*failureRecord
+}
+
+type failureRecord struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// expired returns true once r no longer counts
+// against anyone: its lockout, if any, is over,
+// and its last failure is older than dur.
+func (r *failureRecord) expired(now time.Time, dur time.Duration) bool {
+	if !r.lockedUntil.IsZero() {
+		return !now.Before(r.lockedUntil)
+	}
+	return now.Sub(r.lastFailure) >= dur
+}
+
+func newAuthLockout(maxFailures int, lockoutDur time.Duration) *authLockout {
+	if lockoutDur <= 0 {
+		lockoutDur = DefaultLockoutDuration
+	}
+	return &authLockout{
+		maxFailures: maxFailures,
+		lockoutDur:  lockoutDur,
+		byIP:        make(map[string]*failureRecord),
+		byUser:      make(map[string]*failureRecord),
+	}
+}
+
+// ipOnly strips the port from addr, so that
+// all connections from one host share a record.
+func ipOnly(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Locked returns true if either ip or user is
+// currently locked out. A nil *authLockout
+// never locks anyone out.
+func (a *authLockout) Locked(ip, user string, now time.Time) bool {
+	if a == nil || a.maxFailures <= 0 {
+		return false
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.lockedHelper(a.byIP, ip, now) || a.lockedHelper(a.byUser, user, now)
+}
+
+// caller must hold a.mut
+func (a *authLockout) lockedHelper(m map[string]*failureRecord, key string, now time.Time) bool {
+	r, ok := m[key]
+	if !ok || r.lockedUntil.IsZero() {
+		return false
+	}
+	if now.Before(r.lockedUntil) {
+		return true
+	}
+	// lockout has expired, start counting afresh.
+	delete(m, key)
+	return false
+}
+
+// NoteFailure records a failed attempt from ip for user,
+// starting a lockout for either once it reaches maxFailures.
+func (a *authLockout) NoteFailure(ip, user string, now time.Time) {
+	if a == nil || a.maxFailures <= 0 {
+		return
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.pruneHelper(now)
+	a.failureHelper(a.byIP, ip, now)
+	a.failureHelper(a.byUser, user, now)
+}
+
+// caller must hold a.mut
+func (a *authLockout) failureHelper(m map[string]*failureRecord, key string, now time.Time) {
+	r, ok := m[key]
+	if !ok || r.expired(now, a.lockoutDur) {
+		r = &failureRecord{}
+		m[key] = r
+	}
+	r.count++
+	r.lastFailure = now
+	if r.count >= a.maxFailures {
+		r.lockedUntil = now.Add(a.lockoutDur)
+	}
+}
+
+// pruneHelper drops expired records, at most once
+// per lockoutDur. caller must hold a.mut
+func (a *authLockout) pruneHelper(now time.Time) {
+	if now.Sub(a.lastPrune) < a.lockoutDur {
+		return
+	}
+	a.lastPrune = now
+	for k, r := range a.byIP {
+		if r.expired(now, a.lockoutDur) {
+			delete(a.byIP, k)
+		}
+	}
+	for k, r := range a.byUser {
+		if r.expired(now, a.lockoutDur) {
+			delete(a.byUser, k)
+		}
+	}
+}
+
+// size returns how many records we hold, for tests.
+func (a *authLockout) size() int {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return len(a.byIP) + len(a.byUser)
+}
+
+// NoteSuccess forgets any prior failures for ip and user.
+func (a *authLockout) NoteSuccess(ip, user string) {
+	if a == nil {
+		return
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	delete(a.byIP, ip)
+	delete(a.byUser, user)
+}
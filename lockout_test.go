@@ -0,0 +1,162 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/pquerna/otp/totp"
+)
+
+// fakeConnMeta provides just enough of ssh.ConnMetadata
+// to drive the auth callbacks directly.
+type fakeConnMeta struct {
+	user   string
+	remote net.Addr
+}
+
+func (f *fakeConnMeta) User() string          { return f.user }
+func (f *fakeConnMeta) SessionID() []byte     { return nil }
+func (f *fakeConnMeta) ClientVersion() []byte { return nil }
+func (f *fakeConnMeta) ServerVersion() []byte { return nil }
+func (f *fakeConnMeta) RemoteAddr() net.Addr  { return f.remote }
+func (f *fakeConnMeta) LocalAddr() net.Addr   { return f.remote }
+func (f *fakeConnMeta) NegotiatedAlgorithms() ssh.AlgorithmSet {
+	return ssh.AlgorithmSet{}
+}
+
+var _ ssh.ConnMetadata = &fakeConnMeta{}
+
+func Test104FailedAuthLockout(t *testing.T) {
+
+	cv.Convey("After MaxAuthFailures bad logins, the esshd should refuse further attempts from that IP/user without issuing a challenge, until LockoutDuration has passed.", t, func() {
+
+		maxFail := 2
+		lockDur := 10 * time.Second
+
+		cfg := NewSshegoConfig()
+		cfg.MaxAuthFailures = maxFail
+		cfg.LockoutDuration = lockDur
+		cfg.HostDb = &HostDb{
+			cfg:     cfg,
+			Persist: HostDbPersist{Users: NewAtomicUserMap()},
+		}
+		cfg.Esshd = &Esshd{
+			cfg:     cfg,
+			lockout: newAuthLockout(cfg.MaxAuthFailures, cfg.LockoutDuration),
+		}
+
+		w, err := NewTOTP("bob@example.com", "bob/gosshtun")
+		panicOn(err)
+		pw := "correct horse battery staple"
+		bob := NewUser()
+		bob.MyLogin = "bob"
+		bob.ScryptedPassword = ScryptHash(pw)
+		bob.TotpSecret = w.Key.Secret()
+		bob.oneTime = w
+		cfg.HostDb.Persist.Users.Set("bob", bob)
+
+		conn := &fakeConnMeta{
+			user:   "bob",
+			remote: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4567},
+		}
+		ctx := context.Background()
+
+		challenged := 0
+		badAnswers := func(ctx context.Context, user, instruction string, questions []string, echos []bool) ([]string, error) {
+			challenged++
+			return []string{"wrong", "000000"}, nil
+		}
+
+		a := NewPerAttempt(NewAuthState(nil), cfg)
+		for i := 0; i < maxFail; i++ {
+			_, err := a.KeyboardInteractiveCallback(ctx, conn, badAnswers)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+		cv.So(challenged, cv.ShouldEqual, maxFail)
+
+		// the N+1th attempt is refused before any challenge is issued.
+		_, err = a.KeyboardInteractiveCallback(ctx, conn, badAnswers)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(challenged, cv.ShouldEqual, maxFail)
+
+		// even correct credentials fail during the lockout window,
+		// and from a different port on the same IP.
+		goodAnswers := func(ctx context.Context, user, instruction string, questions []string, echos []bool) ([]string, error) {
+			challenged++
+			code, err := totp.GenerateCode(bob.TotpSecret, time.Now())
+			panicOn(err)
+			return []string{pw, code}, nil
+		}
+		conn.remote = &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 4568}
+		_, err = a.KeyboardInteractiveCallback(ctx, conn, goodAnswers)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(challenged, cv.ShouldEqual, maxFail)
+
+		// the lockout expires after LockoutDuration.
+		lk := cfg.Esshd.lockout
+		now := time.Now()
+		cv.So(lk.Locked("10.1.2.3", "bob", now), cv.ShouldBeTrue)
+		cv.So(lk.Locked("10.1.2.3", "bob", now.Add(lockDur+time.Second)), cv.ShouldBeFalse)
+	})
+}
+
+func Test160LockoutExpiresAndIgnoresKeyOffers(t *testing.T) {
+
+	cv.Convey("Failures older than LockoutDuration should be forgotten and pruned, and rejected public key offers should not count against the lockout by themselves.", t, func() {
+
+		lockDur := time.Minute
+		lk := newAuthLockout(2, lockDur)
+		now := time.Now()
+
+		// two failures far apart don't lock anyone out.
+		lk.NoteFailure("10.1.2.3", "bob", now)
+		lk.NoteFailure("10.1.2.3", "bob", now.Add(lockDur+time.Second))
+		cv.So(lk.Locked("10.1.2.3", "bob", now.Add(lockDur+2*time.Second)), cv.ShouldBeFalse)
+
+		// stale records from many sources get pruned.
+		for i := 0; i < 100; i++ {
+			lk.NoteFailure(fmt.Sprintf("10.9.0.%d", i), fmt.Sprintf("user%d", i), now)
+		}
+		cv.So(lk.size(), cv.ShouldBeGreaterThan, 200)
+		lk.NoteFailure("10.1.2.4", "carol", now.Add(3*lockDur))
+		cv.So(lk.size(), cv.ShouldEqual, 2)
+
+		// an agent offering several wrong keys before the
+		// right one must not lock out a legitimate user.
+		cfg := NewSshegoConfig()
+		cfg.MaxAuthFailures = 2
+		cfg.LockoutDuration = lockDur
+		cfg.HostDb = &HostDb{
+			cfg:     cfg,
+			Persist: HostDbPersist{Users: NewAtomicUserMap()},
+		}
+		cfg.Esshd = &Esshd{
+			cfg:     cfg,
+			lockout: newAuthLockout(cfg.MaxAuthFailures, cfg.LockoutDuration),
+		}
+		conn := &fakeConnMeta{
+			user:   "dave",
+			remote: &net.TCPAddr{IP: net.ParseIP("10.1.2.5"), Port: 4567},
+		}
+		a := NewPerAttempt(NewAuthState(nil), cfg)
+		for i := 0; i < 5; i++ {
+			_, err := a.PublicKeyCallback(conn, nil)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+		cv.So(cfg.Esshd.lockout.Locked("10.1.2.5", "dave", time.Now()), cv.ShouldBeFalse)
+
+		// if the login then fails, that is one failure.
+		a.noteLoginFailed(time.Now())
+		a.noteLoginFailed(time.Now())
+		cv.So(cfg.Esshd.lockout.Locked("10.1.2.5", "dave", time.Now()), cv.ShouldBeFalse)
+		b := NewPerAttempt(NewAuthState(nil), cfg)
+		b.PublicKeyCallback(conn, nil)
+		b.noteLoginFailed(time.Now())
+		cv.So(cfg.Esshd.lockout.Locked("10.1.2.5", "dave", time.Now()), cv.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,60 @@
+package sshego
+
+// Logger receives what a Tricorder has to say about
+// connecting, reconnecting, and its channels. keyvals
+// alternate between keys and values, as in
+//
+//	log.Warn("connect failed, will retry", "err", err, "pause", pause)
+//
+// Set SshegoConfig.Logger, or DialConfig.Logger, to route
+// these events into your own logging. The default
+// discards them.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// NopLogger discards everything. It is used when
+// no Logger has been set.
+var NopLogger Logger = nopLogger{}
+
+// logger returns cfg's Logger, or NopLogger if none.
+func (t *Tricorder) logger() Logger {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.cfg.Logger == nil {
+		return NopLogger
+	}
+	return t.cfg.Logger
+}
+
+// debug, info, warn and errorLog tag each event
+// with our Name before passing it to logger().
+func (t *Tricorder) debug(msg string, keyvals ...interface{}) {
+	t.logger().Debug(msg, t.tag(keyvals)...)
+}
+
+func (t *Tricorder) info(msg string, keyvals ...interface{}) {
+	t.logger().Info(msg, t.tag(keyvals)...)
+}
+
+func (t *Tricorder) warn(msg string, keyvals ...interface{}) {
+	t.logger().Warn(msg, t.tag(keyvals)...)
+}
+
+func (t *Tricorder) errorLog(msg string, keyvals ...interface{}) {
+	t.logger().Error(msg, t.tag(keyvals)...)
+}
+
+func (t *Tricorder) tag(keyvals []interface{}) []interface{} {
+	return append([]interface{}{"tricorder", t.GetName()}, keyvals...)
+}
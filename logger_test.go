@@ -0,0 +1,140 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// capturingLogger records "LEVEL msg" for each event,
+// and the keyvals of the last one.
+type capturingLogger struct {
+	mut    sync.Mutex
+	events []string
+	last   []interface{}
+}
+
+func (c *capturingLogger) add(level, msg string, keyvals []interface{}) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.events = append(c.events, level+" "+msg)
+	c.last = keyvals
+}
+
+func (c *capturingLogger) Debug(msg string, keyvals ...interface{}) { c.add("DEBUG", msg, keyvals) }
+func (c *capturingLogger) Info(msg string, keyvals ...interface{})  { c.add("INFO", msg, keyvals) }
+func (c *capturingLogger) Warn(msg string, keyvals ...interface{})  { c.add("WARN", msg, keyvals) }
+func (c *capturingLogger) Error(msg string, keyvals ...interface{}) { c.add("ERROR", msg, keyvals) }
+
+func (c *capturingLogger) saw(event string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for _, e := range c.events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func Test089TricorderLogsToLogger(t *testing.T) {
+	cv.Convey("A Tricorder should send its connect and reconnect events, tagged with its name, to DialConfig.Logger.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		log := &capturingLogger{}
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			ReconnectDebounce:    time.Millisecond,
+			Logger:               log,
+			LocalNickname:        "test089",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test089")
+		panicOn(err)
+
+		cv.So(log.saw("DEBUG connecting"), cv.ShouldBeTrue)
+		cv.So(log.saw("INFO connected"), cv.ShouldBeTrue)
+		log.mut.Lock()
+		cv.So(log.last[:2], cv.ShouldResemble, []interface{}{"tricorder", "test089"})
+		log.mut.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		tri.reconnectNeededCh <- tri.uhp
+		for i := 0; i < 100 && testutil.ToFloat64(tri.metrics.reconnects) < 1; i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 1)
+		cv.So(log.saw("INFO reconnect requested"), cv.ShouldBeTrue)
+		cv.So(log.saw("INFO reconnected"), cv.ShouldBeTrue)
+
+		_, err = tri.SSHChannel(context.Background(), "no-such-type", "")
+		cv.So(err, cv.ShouldEqual, ErrUnknownChannelType)
+		cv.So(log.saw("WARN unknown channel type"), cv.ShouldBeTrue)
+		cv.So(fmt.Sprint(log.last), cv.ShouldContainSubstring, "no-such-type")
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test125TricorderSetNameWhileLogging(t *testing.T) {
+	cv.Convey("SetName from another goroutine, while the Tricorder's loop is logging, should not race (run under -race), and later events should carry the new name.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		log := &capturingLogger{}
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			Logger:               log,
+			LocalNickname:        "test125",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test125")
+		panicOn(err)
+		cv.So(tri.GetName(), cv.ShouldEqual, "test125")
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				tri.SetName(fmt.Sprintf("renamed-%d", i))
+			}
+		}()
+		// each Reset logs from the loop goroutine.
+		for i := 0; i < 3; i++ {
+			panicOn(tri.Reset(context.Background()))
+		}
+		<-done
+
+		cv.So(tri.GetName(), cv.ShouldEqual, "renamed-99")
+		panicOn(tri.Reset(context.Background()))
+		log.mut.Lock()
+		cv.So(log.last[:2], cv.ShouldResemble, []interface{}{"tricorder", "renamed-99"})
+		log.mut.Unlock()
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
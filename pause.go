@@ -0,0 +1,59 @@
+package sshego
+
+import (
+	"fmt"
+)
+
+// ErrPaused is returned for channel requests, and
+// connect attempts, made while a Tricorder is paused.
+var ErrPaused = fmt.Errorf("Tricorder is paused")
+
+type pauseTicket struct {
+	done  chan struct{}
+	pause bool
+}
+
+// Pause stops the Tricorder from connecting, say for a
+// maintenance window, without shutting it down. While
+// paused, reconnect requests are held back, SSHChannel
+// fails fast with ErrPaused, and Cli returns the
+// current client as is, or ErrPaused if there is none.
+// Channels already open are left alone.
+func (t *Tricorder) Pause() {
+	t.setPaused(true)
+}
+
+// Resume undoes Pause. If a reconnect was requested
+// while we were paused, it happens now; otherwise
+// the next Cli or SSHChannel call connects as needed.
+func (t *Tricorder) Resume() {
+	t.setPaused(false)
+}
+
+func (t *Tricorder) setPaused(pause bool) {
+	tk := &pauseTicket{
+		done:  make(chan struct{}),
+		pause: pause,
+	}
+	select {
+	case t.pauseCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return
+	}
+	<-tk.done
+}
+
+// helperResume is called only on the reconnect loop.
+// stop says the loop should exit.
+func (t *Tricorder) helperResume() (stop bool) {
+	t.paused = false
+	if !t.reconnectWhilePaused {
+		return false
+	}
+	t.reconnectWhilePaused = false
+	if t.cli == nil {
+		return false
+	}
+	stop, _ = t.helperReconnect("reconnect held back by pause")
+	return stop
+}
@@ -0,0 +1,83 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test141TricorderPauseResume(t *testing.T) {
+	cv.Convey("A paused Tricorder should refuse channels with ErrPaused and hold back reconnect requests; Resume should let the held-back reconnect proceed.", t, func() {
+
+		// a sink that accepts and holds connections open.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(io.Discard, conn)
+					conn.Close()
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			ReconnectDebounce:    time.Millisecond,
+			LocalNickname:        "test141",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test141")
+		panicOn(err)
+		cli1, err := tri.Cli()
+		panicOn(err)
+
+		tri.Pause()
+		_, err = tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		cv.So(err, cv.ShouldEqual, ErrPaused)
+		cv.So(tri.Reconnect(), cv.ShouldEqual, ErrPaused)
+
+		// a reconnect request while paused is held back.
+		time.Sleep(10 * time.Millisecond) // past the debounce.
+		tri.ClientReconnectNeededTower.Broadcast(&UHP{
+			User:     s.Mylogin,
+			HostPort: tri.sshdHostPort,
+			Nickname: dc.DestNickname,
+		})
+		time.Sleep(100 * time.Millisecond)
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli, cv.ShouldEqual, cli1)
+
+		// and goes ahead on Resume.
+		tri.Resume()
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		cv.So(ch, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,157 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ErrNotConnected is returned by Ping when the
+// Tricorder has no client connection at the moment.
+var ErrNotConnected = fmt.Errorf("Tricorder is not connected")
+
+// Ping is a cheap liveness probe. It sends a
+// "keepalive@openssh.com" global request on our current
+// client connection and returns nil once any reply comes
+// back, whether the sshd honors the request or not. It
+// does not connect or reconnect: with no connection it
+// returns ErrNotConnected, and on a dead one the error
+// from sending. If ctx is done first, Ping returns ctx.Err().
+func (t *Tricorder) Ping(ctx context.Context) error {
+	cli, err := t.connectedCli(ctx)
+	if err != nil {
+		return err
+	}
+	return ping(ctx, cli)
+}
+
+// DefaultRTTSamples is how many round trips MeasureRTT
+// averages when Tricorder.RTTSamples is not set.
+const DefaultRTTSamples = 3
+
+// MeasureRTT times RTTSamples (default DefaultRTTSamples)
+// Ping round trips, one after another, and returns their
+// average, which LastRTT then reports too. Like Ping, it
+// does not connect; with no connection it returns
+// ErrNotConnected. If any round trip fails, that error is
+// returned and LastRTT is left alone.
+func (t *Tricorder) MeasureRTT(ctx context.Context) (time.Duration, error) {
+	t.mut.Lock()
+	n := t.RTTSamples
+	t.mut.Unlock()
+	if n <= 0 {
+		n = DefaultRTTSamples
+	}
+	cli, err := t.connectedCli(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		t0 := time.Now()
+		err = ping(ctx, cli)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Since(t0)
+	}
+	rtt := total / time.Duration(n)
+	t.mut.Lock()
+	t.lastRTT = rtt
+	t.mut.Unlock()
+	return rtt, nil
+}
+
+// LastRTT returns the result of the last successful
+// MeasureRTT, or 0 if there has been none.
+func (t *Tricorder) LastRTT() time.Duration {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.lastRTT
+}
+
+// StartBackgroundPing runs Ping every interval, on a
+// goroutine of its own, until StopBackgroundPing or the
+// Tricorder shuts down. Each Ping gets interval to
+// finish. When one fails, onFailure, if not nil, is
+// called with the error, on that goroutine; it might,
+// say, call Reconnect. Starting again replaces the
+// running pinger.
+func (t *Tricorder) StartBackgroundPing(interval time.Duration, onFailure func(err error)) {
+	halt := ssh.NewHalter()
+	t.mut.Lock()
+	old := t.bgPing
+	t.bgPing = halt
+	t.mut.Unlock()
+	if old != nil {
+		old.RequestStop()
+	}
+	t.Halt.AddDownstream(halt)
+
+	go func() {
+		defer func() {
+			t.Halt.RemoveDownstream(halt)
+			halt.MarkDone()
+		}()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+			case <-halt.ReqStopChan():
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := t.Ping(ctx)
+			cancel()
+			if err != nil && !halt.IsStopRequested() {
+				t.debug("background ping failed", "err", err)
+				if onFailure != nil {
+					onFailure(err)
+				}
+			}
+		}
+	}()
+}
+
+// StopBackgroundPing stops the pinger started by
+// StartBackgroundPing; onFailure won't be called after.
+// It does not wait, so onFailure may call it. It does
+// nothing if no pinger is running.
+func (t *Tricorder) StopBackgroundPing() {
+	t.mut.Lock()
+	halt := t.bgPing
+	t.bgPing = nil
+	t.mut.Unlock()
+	if halt != nil {
+		halt.RequestStop()
+	}
+}
+
+// connectedCli returns our current client, without
+// connecting if we have none.
+func (t *Tricorder) connectedCli(ctx context.Context) (*ssh.Client, error) {
+	tk := &getCliTicket{done: make(chan struct{}), noConnect: true}
+	select {
+	case t.getCliCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return nil, ErrShutdown
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	<-tk.done
+	if tk.cli == nil {
+		return nil, ErrNotConnected
+	}
+	return tk.cli, nil
+}
+
+func ping(ctx context.Context, cli *ssh.Client) error {
+	_, _, err := cli.SendRequest(ctx, "keepalive@openssh.com", true, nil)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
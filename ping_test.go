@@ -0,0 +1,147 @@
+package sshego
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test112TricorderPing(t *testing.T) {
+	cv.Convey("Tricorder.Ping should return nil on a healthy connection, and an error once the underlying net.Conn is closed.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			// don't let a reconnect replace the dead client.
+			ReconnectDebounce: time.Hour,
+			LocalNickname:     "test112",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test112")
+		panicOn(err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		cv.So(tri.Ping(ctx), cv.ShouldBeNil)
+
+		nc, err := tri.Nc()
+		panicOn(err)
+		nc.Close()
+		cv.So(tri.Ping(ctx), cv.ShouldNotBeNil)
+		cv.So(ctx.Err(), cv.ShouldBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test128TricorderMeasureRTT(t *testing.T) {
+	cv.Convey("Tricorder.MeasureRTT should average keepalive round trips to a loopback sshd, well under 5ms, and LastRTT should report it.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test128",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test128")
+		panicOn(err)
+		cv.So(tri.LastRTT(), cv.ShouldEqual, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		rtt, err := tri.MeasureRTT(ctx)
+		panicOn(err)
+		cv.So(rtt, cv.ShouldBeGreaterThan, 0)
+		cv.So(rtt, cv.ShouldBeLessThan, 5*time.Millisecond)
+		cv.So(tri.LastRTT(), cv.ShouldEqual, rtt)
+
+		tri.RTTSamples = 10
+		rtt, err = tri.MeasureRTT(ctx)
+		panicOn(err)
+		cv.So(rtt, cv.ShouldBeLessThan, 5*time.Millisecond)
+		cv.So(tri.LastRTT(), cv.ShouldEqual, rtt)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test155TricorderBackgroundPing(t *testing.T) {
+	cv.Convey("Tricorder.StartBackgroundPing should call onFailure within two intervals of the connection dropping, and not after StopBackgroundPing.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			// don't let a reconnect replace the dead client.
+			ReconnectDebounce: time.Hour,
+			LocalNickname:     "test155",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test155")
+		panicOn(err)
+
+		const interval = 200 * time.Millisecond
+		failed := make(chan error, 100)
+		tri.StartBackgroundPing(interval, func(err error) {
+			failed <- err
+		})
+
+		// healthy: no failures.
+		time.Sleep(3 * interval)
+		cv.So(len(failed), cv.ShouldEqual, 0)
+
+		// the server goes away.
+		nc, err := tri.Nc()
+		panicOn(err)
+		nc.Close()
+		dropped := time.Now()
+		select {
+		case err := <-failed:
+			cv.So(err, cv.ShouldNotBeNil)
+			cv.So(time.Since(dropped), cv.ShouldBeLessThanOrEqualTo, 2*interval)
+		case <-time.After(10 * time.Second):
+			panic("onFailure never called")
+		}
+
+		tri.StopBackgroundPing()
+		time.Sleep(interval)
+		for len(failed) > 0 {
+			<-failed
+		}
+		time.Sleep(3 * interval)
+		cv.So(len(failed), cv.ShouldEqual, 0)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,91 @@
+package sshego
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// proxyV2Sig starts every PROXY protocol v2 header.
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader returns the PROXY protocol header, version
+// 1 (text) or 2 (binary), announcing a TCP connection
+// from src to dst. If either is not a TCP address, or
+// they are of different IP families, the header says
+// the addresses are unknown.
+func proxyHeader(version int, src, dst net.Addr) ([]byte, error) {
+	s, _ := src.(*net.TCPAddr)
+	d, _ := dst.(*net.TCPAddr)
+	known := s != nil && d != nil && (s.IP.To4() == nil) == (d.IP.To4() == nil)
+	v4 := known && s.IP.To4() != nil
+
+	switch version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		fam := "TCP6"
+		if v4 {
+			fam = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", fam, s.IP, d.IP, s.Port, d.Port)), nil
+
+	case 2:
+		hdr := append([]byte(nil), proxyV2Sig...)
+		// version 2, PROXY command.
+		hdr = append(hdr, 0x21)
+		if !known {
+			return append(hdr, 0x00, 0, 0), nil
+		}
+		var sip, dip net.IP
+		if v4 {
+			hdr = append(hdr, 0x11) // TCP over IPv4
+			sip, dip = s.IP.To4(), d.IP.To4()
+		} else {
+			hdr = append(hdr, 0x21) // TCP over IPv6
+			sip, dip = s.IP.To16(), d.IP.To16()
+		}
+		var n [2]byte
+		binary.BigEndian.PutUint16(n[:], uint16(2*len(sip)+4))
+		hdr = append(hdr, n[:]...)
+		hdr = append(hdr, sip...)
+		hdr = append(hdr, dip...)
+		binary.BigEndian.PutUint16(n[:], uint16(s.Port))
+		hdr = append(hdr, n[:]...)
+		binary.BigEndian.PutUint16(n[:], uint16(d.Port))
+		hdr = append(hdr, n[:]...)
+		return hdr, nil
+	}
+	return nil, fmt.Errorf("unknown PROXY protocol version %d; want 1 or 2", version)
+}
+
+// forwardOpts returns the ChannelOpts for a channel
+// that forwards a connection accepted from origin: a
+// PROXY header naming origin if DialConfig.ProxyProtocol
+// asks for one, and nil otherwise.
+func (t *Tricorder) forwardOpts(origin net.Addr) *ChannelOpts {
+	t.mut.Lock()
+	version := t.dc.ProxyProtocol
+	t.mut.Unlock()
+	if version == 0 {
+		return nil
+	}
+	return &ChannelOpts{ProxyProtocol: version, ProxyOrigin: origin}
+}
+
+// tcpAddrOf returns hostport as a *net.TCPAddr if its
+// host is an IP literal, and nil otherwise.
+func tcpAddrOf(hostport string) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	n, err := strconv.Atoi(port)
+	if ip == nil || err != nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: n}
+}
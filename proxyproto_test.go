@@ -0,0 +1,189 @@
+package sshego
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test144ProxyProtocolHeader(t *testing.T) {
+	cv.Convey("With ChannelOpts.ProxyProtocol set to 1, the backend of a direct-tcpip forward should first read a PROXY v1 line naming the origin and target; version 2 headers should follow the binary format.", t, func() {
+
+		// a backend that reports the first line it reads.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		lines := make(chan string, 2)
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					line, err := bufio.NewReader(conn).ReadString('\n')
+					if err == nil {
+						lines <- line
+					}
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+		readLine := func() string {
+			select {
+			case line := <-lines:
+				return line
+			case <-time.After(10 * time.Second):
+				panic("backend never got a PROXY line")
+			}
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test144",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test144")
+		panicOn(err)
+
+		origin := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5555}
+		ch, err := tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest,
+			&ChannelOpts{ProxyProtocol: 1, ProxyOrigin: origin})
+		panicOn(err)
+		cv.So(readLine(), cv.ShouldEqual, fmt.Sprintf("PROXY TCP4 192.0.2.7 127.0.0.1 5555 %v\r\n", port))
+		ch.Close()
+
+		// without ProxyOrigin, the source is unknown.
+		ch, err = tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest, &ChannelOpts{ProxyProtocol: 1})
+		panicOn(err)
+		cv.So(readLine(), cv.ShouldEqual, "PROXY UNKNOWN\r\n")
+		ch.Close()
+
+		_, err = tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest, &ChannelOpts{ProxyProtocol: 3})
+		cv.So(err, cv.ShouldNotBeNil)
+
+		hdr, err := proxyHeader(2, origin, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 443})
+		panicOn(err)
+		cv.So(hdr, cv.ShouldResemble, []byte{
+			0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a,
+			0x21, 0x11, 0x00, 0x0c,
+			192, 0, 2, 7, 10, 1, 2, 3,
+			0x15, 0xb3, 0x01, 0xbb,
+		})
+		hdr, err = proxyHeader(1, origin, nil)
+		panicOn(err)
+		cv.So(string(hdr), cv.ShouldEqual, "PROXY UNKNOWN\r\n")
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test163ForwardersSendProxyOrigin(t *testing.T) {
+	cv.Convey("With DialConfig.ProxyProtocol set, ListenAndForward and ServeSOCKS5, and SshegoConfig.ProxyProtocol the -listen forward tunnel, should start each forward with a PROXY line naming the connection they accepted as the source.", t, func() {
+
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		lines := make(chan string, 2)
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					line, err := bufio.NewReader(conn).ReadString('\n')
+					if err == nil {
+						lines <- line
+					}
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+		readLine := func() string {
+			select {
+			case line := <-lines:
+				return line
+			case <-time.After(10 * time.Second):
+				panic("backend never got a PROXY line")
+			}
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test163",
+			ProxyProtocol:        1,
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test163")
+		panicOn(err)
+		want := func(c net.Conn) string {
+			origin := c.LocalAddr().(*net.TCPAddr)
+			return fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %v %v\r\n", origin.Port, port)
+		}
+
+		fwd, err := tri.ListenAndForward(context.Background(), "127.0.0.1:0", dest)
+		panicOn(err)
+		c, err := net.Dial("tcp", fwd.Addr().String())
+		panicOn(err)
+		cv.So(readLine(), cv.ShouldEqual, want(c))
+		c.Close()
+		fwd.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		go tri.ServeSOCKS5(ln)
+		c, rep, err := socks5Connect(ln.Addr().String(), dest)
+		panicOn(err)
+		cv.So(rep, cv.ShouldEqual, socks5Succeeded)
+		cv.So(readLine(), cv.ShouldEqual, want(c))
+		c.Close()
+		ln.Close()
+
+		// the -listen forward tunnel, as StartupForwardListener
+		// runs it, goes by SshegoConfig.ProxyProtocol.
+		cli, err := tri.Cli()
+		panicOn(err)
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		c, err = net.Dial("tcp", ln.Addr().String())
+		panicOn(err)
+		fromBrowser, err := ln.Accept()
+		panicOn(err)
+		ln.Close()
+		cfg := &SshegoConfig{ProxyProtocol: 1}
+		cfg.LocalToRemote.Remote.Addr = dest
+		cv.So(NewForward(context.Background(), cfg, cli, fromBrowser), cv.ShouldNotBeNil)
+		cv.So(readLine(), cv.ShouldEqual, want(c))
+		c.Close()
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
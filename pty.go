@@ -48,6 +48,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"sync"
 
@@ -96,8 +97,18 @@ func (cfg *SshegoConfig) handleChannel(ctx context.Context, newChannel ssh.NewCh
 	}
 	t := newChannel.ChannelType()
 
+	if audit := cfg.auditLogger(); audit != nil {
+		audit.ChannelOpen(sshconn, t)
+	}
+
+	if cfg.BastionMode && t != "direct-tcpip" && t != "forwarded-tcpip" {
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("bastion: channel type %s not allowed", t))
+		return
+	}
+
 	if t == "direct-tcpip" {
-		handleDirectTcp(ctx, cfg.Halt, newChannel, ca)
+		handleDirectTcp(ctx, cfg.Halt, newChannel, ca, cfg.AllowForwardTo, cfg.ForwardDialer)
+		return
 	}
 
 	if t != "session" {
@@ -121,9 +132,21 @@ func (cfg *SshegoConfig) handleChannel(ctx context.Context, newChannel ssh.NewCh
 		log.Printf("Could not accept channel (%s)", err)
 		return
 	}
+	cfg.handleSession(ctx, cfg.limitSession(connection), requests, sshconn)
+}
 
-	// Fire up bash for this session
-	bash := exec.Command("bash")
+// handleSession serves the requests on an accepted
+// "session" channel. A "subsystem" request for a
+// registered SubsystemHandler hands the channel over
+// to it; a "shell" request starts bash on a pty.
+func (cfg *SshegoConfig) handleSession(ctx context.Context, connection ssh.Channel, requests <-chan *ssh.Request, sshconn ssh.Conn) {
+
+	var bash *exec.Cmd
+	var bashf *os.File
+
+	// a pty-req or window-change may come before
+	// the shell request that starts bash.
+	var w, h uint32
 
 	// Prepare teardown function
 	close := func() {
@@ -135,25 +158,35 @@ func (cfg *SshegoConfig) handleChannel(ctx context.Context, newChannel ssh.NewCh
 		log.Printf("Session closed")
 	}
 
-	// Allocate a terminal for this channel
-	log.Print("Successful login, creating pty...")
-	bashf, err := ptyStart(bash)
-	if err != nil {
-		log.Printf("Could not start pty (%s)", err)
-		close()
-		return
-	}
+	startBash := func() bool {
+		// Fire up bash for this session
+		bash = exec.Command("bash")
 
-	//pipe session to bash and visa-versa
-	var once sync.Once
-	go func() {
-		io.Copy(connection, bashf)
-		once.Do(close)
-	}()
-	go func() {
-		io.Copy(bashf, connection)
-		once.Do(close)
-	}()
+		// Allocate a terminal for this channel
+		log.Print("Successful login, creating pty...")
+		var err error
+		bashf, err = ptyStart(bash)
+		if err != nil {
+			log.Printf("Could not start pty (%s)", err)
+			connection.Close()
+			return false
+		}
+		if w > 0 && h > 0 {
+			SetWinsize(bashf.Fd(), w, h)
+		}
+
+		//pipe session to bash and visa-versa
+		var once sync.Once
+		go func() {
+			io.Copy(connection, cfg.recordSession(bashf, sshconn.SessionID()))
+			once.Do(close)
+		}()
+		go func() {
+			io.Copy(bashf, connection)
+			once.Do(close)
+		}()
+		return true
+	}
 
 	// Sessions have out-of-band requests such as "shell", "pty-req" and "env"
 	go func() {
@@ -162,21 +195,47 @@ func (cfg *SshegoConfig) handleChannel(ctx context.Context, newChannel ssh.NewCh
 			case "shell":
 				// We only accept the default shell
 				// (i.e. no command in the Payload)
-				if len(req.Payload) == 0 {
+				if len(req.Payload) == 0 && bash == nil {
+					if !startBash() {
+						req.Reply(false, nil)
+						return
+					}
 					req.Reply(true, nil)
 				}
+			case "subsystem":
+				var m struct{ Name string }
+				var fn SubsystemHandler
+				if bash == nil && ssh.Unmarshal(req.Payload, &m) == nil {
+					fn = cfg.subsystemHandler(m.Name)
+				}
+				if fn == nil {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				// the handler gets the rest of the requests.
+				fn(ctx, cfg.recordChannel(connection, sshconn.SessionID()), requests)
+				connection.Close()
+				return
 			case "pty-req":
 				termLen := req.Payload[3]
-				w, h := parseDims(req.Payload[termLen+4:])
-				SetWinsize(bashf.Fd(), w, h)
+				w, h = parseDims(req.Payload[termLen+4:])
+				if bashf != nil {
+					SetWinsize(bashf.Fd(), w, h)
+				}
 				// Responding true (OK) here will let the client
 				// know we have a pty ready for input
 				req.Reply(true, nil)
 			case "window-change":
-				w, h := parseDims(req.Payload)
-				SetWinsize(bashf.Fd(), w, h)
+				w, h = parseDims(req.Payload)
+				if bashf != nil {
+					SetWinsize(bashf.Fd(), w, h)
+				}
 			}
 		}
+		if bash == nil {
+			connection.Close()
+		}
 	}()
 }
 
@@ -0,0 +1,94 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test138AutomaticRekey(t *testing.T) {
+	cv.Convey("With RekeyBytesThreshold set, pushing more than that many bytes should bring on a new key exchange by itself, as should waiting past RekeyTimeThreshold; thresholds absurdly low should be refused.", t, func() {
+
+		// a sink that reads and discards.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(io.Discard, conn)
+					conn.Close()
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		newDc := func(name string) *DialConfig {
+			return &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				LocalNickname:        name,
+			}
+		}
+		waitForKex := func(tri *Tricorder, n int, limit time.Duration) int {
+			cli, err := tri.Cli()
+			panicOn(err)
+			deadline := time.Now().Add(limit)
+			for {
+				got := cli.NegotiatedAlgorithms().KeyExchanges
+				if got >= n || time.Now().After(deadline) {
+					return got
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		dc := newDc("test138-bytes")
+		dc.RekeyBytesThreshold = MinRekeyBytesThreshold
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test138-bytes")
+		panicOn(err)
+		cv.So(waitForKex(tri, 1, time.Second), cv.ShouldEqual, 1)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		_, err = ch.Write(make([]byte, 1<<20))
+		panicOn(err)
+		cv.So(waitForKex(tri, 2, 10*time.Second), cv.ShouldBeGreaterThanOrEqualTo, 2)
+		tri.Halt.RequestStop()
+
+		dc = newDc("test138-time")
+		dc.RekeyTimeThreshold = MinRekeyTimeThreshold
+		tri, err = NewTricorder(dc, s.CliCfg.Halt, "test138-time")
+		panicOn(err)
+		cv.So(waitForKex(tri, 3, 10*time.Second), cv.ShouldBeGreaterThanOrEqualTo, 3)
+		tri.Halt.RequestStop()
+
+		dc = newDc("test138-low")
+		dc.RekeyBytesThreshold = 1024
+		cv.So(dc.Validate(), cv.ShouldNotBeNil)
+		_, err = dc.DeriveNewConfig()
+		cv.So(err, cv.ShouldNotBeNil)
+		dc.RekeyBytesThreshold = 0
+		dc.RekeyTimeThreshold = time.Millisecond
+		cv.So(dc.Validate(), cv.ShouldNotBeNil)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,80 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// remoteForward is the io.Closer returned
+// by Tricorder.OpenRemoteForward. Closing it
+// cancels the forward on the sshd.
+type remoteForward struct {
+	*forwardListener
+	localAddr string
+}
+
+// OpenRemoteForward does remote port forwarding, like
+// ssh -R. It asks the sshd, with a "tcpip-forward" global
+// request, to listen on remoteAddr, and then proxies each
+// "forwarded-tcpip" channel the sshd sends us on to a new
+// tcp connection to localAddr. Close the returned io.Closer
+// to cancel the forward. The forward belongs to the current
+// client connection, and does not survive a reconnect.
+// The host in remoteAddr is resolved by the sshd, not by
+// us, and may be left empty to listen on all its addresses.
+func (t *Tricorder) OpenRemoteForward(ctx context.Context, remoteAddr, localAddr string) (io.Closer, error) {
+	host, portStr, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRemoteForward: bad port in '%s': %v", remoteAddr, err)
+	}
+	cli, err := t.Cli()
+	if err != nil {
+		return nil, err
+	}
+	if cli == nil {
+		return nil, fmt.Errorf("OpenRemoteForward: no ssh client to '%s'", t.sshdHostPort)
+	}
+	lsn, err := cli.ListenTCPHost(ctx, host, port)
+	if err != nil {
+		return nil, err
+	}
+	f := &remoteForward{
+		forwardListener: t.newForwardListener(lsn),
+		localAddr:       localAddr,
+	}
+	go f.serve()
+	return f, nil
+}
+
+func (f *remoteForward) serve() {
+	defer f.Halt.MarkDone()
+
+	// also stop on Tricorder shutdown.
+	f.closeOnStop(nil)
+
+	for {
+		fromRemote, err := f.Accept()
+		if err != nil {
+			// io.EOF once the forward is closed or
+			// the client connection goes away.
+			f.tri.debug("OpenRemoteForward: Accept ended, stopping", "err", err)
+			return
+		}
+		toLocal, err := net.Dial("tcp", f.localAddr)
+		if err != nil {
+			f.tri.warn("OpenRemoteForward: could not dial local address", "addr", f.localAddr, "err", err)
+			fromRemote.Close()
+			continue
+		}
+		f.tri.applyTCPOptions(toLocal)
+		sp := newShovelPair(false)
+		sp.Start(fromRemote, toLocal, "fromRemote<-toLocal", "toLocal<-fromRemote")
+	}
+}
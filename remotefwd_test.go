@@ -0,0 +1,341 @@
+package sshego
+
+import (
+	"context"
+	cryptrand "crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// startTcpipForwardTestServer runs a bare-bones sshd
+// that accepts any client and honors "tcpip-forward"
+// and "cancel-tcpip-forward", since the embedded esshd
+// does not do remote forwarding. It also serves "exec"
+// and "sftp" subsystem requests on "session" channels,
+// which the esshd does not do either; see serveTestSession.
+// It returns the address it is listening on. If tweak is not nil, it may
+// adjust the server's config before we listen.
+func startTcpipForwardTestServer(halt *ssh.Halter, tweak func(*ssh.ServerConfig)) string {
+	key, err := rsa.GenerateKey(cryptrand.Reader, 2048)
+	panicOn(err)
+	signer, err := ssh.NewSignerFromKey(key)
+	panicOn(err)
+
+	config := &ssh.ServerConfig{
+		NoClientAuth: true,
+		Config: ssh.Config{
+			Ciphers: getCiphers(),
+			Halt:    halt,
+		},
+	}
+	config.AddHostKey(signer)
+	if tweak != nil {
+		tweak(config)
+	}
+
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	panicOn(err)
+	go func() {
+		<-halt.ReqStopChan()
+		lsn.Close()
+	}()
+	go func() {
+		for {
+			nConn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			go serveTcpipForward(nConn, config, halt)
+		}
+	}()
+	return lsn.Addr().String()
+}
+
+func serveTcpipForward(nConn net.Conn, config *ssh.ServerConfig, halt *ssh.Halter) {
+	ctx := context.Background()
+	sc, chans, reqs, err := ssh.NewServerConn(ctx, nConn, config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for nc := range chans {
+			if nc.ChannelType() != "session" {
+				nc.Reject(ssh.Prohibited, "no channels here")
+				continue
+			}
+			ch, in, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go serveTestSession(ctx, ch, in)
+		}
+	}()
+
+	forwards := make(map[string]net.Listener)
+	for req := range reqs {
+		var m struct {
+			Addr string
+			Port uint32
+		}
+		switch req.Type {
+		case "tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &m) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			lsn, err := net.Listen("tcp", net.JoinHostPort(m.Addr, strconv.Itoa(int(m.Port))))
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			port := uint32(lsn.Addr().(*net.TCPAddr).Port)
+			// keyed by the bind address as the client
+			// sent it, to match cancel-tcpip-forward.
+			forwards[net.JoinHostPort(m.Addr, strconv.Itoa(int(port)))] = lsn
+			go acceptForwarded(ctx, sc, lsn, m.Addr, port)
+			req.Reply(true, ssh.Marshal(&struct{ Port uint32 }{port}))
+
+		case "cancel-tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &m) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			key := net.JoinHostPort(m.Addr, strconv.Itoa(int(m.Port)))
+			lsn, ok := forwards[key]
+			if ok {
+				lsn.Close()
+				delete(forwards, key)
+			}
+			req.Reply(ok, nil)
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+	for _, lsn := range forwards {
+		lsn.Close()
+	}
+}
+
+// serveTestSession runs the first "exec" or "subsystem"
+// request on ch, then closes ch. After an exec we send
+// its "exit-status". Like an sshd whose AcceptEnv is
+// "*", "env" requests are honored, except for names
+// starting with "REJECT". The only subsystem is "sftp",
+// which gets as far as the version handshake.
+func serveTestSession(ctx context.Context, ch ssh.Channel, in <-chan *ssh.Request) {
+	defer ch.Close()
+	var env []string
+	for req := range in {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ok := ssh.Unmarshal(req.Payload, &kv) == nil &&
+				!strings.HasPrefix(kv.Name, "REJECT")
+			if ok {
+				env = append(env, kv.Name+"="+kv.Value)
+			}
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+
+		case "exec":
+			var m struct{ Command string }
+			if ssh.Unmarshal(req.Payload, &m) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go rejectChannelRequests(in)
+
+			cmd := exec.Command("sh", "-c", m.Command)
+			cmd.Env = append(os.Environ(), env...)
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			var status uint32
+			if err := cmd.Run(); err != nil {
+				status = 255
+				if ee, ok := err.(*exec.ExitError); ok {
+					status = uint32(ee.Sys().(syscall.WaitStatus).ExitStatus())
+				}
+			}
+			ch.CloseWrite()
+			ch.SendRequest("exit-status", false, ssh.Marshal(&struct{ Status uint32 }{status}))
+			return
+
+		case "subsystem":
+			var m struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &m) != nil || m.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go rejectChannelRequests(in)
+			serveSftpVersion(ch)
+			return
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// SFTP packet types, from draft-ietf-secsh-filexfer-02.
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+)
+
+// serveSftpVersion answers an SSH_FXP_INIT with an
+// SSH_FXP_VERSION for version 3, then waits for the
+// client to hang up.
+func serveSftpVersion(ch ssh.Channel) {
+	var hdr [9]byte
+	if _, err := io.ReadFull(ch, hdr[:]); err != nil {
+		return
+	}
+	if hdr[4] != sshFxpInit {
+		return
+	}
+	// length 5: the type byte and the uint32 version.
+	ch.Write([]byte{0, 0, 0, 5, sshFxpVersion, 0, 0, 0, 3})
+	io.Copy(ioutil.Discard, ch)
+}
+
+func acceptForwarded(ctx context.Context, sc *ssh.ServerConn, lsn net.Listener, addr string, port uint32) {
+	for {
+		conn, err := lsn.Accept()
+		if err != nil {
+			return
+		}
+		origin := conn.RemoteAddr().(*net.TCPAddr)
+		payload := ssh.Marshal(&struct {
+			Addr       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}{addr, port, origin.IP.String(), uint32(origin.Port)})
+
+		ch, in, err := sc.OpenChannel(ctx, "forwarded-tcpip", payload, nil)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go ssh.DiscardRequests(ctx, in, nil)
+		go func() {
+			io.Copy(ch, conn)
+			ch.CloseWrite()
+		}()
+		go func() {
+			io.Copy(conn, ch)
+			conn.Close()
+		}()
+	}
+}
+
+func Test072TricorderRemoteForward(t *testing.T) {
+	cv.Convey("Tricorder.OpenRemoteForward should have the sshd listen on a remote port, and a connection to that port should reach our local listener.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		// the local service the forward leads to.
+		localLsn, localPort := GetAvailPort()
+		localMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			localMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			localLsn,
+			nil)
+		localAddr := fmt.Sprintf("127.0.0.1:%v", localPort)
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, nil)
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             sshdHost,
+			Sshdport:             sshdPort,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test072",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test072")
+		panicOn(err)
+
+		remoteLsn, remotePort := GetAvailPort()
+		remoteLsn.Close()
+		remoteAddr := fmt.Sprintf("127.0.0.1:%v", remotePort)
+
+		fwd, err := tri.OpenRemoteForward(context.Background(), remoteAddr, localAddr)
+		panicOn(err)
+
+		conn, err := net.Dial("tcp", remoteAddr)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(conn, confirmationPayload, confirmationReply, payloadByteCount)
+		conn.Close()
+
+		cv.So(fwd.Close(), cv.ShouldBeNil)
+		// closing again is harmless.
+		cv.So(fwd.Close(), cv.ShouldBeNil)
+
+		// the sshd has stopped listening.
+		_, err = net.Dial("tcp", remoteAddr)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		// a host name goes to the sshd unresolved. The
+		// test server only takes one connection, so
+		// start another.
+		localLsn2, localPort2 := GetAvailPort()
+		StartBackgroundTestTcpServer(
+			localMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			localLsn2,
+			nil)
+		remoteLsn, remotePort = GetAvailPort()
+		remoteLsn.Close()
+		fwd, err = tri.OpenRemoteForward(context.Background(), fmt.Sprintf("localhost:%v", remotePort), fmt.Sprintf("127.0.0.1:%v", localPort2))
+		panicOn(err)
+		conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%v", remotePort))
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(conn, confirmationPayload, confirmationReply, payloadByteCount)
+		conn.Close()
+		cv.So(fwd.Close(), cv.ShouldBeNil)
+
+		localMgr.RequestStop()
+		tri.Halt.RequestStop()
+	})
+}
@@ -0,0 +1,86 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test154ChannelOptsLocalResolve(t *testing.T) {
+	cv.Convey("By default, a host name only the sshd can resolve should work as a direct-tcpip target; with ChannelOpts.LocalResolve, resolving it ourselves should fail.", t, func() {
+
+		// an echo backend.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// the sshd alone knows backend.invalid.
+		const serverOnly = "backend.invalid"
+		s.SrvCfg.ForwardDialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, strings.Replace(addr, serverOnly, "127.0.0.1", 1))
+		}
+		s.SrvCfg.Esshd.Start(context.Background())
+		dest := fmt.Sprintf("%v:%v", serverOnly, port)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test154",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test154")
+		panicOn(err)
+
+		ch, err := tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest, &ChannelOpts{})
+		panicOn(err)
+		_, err = ch.Write([]byte("resolved remotely"))
+		panicOn(err)
+		back := make([]byte, len("resolved remotely"))
+		done := make(chan error, 1)
+		go func() {
+			_, err := io.ReadFull(ch, back)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			panicOn(err)
+		case <-time.After(10 * time.Second):
+			panic("no echo through the channel")
+		}
+		cv.So(string(back), cv.ShouldEqual, "resolved remotely")
+		ch.Close()
+
+		_, err = tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest, &ChannelOpts{LocalResolve: true})
+		cv.So(err, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
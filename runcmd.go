@@ -0,0 +1,53 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// RunCommand runs cmd on the sshd's host in a fresh
+// "session" channel, as `ssh host cmd` would, and collects
+// its standard output, its standard error (the channel's
+// extended data), and its exit status. A command that
+// runs but exits non-zero is not an error; see exitCode.
+// If ctx is done first we close the session and
+// return ctx.Err().
+func (t *Tricorder) RunCommand(ctx context.Context, cmd string) (stdout, stderr []byte, exitCode int, err error) {
+	return t.RunCommandOpts(ctx, cmd, nil)
+}
+
+// RunCommandOpts is RunCommand with opts applied to
+// the session before cmd starts.
+func (t *Tricorder) RunCommandOpts(ctx context.Context, cmd string, opts *SessionOpts) (stdout, stderr []byte, exitCode int, err error) {
+	sess, err := t.newSession(ctx, opts)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	defer sess.Close()
+
+	var outBuf, errBuf bytes.Buffer
+	sess.Stdout = &outBuf
+	sess.Stderr = &errBuf
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Run(cmd)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return nil, nil, -1, ctx.Err()
+	case <-t.Halt.ReqStopChan():
+		return nil, nil, -1, ErrShutdown
+	}
+
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return outBuf.Bytes(), errBuf.Bytes(), exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return outBuf.Bytes(), errBuf.Bytes(), -1, err
+	}
+	return outBuf.Bytes(), errBuf.Bytes(), 0, nil
+}
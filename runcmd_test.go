@@ -0,0 +1,150 @@
+package sshego
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test091TricorderRunCommand(t *testing.T) {
+	cv.Convey("Tricorder.RunCommand should return a remote command's stdout, stderr, and exit status separately, and RunCommandOpts should pass env vars.", t, func() {
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, nil)
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		log := &capturingLogger{}
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             sshdHost,
+			Sshdport:             sshdPort,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test091",
+			Logger:               log,
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test091")
+		panicOn(err)
+		ctx := context.Background()
+
+		stdout, stderr, code, err := tri.RunCommand(ctx, "echo hi")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(stdout), cv.ShouldEqual, "hi\n")
+		cv.So(string(stderr), cv.ShouldEqual, "")
+		cv.So(code, cv.ShouldEqual, 0)
+
+		stdout, stderr, code, err = tri.RunCommand(ctx, "sh -c 'echo err 1>&2; exit 3'")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(stdout), cv.ShouldEqual, "")
+		cv.So(string(stderr), cv.ShouldEqual, "err\n")
+		cv.So(code, cv.ShouldEqual, 3)
+
+		// env vars reach the command; rejected ones
+		// are only warned about.
+		stdout, _, code, err = tri.RunCommandOpts(ctx, "sh -c 'echo $FOO$REJECTED'", &SessionOpts{
+			Env: map[string]string{"FOO": "bar", "REJECTED": "baz"},
+		})
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(string(stdout), cv.ShouldEqual, "bar\n")
+		cv.So(code, cv.ShouldEqual, 0)
+		cv.So(log.saw("WARN sshd rejected env var"), cv.ShouldBeTrue)
+
+		// a context that is already done never runs cmd.
+		done, cancel := context.WithCancel(ctx)
+		cancel()
+		_, _, code, err = tri.RunCommand(done, "echo hi")
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(code, cv.ShouldEqual, -1)
+
+		tri.Halt.RequestStop()
+	})
+}
+
+func Test156TricorderOpenChannelRaw(t *testing.T) {
+	cv.Convey("Tricorder.OpenChannelRaw should give us a session channel's requests, so that we see the sshd's exit-status.", t, func() {
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, nil)
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             sshdHost,
+			Sshdport:             sshdPort,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test156",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test156")
+		panicOn(err)
+		ctx := context.Background()
+
+		ch, in, err := tri.OpenChannelRaw(ctx, "session", nil)
+		panicOn(err)
+		defer ch.Close()
+		go io.Copy(ioutil.Discard, ch)
+
+		// tracked like any other channel.
+		infos, err := tri.ListChannels()
+		panicOn(err)
+		cv.So(len(infos), cv.ShouldEqual, 1)
+		cv.So(infos[0].Channel, cv.ShouldEqual, ch)
+
+		ok, err := ch.SendRequest("exec", true, ssh.Marshal(&struct{ Command string }{"exit 7"}))
+		panicOn(err)
+		cv.So(ok, cv.ShouldBeTrue)
+
+		var status uint32
+		found := false
+	wait:
+		for {
+			select {
+			case req, open := <-in:
+				if !open {
+					break wait
+				}
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				if req.Type == "exit-status" {
+					var msg struct{ Status uint32 }
+					panicOn(ssh.Unmarshal(req.Payload, &msg))
+					status = msg.Status
+					found = true
+					break wait
+				}
+			case <-time.After(10 * time.Second):
+				panic("no exit-status")
+			}
+		}
+		cv.So(found, cv.ShouldBeTrue)
+		cv.So(status, cv.ShouldEqual, 7)
+
+		tri.Halt.RequestStop()
+	})
+}
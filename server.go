@@ -33,9 +33,18 @@ type Esshd struct {
 
 	updateHostKey chan ssh.Signer
 
+	// counts failed logins, see SshegoConfig.MaxAuthFailures
+	lockout *authLockout
+
 	mut sync.Mutex
 
 	cr *CommandRecv
+
+	// see GracefulShutdown. conns is guarded by mut.
+	conns            map[net.Conn]bool
+	drainReq         chan struct{}
+	drainOnce        sync.Once
+	stoppedAccepting chan struct{}
 }
 
 func (e *Esshd) Stop() error {
@@ -69,6 +78,10 @@ func (cfg *SshegoConfig) NewEsshd() *Esshd {
 		delUserReq:           make(chan *User),
 		replyWithDeletedDone: make(chan bool),
 		updateHostKey:        make(chan ssh.Signer),
+		lockout:              newAuthLockout(cfg.MaxAuthFailures, cfg.LockoutDuration),
+		conns:                make(map[net.Conn]bool),
+		drainReq:             make(chan struct{}),
+		stoppedAccepting:     make(chan struct{}),
 	}
 	if srv.cfg.HostDb == nil {
 		err := srv.cfg.NewHostDb()
@@ -98,6 +111,20 @@ type PerAttempt struct {
 	Config *ssh.ServerConfig
 
 	cfg *SshegoConfig
+
+	// authorizedKeys caches what cfg.AuthorizedKeysCallback
+	// returned, so it is asked only once per connection.
+	authorizedKeys    []ssh.PublicKey
+	authorizedKeysErr error
+	authorizedKeysSet bool
+
+	// keyRejectedConn is set when we turn down an offered
+	// public key. A client may offer several keys before
+	// the right one, so we only count this against the
+	// lockout if the connection's login fails overall,
+	// and no other failure was noted for it.
+	keyRejectedConn ssh.ConnMetadata
+	failureNoted    bool
 }
 
 func NewPerAttempt(s *AuthState, cfg *SshegoConfig) *PerAttempt {
@@ -413,6 +440,18 @@ func (e *Esshd) Start(ctx context.Context) {
 					//p("we got newSigner")
 					a.HostKey = newSigner
 
+				case <-e.drainReq:
+					// GracefulShutdown: take no new
+					// connections, but stay up until Stop.
+					listener.Close()
+					listener = nil
+					close(e.stoppedAccepting)
+					select {
+					case <-ctx.Done():
+					case <-e.Halt.ReqStopChan():
+					}
+					return
+
 				default:
 					// no stop request, keep looping
 				}
@@ -449,20 +488,46 @@ func (a *PerAttempt) PerConnection(ctx context.Context, nConn net.Conn, ca *Conn
 	// Before use, a handshake must be performed on the incoming
 	// net.Conn.
 
+	audit := a.cfg.auditLogger()
+	a.cfg.Esshd.trackConn(nConn)
 	sshConn, chans, reqs, err := ssh.NewServerConn(ctx, nConn, a.Config)
 	if err != nil {
+		a.cfg.Esshd.untrackConn(nConn)
 		msg := fmt.Errorf("%v sshego PerAttempt.PerConnection() did not handshake: %v", loc, err)
 		p(msg.Error())
+		a.noteLoginFailed(time.Now().UTC())
 		return msg
 	}
+	go func() {
+		sshConn.Wait()
+		a.cfg.Esshd.untrackConn(nConn)
+	}()
+
+	if err = a.admitConnection(sshConn); err != nil {
+		log.Printf("%v sshego PerAttempt.PerConnection() disconnecting user '%s' from '%s': %v", loc, sshConn.User(), sshConn.RemoteAddr(), err)
+		// not sshConn.Close(), which would stop
+		// a.Config.Halt, shared by all our connections.
+		sshConn.Disconnect(ssh.DisconnectTooManyConnections, err.Error())
+		nConn.Close()
+		return err
+	}
+
+	if audit != nil {
+		audit.AuthSuccess(sshConn)
+		go func() {
+			sshConn.Wait()
+			audit.Disconnect(sshConn)
+		}()
+	}
 
 	p("%s done with handshake. handlers in force: '%s'", loc, a.cfg.ChannelHandlerSummary())
 
 	p("server %s sees new SSH connection from %s (%s)", sshConn.LocalAddr(), sshConn.RemoteAddr(), sshConn.ClientVersion())
 
 	// The incoming Request channel must be serviced.
-	// Discard all global out-of-band Requests, except for keepalives.
-	go DiscardRequestsExceptKeepalives(ctx, reqs, a.cfg.Esshd.Halt.ReqStopChan())
+	// Discard all global out-of-band Requests, except for keepalives
+	// and those with a registered GlobalRequestHandler.
+	go a.cfg.handleGlobalRequests(ctx, sshConn, reqs, a.cfg.Esshd.Halt.ReqStopChan())
 	// Accept all channels
 	go a.cfg.handleChannels(ctx, chans, sshConn, ca)
 
@@ -481,27 +546,7 @@ func DiscardRequestsExceptKeepalives(ctx context.Context, in <-chan *ssh.Request
 			if !stillOpen {
 				return
 			}
-			if req != nil && req.WantReply {
-				if req.Type != "keepalive@sshego.glycerine.github.com" || len(req.Payload) == 0 {
-					req.Reply(false, nil)
-					continue
-				}
-				// respond to keepalive pings
-				var ping KeepAlivePing
-				_, err := ping.UnmarshalMsg(req.Payload)
-				if err != nil {
-					req.Reply(false, nil)
-					continue
-				}
-
-				now := time.Now()
-				//p("sshego server.go: discardRequestsExceptKeepalives sees keepalive %v! ping.Sent: '%v'. setting replied to now='%v'", ping.Serial, ping.Sent, now)
-
-				ping.Replied = now
-				pingReplyBy, err := ping.MarshalMsg(nil)
-				panicOn(err)
-				req.Reply(true, pingReplyBy)
-			}
+			discardRequestExceptKeepalive(req)
 		case <-reqStop:
 			return
 		case <-ctx.Done():
@@ -510,6 +555,32 @@ func DiscardRequestsExceptKeepalives(ctx context.Context, in <-chan *ssh.Request
 	}
 }
 
+// discardRequestExceptKeepalive replies to req if it
+// is one of our keepalive pings, and refuses it otherwise.
+func discardRequestExceptKeepalive(req *ssh.Request) {
+	if req != nil && req.WantReply {
+		if req.Type != "keepalive@sshego.glycerine.github.com" || len(req.Payload) == 0 {
+			req.Reply(false, nil)
+			return
+		}
+		// respond to keepalive pings
+		var ping KeepAlivePing
+		_, err := ping.UnmarshalMsg(req.Payload)
+		if err != nil {
+			req.Reply(false, nil)
+			return
+		}
+
+		now := time.Now()
+		//p("sshego server.go: discardRequestsExceptKeepalives sees keepalive %v! ping.Sent: '%v'. setting replied to now='%v'", ping.Serial, ping.Sent, now)
+
+		ping.Replied = now
+		pingReplyBy, err := ping.MarshalMsg(nil)
+		panicOn(err)
+		req.Reply(true, pingReplyBy)
+	}
+}
+
 type TOTP struct {
 	UserEmail string
 	Issuer    string
@@ -567,7 +638,7 @@ func (w *TOTP) LoadFromFile(path string) error {
 }
 
 func (w *TOTP) IsValid(passcode string, mylogin string) bool {
-	valid := totp.Validate(passcode, w.Key.Secret())
+	valid := ValidateTotpCode(passcode, w.Key.Secret(), time.Now())
 
 	if valid {
 		p("Login '%s' successfully used their "+
@@ -581,6 +652,27 @@ func (w *TOTP) IsValid(passcode string, mylogin string) bool {
 	return valid
 }
 
+// TotpSkewSteps is how many 30 second time-steps either
+// side of now we accept a passcode from, to tolerate
+// clock skew between the server and the user's phone.
+const TotpSkewSteps = 1
+
+// ValidateTotpCode checks a 6-digit passcode against
+// the base32 secret at time now, allowing TotpSkewSteps
+// of clock skew in either direction.
+func ValidateTotpCode(passcode string, secret string, now time.Time) bool {
+	valid, err := totp.ValidateCustom(passcode, secret, now.UTC(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      TotpSkewSteps,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return false
+	}
+	return valid
+}
+
 func NewTOTP(userEmail, issuer string) (w *TOTP, err error) {
 
 	key, err := totp.Generate(totp.GenerateOpts{
@@ -621,6 +713,16 @@ func (a *PerAttempt) KeyboardInteractiveCallback(ctx context.Context, conn ssh.C
 	now := time.Now().UTC()
 	remoteAddr := conn.RemoteAddr()
 
+	if a.lockedOut(conn, now) {
+		log.Printf("refusing login '%s' from remoteAddr '%s' at %v: "+
+			"too many failed attempts", mylogin, remoteAddr, now)
+		return nil, keyFail
+	}
+
+	if a.cfg.KeyboardInteractiveCallback != nil {
+		return a.customKeyboardInteractive(ctx, conn, challenge, now)
+	}
+
 	user, knownUser := a.cfg.HostDb.Persist.Users.Get2(mylogin)
 
 	// don't reveal that the user is unknown by
@@ -662,6 +764,7 @@ func (a *PerAttempt) KeyboardInteractiveCallback(ctx context.Context, conn ssh.C
 	if !knownUser {
 		log.Printf("unrecognized login '%s' from remoteAddr '%s' at %v",
 			mylogin, remoteAddr, now)
+		a.noteFailure(conn, now)
 		return nil, keyFail
 	}
 
@@ -672,9 +775,7 @@ func (a *PerAttempt) KeyboardInteractiveCallback(ctx context.Context, conn ssh.C
 		firstPassOK = true
 	}
 	p("KeyboardInteractiveCallback, first pass-phrase accepted: %v; ans[0] was user-attempting-login provided this cleartext: '%s'; our stored scrypted pw is: '%s'", firstPassOK, ans[0], user.ScryptedPassword)
-	user.RestoreTotp()
-
-	if a.cfg.SkipTOTP || (len(ans[totpIdx]) > 0 && user.oneTime.IsValid(ans[totpIdx], mylogin)) {
+	if a.cfg.SkipTOTP || (len(ans[totpIdx]) > 0 && user.ValidTotp(ans[totpIdx], now)) {
 		timeOK = true
 	}
 
@@ -693,13 +794,71 @@ func (a *PerAttempt) KeyboardInteractiveCallback(ctx context.Context, conn ssh.C
 		a.NoteLogin(user, now, conn)
 		return nil, nil
 	}
+	a.noteFailure(conn, now)
 	return nil, keyFail
 }
 
+// customKeyboardInteractive hands the keyboard-interactive
+// round to cfg.KeyboardInteractiveCallback.
+func (a *PerAttempt) customKeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge, now time.Time) (*ssh.Permissions, error) {
+	ok, err := a.cfg.KeyboardInteractiveCallback(conn, challenge)
+	if err != nil {
+		p("KeyboardInteractiveCallback for '%s' returned error: %v", conn.User(), err)
+	}
+	if !ok || err != nil {
+		a.noteFailure(conn, now)
+		return nil, keyFail
+	}
+	a.OneTimeOK = true
+	if !a.PublicKeyOK {
+		p("custom keyboard interactive succeeded, but public-key has not.")
+		return nil, keyFail
+	}
+	if user, known := a.cfg.HostDb.Persist.Users.Get2(conn.User()); known {
+		a.NoteLogin(user, now, conn)
+	}
+	return nil, nil
+}
+
+// lockedOut returns true if conn's source IP or user
+// has too many recent failed logins.
+func (a *PerAttempt) lockedOut(conn ssh.ConnMetadata, now time.Time) bool {
+	if a.cfg.Esshd == nil {
+		return false
+	}
+	return a.cfg.Esshd.lockout.Locked(ipOnly(conn.RemoteAddr()), conn.User(), now)
+}
+
+func (a *PerAttempt) noteFailure(conn ssh.ConnMetadata, now time.Time) {
+	a.failureNoted = true
+	if a.cfg.Esshd == nil {
+		return
+	}
+	a.cfg.Esshd.lockout.NoteFailure(ipOnly(conn.RemoteAddr()), conn.User(), now)
+}
+
+// noteLoginFailed is called once the connection's login
+// has failed. Rejected key offers count as one failure
+// then, unless a failure was already noted for it.
+func (a *PerAttempt) noteLoginFailed(now time.Time) {
+	if a.keyRejectedConn != nil && !a.failureNoted {
+		a.noteFailure(a.keyRejectedConn, now)
+	}
+}
+
 func (a *PerAttempt) NoteLogin(user *User, now time.Time, conn ssh.ConnMetadata) {
 	user.LastLoginTime = now
 	user.LastLoginAddr = conn.RemoteAddr().String()
+	a.cfg.HostDb.Persist.Users.Compute(user.MyLogin, func(u *User, found bool) *User {
+		if found {
+			u.LoginCount++
+		}
+		return u
+	})
 	a.cfg.HostDb.save(lockit)
+	if a.cfg.Esshd != nil {
+		a.cfg.Esshd.lockout.NoteSuccess(ipOnly(conn.RemoteAddr()), conn.User())
+	}
 }
 
 func (a *PerAttempt) AuthLogCallback(conn ssh.ConnMetadata, method string, err error) {
@@ -717,6 +876,16 @@ func (a *PerAttempt) AuthLogCallback(conn ssh.ConnMetadata, method string, err e
 	} else {
 		p("login failure! auth-log-callback: user %q, method %q: %v",
 			conn.User(), method, err)
+		// "none" is only the client asking which methods
+		// we take. A method that passed but is refused
+		// while we wait on the other factor is no failure.
+		pending := (method == "publickey" && a.PublicKeyOK) ||
+			(method == "keyboard-interactive" && a.OneTimeOK)
+		if method != "none" && !pending {
+			if audit := a.cfg.auditLogger(); audit != nil {
+				audit.AuthFailure(conn, err)
+			}
+		}
 	}
 }
 
@@ -740,11 +909,18 @@ func (a *PerAttempt) PublicKeyCallback(c ssh.ConnMetadata, providedPubKey ssh.Pu
 	remoteAddr := c.RemoteAddr()
 	now := time.Now().UTC()
 
+	if a.lockedOut(c, now) {
+		log.Printf("refusing login '%s' from remoteAddr '%s' at %v: "+
+			"too many failed attempts", mylogin, remoteAddr, now)
+		return nil, unknown
+	}
+
 	user, foundUser := a.cfg.HostDb.Persist.Users.Get2(mylogin)
 	if !foundUser {
 		log.Printf("unrecognized user '%s' from remoteAddr '%s' at %v",
 			mylogin, remoteAddr, now)
 		log.Printf("debug: my userdb is = '%s'\n", a.cfg.HostDb)
+		a.keyRejectedConn = c
 		return nil, unknown
 	}
 	p("PublicKeyCallback sees login attempt for recognized user '%v'", user.MyLogin)
@@ -789,14 +965,13 @@ func (a *PerAttempt) PublicKeyCallback(c ssh.ConnMetadata, providedPubKey ssh.Pu
 	}()
 
 	// load up the public key
-	p("loading public key from '%s'", user.PublicKeyPath)
-	onfilePubKey, err := LoadRSAPublicKey(user.PublicKeyPath)
+	onfilePubKey, err := a.authorizedKey(c, user, providedPubKey)
 	if err != nil {
 		return nil, unknown
 	}
 	onfilePubKeyFinger := Fingerprint(onfilePubKey)
-	p("ok: successful load of public key from '%s'... pub fingerprint = '%s'",
-		user.PublicKeyPath, onfilePubKeyFinger)
+	p("ok: successful load of public key for '%s'... pub fingerprint = '%s'",
+		mylogin, onfilePubKeyFinger)
 
 	//	if a.State.AuthorizedKeysMap[string(providedPubKey.Marshal())] {
 	onfilePubKeyStr := string(onfilePubKey.Marshal())
@@ -813,10 +988,43 @@ func (a *PerAttempt) PublicKeyCallback(c ssh.ConnMetadata, providedPubKey ssh.Pu
 	} else {
 		p("public key mismatch; onfilePubKey (%s) did not match providedPubKey (%s)",
 			onfilePubKeyFinger, Fingerprint(providedPubKey))
+		a.keyRejectedConn = c
 	}
 	return nil, unknown
 }
 
+// authorizedKey returns the public key on file for user
+// to compare providedPubKey against. Normally that is
+// the key at user.PublicKeyPath. When
+// cfg.AuthorizedKeysCallback is set, it is instead
+// whichever of the callback's keys matches
+// providedPubKey, or the first one if none do.
+func (a *PerAttempt) authorizedKey(c ssh.ConnMetadata, user *User, providedPubKey ssh.PublicKey) (ssh.PublicKey, error) {
+	if a.cfg.AuthorizedKeysCallback == nil {
+		p("loading public key from '%s'", user.PublicKeyPath)
+		return LoadRSAPublicKey(user.PublicKeyPath)
+	}
+	if !a.authorizedKeysSet {
+		a.authorizedKeys, a.authorizedKeysErr = a.cfg.AuthorizedKeysCallback(c)
+		a.authorizedKeysSet = true
+	}
+	if a.authorizedKeysErr != nil {
+		log.Printf("AuthorizedKeysCallback for user '%s' failed: %v",
+			c.User(), a.authorizedKeysErr)
+		return nil, a.authorizedKeysErr
+	}
+	if len(a.authorizedKeys) == 0 {
+		return nil, fmt.Errorf("no authorized keys for user '%s'", c.User())
+	}
+	provided := string(providedPubKey.Marshal())
+	for _, k := range a.authorizedKeys {
+		if string(k.Marshal()) == provided {
+			return k, nil
+		}
+	}
+	return a.authorizedKeys[0], nil
+}
+
 func (a *AuthState) LoadPublicKeys(authorizedKeysPath string) error {
 	// Public key authentication is done by comparing
 	// the public key of a received connection
@@ -846,7 +1054,7 @@ func (a *PerAttempt) SetupAuthRequirements() {
 		a.Config.PublicKeyCallback = nil
 		a.PublicKeyOK = true
 	}
-	if a.cfg.SkipPassphrase && a.cfg.SkipTOTP {
+	if a.cfg.SkipPassphrase && a.cfg.SkipTOTP && a.cfg.KeyboardInteractiveCallback == nil {
 		a.Config.KeyboardInteractiveCallback = nil
 		a.OneTimeOK = true
 	}
@@ -865,12 +1073,22 @@ func (a *PerAttempt) SetTripleConfig() {
 		KeyboardInteractiveCallback: a.KeyboardInteractiveCallback,
 		AuthLogCallback:             a.AuthLogCallback,
 		Config: ssh.Config{
-			Ciphers:      getCiphers(),
-			KeyExchanges: []string{kexAlgoCurve25519SHA256},
-			Halt:         a.cfg.Halt,
+			Ciphers:            getCiphers(),
+			KeyExchanges:       []string{kexAlgoCurve25519SHA256},
+			Halt:               a.cfg.Halt,
+			RekeyThreshold:     a.cfg.RekeyBytesThreshold,
+			RekeyTimeThreshold: a.cfg.RekeyTimeThreshold,
+			ChannelWindowSize:  a.cfg.ChannelWindowSize,
+			ChannelMaxPacket:   a.cfg.ChannelMaxPacket,
 		},
 		ServerVersion: "SSH-2.0-OpenSSH_6.9",
 	}
+	if a.cfg.CompressionLevel > 0 {
+		// the client's preference wins, so
+		// this only allows compression.
+		a.Config.Compressions = []string{"none", "zlib@openssh.com"}
+		a.Config.CompressionLevel = a.cfg.CompressionLevel
+	}
 	a.Config.AddHostKey(a.State.HostKey)
 }
 
@@ -9,10 +9,13 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	cv "github.com/glycerine/goconvey/convey"
 	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
 	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh/testdata"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
 )
 
 func Test101StartupAndShutdown(t *testing.T) {
@@ -147,6 +150,98 @@ func Test102SSHdRequiresTripleAuth(t *testing.T) {
 	})
 }
 
+func Test103ServerValidatesTotpWithSkewWindow(t *testing.T) {
+
+	cv.Convey("The esshd should accept a TOTP code from the current time-step or one step either side, and reject codes from further away, at a real keyboard-interactive login.", t, func() {
+
+		// a fixed secret and time-step, so the codes below
+		// are known to differ and every check always runs.
+		user := NewUser()
+		user.MyLogin = "bob"
+		user.TotpSecret = "JBSWY3DPEHPK3PXP"
+		now := time.Unix(1500000000, 0)
+
+		code, err := totp.GenerateCode(user.TotpSecret, now)
+		panicOn(err)
+		cv.So(user.ValidTotp(code, now), cv.ShouldBeTrue)
+
+		// one step of clock skew is tolerated
+		skewed, err := totp.GenerateCode(user.TotpSecret, now.Add(-30*time.Second))
+		panicOn(err)
+		cv.So(user.ValidTotp(skewed, now), cv.ShouldBeTrue)
+
+		// but a code from the wrong window is rejected
+		stale, err := totp.GenerateCode(user.TotpSecret, now.Add(-3*30*time.Second))
+		panicOn(err)
+		cv.So(stale, cv.ShouldNotEqual, code)
+		cv.So(stale, cv.ShouldNotEqual, skewed)
+		cv.So(user.ValidTotp(stale, now), cv.ShouldBeFalse)
+
+		// now log in for real, with key, password,
+		// and a code from the time-step we pick.
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		pemBytes, err := ioutil.ReadFile(s.RsaPath)
+		panicOn(err)
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		panicOn(err)
+		key, err := otp.NewKeyFromURL(s.Totp)
+		panicOn(err)
+
+		addr := fmt.Sprintf("%v:%v", s.SrvCfg.EmbeddedSSHd.Host, s.SrvCfg.EmbeddedSSHd.Port)
+		login := func(at time.Time) error {
+			cfg := &ssh.ClientConfig{
+				User: s.Mylogin,
+				Auth: []ssh.AuthMethod{
+					ssh.PublicKeys(signer),
+					ssh.KeyboardInteractive(func(ctx context.Context, user, instruction string, questions []string, echos []bool) ([]string, error) {
+						var ans []string
+						for _, q := range questions {
+							switch q {
+							case passwordChallenge:
+								ans = append(ans, s.Pw)
+							case gauthChallenge:
+								code, err := totp.GenerateCode(key.Secret(), at)
+								panicOn(err)
+								ans = append(ans, code)
+							}
+						}
+						return ans, nil
+					}),
+				},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				HostPort:        addr,
+				Config: ssh.Config{
+					Ciphers: getCiphers(),
+					Halt:    ssh.NewHalter(),
+				},
+			}
+			defer cfg.Config.Halt.RequestStop()
+			cli, err := ssh.Dial(context.Background(), "tcp", addr, cfg)
+			if err != nil {
+				return err
+			}
+			cli.Close()
+			return nil
+		}
+
+		// the esshd starts listening in the background.
+		for i := 0; i < 100; i++ {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		cv.So(login(time.Now()), cv.ShouldBeNil)
+		cv.So(login(time.Now().Add(-3*30*time.Second)), cv.ShouldNotBeNil)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
 // from ~/go/src/github.com/glycerine/xcryptossh/testdata_test.go : init() function.
 
 // Copyright 2014 The Go Authors. All rights reserved.
@@ -0,0 +1,57 @@
+package sshego
+
+import (
+	"context"
+	"sort"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// SessionOpts adjusts the "session" channels opened
+// by RunCommandOpts and ShellOpts. A nil *SessionOpts
+// means the defaults.
+type SessionOpts struct {
+
+	// Env is sent as "env" requests before the
+	// command or shell starts. sshds commonly accept
+	// only the names listed in their AcceptEnv, so a
+	// rejected variable is logged as a warning and
+	// otherwise ignored.
+	Env map[string]string
+}
+
+// newSession opens a "session" channel and
+// applies opts to it.
+func (t *Tricorder) newSession(ctx context.Context, opts *SessionOpts) (*ssh.Session, error) {
+	cli, err := t.Cli()
+	if err != nil {
+		return nil, err
+	}
+	sess, err := cli.NewSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		return sess, nil
+	}
+
+	names := make([]string, 0, len(opts.Env))
+	for name := range opts.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ok, err := sess.SendRequest("env", true, ssh.Marshal(&struct {
+			Name  string
+			Value string
+		}{name, opts.Env[name]}))
+		if err != nil {
+			sess.Close()
+			return nil, err
+		}
+		if !ok {
+			t.warn("sshd rejected env var", "name", name)
+		}
+	}
+	return sess, nil
+}
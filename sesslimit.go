@@ -0,0 +1,130 @@
+package sshego
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// SessionLimits bounds each "session" channel the esshd
+// accepts. A zero field means no limit.
+type SessionLimits struct {
+	// MaxBandwidthBytesPerSec throttles the session's
+	// input and output, each, to this rate.
+	MaxBandwidthBytesPerSec int64
+
+	// MaxSessionDuration is how long a session may
+	// stay open in all.
+	MaxSessionDuration time.Duration
+
+	// MaxIdleDuration is how long a session may go
+	// without reading or writing any data.
+	MaxIdleDuration time.Duration
+}
+
+// SessionLimitSignal is the signal named in the
+// "exit-signal" request we send before closing a
+// session that ran past MaxSessionDuration or
+// MaxIdleDuration.
+const SessionLimitSignal = "ALRM"
+
+// limitedSession enforces SessionLimits on a session channel.
+type limitedSession struct {
+	ssh.Channel
+
+	// lastActive is the UnixNano time of the last
+	// successful Read or Write.
+	lastActive int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// limitSession returns ch, wrapped to enforce
+// cfg.SessionLimits if any are set.
+func (cfg *SshegoConfig) limitSession(ch ssh.Channel) ssh.Channel {
+	lim := cfg.SessionLimits
+	if lim.MaxBandwidthBytesPerSec <= 0 && lim.MaxSessionDuration <= 0 && lim.MaxIdleDuration <= 0 {
+		return ch
+	}
+	if lim.MaxBandwidthBytesPerSec > 0 {
+		ch = newThrottledChannel(ch, int(lim.MaxBandwidthBytesPerSec))
+	}
+	if lim.MaxSessionDuration <= 0 && lim.MaxIdleDuration <= 0 {
+		return ch
+	}
+	s := &limitedSession{
+		Channel:    ch,
+		lastActive: time.Now().UnixNano(),
+		done:       make(chan struct{}),
+	}
+	go s.enforce(lim.MaxSessionDuration, lim.MaxIdleDuration)
+	return s
+}
+
+func (s *limitedSession) Read(data []byte) (n int, err error) {
+	n, err = s.Channel.Read(data)
+	if n > 0 {
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+	return
+}
+
+func (s *limitedSession) Write(data []byte) (n int, err error) {
+	n, err = s.Channel.Write(data)
+	if n > 0 {
+		atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	}
+	return
+}
+
+func (s *limitedSession) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.Channel.Close()
+}
+
+// enforce waits for the session to close, or to
+// run out of time, whichever is first.
+func (s *limitedSession) enforce(maxDur, maxIdle time.Duration) {
+	var deadline <-chan time.Time
+	if maxDur > 0 {
+		deadline = time.After(maxDur)
+	}
+	var idleCheck <-chan time.Time
+	if maxIdle > 0 {
+		tick := time.NewTicker(maxIdle / 4)
+		defer tick.Stop()
+		idleCheck = tick.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.Channel.Done():
+			return
+		case <-deadline:
+			s.kill("session duration limit exceeded")
+			return
+		case now := <-idleCheck:
+			last := time.Unix(0, atomic.LoadInt64(&s.lastActive))
+			if now.Sub(last) >= maxIdle {
+				s.kill("session idle limit exceeded")
+				return
+			}
+		}
+	}
+}
+
+// kill tells the client why with an "exit-signal"
+// request, RFC 4254 section 6.10, and closes the session.
+func (s *limitedSession) kill(why string) {
+	s.Channel.SendRequest("exit-signal", false, ssh.Marshal(&struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}{Signal: SessionLimitSignal, Error: why}))
+	s.Close()
+}
@@ -0,0 +1,69 @@
+package sshego
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test115SessionLimitsMaxSessionDuration(t *testing.T) {
+	cv.Convey("With SessionLimits.MaxSessionDuration of 1 second, the esshd should send an ALRM exit-signal on a session after a second, then close it.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		s.SrvCfg.SessionLimits = SessionLimits{MaxSessionDuration: time.Second}
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test115",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test115")
+		panicOn(err)
+		cli, err := tri.Cli()
+		panicOn(err)
+
+		t0 := time.Now()
+		ch, in, err := cli.OpenChannel(context.Background(), "session", nil, nil)
+		panicOn(err)
+
+		var req *ssh.Request
+		select {
+		case req = <-in:
+		case <-time.After(10 * time.Second):
+			panic("no exit-signal within 10 seconds")
+		}
+		elapsed := time.Since(t0)
+		cv.So(req.Type, cv.ShouldEqual, "exit-signal")
+		var sig struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}
+		panicOn(ssh.Unmarshal(req.Payload, &sig))
+		cv.So(sig.Signal, cv.ShouldEqual, SessionLimitSignal)
+		cv.So(sig.Error, cv.ShouldEqual, "session duration limit exceeded")
+		cv.So(elapsed, cv.ShouldBeGreaterThanOrEqualTo, 900*time.Millisecond)
+		cv.So(elapsed, cv.ShouldBeLessThan, 5*time.Second)
+
+		// and the session is closed.
+		_, err = io.Copy(ioutil.Discard, ch)
+		cv.So(err, cv.ShouldBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
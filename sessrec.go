@@ -0,0 +1,133 @@
+package sshego
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// sessionRecorder serializes the records of all
+// sessions onto one io.Writer. See SetSessionRecorder.
+type sessionRecorder struct {
+	mut  sync.Mutex
+	w    io.Writer
+	seqn int64
+}
+
+// SetSessionRecorder has the esshd record the output of
+// every "session" channel it accepts from now on to w,
+// be it a shell's or a subsystem handler's, and be it
+// written to the channel or to its Stderr.
+// Each chunk of output is written as a header line
+//
+//	<RFC3339Nano timestamp> <session id> <byte count>\n
+//
+// followed by the raw bytes and a "\n". Session ids are
+// the hex of the first 8 bytes of the connection's ssh
+// session id, a '.', and a sequence number, so several
+// sessions on one connection are told apart. Records
+// from concurrent sessions are whole, never interleaved.
+// Errors writing to w are ignored, so that an audit
+// sink can't disturb the sessions themselves. A nil w
+// turns recording off.
+func (cfg *SshegoConfig) SetSessionRecorder(w io.Writer) {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	if w == nil {
+		cfg.sessionRecorder = nil
+		return
+	}
+	cfg.sessionRecorder = &sessionRecorder{w: w}
+}
+
+// recordSession returns r, teed to the session recorder
+// if there is one.
+func (cfg *SshegoConfig) recordSession(r io.Reader, sshSessionID []byte) io.Reader {
+	w := cfg.newSessionRecordWriter(sshSessionID)
+	if w == nil {
+		return r
+	}
+	return io.TeeReader(r, w)
+}
+
+// recordChannel returns ch, with what is written to it
+// and to its Stderr recorded by the session recorder if
+// there is one.
+func (cfg *SshegoConfig) recordChannel(ch ssh.Channel, sshSessionID []byte) ssh.Channel {
+	w := cfg.newSessionRecordWriter(sshSessionID)
+	if w == nil {
+		return ch
+	}
+	return &recordedChannel{Channel: ch, rec: w}
+}
+
+// newSessionRecordWriter returns nil if we have no
+// session recorder.
+func (cfg *SshegoConfig) newSessionRecordWriter(sshSessionID []byte) *sessionRecordWriter {
+	cfg.Mut.Lock()
+	rec := cfg.sessionRecorder
+	cfg.Mut.Unlock()
+	if rec == nil {
+		return nil
+	}
+	if len(sshSessionID) > 8 {
+		sshSessionID = sshSessionID[:8]
+	}
+	rec.mut.Lock()
+	rec.seqn++
+	id := fmt.Sprintf("%s.%d", hex.EncodeToString(sshSessionID), rec.seqn)
+	rec.mut.Unlock()
+	return &sessionRecordWriter{rec: rec, id: id}
+}
+
+type recordedChannel struct {
+	ssh.Channel
+	rec *sessionRecordWriter
+}
+
+// Write records data before sending it, as the
+// TeeReader in recordSession does, so a record is
+// always written by the time its data can be read.
+func (c *recordedChannel) Write(data []byte) (int, error) {
+	if len(data) > 0 {
+		c.rec.Write(data)
+	}
+	return c.Channel.Write(data)
+}
+
+// Stderr records the extended data written to it
+// in the same way.
+func (c *recordedChannel) Stderr() io.ReadWriter {
+	return &recordedStderr{ReadWriter: c.Channel.Stderr(), rec: c.rec}
+}
+
+type recordedStderr struct {
+	io.ReadWriter
+	rec *sessionRecordWriter
+}
+
+func (e *recordedStderr) Write(data []byte) (int, error) {
+	if len(data) > 0 {
+		e.rec.Write(data)
+	}
+	return e.ReadWriter.Write(data)
+}
+
+type sessionRecordWriter struct {
+	rec *sessionRecorder
+	id  string
+}
+
+func (s *sessionRecordWriter) Write(data []byte) (int, error) {
+	rec := s.rec
+	rec.mut.Lock()
+	defer rec.mut.Unlock()
+	fmt.Fprintf(rec.w, "%s %s %d\n", time.Now().UTC().Format(time.RFC3339Nano), s.id, len(data))
+	rec.w.Write(data)
+	io.WriteString(rec.w, "\n")
+	return len(data), nil
+}
@@ -0,0 +1,106 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+type sessionRecord struct {
+	stamp time.Time
+	id    string
+	data  []byte
+}
+
+var sessionRecordHeader = regexp.MustCompile(`^(\S+) ([0-9a-f]{16}\.[0-9]+) ([0-9]+)$`)
+
+// parseSessionRecords panics on anything that is
+// not a well-formed SetSessionRecorder record.
+func parseSessionRecords(b []byte) (recs []sessionRecord) {
+	for len(b) > 0 {
+		nl := bytes.IndexByte(b, '\n')
+		if nl < 0 {
+			panic("record header has no newline")
+		}
+		m := sessionRecordHeader.FindSubmatch(b[:nl])
+		if m == nil {
+			panic("bad record header: " + string(b[:nl]))
+		}
+		stamp, err := time.Parse(time.RFC3339Nano, string(m[1]))
+		panicOn(err)
+		n, err := strconv.Atoi(string(m[3]))
+		panicOn(err)
+		b = b[nl+1:]
+		if len(b) < n+1 || b[n] != '\n' {
+			panic("record body is short, or lacks its newline")
+		}
+		recs = append(recs, sessionRecord{stamp: stamp, id: string(m[2]), data: b[:n]})
+		b = b[n+1:]
+	}
+	return
+}
+
+func Test095SessionRecorder(t *testing.T) {
+	cv.Convey("With SetSessionRecorder, the esshd should record every byte of session output, each record stamped with the time and a session id.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		rec := &syncBuffer{}
+		s.SrvCfg.SetSessionRecorder(rec)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test095",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test095")
+		panicOn(err)
+
+		t0 := time.Now()
+		sh, err := tri.Shell(context.Background(), "xterm", 80, 24)
+		panicOn(err)
+		out := &syncBuffer{}
+		copied := make(chan struct{})
+		go func() {
+			io.Copy(out, sh)
+			close(copied)
+		}()
+
+		_, err = sh.Write([]byte("echo rec-$((6*7))\nexit\n"))
+		panicOn(err)
+		select {
+		case <-copied:
+		case <-time.After(20 * time.Second):
+			panic("shell output never reached EOF after exit")
+		}
+		sh.Close()
+		cv.So(out.String(), cv.ShouldContainSubstring, "rec-42")
+
+		recs := parseSessionRecords([]byte(rec.String()))
+		cv.So(len(recs), cv.ShouldBeGreaterThan, 0)
+		var recorded []byte
+		for _, r := range recs {
+			cv.So(r.id, cv.ShouldEqual, recs[0].id)
+			cv.So(r.stamp.Before(t0.Add(-time.Second)), cv.ShouldBeFalse)
+			cv.So(r.stamp.After(time.Now()), cv.ShouldBeFalse)
+			recorded = append(recorded, r.data...)
+		}
+		cv.So(string(recorded), cv.ShouldEqual, out.String())
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,99 @@
+package sshego
+
+import (
+	"hash/fnv"
+)
+
+// ShardedUserMap is a drop-in replacement for
+// AtomicUserMap that spreads its keys over several
+// AtomicUserMaps, each with its own lock, so that
+// goroutines working on different keys rarely contend.
+type ShardedUserMap struct {
+	shards []*AtomicUserMap
+}
+
+// NewShardedUserMap returns a ShardedUserMap with the
+// given number of shards, or one shard if shards < 1.
+func NewShardedUserMap(shards int) *ShardedUserMap {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &ShardedUserMap{shards: make([]*AtomicUserMap, shards)}
+	for i := range m.shards {
+		m.shards[i] = NewAtomicUserMap()
+	}
+	return m
+}
+
+func (m *ShardedUserMap) shard(key string) *AtomicUserMap {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+func (m *ShardedUserMap) Get(key string) *User {
+	return m.shard(key).Get(key)
+}
+
+func (m *ShardedUserMap) Get2(key string) (*User, bool) {
+	return m.shard(key).Get2(key)
+}
+
+func (m *ShardedUserMap) Set(key string, val *User) {
+	m.shard(key).Set(key, val)
+}
+
+// Compute is AtomicUserMap.Compute on key's shard.
+func (m *ShardedUserMap) Compute(key string, fn func(existing *User, found bool) *User) *User {
+	return m.shard(key).Compute(key, fn)
+}
+
+// GetOrCreate is AtomicUserMap.GetOrCreate on key's shard.
+func (m *ShardedUserMap) GetOrCreate(key string, factory func() *User) *User {
+	return m.shard(key).GetOrCreate(key, factory)
+}
+
+func (m *ShardedUserMap) Del(key string) {
+	m.shard(key).Del(key)
+}
+
+// Len returns the number of users over all shards. It
+// locks one shard at a time, so under concurrent writes
+// it is not a snapshot.
+func (m *ShardedUserMap) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+// ForEachWithError is AtomicUserMap.ForEachWithError,
+// shard by shard.
+func (m *ShardedUserMap) ForEachWithError(fn func(key string, u *User) error) error {
+	for _, s := range m.shards {
+		if err := s.ForEachWithError(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range calls fn on each key and value, shard by shard,
+// until fn returns false. Like Len, it is not a snapshot;
+// fn must not modify m.
+func (m *ShardedUserMap) Range(fn func(key string, val *User) bool) {
+	for _, s := range m.shards {
+		more := true
+		s.Range(func(k string, v *User) bool {
+			more = fn(k, v)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
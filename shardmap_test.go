@@ -0,0 +1,90 @@
+package sshego
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test134ShardedUserMap(t *testing.T) {
+	cv.Convey("ShardedUserMap should behave like AtomicUserMap: Get, Get2, Set, Del, Len and Range should see every key whichever shard it landed in.", t, func() {
+		m := NewShardedUserMap(8)
+
+		const n = 1000
+		var wg sync.WaitGroup
+		for g := 0; g < 10; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < n; i += 10 {
+					key := fmt.Sprintf("user%v", i)
+					m.Set(key, &User{MyLogin: key})
+				}
+			}(g)
+		}
+		wg.Wait()
+		cv.So(m.Len(), cv.ShouldEqual, n)
+
+		u, ok := m.Get2("user42")
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(u.MyLogin, cv.ShouldEqual, "user42")
+		cv.So(m.Get("user1000"), cv.ShouldBeNil)
+
+		seen := make(map[string]bool)
+		m.Range(func(k string, v *User) bool {
+			cv.So(v.MyLogin, cv.ShouldEqual, k)
+			seen[k] = true
+			return true
+		})
+		cv.So(len(seen), cv.ShouldEqual, n)
+
+		// Range stops when fn returns false.
+		count := 0
+		m.Range(func(k string, v *User) bool {
+			count++
+			return count < 3
+		})
+		cv.So(count, cv.ShouldEqual, 3)
+
+		m.Del("user42")
+		_, ok = m.Get2("user42")
+		cv.So(ok, cv.ShouldBeFalse)
+		cv.So(m.Len(), cv.ShouldEqual, n-1)
+	})
+}
+
+// BenchmarkShardedUserMap has all procs hammer the map
+// with a 50/50 mix of Get and Set over 1024 keys, at
+// 1 through 8 shards. Run with -cpu 8 to see the
+// throughput grow with the shard count.
+func BenchmarkShardedUserMap(b *testing.B) {
+	const nkeys = 1024
+	keys := make([]string, nkeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user%v", i)
+	}
+	for _, shards := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("shards=%v", shards), func(b *testing.B) {
+			m := NewShardedUserMap(shards)
+			u := &User{}
+			for _, k := range keys {
+				m.Set(k, u)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					k := keys[i%nkeys]
+					if i%2 == 0 {
+						m.Get(k)
+					} else {
+						m.Set(k, u)
+					}
+					i += 7
+				}
+			})
+		})
+	}
+}
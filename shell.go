@@ -0,0 +1,90 @@
+package sshego
+
+import (
+	"context"
+	"io"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// ShellSession is an interactive shell on the sshd's
+// host, running on a pty. Writes go to the shell's
+// input; Reads return its terminal output, which
+// has stdout and stderr interleaved as a terminal
+// would show them.
+type ShellSession struct {
+	sess  *ssh.Session
+	stdin io.WriteCloser
+	out   *io.PipeReader
+}
+
+// Shell opens a "session" channel on our connection,
+// requests a pty of type term (e.g. "xterm") sized
+// cols x rows, and starts the user's login shell in it.
+// The returned io.ReadWriteCloser is a *ShellSession;
+// use its WindowChange method when the local terminal
+// is resized. Close ends the shell.
+func (t *Tricorder) Shell(ctx context.Context, term string, cols, rows int) (io.ReadWriteCloser, error) {
+	return t.ShellOpts(ctx, term, cols, rows, nil)
+}
+
+// ShellOpts is Shell with opts applied to the
+// session before the shell starts.
+func (t *Tricorder) ShellOpts(ctx context.Context, term string, cols, rows int, opts *SessionOpts) (io.ReadWriteCloser, error) {
+	sess, err := t.newSession(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err = sess.RequestPty(term, rows, cols, modes); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	sess.Stdout = pw
+	sess.Stderr = pw
+	if err = sess.Shell(); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	go func() {
+		// readers see EOF once the shell exits.
+		err := sess.Wait()
+		if _, ok := err.(*ssh.ExitError); ok || err == nil {
+			err = io.EOF
+		}
+		pw.CloseWithError(err)
+	}()
+	return &ShellSession{sess: sess, stdin: stdin, out: pr}, nil
+}
+
+// Read returns output from the shell's terminal.
+func (s *ShellSession) Read(p []byte) (int, error) {
+	return s.out.Read(p)
+}
+
+// Write sends p to the shell, as if typed.
+func (s *ShellSession) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+// Close ends the shell session and its channel.
+func (s *ShellSession) Close() error {
+	s.out.Close()
+	return s.sess.Close()
+}
+
+// WindowChange tells the remote pty that the
+// terminal is now cols x rows.
+func (s *ShellSession) WindowChange(cols, rows int) error {
+	return s.sess.WindowChange(rows, cols)
+}
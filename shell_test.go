@@ -0,0 +1,107 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+// syncBuffer lets a test poll output that a
+// background io.Copy is still writing.
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+// waitFor returns true once b contains want.
+func (b *syncBuffer) waitFor(want string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if strings.Contains(b.String(), want) {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func Test093TricorderShell(t *testing.T) {
+	cv.Convey("Tricorder.Shell should give us an interactive shell on a pty that runs what we type, and WindowChange should resize the pty.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test093",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test093")
+		panicOn(err)
+
+		sh, err := tri.Shell(context.Background(), "xterm", 80, 24)
+		panicOn(err)
+		out := &syncBuffer{}
+		copied := make(chan struct{})
+		go func() {
+			io.Copy(out, sh)
+			close(copied)
+		}()
+
+		// the echoed command line shows $((6*7)), so
+		// only the shell's output has the 42.
+		_, err = sh.Write([]byte("echo hello-$((6*7))\n"))
+		panicOn(err)
+		cv.So(out.waitFor("hello-42", 10*time.Second), cv.ShouldBeTrue)
+		cv.So(out.String(), cv.ShouldContainSubstring, "echo hello-$((6*7))")
+
+		cv.So(sh.(*ShellSession).WindowChange(100, 40), cv.ShouldBeNil)
+		// the esshd serves window-change apart from the
+		// channel's data, so stty may run before the
+		// resize lands; ask again until it has.
+		resized := false
+		for i := 0; i < 20 && !resized; i++ {
+			_, err = sh.Write([]byte("stty size\n"))
+			panicOn(err)
+			resized = out.waitFor("40 100", 500*time.Millisecond)
+		}
+		cv.So(resized, cv.ShouldBeTrue)
+
+		_, err = sh.Write([]byte("exit\n"))
+		panicOn(err)
+		select {
+		case <-copied:
+		case <-time.After(10 * time.Second):
+			panic("shell output never reached EOF after exit")
+		}
+		sh.Close()
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -14,6 +14,10 @@ import (
 type shovel struct {
 	Halt *ssh.Halter
 
+	// halfClosed is closed when we have copied r to EOF
+	// and passed that on with w.CloseWrite. See Start.
+	halfClosed chan struct{}
+
 	// logging functionality, off by default
 	DoLog     bool
 	LogReads  io.Writer
@@ -23,10 +27,11 @@ type shovel struct {
 // make a new Shovel
 func newShovel(doLog bool) *shovel {
 	return &shovel{
-		Halt:      ssh.NewHalter(),
-		DoLog:     doLog,
-		LogReads:  os.Stdout,
-		LogWrites: os.Stdout,
+		Halt:       ssh.NewHalter(),
+		halfClosed: make(chan struct{}),
+		DoLog:      doLog,
+		LogReads:   os.Stdout,
+		LogWrites:  os.Stdout,
 	}
 }
 
@@ -38,11 +43,22 @@ type writerNilCloser struct{ io.Writer }
 
 func (wc *writerNilCloser) Close() error { return nil }
 
+// closeWriter is implemented by *net.TCPConn, *net.UnixConn,
+// and ssh.Channel, whose CloseWrite sends SSH_MSG_CHANNEL_EOF.
+type closeWriter interface {
+	CloseWrite() error
+}
+
 // Start starts the shovel doing an io.Copy from r to w. The
 // goroutine that is running the copy will close the Ready
 // channel just before starting the io.Copy. The
 // label parameter allows reporting on when a specific shovel
 // was shut down.
+//
+// If r reaches EOF and w has a CloseWrite method, we
+// half-close w, so that the EOF is relayed, and then wait
+// for Stop rather than finishing, leaving the other
+// direction of a shovelPair to run on.
 func (s *shovel) Start(w io.WriteCloser, r io.ReadCloser, label string) {
 
 	if s.DoLog {
@@ -64,6 +80,13 @@ func (s *shovel) Start(w io.WriteCloser, r io.ReadCloser, label string) {
 		}()
 		s.Halt.MarkReady()
 		n, err = io.Copy(w, r)
+		if err == nil {
+			if cw, ok := w.(closeWriter); ok && cw.CloseWrite() == nil {
+				close(s.halfClosed)
+				<-s.Halt.ReqStopChan()
+				return
+			}
+		}
 		if err != nil {
 			// don't freak out, the network connection got closed most likely.
 			// e.g. read tcp 127.0.0.1:33631: use of closed network connection
@@ -116,15 +139,30 @@ func (s *shovelPair) Start(a io.ReadWriteCloser, b io.ReadWriteCloser, abLabel s
 	<-s.BA.Halt.ReadyChan()
 	s.Halt.MarkReady()
 
-	// if one stops, shut down the other
+	// if one stops, shut down the other. One that has
+	// only half-closed is waited out, until both have.
 	go func() {
-		select {
-		case <-s.Halt.ReqStopChan():
-		case <-s.Halt.DoneChan():
-		case <-s.AB.Halt.ReqStopChan():
-		case <-s.AB.Halt.DoneChan():
-		case <-s.BA.Halt.ReqStopChan():
-		case <-s.BA.Halt.DoneChan():
+		abHalf, baHalf := s.AB.halfClosed, s.BA.halfClosed
+	wait:
+		for abHalf != nil || baHalf != nil {
+			select {
+			case <-abHalf:
+				abHalf = nil
+			case <-baHalf:
+				baHalf = nil
+			case <-s.Halt.ReqStopChan():
+				break wait
+			case <-s.Halt.DoneChan():
+				break wait
+			case <-s.AB.Halt.ReqStopChan():
+				break wait
+			case <-s.AB.Halt.DoneChan():
+				break wait
+			case <-s.BA.Halt.ReqStopChan():
+				break wait
+			case <-s.BA.Halt.DoneChan():
+				break wait
+			}
 		}
 		s.AB.Stop()
 		s.BA.Stop()
@@ -2,6 +2,10 @@ package sshego
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
 	"testing"
 	"time"
 
@@ -65,3 +69,144 @@ func (m *mockRwc) Write(p []byte) (n int, err error) {
 func (m *mockRwc) Close() error {
 	return nil
 }
+
+func Test145HalfCloseIsRelayed(t *testing.T) {
+	cv.Convey("Half-closing our side of a direct-tcpip channel should give the backend EOF, while the backend can still send its response back to us.", t, func() {
+
+		// a backend that reads a request to EOF, then answers.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		got := make(chan string, 1)
+		go func() {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			req, err := io.ReadAll(conn)
+			if err != nil {
+				got <- "read error: " + err.Error()
+				return
+			}
+			got <- string(req)
+			conn.Write([]byte("response to " + string(req)))
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test145",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test145")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		_, err = ch.Write([]byte("GET / HTTP/1.0"))
+		panicOn(err)
+		panicOn(ch.CloseWrite())
+
+		select {
+		case req := <-got:
+			cv.So(req, cv.ShouldEqual, "GET / HTTP/1.0")
+		case <-time.After(10 * time.Second):
+			panic("backend never saw EOF")
+		}
+
+		resp, err := io.ReadAll(ch)
+		panicOn(err)
+		cv.So(string(resp), cv.ShouldEqual, "response to GET / HTTP/1.0")
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test146EOFFromPeerLeavesWritesOpen(t *testing.T) {
+	cv.Convey("When the far side of a direct-tcpip channel sends EOF, our reads should end with io.EOF while our writes still go through; after our own CloseWrite, writes should fail.", t, func() {
+
+		// a backend that greets, half-closes, then reads to EOF.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		got := make(chan string, 1)
+		go func() {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("hello"))
+			conn.(*net.TCPConn).CloseWrite()
+			req, err := io.ReadAll(conn)
+			if err != nil {
+				got <- "read error: " + err.Error()
+				return
+			}
+			got <- string(req)
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test146",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test146")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		greeting, err := io.ReadAll(ch)
+		panicOn(err)
+		cv.So(string(greeting), cv.ShouldEqual, "hello")
+
+		// reads stay at EOF, without blocking.
+		n, err := ch.Read(make([]byte, 10))
+		cv.So(n, cv.ShouldEqual, 0)
+		cv.So(err, cv.ShouldEqual, io.EOF)
+
+		// but we can still write.
+		_, err = ch.Write([]byte("still "))
+		cv.So(err, cv.ShouldBeNil)
+		_, err = ch.Write([]byte("writing"))
+		cv.So(err, cv.ShouldBeNil)
+		panicOn(ch.CloseWrite())
+
+		select {
+		case req := <-got:
+			cv.So(req, cv.ShouldEqual, "still writing")
+		case <-time.After(10 * time.Second):
+			panic("backend never saw our writes and EOF")
+		}
+
+		_, err = ch.Write([]byte("too late"))
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(ch.CloseWrite(), cv.ShouldBeNil)
+
+		ch.Close()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,227 @@
+package sshego
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// SOCKS5 constants from RFC 1928.
+const (
+	socks5Version = 5
+
+	socks5NoAuth       = 0
+	socks5NoAcceptable = 0xff
+
+	socks5CmdConnect = 1
+
+	socks5AtypIPv4   = 1
+	socks5AtypDomain = 3
+	socks5AtypIPv6   = 4
+
+	socks5Succeeded        = 0
+	socks5HostUnreachable  = 4
+	socks5ConnRefused      = 5
+	socks5CmdNotSupported  = 7
+	socks5AtypNotSupported = 8
+)
+
+// socks5HandshakeTimeout bounds how long a
+// client may take to tell us its target.
+const socks5HandshakeTimeout = 10 * time.Second
+
+// socks5Forwarder is the io.Closer returned
+// by Tricorder.StartLocalSOCKS5.
+type socks5Forwarder struct {
+	*forwardListener
+}
+
+// StartLocalSOCKS5 does dynamic port forwarding, like
+// ssh -D. It listens on listenAddr for SOCKS5 clients,
+// and tunnels each CONNECT through its own "direct-tcpip"
+// channel to whatever target the client asked for. Only
+// the no-authentication method is offered, so listenAddr
+// should normally be a loopback address. Close the returned
+// io.Closer to stop listening; connections already
+// forwarded are left to finish on their own.
+func (t *Tricorder) StartLocalSOCKS5(listenAddr string) (io.Closer, error) {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("StartLocalSOCKS5: could not listen on '%s': %v", listenAddr, err)
+	}
+	s := &socks5Forwarder{t.newForwardListener(ln)}
+	go s.serve()
+	return s, nil
+}
+
+// ServeSOCKS5 is StartLocalSOCKS5 on a listener of our
+// caller's making. It serves SOCKS5 clients on ln until
+// ln is closed or the Tricorder shuts down, and returns
+// the error that stopped it, or nil for those two.
+// Each CONNECT, however many run at once, gets its own
+// channel over our one connection to the sshd.
+func (t *Tricorder) ServeSOCKS5(ln net.Listener) error {
+	s := &socks5Forwarder{t.newForwardListener(ln)}
+	defer t.Halt.RemoveDownstream(s.Halt)
+	return s.serve()
+}
+
+func (s *socks5Forwarder) serve() error {
+	defer s.Halt.MarkDone()
+
+	// also close on Tricorder shutdown.
+	s.closeOnStop(nil)
+
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			if s.Halt.IsStopRequested() || isClosedConnError(err) {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			s.tri.warn("StartLocalSOCKS5: Accept error, stopping", "addr", s.Addr(), "err", err)
+			return err
+		}
+		s.tri.applyTCPOptions(conn)
+		go s.handle(conn)
+	}
+}
+
+func (s *socks5Forwarder) handle(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	target, err := socks5Handshake(conn)
+	if err != nil {
+		s.tri.debug("StartLocalSOCKS5: handshake failed", "err", err)
+		conn.Close()
+		return
+	}
+
+	ch, err := s.tri.SSHChannelOpts(context.Background(), "direct-tcpip", target, s.tri.forwardOpts(conn.RemoteAddr()))
+	if err != nil {
+		s.tri.warn("StartLocalSOCKS5: could not reach target", "target", target, "err", err)
+		socks5Reply(conn, socks5ReplyCode(err))
+		conn.Close()
+		return
+	}
+	err = socks5Reply(conn, socks5Succeeded)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	sp := newShovelPair(false)
+	sp.Start(conn, ch, "socksClient<-sshChannel", "sshChannel<-socksClient")
+}
+
+// socks5Handshake reads the method negotiation and
+// the CONNECT request from conn, and returns the
+// requested host:port.
+func socks5Handshake(conn net.Conn) (target string, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != socks5Version {
+		return "", fmt.Errorf("socks5: unsupported version %v", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			noAuth = true
+			break
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", fmt.Errorf("socks5: client does not offer no-auth")
+	}
+	if _, err = conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+
+	var req [4]byte
+	if _, err = io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socks5Version {
+		return "", fmt.Errorf("socks5: unsupported version %v in request", req[0])
+	}
+	if req[1] != socks5CmdConnect {
+		socks5Reply(conn, socks5CmdNotSupported)
+		return "", fmt.Errorf("socks5: unsupported command %v", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socks5AtypIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		var n [1]byte
+		if _, err = io.ReadFull(conn, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err = io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socks5Reply(conn, socks5AtypNotSupported)
+		return "", fmt.Errorf("socks5: unsupported address type %v", req[3])
+	}
+
+	var port [2]byte
+	if _, err = io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// isClosedConnError reports whether err is what Accept
+// returns once its listener has been closed.
+func isClosedConnError(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
+
+// socks5ReplyCode picks the reply for a failed CONNECT
+// from why we could not open the channel. The sshd passes
+// on its dial error as the rejection message.
+func socks5ReplyCode(err error) byte {
+	if oe, ok := err.(*ssh.OpenChannelError); ok && strings.Contains(oe.Message, "refused") {
+		return socks5ConnRefused
+	}
+	return socks5HostUnreachable
+}
+
+// socks5Reply sends a reply with the given status. We
+// don't reveal the sshd's bound address, so BND.ADDR
+// and BND.PORT are always zero.
+func socks5Reply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
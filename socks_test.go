@@ -0,0 +1,245 @@
+package sshego
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test069TricorderSOCKS5Forwarding(t *testing.T) {
+	cv.Convey("Tricorder.StartLocalSOCKS5 should let an http.Client reach an in-process http server through the ssh tunnel.", t, func() {
+
+		greeting := RandomString(30)
+		web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, greeting)
+		}))
+		defer web.Close()
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test069",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test069")
+		panicOn(err)
+
+		lsn, port := GetAvailPort()
+		lsn.Close()
+		socksAddr := fmt.Sprintf("127.0.0.1:%v", port)
+
+		closer, err := tri.StartLocalSOCKS5(socksAddr)
+		panicOn(err)
+
+		dial := func(network, addr string) (net.Conn, error) {
+			conn, rep, err := socks5Connect(socksAddr, addr)
+			if err != nil {
+				return nil, err
+			}
+			if rep != socks5Succeeded {
+				conn.Close()
+				return nil, fmt.Errorf("socks5 CONNECT to '%s' failed: %v", addr, rep)
+			}
+			return conn, nil
+		}
+		client := &http.Client{
+			Transport: &http.Transport{Dial: dial},
+		}
+
+		resp, err := client.Get(web.URL)
+		panicOn(err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		panicOn(err)
+		cv.So(string(body), cv.ShouldEqual, greeting)
+
+		cv.So(closer.Close(), cv.ShouldBeNil)
+		// closing again is harmless.
+		cv.So(closer.Close(), cv.ShouldBeNil)
+
+		// no longer listening.
+		_, err = (&http.Client{
+			Transport: &http.Transport{Dial: dial},
+		}).Get(web.URL)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+// socks5Connect does a no-auth SOCKS5 CONNECT to target
+// over a new connection to socksAddr, and returns the
+// connection along with the reply's status byte.
+func socks5Connect(socksAddr, target string) (net.Conn, byte, error) {
+	conn, err := net.Dial("tcp", socksAddr)
+	if err != nil {
+		return nil, 0, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	req := []byte{socks5Version, 1, socks5NoAuth, socks5Version, socks5CmdConnect, 0, socks5AtypDomain, byte(len(host))}
+	req = append(req, host...)
+	var pb [2]byte
+	binary.BigEndian.PutUint16(pb[:], uint16(port))
+	req = append(req, pb[:]...)
+	if _, err = conn.Write(req); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	// method choice, then the 10 byte reply.
+	reply := make([]byte, 2+10)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, reply[3], nil
+}
+
+func Test152ServeSOCKS5ConcurrentConnects(t *testing.T) {
+	cv.Convey("Tricorder.ServeSOCKS5 should route many concurrent CONNECTs each to its own target, and answer 0x05 for a refused connection and 0x04 for an unreachable host.", t, func() {
+
+		// three backends that each prefix what they echo.
+		var backends []string
+		for i := 0; i < 3; i++ {
+			lsn, port := GetAvailPort()
+			defer lsn.Close()
+			name := fmt.Sprintf("backend%v", i)
+			go func() {
+				for {
+					conn, err := lsn.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						buf := make([]byte, 100)
+						n, err := conn.Read(buf)
+						if err != nil {
+							return
+						}
+						conn.Write([]byte(name + ":" + string(buf[:n])))
+					}()
+				}
+			}()
+			backends = append(backends, fmt.Sprintf("127.0.0.1:%v", port))
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test152",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test152")
+		panicOn(err)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		served := make(chan error, 1)
+		go func() {
+			served <- tri.ServeSOCKS5(ln)
+		}()
+		socksAddr := ln.Addr().String()
+
+		var wg sync.WaitGroup
+		var mut sync.Mutex
+		var wrong []string
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				b := i % len(backends)
+				msg := fmt.Sprintf("hello%v", i)
+				want := fmt.Sprintf("backend%v:%v", b, msg)
+
+				got := func() string {
+					conn, status, err := socks5Connect(socksAddr, backends[b])
+					if err != nil {
+						return err.Error()
+					}
+					defer conn.Close()
+					if status != socks5Succeeded {
+						return fmt.Sprintf("status %v", status)
+					}
+					conn.SetDeadline(time.Now().Add(10 * time.Second))
+					if _, err = conn.Write([]byte(msg)); err != nil {
+						return err.Error()
+					}
+					back := make([]byte, len(want))
+					if _, err = io.ReadFull(conn, back); err != nil {
+						return err.Error()
+					}
+					return string(back)
+				}()
+				if got != want {
+					mut.Lock()
+					wrong = append(wrong, fmt.Sprintf("want '%v', got '%v'", want, got))
+					mut.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		cv.So(wrong, cv.ShouldBeEmpty)
+
+		// nothing listens on a port we just gave back.
+		lsn, port := GetAvailPort()
+		lsn.Close()
+		_, status, err := socks5Connect(socksAddr, fmt.Sprintf("127.0.0.1:%v", port))
+		panicOn(err)
+		cv.So(status, cv.ShouldEqual, socks5ConnRefused)
+
+		_, status, err = socks5Connect(socksAddr, "no-such-host.invalid:80")
+		panicOn(err)
+		cv.So(status, cv.ShouldEqual, socks5HostUnreachable)
+
+		ln.Close()
+		select {
+		case err := <-served:
+			cv.So(err, cv.ShouldBeNil)
+		case <-time.After(10 * time.Second):
+			panic("ServeSOCKS5 did not return after its listener closed")
+		}
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
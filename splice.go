@@ -0,0 +1,70 @@
+package sshego
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Splice opens a "direct-tcpip" channel to targetHostPort
+// and copies between it and local in both directions,
+// returning when both directions are done, or when ctx
+// is. An EOF read on one side is passed on to the other
+// with CloseWrite; where the other side has no CloseWrite,
+// both sides are closed instead. local and the channel
+// are always closed by the time Splice returns. See
+// DialConfig.ProxyProtocol to tell the target who local's
+// remote end is.
+func (t *Tricorder) Splice(ctx context.Context, local net.Conn, targetHostPort string) error {
+	ch, err := t.SSHChannelOpts(ctx, "direct-tcpip", targetHostPort, t.forwardOpts(local.RemoteAddr()))
+	if err != nil {
+		local.Close()
+		return err
+	}
+
+	var shut int32
+	shutdown := func() bool {
+		if !atomic.CompareAndSwapInt32(&shut, 0, 1) {
+			return false
+		}
+		local.Close()
+		ch.Close()
+		return true
+	}
+	defer shutdown()
+
+	errs := make(chan error, 2)
+	pump := func(dst io.Writer, src io.Reader) {
+		_, err := io.Copy(dst, src)
+		if err == nil {
+			if cw, ok := dst.(closeWriter); ok {
+				err = cw.CloseWrite()
+			} else {
+				shutdown()
+			}
+		}
+		if err != nil && !shutdown() {
+			// we closed the conns ourselves; the
+			// error that follows is not news.
+			err = nil
+		}
+		errs <- err
+	}
+	go pump(ch, local)
+	go pump(local, ch)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-errs:
+			if e != nil && err == nil {
+				err = e
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.Halt.ReqStopChan():
+			return ErrShutdown
+		}
+	}
+	return err
+}
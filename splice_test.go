@@ -0,0 +1,91 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test147SpliceIsFullDuplex(t *testing.T) {
+	cv.Convey("Tricorder.Splice should carry data both ways between a local in-memory conn and a backend behind the sshd, and pass an EOF through so that everything winds down cleanly.", t, func() {
+
+		// an echo backend that reports what it echoed.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		echoed := make(chan string, 1)
+		go func() {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			var all []byte
+			buf := make([]byte, 100)
+			for {
+				n, err := conn.Read(buf)
+				all = append(all, buf[:n]...)
+				conn.Write(buf[:n])
+				if err != nil {
+					break
+				}
+			}
+			echoed <- string(all)
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test147",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test147")
+		panicOn(err)
+
+		ours, local := net.Pipe()
+		spliced := make(chan error, 1)
+		go func() {
+			spliced <- tri.Splice(context.Background(), local, dest)
+		}()
+
+		for _, msg := range []string{"ping", "pong"} {
+			_, err = ours.Write([]byte(msg))
+			panicOn(err)
+			back := make([]byte, len(msg))
+			_, err = io.ReadFull(ours, back)
+			panicOn(err)
+			cv.So(string(back), cv.ShouldEqual, msg)
+		}
+		ours.Close()
+
+		select {
+		case all := <-echoed:
+			cv.So(all, cv.ShouldEqual, "pingpong")
+		case <-time.After(10 * time.Second):
+			panic("backend never saw EOF")
+		}
+		select {
+		case err := <-spliced:
+			cv.So(err, cv.ShouldBeNil)
+		case <-time.After(10 * time.Second):
+			panic("Splice never returned")
+		}
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
@@ -15,6 +17,19 @@ import (
 	"github.com/pquerna/otp/totp"
 )
 
+// ErrRerunWithoutNew is wrapped by the host key errors that
+// ask the caller to connect again with TofuAddIfNotKnown
+// off: either the host was just added to known hosts, or it
+// was already known and -new was not needed.
+var ErrRerunWithoutNew = errors.New("Re-run without -new")
+
+// isConnRefused reports whether err, however wrapped,
+// is a refused TCP connection.
+func isConnRefused(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && isConnRefusedErrno(errno)
+}
+
 type kiCliHelp struct {
 	passphrase string
 	toptUrl    string
@@ -111,7 +126,7 @@ func (h *KnownHosts) HostAlreadyKnown(hostname string, remote net.Addr, key ssh.
 			p("in HostAlreadyKnown, no host checking when coming from localhost, returning KnownOK")
 			/*
 				if addIfNotKnown {
-					msg := fmt.Errorf("error: flag -new given but not needed. Re-run without -new. No host checking on localhost/127.0.0.1. We saw hostname: '%s'", hostname)
+					msg := fmt.Errorf("error: flag -new given but not needed. %w. No host checking on localhost/127.0.0.1. We saw hostname: '%s'", ErrRerunWithoutNew, hostname)
 					p(msg.Error())
 					return KnownOK, record, msg
 				}
@@ -147,7 +162,7 @@ func (h *KnownHosts) HostAlreadyKnown(hostname string, remote net.Addr, key ssh.
 		}
 		p("in HostAlreadyKnown, returning KnownOK.")
 		if addIfNotKnown {
-			msg := fmt.Errorf("error: flag -new given but not needed. Re-run without -new : this is important to prevent MITM attacks; TofuAddIfNotKnown must be false once the server/host is known.")
+			msg := fmt.Errorf("error: flag -new given but not needed. %w : this is important to prevent MITM attacks; TofuAddIfNotKnown must be false once the server/host is known.", ErrRerunWithoutNew)
 			p(msg.Error())
 			return KnownOK, record, msg
 		}
@@ -184,6 +199,7 @@ func (cfg *SshegoConfig) SSHConnect(ctxPar context.Context, h *KnownHosts, usern
 	}
 
 	// the callback just after key-exchange to validate server is here
+	var hostKeyErr error
 	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 
 		pubBytes := ssh.MarshalAuthorizedKey(key)
@@ -202,6 +218,7 @@ func (cfg *SshegoConfig) SSHConnect(ctxPar context.Context, h *KnownHosts, usern
 			// this is strict checking of hosts here, any non-nil error
 			// will fail the ssh handshake.
 			p("err not nil at line 178 of sshutil.go: '%v'", err)
+			hostKeyErr = err
 			return err
 		}
 
@@ -263,7 +280,7 @@ func (cfg *SshegoConfig) SSHConnect(ctxPar context.Context, h *KnownHosts, usern
 		// to test that we fail without rsa key,
 		// allow submitting auth without it
 		// if the keypath == ""
-		if keypath == "" {
+		if keypath == "" || len(cfg.AgentSigners) > 0 {
 			useRSA = false
 		} else {
 			// client forward tunnel with this RSA key
@@ -277,6 +294,9 @@ func (cfg *SshegoConfig) SSHConnect(ctxPar context.Context, h *KnownHosts, usern
 		if useRSA {
 			auth = append(auth, ssh.PublicKeys(privkey))
 		}
+		if len(cfg.AgentSigners) > 0 {
+			auth = append(auth, ssh.PublicKeys(cfg.AgentSigners...))
+		}
 		if passphrase != "" {
 			auth = append(auth, ssh.Password(passphrase))
 		}
@@ -287,28 +307,32 @@ func (cfg *SshegoConfig) SSHConnect(ctxPar context.Context, h *KnownHosts, usern
 			}
 			auth = append(auth, ssh.KeyboardInteractiveChallenge(ans.helper))
 		}
+		auth = append(auth, cfg.FallbackAuth...)
 
 		cliCfg := &ssh.ClientConfig{
 			User:     username,
-			HostPort: fmt.Sprintf("%v:%v", sshdHost, sshdPort),
+			HostPort: JoinHostPort(sshdHost, sshdPort),
 			Auth:     auth,
 			// HostKeyCallback, if not nil, is called during the cryptographic
 			// handshake to validate the server's host key. A nil HostKeyCallback
 			// implies that all host keys are accepted.
 			HostKeyCallback: hostKeyCallback,
-			Config: ssh.Config{
-				Ciphers: getCiphers(),
-				Halt:    halt,
-			},
+			Config:          cfg.clientSSHConfig(halt),
+			ClientVersion:   cfg.ClientVersion,
 		}
-		hostport := fmt.Sprintf("%s:%d", sshdHost, sshdPort)
+		hostport := JoinHostPort(sshdHost, sshdPort)
 		p("about to ssh.Dial hostport='%s'", hostport)
 		sshClient, nc, err = cfg.mySSHDial(ctx, "tcp", hostport, cliCfg, halt)
 		p("sshClient back from mySSHDial() = %p, err=%v", sshClient, err)
 
 		if err != nil {
 			p("returning early on %v", err)
-			return nil, nil, fmt.Errorf("sshConnect() errored at dial to '%s': '%s' ", hostport, err.Error())
+			if hostKeyErr != nil {
+				// the handshake flattens the error to text; keep
+				// the host key verdict visible to errors.Is.
+				err = hostKeyErr
+			}
+			return nil, nil, fmt.Errorf("sshConnect() errored at dial to '%s': '%w' ", hostport, err)
 		}
 		if sshClient == nil {
 			panic("mySSHDial must give us sshClient if err == nil")
@@ -362,6 +386,8 @@ func (cfg *SshegoConfig) StartupForwardListener(ctx context.Context, sshClientCo
 				log.Printf("sshego: accepted forward connection on %s, forwarding --> to sshd host %s, and thence --> to remote %s\n", cfg.LocalToRemote.Listen.Addr, cfg.SSHdServer.Addr, cfg.LocalToRemote.Remote.Addr)
 			}
 
+			cfg.applyTCPOptions(fromBrowser)
+
 			// if you want to collect them...
 			//cfg.Fwd = append(cfg.Fwd, NewForward(cfg, sshClientConn, fromBrowser))
 			// or just fire and forget...
@@ -397,6 +423,18 @@ func NewForward(ctx context.Context, cfg *SshegoConfig, sshClientConn *ssh.Clien
 		log.Printf(msg.Error())
 		return nil
 	}
+	if cfg.ProxyProtocol != 0 {
+		hdr, err := proxyHeader(cfg.ProxyProtocol, fromBrowser.RemoteAddr(), tcpAddrOf(cfg.LocalToRemote.Remote.Addr))
+		if err == nil {
+			_, err = channelToSSHd.Write(hdr)
+		}
+		if err != nil {
+			log.Printf("sending PROXY header to '%s' failed: %s", cfg.LocalToRemote.Remote.Addr, err)
+			channelToSSHd.Close()
+			fromBrowser.Close()
+			return nil
+		}
+	}
 
 	// here is the heart of the ssh-secured tunnel functionality:
 	// we start the two shovels that keep traffic flowing
@@ -465,6 +503,7 @@ func (cfg *SshegoConfig) StartNewReverse(sshClientConn *ssh.Client, fromRemote n
 		log.Printf(msg.Error())
 		return nil, msg
 	}
+	cfg.applyTCPOptions(channelToLocalFwd)
 
 	sp := newShovelPair(false)
 	rev := &Reverse{shovelPair: sp}
@@ -512,7 +551,7 @@ func (h *KnownHosts) AddNeeded(addIfNotKnown, allowOneshotConnect bool, hostname
 		if allowOneshotConnect {
 			return KnownOK, record, nil
 		}
-		msg := fmt.Errorf("good: added previously unknown sshd host '%v' with the -new flag. Re-run without -new (or setting TofuAddIfNotKnown=false) now", remote)
+		msg := fmt.Errorf("good: added previously unknown sshd host '%v' with the -new flag. %w (or setting TofuAddIfNotKnown=false) now", remote, ErrRerunWithoutNew)
 		return AddedNew, record, msg
 	}
 
@@ -534,12 +573,50 @@ func getCiphers() []string {
 	*/
 }
 
+// clientSSHConfig returns the algorithm preferences
+// for our ssh client, per cfg.
+func (cfg *SshegoConfig) clientSSHConfig(halt *ssh.Halter) ssh.Config {
+	c := ssh.Config{
+		Ciphers: getCiphers(),
+		Halt:    halt,
+	}
+	if len(cfg.Ciphers) > 0 {
+		c.Ciphers = cfg.Ciphers
+	}
+	if len(cfg.MACs) > 0 {
+		c.MACs = cfg.MACs
+	}
+	if len(cfg.KexAlgorithms) > 0 {
+		c.KeyExchanges = cfg.KexAlgorithms
+	}
+	if cfg.CompressionLevel > 0 {
+		c.Compressions = []string{"zlib@openssh.com", "none"}
+		c.CompressionLevel = cfg.CompressionLevel
+	}
+	c.RekeyThreshold = cfg.RekeyBytesThreshold
+	c.RekeyTimeThreshold = cfg.RekeyTimeThreshold
+	c.ChannelWindowSize = cfg.ChannelWindowSize
+	c.ChannelMaxPacket = cfg.ChannelMaxPacket
+	return c
+}
+
 func (cfg *SshegoConfig) mySSHDial(ctx context.Context, network, addr string, config *ssh.ClientConfig, halt *ssh.Halter) (*ssh.Client, net.Conn, error) {
 	//pp("starting SshegoConfig.mySSHDial().")
-	netconn, err := net.DialTimeout(network, addr, config.Timeout)
+	var netconn net.Conn
+	var err error
+	if cfg.Dialer != nil {
+		netconn, err = cfg.Dialer(ctx, network, addr)
+	} else {
+		dialer := net.Dialer{Timeout: config.Timeout}
+		if cfg.LocalBindIP != "" {
+			dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(cfg.LocalBindIP)}
+		}
+		netconn, err = dialer.DialContext(ctx, network, addr)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
+	cfg.applyTCPOptions(netconn)
 
 	// Close netconn when when get a shutdown request.
 	// This close on the underlying TCP connection
@@ -571,7 +648,7 @@ func (cfg *SshegoConfig) mySSHDial(ctx context.Context, network, addr string, co
 
 	if cfg.KeepAliveEvery > 0 {
 		//pp("SshegoConfig.mySSHDial: calling cfg.startKeepalives(): cfg.KeepAliveEvery=%v", cfg.KeepAliveEvery)
-		uhp := &UHP{User: config.User, HostPort: config.HostPort}
+		uhp := &UHP{User: config.User, HostPort: config.HostPort, Nickname: cfg.DestNickname}
 		err = cfg.startKeepalives(ctx, cfg.KeepAliveEvery, cli, uhp)
 	} else {
 		//pp("SshegoConfig.mySSHDial: *not* calling cfg.startKeepalives(): cfg.KeepAliveEvery=%v", cfg.KeepAliveEvery)
@@ -0,0 +1,77 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// SubsystemHandler serves one "subsystem" request on a
+// "session" channel, speaking the subsystem's protocol
+// over ch. reqs carries the channel's further requests.
+type SubsystemHandler func(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request)
+
+// RegisterSubsystem has the esshd serve the subsystem
+// called name, e.g. "sftp", with fn from now on. The
+// session channel is closed when fn returns. A nil fn
+// removes the subsystem; requests for a subsystem that
+// is not registered are refused.
+func (cfg *SshegoConfig) RegisterSubsystem(name string, fn SubsystemHandler) {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	if fn == nil {
+		delete(cfg.subsystems, name)
+		return
+	}
+	if cfg.subsystems == nil {
+		cfg.subsystems = make(map[string]SubsystemHandler)
+	}
+	cfg.subsystems[name] = fn
+}
+
+func (cfg *SshegoConfig) subsystemHandler(name string) SubsystemHandler {
+	cfg.Mut.Lock()
+	defer cfg.Mut.Unlock()
+	return cfg.subsystems[name]
+}
+
+// OpenSubsystem opens a "session" channel on our
+// connection and starts the sshd's subsystem called
+// name in it, e.g. "sftp". The returned channel
+// carries the subsystem's protocol; hand it to a
+// client for that protocol, such as an SFTP client.
+// Requests the sshd sends on the channel, like
+// "exit-status", are refused and dropped.
+func (t *Tricorder) OpenSubsystem(ctx context.Context, name string) (ssh.Channel, error) {
+	cli, err := t.Cli()
+	if err != nil {
+		return nil, err
+	}
+	ch, in, err := cli.OpenChannel(ctx, "session", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	go rejectChannelRequests(in)
+
+	ok, err := ch.SendRequest("subsystem", true, ssh.Marshal(&struct{ Name string }{name}))
+	if err == nil && !ok {
+		err = fmt.Errorf("sshd refused subsystem '%s'", name)
+	}
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// rejectChannelRequests refuses every request on in until
+// the channel closes. Unlike ssh.DiscardRequests, it does
+// not spin once in is closed.
+func rejectChannelRequests(in <-chan *ssh.Request) {
+	for req := range in {
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sshego
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test096TricorderOpenSubsystem(t *testing.T) {
+	cv.Convey("Tricorder.OpenSubsystem should give us a channel to the sshd's sftp subsystem, over which the SFTP version handshake succeeds.", t, func() {
+
+		srvHalt := ssh.NewHalter()
+		defer srvHalt.RequestStop()
+		sshdAddr := startTcpipForwardTestServer(srvHalt, nil)
+		sshdHost, sshdPort, err := SplitHostPort(sshdAddr)
+		panicOn(err)
+
+		// only for the client's known hosts and keys.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             sshdHost,
+			Sshdport:             sshdPort,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test096",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test096")
+		panicOn(err)
+		ctx := context.Background()
+
+		_, err = tri.OpenSubsystem(ctx, "no-such-subsystem")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		ch, err := tri.OpenSubsystem(ctx, "sftp")
+		panicOn(err)
+
+		// SSH_FXP_INIT, version 3.
+		_, err = ch.Write([]byte{0, 0, 0, 5, sshFxpInit, 0, 0, 0, 3})
+		panicOn(err)
+		var reply [9]byte
+		_, err = io.ReadFull(ch, reply[:])
+		panicOn(err)
+		cv.So(reply[:], cv.ShouldResemble, []byte{0, 0, 0, 5, sshFxpVersion, 0, 0, 0, 3})
+		ch.Close()
+
+		tri.Halt.RequestStop()
+	})
+}
+
+func Test109EsshdRegisterSubsystem(t *testing.T) {
+	cv.Convey("A subsystem registered with RegisterSubsystem should be served by the esshd: data sent to an echo subsystem should come back unchanged, and unregistered subsystems should be refused. The session recorder should record the subsystem's output, including what it writes to Stderr.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+		rec := &syncBuffer{}
+		s.SrvCfg.SetSessionRecorder(rec)
+
+		s.SrvCfg.RegisterSubsystem("echo", func(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request) {
+			go rejectChannelRequests(reqs)
+			io.Copy(ch, ch)
+		})
+		s.SrvCfg.RegisterSubsystem("errecho", func(ctx context.Context, ch ssh.Channel, reqs <-chan *ssh.Request) {
+			go rejectChannelRequests(reqs)
+			io.Copy(ch.Stderr(), ch)
+		})
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test109",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test109")
+		panicOn(err)
+		ctx := context.Background()
+
+		_, err = tri.OpenSubsystem(ctx, "sftp")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		ch, err := tri.OpenSubsystem(ctx, "echo")
+		panicOn(err)
+		msg := RandomString(100)
+		_, err = ch.Write([]byte(msg))
+		panicOn(err)
+		reply := make([]byte, len(msg))
+		_, err = io.ReadFull(ch, reply)
+		panicOn(err)
+		cv.So(string(reply), cv.ShouldEqual, msg)
+		ch.Close()
+
+		ch, err = tri.OpenSubsystem(ctx, "errecho")
+		panicOn(err)
+		errMsg := RandomString(100)
+		_, err = ch.Write([]byte(errMsg))
+		panicOn(err)
+		_, err = io.ReadFull(ch.Stderr(), reply)
+		panicOn(err)
+		cv.So(string(reply), cv.ShouldEqual, errMsg)
+		ch.Close()
+
+		var recorded []byte
+		for _, r := range parseSessionRecords([]byte(rec.String())) {
+			recorded = append(recorded, r.data...)
+		}
+		cv.So(string(recorded), cv.ShouldEqual, msg+errMsg)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
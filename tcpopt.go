@@ -0,0 +1,40 @@
+package sshego
+
+import (
+	"net"
+)
+
+// applyTCPOptions sets TCP_NODELAY and TCP keepalive on c
+// per cfg.TCPNoDelay and cfg.TCPKeepAlive. Only a
+// *net.TCPConn is touched; any other net.Conn, such as
+// one wrapped by a custom Dialer, is left as is.
+func (cfg *SshegoConfig) applyTCPOptions(c net.Conn) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if cfg.TCPNoDelay {
+		err := tc.SetNoDelay(true)
+		if err != nil {
+			p("applyTCPOptions: SetNoDelay error: '%v'", err)
+		}
+	}
+	if cfg.TCPKeepAlive > 0 {
+		err := tc.SetKeepAlive(true)
+		if err == nil {
+			err = tc.SetKeepAlivePeriod(cfg.TCPKeepAlive)
+		}
+		if err != nil {
+			p("applyTCPOptions: keepalive error: '%v'", err)
+		}
+	}
+}
+
+// applyTCPOptions applies our current SshegoConfig's
+// TCP options to c, a connection we are forwarding.
+func (t *Tricorder) applyTCPOptions(c net.Conn) {
+	t.mut.Lock()
+	cfg := t.cfg
+	t.mut.Unlock()
+	cfg.applyTCPOptions(c)
+}
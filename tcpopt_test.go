@@ -0,0 +1,82 @@
+// +build darwin linux
+
+package sshego
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test131TCPNoDelayAndKeepAlive(t *testing.T) {
+	cv.Convey("DialConfig.TCPNoDelay and TCPKeepAlive should be applied to the socket our Dialer returns, before the ssh handshake.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// Go turns on TCP_NODELAY and keepalive by default, so
+		// our wrapper dialer turns both off; they should come
+		// back on only because we asked.
+		var mut sync.Mutex
+		var dialed []*net.TCPConn
+		dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := net.Dialer{KeepAlive: -1}
+			nc, err := d.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tc := nc.(*net.TCPConn)
+			panicOn(tc.SetNoDelay(false))
+			mut.Lock()
+			dialed = append(dialed, tc)
+			mut.Unlock()
+			return tc, nil
+		}
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			TCPNoDelay:           true,
+			TCPKeepAlive:         30 * time.Second,
+			Dialer:               dialer,
+			LocalNickname:        "test131",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test131")
+		panicOn(err)
+
+		mut.Lock()
+		cv.So(len(dialed), cv.ShouldBeGreaterThan, 0)
+		tc := dialed[len(dialed)-1]
+		mut.Unlock()
+
+		cv.So(tcpSockopt(tc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY), cv.ShouldNotEqual, 0)
+		cv.So(tcpSockopt(tc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE), cv.ShouldNotEqual, 0)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func tcpSockopt(tc *net.TCPConn, level, opt int) int {
+	rc, err := tc.SyscallConn()
+	panicOn(err)
+	var v int
+	var gerr error
+	panicOn(rc.Control(func(fd uintptr) {
+		v, gerr = syscall.GetsockoptInt(int(fd), level, opt)
+	}))
+	panicOn(gerr)
+	return v
+}
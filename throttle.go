@@ -0,0 +1,127 @@
+package sshego
+
+import (
+	"sync"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// tokenBucket is a simple token bucket rate limiter.
+// Tokens are bytes for throttledChannel, and requests
+// for handleGlobalRequests. The bucket holds at most
+// burst tokens, and refills at rate tokens per second.
+type tokenBucket struct {
+	mut    sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	// a tenth of a second's worth keeps
+	// transfers smooth without letting
+	// much more than rate through at once.
+	return newTokenBucketBurst(float64(bytesPerSec), bytesPerSec/10)
+}
+
+// newTokenBucketBurst makes a full bucket of burst
+// tokens, at least 1, refilling at rate per second.
+func newTokenBucketBurst(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens earned since b.last.
+// The caller holds b.mut.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+}
+
+// allow removes one token if there is one, and
+// reports whether it did. It never sleeps.
+func (b *tokenBucket) allow() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// take removes n tokens, sleeping until the
+// bucket has paid off any resulting debt.
+func (b *tokenBucket) take(n int) {
+	b.mut.Lock()
+	b.refill()
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mut.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// throttledChannel limits the Read and Write
+// throughput of an ssh.Channel. See ChannelOpts.MaxBytesPerSec.
+type throttledChannel struct {
+	ssh.Channel
+	rd *tokenBucket
+	wr *tokenBucket
+}
+
+func newThrottledChannel(ch ssh.Channel, bytesPerSec int) *throttledChannel {
+	return &throttledChannel{
+		Channel: ch,
+		rd:      newTokenBucket(bytesPerSec),
+		wr:      newTokenBucket(bytesPerSec),
+	}
+}
+
+func (c *throttledChannel) Read(data []byte) (n int, err error) {
+	if len(data) > c.rd.burst {
+		data = data[:c.rd.burst]
+	}
+	n, err = c.Channel.Read(data)
+	if n > 0 {
+		c.rd.take(n)
+	}
+	return
+}
+
+func (c *throttledChannel) Write(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > c.wr.burst {
+			chunk = chunk[:c.wr.burst]
+		}
+		c.wr.take(len(chunk))
+		var k int
+		k, err = c.Channel.Write(chunk)
+		n += k
+		if err != nil {
+			return
+		}
+		data = data[len(chunk):]
+	}
+	return
+}
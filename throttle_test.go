@@ -0,0 +1,92 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test071ChannelBandwidthThrottle(t *testing.T) {
+	cv.Convey("With ChannelOpts.MaxBytesPerSec set, moving a fixed payload in either direction should take about bytes/rate.", t, func() {
+
+		rate := 100000
+		payloadByteCount := 2 * rate
+		expected := time.Duration(float64(payloadByteCount) / float64(rate) * float64(time.Second))
+
+		// the server reads the whole payload, acks it
+		// with one byte, then sends it all back.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			conn, err := lsn.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf := make([]byte, payloadByteCount)
+			_, err = io.ReadFull(conn, buf)
+			if err != nil {
+				return
+			}
+			_, err = conn.Write([]byte{1})
+			if err != nil {
+				return
+			}
+			conn.Write(buf)
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test071",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test071")
+		panicOn(err)
+
+		ch, err := tri.SSHChannelOpts(context.Background(), "direct-tcpip", dest,
+			&ChannelOpts{MaxBytesPerSec: rate})
+		panicOn(err)
+
+		payload := []byte(RandomString(payloadByteCount))
+		t0 := time.Now()
+		_, err = ch.Write(payload)
+		panicOn(err)
+		ack := make([]byte, 1)
+		_, err = io.ReadFull(ch, ack)
+		panicOn(err)
+		writeElap := time.Since(t0)
+
+		t1 := time.Now()
+		back := make([]byte, payloadByteCount)
+		_, err = io.ReadFull(ch, back)
+		panicOn(err)
+		readElap := time.Since(t1)
+
+		cv.So(string(back), cv.ShouldEqual, string(payload))
+
+		pp("Test071: expected %v, write took %v, read took %v", expected, writeElap, readElap)
+		// the lower bound shows the throttle works; the
+		// upper one only catches a stall, since a loaded
+		// machine can be slow for reasons of its own.
+		cv.So(writeElap, cv.ShouldBeBetween, expected*3/4, expected*4)
+		cv.So(readElap, cv.ShouldBeBetween, expected*3/4, expected*4)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,107 @@
+package sshego
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceKind says what a TraceEvent records.
+type TraceKind string
+
+const (
+	TraceConnectAttempt TraceKind = "connect-attempt"
+	TraceConnected      TraceKind = "connected"
+	TraceConnectError   TraceKind = "connect-error"
+	TraceReconnect      TraceKind = "reconnect"
+	TraceChannelOpen    TraceKind = "channel-open"
+	TraceChannelError   TraceKind = "channel-error"
+	TraceChannelClose   TraceKind = "channel-close"
+)
+
+// TraceEvent is one entry in a ConnectionTrace.
+type TraceEvent struct {
+	When   time.Time
+	Kind   TraceKind
+	Detail string
+}
+
+// ConnectionTrace is a time-stamped log of what a Tricorder
+// did while tracing: connect attempts and their errors,
+// reconnects, and channel opens and closes. See
+// Tricorder.StartTracing.
+type ConnectionTrace struct {
+	mut    sync.Mutex
+	events []TraceEvent
+}
+
+func (ct *ConnectionTrace) add(ev TraceEvent) {
+	ct.mut.Lock()
+	ct.events = append(ct.events, ev)
+	ct.mut.Unlock()
+}
+
+// Events returns a copy of the events so far, oldest first.
+func (ct *ConnectionTrace) Events() []TraceEvent {
+	ct.mut.Lock()
+	defer ct.mut.Unlock()
+	return append([]TraceEvent(nil), ct.events...)
+}
+
+// Dump writes the events to w, one per line, oldest first.
+func (ct *ConnectionTrace) Dump(w io.Writer) error {
+	for _, ev := range ct.Events() {
+		_, err := fmt.Fprintf(w, "%s %-15s %s\n",
+			ev.When.Format("2006-01-02 15:04:05.000000"), ev.Kind, ev.Detail)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartTracing begins recording our events into a new
+// ConnectionTrace, until StopTracing. More than one
+// trace may be running at once.
+func (t *Tricorder) StartTracing() *ConnectionTrace {
+	ct := &ConnectionTrace{}
+	t.mut.Lock()
+	if t.traces == nil {
+		t.traces = make(map[*ConnectionTrace]bool)
+	}
+	t.traces[ct] = true
+	t.mut.Unlock()
+	return ct
+}
+
+// StopTracing stops recording into ct. What ct
+// already holds stays available.
+func (t *Tricorder) StopTracing(ct *ConnectionTrace) {
+	t.mut.Lock()
+	delete(t.traces, ct)
+	t.mut.Unlock()
+}
+
+// trace adds an event to every running ConnectionTrace.
+// keyvals alternate between keys and values, as for Logger.
+func (t *Tricorder) trace(kind TraceKind, msg string, keyvals ...interface{}) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if len(t.traces) == 0 {
+		return
+	}
+	parts := []string{msg}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		parts = append(parts, fmt.Sprintf("%v=%v", keyvals[i], keyvals[i+1]))
+	}
+	ev := TraceEvent{
+		When:   time.Now(),
+		Kind:   kind,
+		Detail: strings.Join(parts, " "),
+	}
+	for ct := range t.traces {
+		ct.add(ev)
+	}
+}
@@ -0,0 +1,110 @@
+package sshego
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+func Test124TricorderConnectionTrace(t *testing.T) {
+	cv.Convey("A ConnectionTrace should record, in order, a forced reconnect, the opening of two channels, and the close of one of them.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		var dests []string
+		for i := 0; i < 2; i++ {
+			tcpSrvLsn, tcpSrvPort := GetAvailPort()
+			StartBackgroundTestTcpServer(
+				tcpServerMgr,
+				payloadByteCount,
+				confirmationPayload,
+				confirmationReply,
+				tcpSrvLsn,
+				nil)
+			dests = append(dests, fmt.Sprintf("127.0.0.1:%v", tcpSrvPort))
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test124",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test124")
+		panicOn(err)
+		_, err = tri.Cli()
+		panicOn(err)
+
+		ct := tri.StartTracing()
+
+		panicOn(tri.Reset(context.Background()))
+
+		var chans []ssh.Channel
+		for _, dest := range dests {
+			ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+			panicOn(err)
+			VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+			chans = append(chans, ch)
+		}
+		cv.So(tri.CloseChannel(chans[0]), cv.ShouldBeNil)
+
+		tri.StopTracing(ct)
+		// not traced.
+		cv.So(tri.CloseChannel(chans[1]), cv.ShouldBeNil)
+
+		evs := ct.Events()
+		for i := 1; i < len(evs); i++ {
+			cv.So(evs[i].When.Before(evs[i-1].When), cv.ShouldBeFalse)
+		}
+
+		// each expected event, in this order, among the rest.
+		want := []struct {
+			kind   TraceKind
+			detail string
+		}{
+			{TraceReconnect, "reset"},
+			{TraceConnectAttempt, "dialing"},
+			{TraceConnected, "connected"},
+			{TraceChannelOpen, dests[0]},
+			{TraceChannelOpen, dests[1]},
+			{TraceChannelClose, dests[0]},
+		}
+		j := 0
+		for _, ev := range evs {
+			if j < len(want) && ev.Kind == want[j].kind && strings.Contains(ev.Detail, want[j].detail) {
+				j++
+			}
+		}
+		cv.So(j, cv.ShouldEqual, len(want))
+		for _, ev := range evs {
+			cv.So(ev.Detail, cv.ShouldNotContainSubstring, "channel closed type=direct-tcpip target="+dests[1])
+		}
+
+		var buf bytes.Buffer
+		panicOn(ct.Dump(&buf))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		cv.So(len(lines), cv.ShouldEqual, len(evs))
+		cv.So(buf.String(), cv.ShouldContainSubstring, "channel-close")
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -2,31 +2,99 @@ package sshego
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/pquerna/otp"
 )
 
 var ErrShutdown = fmt.Errorf("shutting down")
 
+// ErrTooManyChannels is returned by SSHChannel while
+// DialConfig.MaxChannels channels are open.
+var ErrTooManyChannels = fmt.Errorf("Tricorder has DialConfig.MaxChannels channels open")
+
+// ErrNoKnownHosts and ErrNoPrivateKey report a Tricorder
+// config that can never connect. They are always treated
+// as Permanent, whatever the error classifier says.
+var ErrNoKnownHosts = fmt.Errorf("Tricorder has no KnownHosts")
+var ErrNoPrivateKey = fmt.Errorf("Tricorder has no PrivateKeyPath and no AgentSigners")
+
+// ErrorClass tells the Tricorder whether a failed
+// connection attempt is worth retrying.
+type ErrorClass int
+
+const (
+	// Transient errors, like connection refused, are retried.
+	Transient ErrorClass = 0
+
+	// Permanent errors stop the reconnect loop.
+	Permanent ErrorClass = 1
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Transient:
+		return "Transient"
+	case Permanent:
+		return "Permanent"
+	}
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// DefaultErrorClassifier treats a known-hosts refusal
+// (ErrRerunWithoutNew), ErrNoKnownHosts, ErrNoPrivateKey,
+// and a channel rejected for a reason that is not
+// IsTemporary as Permanent, since retrying will never
+// succeed without operator action. Everything else,
+// including connection refused, is Transient.
+func DefaultErrorClassifier(err error) ErrorClass {
+	if err == nil {
+		return Transient
+	}
+	if err == ErrNoKnownHosts || err == ErrNoPrivateKey {
+		return Permanent
+	}
+	if oce, ok := err.(*ssh.OpenChannelError); ok {
+		if oce.Reason.IsTemporary() {
+			return Transient
+		}
+		return Permanent
+	}
+	if errors.Is(err, ErrRerunWithoutNew) {
+		return Permanent
+	}
+	return Transient
+}
+
 // Tricorder records (holds) three key objects:
 //   an *ssh.Client, the underlyign net.Conn, and a
 //   set of ssh.Channel(s).
 //
 // Tricorder supports auto reconnect when disconnected.
 //
-// There should be exactly one Tricorder per (username, sshdHost, sshdPort) triple.
+// There should be exactly one Tricorder per (username, sshdHost, sshdPort) triple;
+// TricorderPool can enforce this.
 //
 type Tricorder struct {
-	Name string
+	// name holds a string. Use GetName and SetName,
+	// which are safe while the Tricorder is running.
+	name atomic.Value
 
 	// shuts down everything, include the cli
 	Halt *ssh.Halter
 
+	// agentDisowned, if 1, has us leave dc's ssh-agent
+	// connection open when we halt. See disownAgent.
+	agentDisowned int32
+
 	// shared with cfg
 	ClientReconnectNeededTower *UHPTower
 
@@ -46,19 +114,83 @@ type Tricorder struct {
 	cli         *ssh.Client
 	nc          io.Closer
 	uhp         *UHP
-	sshChannels map[net.Conn]context.CancelFunc
+	sshChannels map[net.Conn]*chanState
+
+	// channelTypes is guarded by mut. See RegisterChannelType.
+	channelTypes map[string]ChannelHandler
 
 	getChannelCh      chan *getChannelTicket
-	getCliCh          chan *ssh.Client
+	getChannelHighCh  chan *getChannelTicket // for Priority > 0
+	resetCh           chan *resetTicket
+	reconnectCh       chan *reconnectTicket
+	pauseCh           chan *pauseTicket
+	setIdleCh         chan *setIdleTicket
+	closeChanCh       chan *closeChannelTicket
+	listChansCh       chan *listChannelsTicket
+	annotateCh        chan *annotateTicket
+	chanClosedCh      chan ssh.Channel
+	rotateTotpCh      chan *rotateTotpTicket
+	getCliCh          chan *getCliTicket
 	getNcCh           chan io.Closer
 	reconnectNeededCh chan *UHP
+	rotatedCh         chan *rotation
 
-	tofu bool
+	// tofuOK says, per User and HostPort, whether the next
+	// connect may trust an unknown host key. Absent means
+	// dc.TofuAddIfNotKnown. Connecting sets it false, and
+	// only ReTOFU sets it true again. Guarded by mut.
+	tofuOK map[UHP]bool
+
+	// paused, and reconnectWhilePaused, are touched
+	// only on the reconnect loop. See Pause.
+	paused               bool
+	reconnectWhilePaused bool
+
+	// rotating is set, on the reconnect loop, while
+	// a rotateIfOld dial is in flight.
+	rotating bool
 
 	retries             int           // example: 10
 	pauseBetweenRetries time.Duration // example: 1000 * time.Millisecond
 
+	// lastConnectTime is when we last connected
+	// successfully, by any path. Failed attempts leave
+	// it alone, so they do not extend reconnectDebounce.
 	lastConnectTime time.Time
+
+	// lastActivity is when we last connected or opened
+	// a channel; channel traffic is read from the
+	// channels' idle timers. See closeIfIdle.
+	lastActivity time.Time
+	cliCancel    context.CancelFunc
+
+	metrics *tricorderMetrics
+
+	// errorClassifier is guarded by mut.
+	// See SetErrorClassifier.
+	errorClassifier func(err error) ErrorClass
+
+	// RTTSamples is how many round trips MeasureRTT
+	// averages. It defaults to DefaultRTTSamples. Set it
+	// right after NewTricorder returns.
+	RTTSamples int
+
+	// lastRTT is guarded by mut. See MeasureRTT.
+	lastRTT time.Duration
+
+	// bgPing stops the StartBackgroundPing goroutine,
+	// if one is running. Guarded by mut.
+	bgPing *ssh.Halter
+
+	// shared is guarded by sharedMut. See SharedChannel.
+	sharedMut sync.Mutex
+	shared    ssh.Channel
+
+	mut              sync.Mutex
+	onPermanentError func(err error)
+
+	// traces is guarded by mut. See StartTracing.
+	traces map[*ConnectionTrace]bool
 }
 
 /*
@@ -68,14 +200,17 @@ make too many, and get them mixed up.
 */
 func NewTricorder(dc *DialConfig, halt *ssh.Halter, name string) (tri *Tricorder, err error) {
 
+	err = dc.Validate()
+	if err != nil {
+		return nil, err
+	}
 	cfg, err := dc.DeriveNewConfig()
 	if err != nil {
 		return nil, err
 	}
-	sshdHostPort := fmt.Sprintf("%v:%v", dc.Sshdhost, dc.Sshdport)
+	sshdHostPort := JoinHostPort(dc.Sshdhost, dc.Sshdport)
 
 	tri = &Tricorder{
-		Name:         name,
 		dc:           dc,
 		cfg:          cfg,
 		sshdHostPort: sshdHostPort,
@@ -83,16 +218,31 @@ func NewTricorder(dc *DialConfig, halt *ssh.Halter, name string) (tri *Tricorder
 		Halt:         ssh.NewHalter(),
 		channelsHalt: ssh.NewHalter(),
 
-		sshChannels: make(map[net.Conn]context.CancelFunc),
+		sshChannels: make(map[net.Conn]*chanState),
 
 		reconnectNeededCh:   make(chan *UHP, 1),
 		getChannelCh:        make(chan *getChannelTicket),
-		getCliCh:            make(chan *ssh.Client),
+		getChannelHighCh:    make(chan *getChannelTicket),
+		resetCh:             make(chan *resetTicket),
+		reconnectCh:         make(chan *reconnectTicket),
+		pauseCh:             make(chan *pauseTicket),
+		setIdleCh:           make(chan *setIdleTicket),
+		closeChanCh:         make(chan *closeChannelTicket),
+		listChansCh:         make(chan *listChannelsTicket),
+		annotateCh:          make(chan *annotateTicket),
+		chanClosedCh:        make(chan ssh.Channel),
+		rotateTotpCh:        make(chan *rotateTotpTicket),
+		getCliCh:            make(chan *getCliTicket),
 		getNcCh:             make(chan io.Closer),
-		tofu:                dc.TofuAddIfNotKnown,
+		rotatedCh:           make(chan *rotation),
+		tofuOK:              make(map[UHP]bool),
 		retries:             10,
 		pauseBetweenRetries: 1000 * time.Millisecond,
+		metrics:             newTricorderMetrics(name),
+		errorClassifier:     DefaultErrorClassifier,
 	}
+	tri.name.Store(name)
+	tri.registerBuiltinChannelTypes()
 	tri.uhp = &UHP{
 		User:     tri.dc.Mylogin,
 		HostPort: tri.sshdHostPort,
@@ -118,27 +268,257 @@ func NewTricorder(dc *DialConfig, halt *ssh.Halter, name string) (tri *Tricorder
 //const CustomInprocStreamChanName = "custom-inproc-stream"
 const CustomInprocStreamChanName = "direct-tcpip"
 
+// InprocStreamChanName is the channel type for a stream
+// to a consumer inside the sshd's process, rather than on
+// to a tcp address; see Tricorder.InprocStream and
+// SshegoConfig.ServeInprocStreams.
+const InprocStreamChanName = "custom-inproc-stream"
+
+// DirectStreamLocalChanName is the channel type for
+// forwarding to a Unix domain socket on the sshd's host.
+// SSHChannel also accepts the short form, "direct-streamlocal".
+const DirectStreamLocalChanName = "direct-streamlocal@openssh.com"
+
 func (t *Tricorder) closeChannels() {
 	if len(t.sshChannels) > 0 {
-		for ch, cancel := range t.sshChannels {
+		for ch, st := range t.sshChannels {
 			ch.Close()
-			if cancel != nil {
-				cancel()
+			if st.cancel != nil {
+				st.cancel()
 			}
+			t.trace(TraceChannelClose, "channel closed", "type", st.typ, "target", st.target)
 		}
 	}
-	t.sshChannels = make(map[net.Conn]context.CancelFunc)
+	t.sshChannels = make(map[net.Conn]*chanState)
+	t.metrics.setChannelCount(0)
+}
+
+// resetChannels closes all our channels and
+// gives any new ones a fresh channelsHalt.
+func (t *Tricorder) resetChannels() {
+	t.closeChannels()
+
+	t.channelsHalt.RequestStop()
+	t.channelsHalt.MarkDone()
+
+	t.Halt.RemoveDownstream(t.channelsHalt)
+	t.channelsHalt = ssh.NewHalter()
+	t.Halt.AddDownstream(t.channelsHalt)
+}
+
+// closeIfIdle drops the client connection if none of
+// its channels has seen traffic for cfg.ConnIdleTimeout.
+// We leave t.cli nil, and helperGetChannel will
+// reconnect on the next SSHChannel request.
+func (t *Tricorder) closeIfIdle(now time.Time) {
+	if t.cli == nil {
+		return
+	}
+	last := t.lastActivity
+	for ch := range t.sshChannels {
+		sshChan, ok := ch.(ssh.Channel)
+		if !ok {
+			continue
+		}
+		for _, it := range []*ssh.IdleTimer{sshChan.GetReadIdleTimer(), sshChan.GetWriteIdleTimer()} {
+			if it == nil {
+				continue
+			}
+			lastOK, _, mnow := it.LastOKLastStartAndMonoNow()
+			if lastOK <= 0 {
+				continue
+			}
+			at := now.Add(-time.Duration(mnow - lastOK))
+			if at.After(last) {
+				last = at
+			}
+		}
+	}
+	if now.Sub(last) < t.cfg.ConnIdleTimeout {
+		return
+	}
+	t.info("no traffic, closing idle connection", "idle", now.Sub(last), "hostport", t.uhp.HostPort)
+	t.closeClient()
+}
+
+// rotation is the outcome of a rotateIfOld dial,
+// handed back to the reconnect loop.
+type rotation struct {
+	old    *ssh.Client
+	cli    *ssh.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error
+}
+
+// rotateIfOld replaces our client connection once it
+// is dc.MaxConnLifetime old. The new connection is made
+// first, so there is no moment without one; only then
+// are the old connection and its channels closed. The
+// dial is a single attempt, made off the reconnect loop;
+// finishRotation takes up the result. If it fails we
+// keep the old connection and try again at the next check.
+func (t *Tricorder) rotateIfOld(now time.Time) {
+	if t.cli == nil || t.dc.MaxConnLifetime <= 0 || t.paused || t.rotating {
+		return
+	}
+	age := now.Sub(t.lastConnectTime)
+	if age < t.dc.MaxConnLifetime {
+		return
+	}
+	t.info("connection reached max lifetime, rotating", "age", age, "hostport", t.uhp.HostPort)
+	t.trace(TraceReconnect, "max lifetime rotation", "age", age, "hostport", t.uhp.HostPort)
+	t.rotating = true
+
+	r := &rotation{old: t.cli}
+	dc, cfg, timeout := t.dc, t.cfg, t.dc.DialTimeout
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		// as in helperNewClientConnect, ctx outlives
+		// a successful Dial as the connection's context.
+		var dialTimer *time.Timer
+		if timeout > 0 {
+			dialTimer = time.AfterFunc(timeout, cancel)
+		}
+		_, r.cli, _, r.err = dc.Dial(ctx, cfg, true)
+		if dialTimer != nil && !dialTimer.Stop() && r.err == nil {
+			r.cli.Close()
+			r.cli = nil
+			r.err = fmt.Errorf("dial to %s timed out after %v", JoinHostPort(dc.Sshdhost, dc.Sshdport), timeout)
+		}
+		if r.err != nil {
+			cancel()
+		}
+		r.ctx, r.cancel = ctx, cancel
+		select {
+		case t.rotatedCh <- r:
+		case <-t.Halt.ReqStopChan():
+			if r.cli != nil {
+				r.cli.Close()
+			}
+			cancel()
+		}
+	}()
+}
+
+// finishRotation swaps in the connection a rotateIfOld
+// dial made, then closes the old connection and its
+// channels. If our connection changed meanwhile, by
+// a reconnect or reset, the new one is dropped.
+func (t *Tricorder) finishRotation(r *rotation) {
+	t.rotating = false
+	if r.err != nil {
+		t.trace(TraceConnectError, "rotation failed", "err", r.err)
+		t.warn("rotation failed, keeping the old connection", "err", r.err)
+		return
+	}
+	if t.cli != r.old {
+		t.debug("connection changed while rotating, dropping the new one")
+		r.cli.Close()
+		r.cancel()
+		return
+	}
+	oldCli, oldCancel := t.cli, t.cliCancel
+
+	// our channels all belong to the old connection.
+	t.resetChannels()
+
+	r.cli.TmpCtx = r.ctx
+	t.cli = r.cli
+	t.nc = r.cli.NcCloser()
+	t.cliCancel = r.cancel
+	t.lastConnectTime = time.Now()
+	t.lastActivity = t.lastConnectTime
+	t.info("connected", "hostport", t.uhp.HostPort)
+	t.trace(TraceConnected, "connected", "hostport", t.uhp.HostPort)
+	t.metrics.reconnected()
+
+	oldCli.Halt.RequestStop()
+	oldCli.Close()
+	if oldCancel != nil {
+		oldCancel()
+	}
+}
+
+// closeClient closes all our channels and the
+// current client, leaving t.cli nil. Only
+// channelsHalt is replaced; t.Halt is untouched.
+func (t *Tricorder) closeClient() {
+	t.resetChannels()
+	if t.cli != nil {
+		t.cli.Halt.RequestStop()
+		t.cli.Close()
+	}
+	if t.cliCancel != nil {
+		t.cliCancel()
+		t.cliCancel = nil
+	}
+	t.cli = nil
+	t.nc = nil
+}
+
+// reconnectDebounce is how long after a successful
+// connect we ignore reconnect requests, which by then
+// likely concern the connection we just replaced.
+func (t *Tricorder) reconnectDebounce() time.Duration {
+	if t.dc.ReconnectDebounce > 0 {
+		return t.dc.ReconnectDebounce
+	}
+	return time.Second
 }
 
 func (t *Tricorder) startReconnectLoop() error {
 
-	// do the initial connect.
-	err := t.helperNewClientConnect(context.Background())
-	if err != nil {
-		return err
+	// do the initial connect, unless lazy.
+	if !t.dc.LazyConnect {
+		err := t.helperNewClientConnect(context.Background())
+		if err != nil {
+			return err
+		}
 	}
 
 	go func() {
+		// ReplaceDC may change cfg.ConnIdleTimeout,
+		// so be ready to re-arm.
+		var idleTicker *time.Ticker
+		var idleCheck <-chan time.Time
+		armIdleCheck := func() {
+			if idleTicker != nil {
+				idleTicker.Stop()
+				idleTicker = nil
+				idleCheck = nil
+			}
+			if t.cfg.ConnIdleTimeout > 0 {
+				idleTicker = time.NewTicker(t.cfg.ConnIdleTimeout / 4)
+				idleCheck = idleTicker.C
+			}
+		}
+		armIdleCheck()
+		defer func() {
+			if idleTicker != nil {
+				idleTicker.Stop()
+			}
+		}()
+		// likewise for dc.MaxConnLifetime.
+		var lifetimeTicker *time.Ticker
+		var lifetimeCheck <-chan time.Time
+		armLifetimeCheck := func() {
+			if lifetimeTicker != nil {
+				lifetimeTicker.Stop()
+				lifetimeTicker = nil
+				lifetimeCheck = nil
+			}
+			if t.dc.MaxConnLifetime > 0 {
+				lifetimeTicker = time.NewTicker(t.dc.MaxConnLifetime / 4)
+				lifetimeCheck = lifetimeTicker.C
+			}
+		}
+		armLifetimeCheck()
+		defer func() {
+			if lifetimeTicker != nil {
+				lifetimeTicker.Stop()
+			}
+		}()
 		defer func() {
 			t.channelsHalt.RequestStop()
 			t.channelsHalt.MarkDone()
@@ -148,67 +528,203 @@ func (t *Tricorder) startReconnectLoop() error {
 				t.parentHalt.RemoveDownstream(t.Halt)
 			}
 			t.closeChannels()
+			if atomic.LoadInt32(&t.agentDisowned) == 0 {
+				t.dc.CloseAgent()
+			}
 		}()
 		for {
+			// high priority channel requests jump the queue.
+			select {
+			case tk := <-t.getChannelHighCh:
+				t.helperGetChannel(tk)
+				continue
+			default:
+			}
+
 			select {
 			case <-t.Halt.ReqStopChan():
 				return
 			case uhp := <-t.reconnectNeededCh:
-				pp("%s Tricorder sees reconnectNeeded to '%#v'!!", uhp, t.Name)
+				t.info("reconnect requested", "hostport", uhp.HostPort)
 
-				if uhp.User != t.uhp.User {
-					panic(fmt.Sprintf("%s yikes, bad! uhp from reconnectNeededChan asks for change of user: '%v' != '%v' previous", t.Name, uhp.User, t.uhp.User))
+				if !uhp.Equals(t.uhp) {
+					t.warn("ignoring reconnect request for another destination", "requested", uhp.String(), "ours", t.uhp.String())
+					continue
 				}
-				if uhp.HostPort != t.uhp.HostPort {
-					panic(fmt.Sprintf("%s yikes, bad! uhp from reconnectNeededChan asks for change of hostport: '%v' != '%v' previous", t.Name, uhp.HostPort, t.uhp.HostPort))
+				if t.cli == nil {
+					t.debug("ignoring reconnect request while disconnected; will connect on demand")
+					continue
 				}
 				now := time.Now()
-				if now.Sub(t.lastConnectTime) < time.Second {
-					pp("%s Tricorder ignoring reconnectNeeded within "+
-						"1 second of successful connection.", t.Name)
+				if debounce := t.reconnectDebounce(); now.Sub(t.lastConnectTime) < debounce {
+					t.debug("ignoring reconnect request so soon after connecting", "debounce", debounce)
+					continue
+				}
+				if t.paused {
+					t.debug("paused; holding back reconnect request until Resume")
+					t.reconnectWhilePaused = true
 					continue
 				}
 				t.uhp = uhp
-				t.closeChannels()
-
-				t.channelsHalt.RequestStop()
-				t.channelsHalt.MarkDone()
-
-				t.Halt.RemoveDownstream(t.channelsHalt)
-				t.channelsHalt = ssh.NewHalter()
-				t.Halt.AddDownstream(t.channelsHalt)
+				if stop, _ := t.helperReconnect("reconnect requested"); stop {
+					return
+				}
 
-				t.cli = nil
-				t.nc = nil
-				// need to reconnect!
-				ctx := context.Background()
-				err := t.helperNewClientConnect(ctx)
-				if err == ErrShutdown {
+			case tk := <-t.reconnectCh:
+				if t.paused {
+					tk.err = ErrPaused
+					close(tk.done)
+					continue
+				}
+				// asked for explicitly, so no debounce.
+				stop, err := t.helperReconnect("reconnect forced")
+				tk.err = err
+				close(tk.done)
+				if stop {
 					return
 				}
-				panicOn(err)
 
 				// provide current state
-			case t.getCliCh <- t.cli:
+			case tk := <-t.getCliCh:
+				if t.cli == nil && !tk.noConnect {
+					if t.paused {
+						tk.err = ErrPaused
+					} else {
+						tk.err = t.helperNewClientConnect(context.Background())
+					}
+				}
+				tk.cli = t.cli
+				close(tk.done)
 			case t.getNcCh <- t.nc:
-				pp("%s tri sent t.nc='%#v'", t.Name, t.nc)
 
 				// bring up a new channel
 			case tk := <-t.getChannelCh:
 				t.helperGetChannel(tk)
+			case tk := <-t.getChannelHighCh:
+				t.helperGetChannel(tk)
+
+			case <-idleCheck:
+				t.closeIfIdle(time.Now())
+
+			case <-lifetimeCheck:
+				t.rotateIfOld(time.Now())
+			case r := <-t.rotatedCh:
+				t.finishRotation(r)
+
+			case tk := <-t.pauseCh:
+				if tk.pause {
+					t.paused = true
+					close(tk.done)
+					continue
+				}
+				stop := t.helperResume()
+				close(tk.done)
+				if stop {
+					return
+				}
+
+			case tk := <-t.setIdleCh:
+				t.helperSetIdleTimeout(tk.dur)
+				close(tk.done)
+
+			case tk := <-t.closeChanCh:
+				if _, ok := t.sshChannels[tk.ch]; ok {
+					t.dropChannel(tk.ch)
+				} else {
+					tk.err = fmt.Errorf("%s Tricorder.CloseChannel: not one of our open channels", t.GetName())
+				}
+				close(tk.done)
+
+			case ch := <-t.chanClosedCh:
+				if _, ok := t.sshChannels[ch]; ok {
+					t.dropChannel(ch)
+				}
+
+			case tk := <-t.rotateTotpCh:
+				t.helperRotateTOTP(tk.totpUrl)
+				close(tk.done)
+
+			case tk := <-t.listChansCh:
+				for ch, st := range t.sshChannels {
+					sshChan, ok := ch.(ssh.Channel)
+					if !ok {
+						continue
+					}
+					if sshChan.GetHalter().IsStopRequested() {
+						// closed, but reapWhenClosed
+						// hasn't told us yet.
+						t.dropChannel(sshChan)
+						continue
+					}
+					tk.chans = append(tk.chans, ChannelInfo{
+						Channel:     sshChan,
+						Annotations: copyAnnotations(st.annotations),
+					})
+				}
+				close(tk.done)
+
+			case tk := <-t.annotateCh:
+				if st, ok := t.sshChannels[tk.ch]; ok {
+					if st.annotations == nil {
+						st.annotations = make(map[string]string)
+					}
+					for k, v := range tk.annotations {
+						st.annotations[k] = v
+					}
+				} else {
+					tk.err = fmt.Errorf("%s Tricorder.AnnotateChannel: not one of our open channels", t.GetName())
+				}
+				close(tk.done)
+
+			case tk := <-t.resetCh:
+				t.trace(TraceReconnect, "reset", "hostport", t.uhp.HostPort)
+				t.closeClient()
+				if tk.dc != nil {
+					t.swapDC(tk.dc, tk.cfg)
+					armIdleCheck()
+					armLifetimeCheck()
+				}
+				tk.err = t.helperNewClientConnect(tk.ctx)
+				close(tk.done)
 			}
 		}
 	}()
 	return nil
 }
 
+// helperReconnect closes our channels and client and
+// connects again. stop says the loop should exit, on
+// shutdown or a permanent error. After any other error
+// t.cli stays nil; the next Cli or SSHChannel call will
+// try again and get the error if it persists.
+func (t *Tricorder) helperReconnect(why string) (stop bool, err error) {
+	t.trace(TraceReconnect, why, "hostport", t.uhp.HostPort)
+	t.closeClient()
+
+	err = t.helperNewClientConnect(context.Background())
+	if err == ErrShutdown {
+		return true, err
+	}
+	if err != nil {
+		if t.classify(err) == Permanent {
+			// onPermanentError was already called.
+			return true, err
+		}
+		t.errorLog("reconnect failed", "err", err)
+		return false, err
+	}
+	t.info("reconnected", "hostport", t.uhp.HostPort)
+	t.metrics.reconnected()
+	return false, nil
+}
+
 // only reconnect, don't open any new channels!
 func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 
-	pp("%s Tricorder.helperNewClientConnect starting! t.uhp='%#v'.", t.Name, t.uhp)
+	t.debug("connecting", "hostport", t.uhp.HostPort)
 
 	defer func() {
-		if err != nil {
+		if err == nil {
 			t.lastConnectTime = time.Now()
 		}
 	}()
@@ -228,16 +744,22 @@ func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 	tries := t.retries
 	pause := t.pauseBetweenRetries
 	if t.cfg.KnownHosts == nil {
-		panic("problem! t.cfg.KnownHosts is nil")
+		err = ErrNoKnownHosts
+	} else if t.cfg.PrivateKeyPath == "" && len(t.cfg.AgentSigners) == 0 {
+		err = ErrNoPrivateKey
 	}
-	if t.cfg.PrivateKeyPath == "" {
-		panic("problem! t.cfg.PrivateKeyPath is empty")
+	if err != nil {
+		t.errorLog("cannot connect", "err", err)
+		t.trace(TraceConnectError, "cannot connect", "err", err)
+		t.permanentError(err)
+		return err
 	}
 
 	var okCtx context.Context
 
 	for i := 0; i < tries; i++ {
-		pp("%s Tricorder.helperNewClientConnect() calling t.dc.Dial(), i=%v", t.Name, i)
+		t.debug("dialing", "attempt", i)
+		t.trace(TraceConnectAttempt, "dialing", "hostport", t.uhp.HostPort, "attempt", i)
 
 		// check for shutdown request
 		select {
@@ -248,14 +770,34 @@ func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 
 		ctxChild, cancelChildCtx := context.WithCancel(ctx)
 
-		//t.cfg.AddIfNotKnown = t.tofu
-		//t.dc.TofuAddIfNotKnown = t.tofu
+		tofu := t.tofuAllowed()
+		t.cfg.AddIfNotKnown = tofu
 
+		// ctxChild outlives a successful Dial as the
+		// connection's context, so rather than a
+		// context.WithTimeout we cancel it only if
+		// the timer fires before Dial returns.
+		var dialTimer *time.Timer
+		if t.dc.DialTimeout > 0 {
+			dialTimer = time.AfterFunc(t.dc.DialTimeout, cancelChildCtx)
+		}
 		_, sshcli, _, err = t.dc.Dial(ctxChild, t.cfg, true)
+		if dialTimer != nil && !dialTimer.Stop() {
+			if sshcli != nil {
+				sshcli.Close()
+				sshcli = nil
+			}
+			err = fmt.Errorf("dial to %s timed out after %v", t.uhp.HostPort, t.dc.DialTimeout)
+			t.trace(TraceConnectError, "dial timed out", "err", err)
+			t.warn("dial timed out, will retry", "hostport", t.uhp.HostPort, "timeout", t.dc.DialTimeout, "pause", pause)
+			time.Sleep(pause)
+			continue
+		}
 		if err == nil {
-			t.tofu = false
+			t.tofuSpent()
 			t.cfg.AddIfNotKnown = false
 			okCtx = ctxChild
+			t.cliCancel = cancelChildCtx
 
 			if sshcli == nil {
 				panic("err must not be nil if sshcli is nil, back from cfg.SSHConnect")
@@ -263,23 +805,23 @@ func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 			break
 		} else {
 			cancelChildCtx()
-			errs := err.Error()
-			if strings.Contains(errs, "Re-run without -new") {
-				if t.tofu {
-					p("auto-handling tofu b/c t.tofu is true")
-					t.tofu = false
-					t.dc.TofuAddIfNotKnown = false
-					t.cfg.AddIfNotKnown = false
-					continue
-				}
+			t.trace(TraceConnectError, "connect failed", "err", err)
+			if errors.Is(err, ErrRerunWithoutNew) && tofu {
+				t.debug("host key now known, retrying without TOFU")
+				t.tofuSpent()
+				continue
+			}
+			if t.classify(err) == Permanent {
+				t.errorLog("permanent connect error, giving up", "err", err)
+				t.permanentError(err)
 				return err
 			}
-			if strings.Contains(errs, "getsockopt: connection refused") {
-				pp("%s Tricorder.helperNewClientConnect: ignoring 'connection refused' and retrying after %v. connecting to '%#v'", t.Name, pause, t.uhp)
+			if isConnRefused(err) {
+				t.warn("connection refused, will retry", "hostport", t.uhp.HostPort, "pause", pause)
 				time.Sleep(pause)
 				continue
 			}
-			pp("%s Tricorder: err = '%v'. retrying after %v", t.Name, err, pause)
+			t.warn("connect failed, will retry", "err", err, "pause", pause)
 			time.Sleep(pause)
 			continue
 		}
@@ -291,8 +833,10 @@ func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 	if err != nil {
 		return err
 	}
-	pp("good: %s Tricorder.helperNewClientConnect succeeded to '%#v'.", t.Name, t.uhp)
+	t.info("connected", "hostport", t.uhp.HostPort)
+	t.trace(TraceConnected, "connected", "hostport", t.uhp.HostPort)
 	t.cli = sshcli
+	t.lastActivity = time.Now()
 	if t.cli != nil {
 		t.nc = t.cli.NcCloser()
 	} else {
@@ -303,39 +847,100 @@ func (t *Tricorder) helperNewClientConnect(ctx context.Context) (err error) {
 
 func (t *Tricorder) helperGetChannel(tk *getChannelTicket) {
 
-	pp("%s Tricorder.helperGetChannel starting! t.uhp='%#v'", t.Name, t.uhp)
+	t.debug("opening channel", "type", tk.typ)
 
 	var ch ssh.Channel
-	var in <-chan *ssh.Request
 	var err error
+
+	// the caller may have given up while we were busy.
+	if err = tk.ctx.Err(); err != nil {
+		tk.err = err
+		t.finishChannelTicket(tk)
+		return
+	}
+	if t.paused {
+		tk.err = ErrPaused
+		t.finishChannelTicket(tk)
+		return
+	}
+	if t.dc.MaxChannels > 0 && len(t.sshChannels) >= t.dc.MaxChannels {
+		t.metrics.channelErrors.Inc()
+		tk.err = ErrTooManyChannels
+		t.finishChannelTicket(tk)
+		return
+	}
+	handler := t.channelHandler(tk.typ)
+	if tk.raw {
+		handler = t.openPlainChannel
+	}
+	if handler == nil {
+		t.metrics.channelErrors.Inc()
+		t.warn("unknown channel type", "type", tk.typ)
+		tk.err = ErrUnknownChannelType
+		t.finishChannelTicket(tk)
+		return
+	}
 	if t.cli == nil {
-		pp("%s Tricorder.helperGetChannel: saw nil cli, so making new client", t.Name)
+		t.debug("no client yet, connecting before opening channel")
 		err = t.helperNewClientConnect(tk.ctx)
 		if err != nil {
+			t.metrics.channelErrors.Inc()
 			tk.err = err
-			close(tk.done)
+			t.finishChannelTicket(tk)
 			return
 		}
 	}
 
-	pp("%s Tricorder.helperGetChannel: had cli already, so calling t.cli.Dial()", t.Name)
 	discardCtx, discardCtxCancel := context.WithCancel(tk.ctx)
 
-	if tk.typ == "direct-tcpip" {
-		hp := strings.Trim(tk.targetHostPort, "\n\r\t ")
-
-		pp("%s Tricorder.helperGetChannel dialing hp='%v'", t.Name, hp)
-		ch, err = t.cli.DialWithContext(discardCtx, "tcp", hp)
-
-	} else {
-
-		ch, in, err = t.cli.OpenChannel(tk.ctx, tk.typ, nil, t.channelsHalt)
-		if err == nil {
-			go DiscardRequestsExceptKeepalives(discardCtx, in, t.channelsHalt.ReqStopChan())
+	req := &ChannelRequest{tk: tk}
+	err = handler(discardCtx, req, t.cli)
+	ch = tk.sshChannel
+	if fallback := tk.opts.fallbackType(tk.typ, err); fallback != "" {
+		if fh := t.channelHandler(fallback); fh != nil {
+			t.info("channel type refused, falling back", "type", tk.typ, "fallback", fallback, "err", err)
+			if ch != nil {
+				ch.Close()
+			}
+			tk.sshChannel = nil
+			tk.requests = nil
+			tk.typ = fallback
+			err = fh(discardCtx, req, t.cli)
+			ch = tk.sshChannel
+		}
+	}
+	target := tk.targetHostPort
+	if tk.socketPath != "" {
+		target = tk.socketPath
+	}
+	if err == nil && ch == nil {
+		err = fmt.Errorf("channel handler for type '%s' returned no channel", tk.typ)
+	}
+	if err != nil {
+		t.metrics.channelErrors.Inc()
+		t.trace(TraceChannelError, "channel open failed", "type", tk.typ, "target", target, "err", err)
+		if ch != nil {
+			ch.Close()
+			ch = nil
 		}
+		tk.requests = nil
 	}
-	if ch != nil {
-		t.sshChannels[ch] = discardCtxCancel
+	if ch == nil {
+		// nothing for discardCtx to guard.
+		discardCtxCancel()
+	} else {
+		ch = tk.opts.wrap(t.metrics.count(ch))
+		tk.opts.startKeepalives(discardCtx, ch, t.channelsHalt, t.warn)
+		t.sshChannels[ch] = &chanState{
+			cancel: discardCtxCancel,
+			typ:    tk.typ,
+			target: target,
+		}
+		t.trace(TraceChannelOpen, "channel opened", "type", tk.typ, "target", target)
+		go t.reapWhenClosed(discardCtx, ch)
+		t.lastActivity = time.Now()
+		t.metrics.channelsOpen.Inc()
+		t.metrics.setChannelCount(len(t.sshChannels))
 
 		if t.cfg.IdleTimeoutDur > 0 {
 			sshChan, ok := ch.(ssh.Channel)
@@ -348,16 +953,100 @@ func (t *Tricorder) helperGetChannel(tk *getChannelTicket) {
 	tk.sshChannel = ch
 	tk.err = err
 
+	t.finishChannelTicket(tk)
+}
+
+// finishChannelTicket hands tk back to SSHChannel, unless
+// SSHChannel already gave up on it; then we close any
+// channel we made rather than leak it.
+func (t *Tricorder) finishChannelTicket(tk *getChannelTicket) {
+	tk.mut.Lock()
+	defer tk.mut.Unlock()
+	if tk.abandoned && tk.sshChannel != nil {
+		t.info("closing channel orphaned by its caller")
+		t.dropChannel(tk.sshChannel)
+		tk.sshChannel = nil
+		tk.requests = nil
+	}
 	close(tk.done)
 }
 
+// reapWhenClosed has the reconnect loop forget ch once
+// it is closed, by either end, so that long lived
+// Tricorders don't accumulate dead channels. If ctx is
+// done first, ch has been dropped already.
+func (t *Tricorder) reapWhenClosed(ctx context.Context, ch ssh.Channel) {
+	select {
+	case <-ch.GetHalter().ReqStopChan():
+	case <-ctx.Done():
+		return
+	case <-t.Halt.ReqStopChan():
+		return
+	}
+	select {
+	case t.chanClosedCh <- ch:
+	case <-ctx.Done():
+	case <-t.Halt.ReqStopChan():
+	}
+}
+
+// dropChannel closes ch and forgets it.
+func (t *Tricorder) dropChannel(ch ssh.Channel) {
+	ch.Close()
+	if st := t.sshChannels[ch]; st != nil {
+		if st.cancel != nil {
+			st.cancel()
+		}
+		t.trace(TraceChannelClose, "channel closed", "type", st.typ, "target", st.target)
+	}
+	delete(t.sshChannels, ch)
+	t.metrics.setChannelCount(len(t.sshChannels))
+}
+
 type getChannelTicket struct {
 	done           chan struct{}
 	sshChannel     ssh.Channel
 	targetHostPort string // leave empty for "custom-inproc-stream", else downstream addr
-	typ            string // "direct-tcpip" or "custom-inproc-stream"
+	socketPath     string // remote Unix socket, for DirectStreamLocalChanName
+	typ            string // "direct-tcpip", DirectStreamLocalChanName, or "custom-inproc-stream"
 	err            error
 	ctx            context.Context
+	opts           *ChannelOpts
+
+	// wantRequests asks for the channel's incoming
+	// requests in requests, rather than having them
+	// discarded. Only openPlainChannel honors it.
+	wantRequests bool
+	requests     <-chan *ssh.Request
+
+	// raw opens a plain channel of type typ, whatever
+	// handler is registered for it. See OpenChannelRaw.
+	raw bool
+
+	// Priority 0 is normal. Tickets with Priority > 0
+	// are served before any normal ones waiting.
+	Priority int
+
+	// mut protects abandoned, which SSHChannel
+	// sets if its ctx is done before we are.
+	mut       sync.Mutex
+	abandoned bool
+}
+
+// abandon tells the reconnect loop that nobody is
+// waiting for tk any more. If the loop has already
+// finished with tk, we return false and the
+// caller should take the result after all.
+func (tk *getChannelTicket) abandon() bool {
+	tk.mut.Lock()
+	defer tk.mut.Unlock()
+	select {
+	case <-tk.done:
+		return false
+	default:
+	}
+	tk.abandoned = true
+	return true
 }
 
 func newGetChannelTicket(ctx context.Context) *getChannelTicket {
@@ -367,24 +1056,458 @@ func newGetChannelTicket(ctx context.Context) *getChannelTicket {
 	}
 }
 
-// typ can be "direct-tcpip" (specify destHostPort), or "custom-inproc-stream"
-// in which case leave destHostPort as the empty string.
+type resetTicket struct {
+	done chan struct{}
+	err  error
+	ctx  context.Context
+
+	// for ReplaceDC; nil for a plain Reset.
+	dc  *DialConfig
+	cfg *SshegoConfig
+}
+
+// Reset closes all open channels and the current
+// ssh.Client, then reconnects once, without shutting
+// down the Tricorder. Use it after a config change,
+// for example. Channels obtained before Reset are
+// no longer usable; call SSHChannel for new ones.
+func (t *Tricorder) Reset(ctx context.Context) error {
+	tk := &resetTicket{
+		done: make(chan struct{}),
+		ctx:  ctx,
+	}
+	select {
+	case t.resetCh <- tk:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return tk.err
+}
+
+type reconnectTicket struct {
+	done chan struct{}
+	err  error
+}
+
+// Reconnect drops the current connection and makes a
+// new one right away, for when we know out-of-band
+// that the connection is bad. Unlike a broadcast on
+// ClientReconnectNeededTower, it is never debounced.
+// As with Reset, channels obtained before are closed.
+func (t *Tricorder) Reconnect() error {
+	tk := &reconnectTicket{
+		done: make(chan struct{}),
+	}
+	select {
+	case t.reconnectCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return tk.err
+}
+
+// ReplaceDC swaps in a new DialConfig, say after the
+// private key or TOTP secret has been rotated, and
+// reconnects with it. Like Reset, any open channels
+// and the current ssh.Client are closed first. If dc
+// is not usable, we return an error and leave the
+// current connection alone.
+func (t *Tricorder) ReplaceDC(ctx context.Context, dc *DialConfig) error {
+	if dc == nil {
+		return fmt.Errorf("Tricorder.ReplaceDC: nil DialConfig")
+	}
+	err := dc.Validate()
+	if err != nil {
+		return err
+	}
+	cfg, err := dc.DeriveNewConfig()
+	if err != nil {
+		return err
+	}
+	tk := &resetTicket{
+		done: make(chan struct{}),
+		ctx:  ctx,
+		dc:   dc,
+		cfg:  cfg,
+	}
+	select {
+	case t.resetCh <- tk:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return tk.err
+}
+
+type setIdleTicket struct {
+	done chan struct{}
+	dur  time.Duration
+}
+
+// SetIdleTimeout changes the idle timeout, initially
+// SshegoConfig.IdleTimeoutDur, that SSHChannel gives
+// new channels, and applies it to the channels already
+// open. A read or write that waits longer than d for
+// progress then fails with a timeout. d <= 0 turns
+// the timeout off.
+func (t *Tricorder) SetIdleTimeout(d time.Duration) {
+	tk := &setIdleTicket{
+		done: make(chan struct{}),
+		dur:  d,
+	}
+	select {
+	case t.setIdleCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return
+	}
+	<-tk.done
+}
+
+// helperSetIdleTimeout is called only on the
+// reconnect loop's goroutine.
+func (t *Tricorder) helperSetIdleTimeout(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	t.mut.Lock()
+	t.cfg.IdleTimeoutDur = d
+	t.mut.Unlock()
+	for ch := range t.sshChannels {
+		if sshChan, ok := ch.(ssh.Channel); ok {
+			sshChan.SetIdleTimeout(d)
+		}
+	}
+}
+
+type rotateTotpTicket struct {
+	done    chan struct{}
+	totpUrl string
+}
+
+// RotateTOTP replaces the TOTP secret we log in with,
+// for use from the next connect on. Unlike ReplaceDC, the
+// current connection and its channels are left alone.
+func (t *Tricorder) RotateTOTP(ctx context.Context, newTotpUrl string) error {
+	key, err := otp.NewKeyFromURL(strings.TrimSpace(newTotpUrl))
+	if err == nil && key.Secret() == "" {
+		err = fmt.Errorf("no secret")
+	}
+	if err != nil {
+		return fmt.Errorf("Tricorder.RotateTOTP: bad TOTP url: %v", err)
+	}
+	tk := &rotateTotpTicket{
+		done:    make(chan struct{}),
+		totpUrl: newTotpUrl,
+	}
+	select {
+	case t.rotateTotpCh <- tk:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return nil
+}
+
+// helperRotateTOTP is called only on the reconnect
+// loop's goroutine. We copy dc rather than change
+// the caller's DialConfig underneath them.
+func (t *Tricorder) helperRotateTOTP(totpUrl string) {
+	dc := *t.dc
+	dc.TotpUrl = totpUrl
+	t.mut.Lock()
+	t.dc = &dc
+	t.cfg.TotpUrl = totpUrl
+	t.mut.Unlock()
+}
+
+type closeChannelTicket struct {
+	done chan struct{}
+	ch   ssh.Channel
+	err  error
+}
+
+// CloseChannel closes ch, which must have come from
+// SSHChannel, and forgets it, stopping any goroutine
+// we run on its behalf. Closing ch directly leaves it
+// in our list of open channels until the next Reset.
+func (t *Tricorder) CloseChannel(ch ssh.Channel) error {
+	tk := &closeChannelTicket{
+		done: make(chan struct{}),
+		ch:   ch,
+	}
+	select {
+	case t.closeChanCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return tk.err
+}
+
+// chanState is what we keep on each channel in sshChannels.
+type chanState struct {
+	// cancel stops the goroutines we run for the channel.
+	cancel      context.CancelFunc
+	annotations map[string]string
+
+	// typ and target are as given to SSHChannel.
+	typ    string
+	target string
+}
+
+// ChannelInfo describes a channel for ListChannels.
+type ChannelInfo struct {
+	Channel ssh.Channel
+
+	// Annotations are those given to AnnotateChannel.
+	Annotations map[string]string
+}
+
+type listChannelsTicket struct {
+	done  chan struct{}
+	chans []ChannelInfo
+}
+
+// ListChannels returns the channels SSHChannel has
+// opened, in no particular order, less any since
+// closed by CloseChannel, Reset, or a reconnect.
+func (t *Tricorder) ListChannels() ([]ChannelInfo, error) {
+	tk := &listChannelsTicket{done: make(chan struct{})}
+	select {
+	case t.listChansCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return nil, ErrShutdown
+	}
+	<-tk.done
+	return tk.chans, nil
+}
+
+type annotateTicket struct {
+	done        chan struct{}
+	ch          net.Conn
+	annotations map[string]string
+	err         error
+}
+
+// AnnotateChannel tags ch, which must have come from
+// SSHChannel, with annotations such as a request ID or
+// service name, to be reported by ListChannels. The
+// annotations are added to any given before; a key
+// given again takes the new value.
+func (t *Tricorder) AnnotateChannel(ch net.Conn, annotations map[string]string) error {
+	tk := &annotateTicket{
+		done:        make(chan struct{}),
+		ch:          ch,
+		annotations: copyAnnotations(annotations),
+	}
+	select {
+	case t.annotateCh <- tk:
+	case <-t.Halt.ReqStopChan():
+		return ErrShutdown
+	}
+	<-tk.done
+	return tk.err
+}
+
+func copyAnnotations(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// GetName returns the Tricorder's name, used to tag
+// its log events and metrics.
+func (t *Tricorder) GetName() string {
+	return t.name.Load().(string)
+}
+
+// SetName renames the Tricorder. Log events and
+// MetricsRegistry output from then on carry the new
+// name. The Prometheus "name" label keeps the old one.
+func (t *Tricorder) SetName(name string) {
+	t.name.Store(name)
+}
+
+// tofuAllowed says whether this connect to t.uhp may trust
+// an unknown host key. Called only on the reconnect loop's
+// goroutine.
+func (t *Tricorder) tofuAllowed() bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	ok, seen := t.tofuOK[tofuKey(t.uhp)]
+	if !seen {
+		return t.dc.TofuAddIfNotKnown
+	}
+	return ok
+}
+
+// tofuSpent records that t.uhp's host key is known, so
+// later reconnects will refuse a changed key.
+func (t *Tricorder) tofuSpent() {
+	t.mut.Lock()
+	t.tofuOK[tofuKey(t.uhp)] = false
+	t.mut.Unlock()
+}
+
+func tofuKey(uhp *UHP) UHP {
+	return UHP{User: uhp.User, HostPort: uhp.HostPort}
+}
+
+// ReTOFU lets the next connect to uhp trust whatever host
+// key it is shown, as a first connect with TofuAddIfNotKnown
+// does. Once we have connected to a host, a changed host
+// key is refused on every reconnect until ReTOFU is called.
+// ReTOFU does not itself reconnect; call Reset for that.
+func (t *Tricorder) ReTOFU(uhp *UHP) {
+	t.mut.Lock()
+	t.tofuOK[tofuKey(uhp)] = true
+	t.mut.Unlock()
+}
+
+// disownAgent leaves the ssh-agent connection open
+// when we halt, for a Tricorder sharing our DialConfig.
+func (t *Tricorder) disownAgent() {
+	atomic.StoreInt32(&t.agentDisowned, 1)
+}
+
+// swapDC is called only on the reconnect loop's goroutine,
+// after closeClient.
+func (t *Tricorder) swapDC(dc *DialConfig, cfg *SshegoConfig) {
+	// keep our subscribers, ourselves included.
+	cfg.ClientReconnectNeededTower = t.cfg.ClientReconnectNeededTower
+
+	if t.dc.agentConn != dc.agentConn {
+		t.dc.CloseAgent()
+	}
+	t.mut.Lock()
+	t.dc = dc
+	t.cfg = cfg
+	t.mut.Unlock()
+	t.sshdHostPort = JoinHostPort(dc.Sshdhost, dc.Sshdport)
+	t.uhp = &UHP{
+		User:     dc.Mylogin,
+		HostPort: t.sshdHostPort,
+		Nickname: dc.DestNickname,
+	}
+}
+
+// typ can be "direct-tcpip" (specify destHostPort), "direct-streamlocal"
+// (give the path of a Unix domain socket on the sshd's host as
+// destHostPort), or "custom-inproc-stream" in which case leave
+// destHostPort as the empty string.
+// If ctx is done before the channel is ready, we return
+// ctx.Err() and the channel, if made, is closed for us.
 func (t *Tricorder) SSHChannel(ctx context.Context, typ, targetHostPort string) (ssh.Channel, error) {
+	return t.SSHChannelOpts(ctx, typ, targetHostPort, nil)
+}
+
+// SSHChannelOpts is SSHChannel with per-channel options.
+// opts may be nil.
+func (t *Tricorder) SSHChannelOpts(ctx context.Context, typ, targetHostPort string, opts *ChannelOpts) (ssh.Channel, error) {
+	ch, _, err := t.getChannel(ctx, typ, targetHostPort, opts, false, false)
+	return ch, err
+}
+
+// SSHChannelAndRequests is SSHChannel, but also hands back
+// the channel's incoming requests, such as "exit-status",
+// instead of refusing them. The caller must service the
+// requests, keepalives included, or the channel will
+// stall. Only "custom-inproc-stream" and other plain
+// channel types have requests; for "direct-tcpip" and
+// "direct-streamlocal" the returned request channel is nil.
+func (t *Tricorder) SSHChannelAndRequests(ctx context.Context, typ, targetHostPort string) (ssh.Channel, <-chan *ssh.Request, error) {
+	return t.getChannel(ctx, typ, targetHostPort, nil, true, false)
+}
+
+// getChannel does the work of SSHChannelOpts,
+// SSHChannelAndRequests, and OpenChannelRaw.
+func (t *Tricorder) getChannel(ctx context.Context, typ, targetHostPort string, opts *ChannelOpts, wantRequests, raw bool) (ssh.Channel, <-chan *ssh.Request, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if opts != nil && opts.ProxyProtocol != 0 && opts.ProxyProtocol != 1 && opts.ProxyProtocol != 2 {
+		return nil, nil, fmt.Errorf("Tricorder.SSHChannelOpts: ProxyProtocol %d; want 1 or 2", opts.ProxyProtocol)
+	}
+	if len(opts.openData()) > 0 && !raw {
+		switch typ {
+		case "direct-tcpip", "direct-streamlocal", DirectStreamLocalChanName:
+			return nil, nil, fmt.Errorf("Tricorder.SSHChannelOpts: OpenData is not allowed for '%s' channels", typ)
+		}
+	}
 	tk := newGetChannelTicket(ctx)
 	tk.typ = typ
-	tk.targetHostPort = targetHostPort
-	t.getChannelCh <- tk
-	<-tk.done
-	return tk.sshChannel, tk.err
+	tk.opts = opts
+	tk.wantRequests = wantRequests
+	tk.raw = raw
+	getChannelCh := t.getChannelCh
+	if opts != nil && opts.Priority > 0 {
+		tk.Priority = opts.Priority
+		getChannelCh = t.getChannelHighCh
+	}
+	switch {
+	case raw:
+	case typ == "direct-streamlocal" || typ == DirectStreamLocalChanName:
+		tk.typ = DirectStreamLocalChanName
+		tk.socketPath = targetHostPort
+	default:
+		tk.targetHostPort = targetHostPort
+	}
+	select {
+	case getChannelCh <- tk:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-t.Halt.ReqStopChan():
+		return nil, nil, ErrShutdown
+	}
+	select {
+	case <-tk.done:
+	case <-ctx.Done():
+		if tk.abandon() {
+			return nil, nil, ctx.Err()
+		}
+	case <-t.Halt.ReqStopChan():
+		if tk.abandon() {
+			return nil, nil, ErrShutdown
+		}
+	}
+	return tk.sshChannel, tk.requests, tk.err
+}
+
+type getCliTicket struct {
+	done chan struct{}
+	cli  *ssh.Client
+	err  error
+
+	// noConnect asks for t.cli as is, even if nil.
+	noConnect bool
 }
 
+// Cli returns the current client, first connecting
+// if we have none; as happens with DialConfig.LazyConnect,
+// or after SshegoConfig.ConnIdleTimeout has closed
+// an idle connection.
 func (t *Tricorder) Cli() (cli *ssh.Client, err error) {
+	tk := &getCliTicket{done: make(chan struct{})}
 	select {
-	case cli = <-t.getCliCh:
+	case t.getCliCh <- tk:
 	case <-t.Halt.ReqStopChan():
-		err = ErrShutdown
+		return nil, ErrShutdown
 	}
-	return
+	<-tk.done
+	return tk.cli, tk.err
 }
 
 func (t *Tricorder) Nc() (nc io.Closer, err error) {
@@ -395,3 +1518,74 @@ func (t *Tricorder) Nc() (nc io.Closer, err error) {
 	}
 	return
 }
+
+// SetErrorClassifier sets fn to decide whether a failed
+// connect should be retried, in place of the default,
+// DefaultErrorClassifier. A nil fn restores the default.
+func (t *Tricorder) SetErrorClassifier(fn func(err error) ErrorClass) {
+	t.mut.Lock()
+	t.errorClassifier = fn
+	t.mut.Unlock()
+}
+
+// OnPermanentError registers fn to be called, on the
+// reconnect loop's goroutine, when the error classifier
+// deems a connect error Permanent. By then the
+// Tricorder has already requested its own shutdown,
+// so fn should not expect Cli, Nc, or SSHChannel to succeed.
+func (t *Tricorder) OnPermanentError(fn func(err error)) {
+	t.mut.Lock()
+	t.onPermanentError = fn
+	t.mut.Unlock()
+}
+
+func (t *Tricorder) classify(err error) ErrorClass {
+	if err == ErrNoKnownHosts || err == ErrNoPrivateKey {
+		return Permanent
+	}
+	t.mut.Lock()
+	classifier := t.errorClassifier
+	t.mut.Unlock()
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	return classifier(err)
+}
+
+// permanentError stops the Tricorder and tells
+// the registered hook, if any, why.
+func (t *Tricorder) permanentError(err error) {
+	t.Halt.RequestStop()
+	t.mut.Lock()
+	fn := t.onPermanentError
+	t.mut.Unlock()
+	if fn != nil {
+		fn(err)
+	}
+}
+
+// NewChildTricorder makes a Tricorder for another target
+// that shares our credentials. Fields left empty in dc,
+// like Mylogin, RsaPath, TotpUrl and Pw, are taken from
+// our own DialConfig, and the child gets its own in-memory
+// copy of our KnownHosts. The child's Halt is downstream of
+// ours, so halting us halts all our children.
+func (t *Tricorder) NewChildTricorder(dc *DialConfig, name string) (*Tricorder, error) {
+	if dc == nil {
+		return nil, fmt.Errorf("Tricorder.NewChildTricorder: nil DialConfig")
+	}
+	t.mut.Lock()
+	parentDc := t.dc
+	parentKh := t.cfg.KnownHosts
+	t.mut.Unlock()
+
+	child := dc.inheritFrom(parentDc)
+	if child.KnownHosts == nil && parentKh != nil {
+		// the clone shares the parent's FilepathPrefix;
+		// keep it in memory only, so the child's saves
+		// can't overwrite hosts the parent has added.
+		child.KnownHosts = parentKh.Clone()
+		child.KnownHosts.NoSave = true
+	}
+	return NewTricorder(child, t.Halt, name)
+}
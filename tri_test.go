@@ -1,5 +1,24 @@
 package sshego
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
 // Going through a NAT, if
 // origin -> dest is established by origin
 // initiating, then how do we know at dest
@@ -9,3 +28,1841 @@ package sshego
 // it is available to us, we should be able
 // to use it to open new channels to speak
 // with origin directly as needed.
+
+func Test062TricorderPermanentErrorStopsReconnectLoop(t *testing.T) {
+	cv.Convey("When the error classifier set by SetErrorClassifier deems a reconnect error Permanent, the Tricorder should call the OnPermanentError hook and its reconnect loop should exit cleanly instead of retrying.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			ReconnectDebounce:    time.Millisecond, // we reconnect right after connecting.
+			LocalNickname:        "test062",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test062")
+		panicOn(err)
+
+		cv.So(DefaultErrorClassifier(fmt.Errorf("getsockopt: connection refused")), cv.ShouldEqual, Transient)
+		cv.So(DefaultErrorClassifier(fmt.Errorf("host key mismatch. %w", ErrRerunWithoutNew)), cv.ShouldEqual, Permanent)
+		cv.So(DefaultErrorClassifier(&ssh.OpenChannelError{Reason: ssh.ResourceShortage}), cv.ShouldEqual, Transient)
+		cv.So(DefaultErrorClassifier(&ssh.OpenChannelError{Reason: ssh.Prohibited}), cv.ShouldEqual, Permanent)
+
+		// treat everything, even connection refused, as permanent.
+		tri.SetErrorClassifier(func(err error) ErrorClass { return Permanent })
+		permErr := make(chan error, 1)
+		tri.OnPermanentError(func(err error) {
+			permErr <- err
+		})
+
+		// with the sshd gone, the reconnect will be refused.
+		s.SrvCfg.Esshd.Stop()
+		tri.ClientReconnectNeededTower.Broadcast(&UHP{
+			User:     s.Mylogin,
+			HostPort: tri.sshdHostPort,
+		})
+
+		select {
+		case err = <-permErr:
+			cv.So(err, cv.ShouldNotBeNil)
+		case <-time.After(10 * time.Second):
+			panic("OnPermanentError hook never called")
+		}
+
+		select {
+		case <-tri.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("reconnect loop did not exit after permanent error")
+		}
+
+		_, err = tri.Cli()
+		cv.So(err, cv.ShouldEqual, ErrShutdown)
+		_, err = tri.SSHChannel(context.Background(), "direct-tcpip", "127.0.0.1:1")
+		cv.So(err, cv.ShouldEqual, ErrShutdown)
+	})
+}
+
+func Test063TricorderClosesIdleConnection(t *testing.T) {
+	cv.Convey("With ConnIdleTimeout set, a Tricorder whose channels see no traffic should close its client connection, and reconnect on the next SSHChannel request.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		// each test tcp server accepts only once, so
+		// we need one for before and one for after.
+		tcpServerMgr := ssh.NewHalter()
+		var dests []string
+		for i := 0; i < 2; i++ {
+			tcpSrvLsn, tcpSrvPort := GetAvailPort()
+			StartBackgroundTestTcpServer(
+				tcpServerMgr,
+				payloadByteCount,
+				confirmationPayload,
+				confirmationReply,
+				tcpSrvLsn,
+				nil)
+			dests = append(dests, fmt.Sprintf("127.0.0.1:%v", tcpSrvPort))
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		idleTimeout := 3 * time.Second
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test063",
+			ConnIdleTimeout:      idleTimeout,
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test063")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dests[0])
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cli1, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli1, cv.ShouldNotBeNil)
+
+		// no traffic: the connection should be dropped.
+		select {
+		case <-cli1.Halt.ReqStopChan():
+		case <-time.After(20 * idleTimeout):
+			panic("idle connection was not closed")
+		}
+
+		// and come back on demand.
+		ch, err = tri.SSHChannel(context.Background(), "direct-tcpip", dests[1])
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli, cv.ShouldNotBeNil)
+		cv.So(cli, cv.ShouldNotEqual, cli1)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test064TricorderReset(t *testing.T) {
+	cv.Convey("Tricorder.Reset should close channels opened before it, reconnect on a fresh client, and leave the Tricorder usable.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		var dests []string
+		for i := 0; i < 2; i++ {
+			tcpSrvLsn, tcpSrvPort := GetAvailPort()
+			StartBackgroundTestTcpServer(
+				tcpServerMgr,
+				payloadByteCount,
+				confirmationPayload,
+				confirmationReply,
+				tcpSrvLsn,
+				nil)
+			dests = append(dests, fmt.Sprintf("127.0.0.1:%v", tcpSrvPort))
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test064",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test064")
+		panicOn(err)
+
+		before, err := tri.SSHChannel(context.Background(), "direct-tcpip", dests[0])
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(before, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cli1, err := tri.Cli()
+		panicOn(err)
+
+		cv.So(tri.Reset(context.Background()), cv.ShouldBeNil)
+
+		select {
+		case <-before.Done():
+		case <-time.After(10 * time.Second):
+			panic("channel opened before Reset was not closed")
+		}
+
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotBeNil)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+
+		after, err := tri.SSHChannel(context.Background(), "direct-tcpip", dests[1])
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(after, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cv.So(tri.Halt.IsStopRequested(), cv.ShouldBeFalse)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test065TricorderLazyConnect(t *testing.T) {
+	cv.Convey("With DialConfig.LazyConnect, NewTricorder should succeed while the sshd is down, and connect once the sshd is up and a channel is requested.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		// sshd not started yet.
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test065",
+			LazyConnect:          true,
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test065")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(tri, cv.ShouldNotBeNil)
+
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli, cv.ShouldNotBeNil)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test066TricorderReplaceDCRotatesKey(t *testing.T) {
+	cv.Convey("After the user's private key is rotated on the server, Tricorder.ReplaceDC with a DialConfig naming the new key should reconnect and authenticate.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test066",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test066")
+		panicOn(err)
+		cli1, err := tri.Cli()
+		panicOn(err)
+
+		// rotate the key: the server now only accepts the new one.
+		newRsaPath := s.RsaPath + ".rotated"
+		_, _, err = GenRSAKeyPair(newRsaPath, s.SrvCfg.BitLenRSAkeys, "bob@example.com")
+		panicOn(err)
+		user := s.SrvCfg.HostDb.Persist.Users.Get(s.Mylogin)
+		user.PrivateKeyPath = newRsaPath
+		user.PublicKeyPath = newRsaPath + ".pub"
+
+		cv.So(tri.ReplaceDC(context.Background(), nil), cv.ShouldNotBeNil)
+
+		dc2 := *dc
+		dc2.RsaPath = newRsaPath
+		dc2.TofuAddIfNotKnown = false
+		cv.So(tri.ReplaceDC(context.Background(), &dc2), cv.ShouldBeNil)
+
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotBeNil)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test068ChildTricordersHaltWithParent(t *testing.T) {
+	cv.Convey("NewChildTricorder should inherit the parent's credentials, and halting the parent should halt every child.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test068",
+		}
+
+		parent, err := NewTricorder(dc, s.CliCfg.Halt, "test068-parent")
+		panicOn(err)
+
+		var kids []*Tricorder
+		for i := 0; i < 3; i++ {
+			// only the target is given; the
+			// credentials come from the parent.
+			kid, err := parent.NewChildTricorder(&DialConfig{
+				Sshdhost: s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport: s.SrvCfg.EmbeddedSSHd.Port,
+			}, fmt.Sprintf("test068-child-%v", i))
+			panicOn(err)
+			cli, err := kid.Cli()
+			panicOn(err)
+			cv.So(cli, cv.ShouldNotBeNil)
+			cv.So(kid.cfg.KnownHosts, cv.ShouldNotEqual, parent.cfg.KnownHosts)
+			cv.So(kid.cfg.KnownHosts.NoSave, cv.ShouldBeTrue)
+			kids = append(kids, kid)
+		}
+
+		// the inherited copy shares no slices or
+		// KnownHosts with the caller's DialConfig.
+		ciphers := []string{"aes128-ctr"}
+		kh := parent.cfg.KnownHosts
+		inherited := (&DialConfig{Ciphers: ciphers, KnownHosts: kh}).inheritFrom(dc)
+		inherited.Ciphers[0] = "changed"
+		cv.So(ciphers[0], cv.ShouldEqual, "aes128-ctr")
+		cv.So(inherited.KnownHosts, cv.ShouldNotEqual, kh)
+		cv.So(inherited.Mylogin, cv.ShouldEqual, dc.Mylogin)
+
+		parent.Halt.RequestStop()
+		for _, kid := range kids {
+			select {
+			case <-kid.Halt.DoneChan():
+			case <-time.After(10 * time.Second):
+				panic(fmt.Sprintf("child '%s' did not halt with its parent", kid.GetName()))
+			}
+		}
+		<-parent.Halt.DoneChan()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test070SSHChannelHonorsContextCancel(t *testing.T) {
+	cv.Convey("SSHChannel should return promptly with ctx.Err() for a cancelled context, and a channel made for a caller that gave up should be closed rather than leaked.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		// the orphaned channel gets closed on its
+		// server, so it needs one that doesn't mind.
+		orphanLsn, orphanPort := GetAvailPort()
+		defer orphanLsn.Close()
+		go func() {
+			for {
+				conn, err := orphanLsn.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		orphanDest := fmt.Sprintf("127.0.0.1:%v", orphanPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test070",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test070")
+		panicOn(err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		t0 := time.Now()
+		ch, err := tri.SSHChannel(ctx, "direct-tcpip", dest)
+		cv.So(err, cv.ShouldEqual, context.Canceled)
+		cv.So(ch, cv.ShouldBeNil)
+		cv.So(time.Since(t0), cv.ShouldBeLessThan, time.Second)
+		cv.So(testutil.ToFloat64(tri.metrics.channelCount), cv.ShouldEqual, 0)
+
+		// a ticket whose caller has gone away: the channel
+		// is still opened, but must be closed and forgotten.
+		tk := newGetChannelTicket(context.Background())
+		tk.typ = "direct-tcpip"
+		tk.targetHostPort = orphanDest
+		tk.abandoned = true
+		tri.getChannelCh <- tk
+		<-tk.done
+		cv.So(tk.sshChannel, cv.ShouldBeNil)
+		cv.So(testutil.ToFloat64(tri.metrics.channelsOpen), cv.ShouldEqual, 1)
+		cv.So(testutil.ToFloat64(tri.metrics.channelCount), cv.ShouldEqual, 0)
+
+		// and the Tricorder is still fine.
+		ch, err = tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test074TricorderDirectStreamLocal(t *testing.T) {
+	cv.Convey("Tricorder.SSHChannel with typ \"direct-streamlocal\" should reach a Unix domain socket on the sshd's host.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		sockPath := filepath.Join(s.SrvCfg.Tempdir, "test074.sock")
+		unixLsn, err := net.Listen("unix", sockPath)
+		panicOn(err)
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			unixLsn,
+			nil)
+
+		// the embedded sshd doesn't do streamlocal
+		// forwarding itself, so proxy it here.
+		s.SrvCfg.CustomChannelHandlers = map[string]CustomChannelHandlerCB{
+			DirectStreamLocalChanName: func(nc ssh.NewChannel, sshconn ssh.Conn, ca *ConnectionAlert) {
+				var m struct {
+					SocketPath string
+					Reserved0  string
+					Reserved1  uint32
+				}
+				err := ssh.Unmarshal(nc.ExtraData(), &m)
+				if err != nil {
+					nc.Reject(ssh.ConnectionFailed, err.Error())
+					return
+				}
+				conn, err := net.Dial("unix", m.SocketPath)
+				if err != nil {
+					nc.Reject(ssh.ConnectionFailed, err.Error())
+					return
+				}
+				ch, reqs, err := nc.Accept()
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(context.Background(), reqs, nil)
+				sp := newShovelPair(false)
+				sp.Start(conn, ch, "unixSocket<-channel", "channel<-unixSocket")
+			},
+		}
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test074",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test074")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-streamlocal", sockPath)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test079TricorderReconnectDebounce(t *testing.T) {
+	cv.Convey("Right after a successful connect, a Tricorder should ignore reconnect requests for DialConfig.ReconnectDebounce, 1 second by default.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		newTri := func(debounce time.Duration, name string) *Tricorder {
+			dc := &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				ReconnectDebounce:    debounce,
+				LocalNickname:        name,
+			}
+			tri, err := NewTricorder(dc, s.CliCfg.Halt, name)
+			panicOn(err)
+			return tri
+		}
+
+		// requestReconnect returns once the reconnect
+		// loop has dealt with our request.
+		requestReconnect := func(tri *Tricorder) *ssh.Client {
+			tri.reconnectNeededCh <- tri.uhp
+			for len(tri.reconnectNeededCh) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cli, err := tri.Cli()
+			panicOn(err)
+			return cli
+		}
+
+		// default window
+		tri := newTri(0, "test079-default")
+		cli0, err := tri.Cli()
+		panicOn(err)
+		time.Sleep(500 * time.Millisecond)
+		cv.So(requestReconnect(tri), cv.ShouldEqual, cli0)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 0)
+		tri.Halt.RequestStop()
+
+		// custom window
+		debounce := 300 * time.Millisecond
+		tri = newTri(debounce, "test079-custom")
+		cli0, err = tri.Cli()
+		panicOn(err)
+		cv.So(requestReconnect(tri), cv.ShouldEqual, cli0)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 0)
+
+		time.Sleep(debounce + 100*time.Millisecond)
+		cli1 := requestReconnect(tri)
+		cv.So(cli1, cv.ShouldNotEqual, cli0)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 1)
+
+		// and again suppressed, right after that reconnect.
+		cv.So(requestReconnect(tri), cv.ShouldEqual, cli1)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 1)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test081TricorderDebounceFollowsEverySuccessfulConnect(t *testing.T) {
+	cv.Convey("The reconnect debounce window should start at every successful connect: a lazy first connect, and a Reset, not just the initial dial.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LazyConnect:          true,
+			ReconnectDebounce:    time.Minute,
+			LocalNickname:        "test081",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test081")
+		panicOn(err)
+
+		requestReconnect := func() *ssh.Client {
+			tri.reconnectNeededCh <- tri.uhp
+			for len(tri.reconnectNeededCh) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cli, err := tri.Cli()
+			panicOn(err)
+			return cli
+		}
+
+		// lazy connect
+		cli0, err := tri.Cli()
+		panicOn(err)
+		cv.So(requestReconnect(), cv.ShouldEqual, cli0)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 0)
+
+		// Reset
+		panicOn(tri.Reset(context.Background()))
+		cli1, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli1, cv.ShouldNotEqual, cli0)
+		cv.So(requestReconnect(), cv.ShouldEqual, cli1)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 0)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test082TricorderSetIdleTimeout(t *testing.T) {
+	cv.Convey("Tricorder.SetIdleTimeout should apply to channels that are already open, so a quiet channel times out after the new, shorter, idle timeout.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test082",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test082")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tri.SetIdleTimeout(50 * time.Millisecond)
+		time.Sleep(60 * time.Millisecond)
+
+		// the server sends nothing more, so our read
+		// should give up well before the 5 second default.
+		t0 := time.Now()
+		_, err = ch.Read(make([]byte, 1))
+		cv.So(err, cv.ShouldNotBeNil)
+		nerr, ok := err.(net.Error)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(nerr.Timeout(), cv.ShouldBeTrue)
+		cv.So(time.Since(t0), cv.ShouldBeLessThan, time.Second)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test084TricorderRegisterChannelType(t *testing.T) {
+	cv.Convey("Tricorder.RegisterChannelType should route SSHChannel requests of that type to our handler, with the ticket and client; unregistered types should get ErrUnknownChannelType.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test084",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test084")
+		panicOn(err)
+
+		_, err = tri.SSHChannel(context.Background(), "no-such-type@example.com", dest)
+		cv.So(err, cv.ShouldEqual, ErrUnknownChannelType)
+
+		// our handler tunnels to dest like direct-tcpip.
+		var sawTyp, sawTarget string
+		var sawCli *ssh.Client
+		var sawCtx context.Context
+		tri.RegisterChannelType("test084@example.com", func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) (err error) {
+			sawCtx, sawTyp, sawTarget, sawCli = ctx, req.Type(), req.Target(), cli
+			ch, err := cli.DialWithContext(ctx, "tcp", req.Target())
+			req.SetChannel(ch)
+			return err
+		})
+
+		ch, err := tri.SSHChannel(context.Background(), "test084@example.com", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(sawCtx, cv.ShouldNotBeNil)
+		cv.So(sawTyp, cv.ShouldEqual, "test084@example.com")
+		cv.So(sawTarget, cv.ShouldEqual, dest)
+		cv.So(sawCli, cv.ShouldEqual, cli)
+
+		// a handler that sets no channel and returns no error
+		// gets an error, and its ctx is cancelled.
+		var lazyCtx context.Context
+		tri.RegisterChannelType("test084-lazy@example.com", func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error {
+			lazyCtx = ctx
+			return nil
+		})
+		_, err = tri.SSHChannel(context.Background(), "test084-lazy@example.com", dest)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(lazyCtx.Err(), cv.ShouldNotBeNil)
+
+		// and unregistering it leaves it unknown again.
+		tri.RegisterChannelType("test084@example.com", nil)
+		_, err = tri.SSHChannel(context.Background(), "test084@example.com", dest)
+		cv.So(err, cv.ShouldEqual, ErrUnknownChannelType)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test085TricorderCloseChannel(t *testing.T) {
+	cv.Convey("Tricorder.CloseChannel should close just the one channel, cancel its context, and drop it from ListChannels.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		var dests []string
+		for i := 0; i < 2; i++ {
+			tcpSrvLsn, tcpSrvPort := GetAvailPort()
+			StartBackgroundTestTcpServer(
+				tcpServerMgr,
+				payloadByteCount,
+				confirmationPayload,
+				confirmationReply,
+				tcpSrvLsn,
+				nil)
+			dests = append(dests, fmt.Sprintf("127.0.0.1:%v", tcpSrvPort))
+		}
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test085",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test085")
+		panicOn(err)
+
+		// direct-tcpip, but keeping each channel's context.
+		ctxs := make(map[string]context.Context)
+		tri.RegisterChannelType("direct-tcpip", func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) (err error) {
+			ctxs[req.Target()] = ctx
+			ch, err := cli.DialWithContext(ctx, "tcp", req.Target())
+			req.SetChannel(ch)
+			return err
+		})
+
+		var chans []ssh.Channel
+		for _, dest := range dests {
+			ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+			panicOn(err)
+			VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+			chans = append(chans, ch)
+		}
+		open, err := tri.ListChannels()
+		panicOn(err)
+		cv.So(len(open), cv.ShouldEqual, 2)
+
+		cv.So(tri.CloseChannel(chans[0]), cv.ShouldBeNil)
+
+		open, err = tri.ListChannels()
+		panicOn(err)
+		cv.So(len(open), cv.ShouldEqual, 1)
+		cv.So(open[0].Channel, cv.ShouldEqual, chans[1])
+
+		cv.So(ctxs[dests[0]].Err(), cv.ShouldEqual, context.Canceled)
+		cv.So(ctxs[dests[1]].Err(), cv.ShouldBeNil)
+
+		// a second close is an error.
+		cv.So(tri.CloseChannel(chans[0]), cv.ShouldNotBeNil)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test086TricorderChannelPriority(t *testing.T) {
+	cv.Convey("While the reconnect loop is busy, queued SSHChannel requests with ChannelOpts.Priority > 0 should be served before the normal ones.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test086",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test086")
+		panicOn(err)
+
+		// "block" holds up the loop until released; "record"
+		// notes the order requests are served in. Neither
+		// opens a real channel, so both return errNoChannel.
+		// Both run on the loop goroutine.
+		errNoChannel := fmt.Errorf("test086: no channel")
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		tri.RegisterChannelType("block", func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error {
+			close(entered)
+			<-release
+			return errNoChannel
+		})
+		var served []int
+		tri.RegisterChannelType("record", func(ctx context.Context, req *ChannelRequest, cli *ssh.Client) error {
+			served = append(served, req.Opts().Priority)
+			return errNoChannel
+		})
+
+		go tri.SSHChannel(context.Background(), "block", "")
+		<-entered
+
+		var wg sync.WaitGroup
+		request := func(prio int) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := tri.SSHChannelOpts(context.Background(), "record", "", &ChannelOpts{Priority: prio})
+				if err != errNoChannel {
+					panic(err)
+				}
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			request(0)
+		}
+		for i := 0; i < 5; i++ {
+			request(1)
+		}
+		// let them all queue up.
+		time.Sleep(200 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		cv.So(len(served), cv.ShouldEqual, 15)
+		cv.So(served[:5], cv.ShouldResemble, []int{1, 1, 1, 1, 1})
+		for _, prio := range served[5:] {
+			cv.So(prio, cv.ShouldEqual, 0)
+		}
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test087TricorderReapsClosedChannels(t *testing.T) {
+	cv.Convey("Channels closed by either end, without CloseChannel, should be dropped from the Tricorder's channel map.", t, func() {
+
+		// closer hangs up on everyone; holder waits for us to.
+		// closer resets rather than closes, since the sshd
+		// relays a plain close as a half-close, channel EOF,
+		// and keeps the channel open for our side.
+		closerLsn, closerPort := GetAvailPort()
+		defer closerLsn.Close()
+		go func() {
+			for {
+				c, err := closerLsn.Accept()
+				if err != nil {
+					return
+				}
+				c.(*net.TCPConn).SetLinger(0)
+				c.Close()
+			}
+		}()
+		holderLsn, holderPort := GetAvailPort()
+		defer holderLsn.Close()
+		go func() {
+			for {
+				c, err := holderLsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(ioutil.Discard, c)
+					c.Close()
+				}()
+			}
+		}()
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test087",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test087")
+		panicOn(err)
+
+		var chans []ssh.Channel
+		for i := 0; i < 6; i++ {
+			ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", fmt.Sprintf("127.0.0.1:%v", closerPort))
+			panicOn(err)
+			chans = append(chans, ch)
+		}
+		for i := 0; i < 4; i++ {
+			ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", fmt.Sprintf("127.0.0.1:%v", holderPort))
+			panicOn(err)
+			ch.Close()
+			chans = append(chans, ch)
+		}
+		for i, ch := range chans {
+			select {
+			case <-ch.GetHalter().ReqStopChan():
+			case <-time.After(10 * time.Second):
+				panic(fmt.Sprintf("channel %v never closed", i))
+			}
+		}
+
+		open, err := tri.ListChannels()
+		panicOn(err)
+		cv.So(len(open), cv.ShouldEqual, 0)
+		cv.So(testutil.ToFloat64(tri.metrics.channelsOpen), cv.ShouldEqual, 10)
+		cv.So(testutil.ToFloat64(tri.metrics.channelCount), cv.ShouldEqual, 0)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test088TricorderRotateTOTP(t *testing.T) {
+	cv.Convey("After the user's TOTP secret is rotated on the server, Tricorder.RotateTOTP should leave open channels working and let the next connect log in with the new secret.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test088",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test088")
+		panicOn(err)
+		cli1, err := tri.Cli()
+		panicOn(err)
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		cv.So(tri.RotateTOTP(context.Background(), "not a url"), cv.ShouldNotBeNil)
+
+		// rotate the secret: the server now only accepts the new one.
+		w, err := NewTOTP("bob@example.com", "test088")
+		panicOn(err)
+		user := s.SrvCfg.HostDb.Persist.Users.Get(s.Mylogin)
+		user.TOTPorig = w.Key.String()
+		user.TotpSecret = w.Key.Secret()
+
+		cv.So(tri.RotateTOTP(context.Background(), w.Key.String()), cv.ShouldBeNil)
+		cv.So(dc.TotpUrl, cv.ShouldEqual, s.Totp)
+
+		// no reconnect, and our channel still works.
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli, cv.ShouldEqual, cli1)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		// re-authenticating needs the new secret.
+		cv.So(tri.Reset(context.Background()), cv.ShouldBeNil)
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test106TricorderConfigErrorsDoNotPanic(t *testing.T) {
+	cv.Convey("A Tricorder with no KnownHosts, or no private key, should return ErrNoKnownHosts or ErrNoPrivateKey from connecting and tell its OnPermanentError hook, rather than panic.", t, func() {
+
+		origdir, tempdir := MakeAndMoveToTempDir()
+		defer TempDirCleanup(origdir, tempdir)
+
+		// Pw alone fails Validate; the Tricorder
+		// needs a key to log in with.
+		dc := &DialConfig{
+			ClientKnownHostsPath: tempdir + "/known_hosts",
+			Mylogin:              "bob",
+			Pw:                   "pw",
+			Sshdhost:             "127.0.0.1",
+			Sshdport:             1,
+			LocalNickname:        "test106",
+		}
+		tri, err := NewTricorder(dc, nil, "test106a")
+		cv.So(tri, cv.ShouldBeNil)
+		_, isConfigErr := err.(*DialConfigError)
+		cv.So(isConfigErr, cv.ShouldBeTrue)
+
+		// bypass Validate by dropping the key, or KnownHosts,
+		// from a lazily connecting Tricorder.
+		dc = &DialConfig{
+			ClientKnownHostsPath: tempdir + "/known_hosts",
+			Mylogin:              "bob",
+			RsaPath:              origdir + "/id_rsa_test",
+			Sshdhost:             "127.0.0.1",
+			Sshdport:             1,
+			LazyConnect:          true,
+			LocalNickname:        "test106",
+		}
+		tri, err = NewTricorder(dc, nil, "test106b")
+		panicOn(err)
+		tri.cfg.PrivateKeyPath = ""
+		_, err = tri.Cli()
+		cv.So(err, cv.ShouldEqual, ErrNoPrivateKey)
+		tri.Halt.RequestStop()
+
+		tri, err = NewTricorder(dc, nil, "test106c")
+		panicOn(err)
+		permErr := make(chan error, 1)
+		tri.OnPermanentError(func(err error) {
+			permErr <- err
+		})
+		tri.cfg.KnownHosts = nil
+
+		_, err = tri.Cli()
+		cv.So(err, cv.ShouldEqual, ErrNoKnownHosts)
+		select {
+		case err = <-permErr:
+			cv.So(err, cv.ShouldEqual, ErrNoKnownHosts)
+		case <-time.After(10 * time.Second):
+			panic("OnPermanentError hook never called")
+		}
+		select {
+		case <-tri.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("reconnect loop did not exit after ErrNoKnownHosts")
+		}
+	})
+}
+
+func Test110TricorderDialTimeout(t *testing.T) {
+	cv.Convey("With DialTimeout set, a Tricorder dialing an sshd that never answers should give up after about retries * DialTimeout, rather than hang.", t, func() {
+
+		origdir, tempdir := MakeAndMoveToTempDir()
+		defer TempDirCleanup(origdir, tempdir)
+
+		// the kernel completes the TCP handshake, but
+		// nobody ever sends an ssh version line.
+		lsn, err := net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		defer lsn.Close()
+		host, port, err := SplitHostPort(lsn.Addr().String())
+		panicOn(err)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: tempdir + "/known_hosts",
+			Mylogin:              "bob",
+			RsaPath:              origdir + "/id_rsa_test",
+			Sshdhost:             host,
+			Sshdport:             port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LazyConnect:          true,
+			DialTimeout:          200 * time.Millisecond,
+			LocalNickname:        "test110",
+		}
+		tri, err := NewTricorder(dc, nil, "test110")
+		panicOn(err)
+		defer tri.Halt.RequestStop()
+		tri.retries = 3
+		tri.pauseBetweenRetries = 10 * time.Millisecond
+
+		t0 := time.Now()
+		_, err = tri.Cli()
+		elapsed := time.Since(t0)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "timed out after 200ms")
+		cv.So(elapsed, cv.ShouldBeGreaterThanOrEqualTo, 3*200*time.Millisecond)
+		cv.So(elapsed, cv.ShouldBeLessThan, 5*time.Second)
+	})
+}
+
+func Test116TricorderChannelFallbackType(t *testing.T) {
+	cv.Convey("When the sshd refuses a channel type, SSHChannelOpts should retry once with ChannelOpts.FallbackType, here direct-tcpip, and hand back that channel.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		defer tcpServerMgr.RequestStop()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test116",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test116")
+		panicOn(err)
+
+		// the esshd knows nothing of this type.
+		tri.RegisterChannelType("no-such-type", tri.openPlainChannel)
+		ctx := context.Background()
+
+		_, err = tri.SSHChannel(ctx, "no-such-type", dest)
+		oce, ok := err.(*ssh.OpenChannelError)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(oce.Reason, cv.ShouldEqual, ssh.UnknownChannelType)
+
+		ch, err := tri.SSHChannelOpts(ctx, "no-such-type", dest, &ChannelOpts{FallbackType: "direct-tcpip"})
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test119ConnectErrorsAreTyped(t *testing.T) {
+	cv.Convey("Connection refused and the TOFU re-run request should be recognized by type, not by message text.", t, func() {
+
+		// a refused dial, wrapped the way net and our callers wrap it,
+		// with message text that says nothing about refusal.
+		refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+		wrapped := fmt.Errorf("sshConnect() errored at dial: '%w'", refused)
+		cv.So(isConnRefused(refused), cv.ShouldBeTrue)
+		cv.So(isConnRefused(wrapped), cv.ShouldBeTrue)
+		cv.So(isConnRefused(fmt.Errorf("getsockopt: connection refused")), cv.ShouldBeFalse)
+		cv.So(DefaultErrorClassifier(wrapped), cv.ShouldEqual, Transient)
+
+		// a real refused dial.
+		lsn, err := net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		addr := lsn.Addr().String()
+		lsn.Close()
+		_, err = net.Dial("tcp", addr)
+		cv.So(isConnRefused(err), cv.ShouldBeTrue)
+
+		rerun := fmt.Errorf("sshConnect() errored at dial: '%w'", ErrRerunWithoutNew)
+		cv.So(DefaultErrorClassifier(rerun), cv.ShouldEqual, Permanent)
+		cv.So(DefaultErrorClassifier(fmt.Errorf("please Re-run without -new")), cv.ShouldEqual, Transient)
+	})
+}
+
+func Test121TricorderReTOFU(t *testing.T) {
+	cv.Convey("TOFU should apply only to the first connect to a host: after the sshd's host key changes, reconnects should be refused until ReTOFU is called.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test121",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test121")
+		panicOn(err)
+		tri.retries = 2
+		tri.pauseBetweenRetries = 10 * time.Millisecond
+		ctx := context.Background()
+
+		_, err = tri.Cli()
+		panicOn(err)
+
+		s.forTestingUpdateServerHostKey(s.SrvCfg.Tempdir + "/testdata/id_rsa_b")
+
+		// dc still says TofuAddIfNotKnown, but that was spent
+		// on the first connect.
+		err = tri.Reset(ctx)
+		cv.So(err, cv.ShouldNotBeNil)
+
+		tri.ReTOFU(&UHP{
+			User:     s.Mylogin,
+			HostPort: JoinHostPort(dc.Sshdhost, dc.Sshdport),
+		})
+		cv.So(tri.Reset(ctx), cv.ShouldBeNil)
+		cli, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test122TricorderAnnotateChannel(t *testing.T) {
+	cv.Convey("Annotations given to AnnotateChannel should come back from ListChannels with their channel.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpServerMgr := ssh.NewHalter()
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test122",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test122")
+		panicOn(err)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		ann := map[string]string{"request-id": "r-42", "service": "billing"}
+		cv.So(tri.AnnotateChannel(ch, ann), cv.ShouldBeNil)
+		// our copy is not shared with the caller.
+		ann["service"] = "changed"
+		cv.So(tri.AnnotateChannel(ch, map[string]string{"request-id": "r-43"}), cv.ShouldBeNil)
+
+		open, err := tri.ListChannels()
+		panicOn(err)
+		cv.So(len(open), cv.ShouldEqual, 1)
+		cv.So(open[0].Channel, cv.ShouldEqual, ch)
+		cv.So(open[0].Annotations, cv.ShouldResemble, map[string]string{"request-id": "r-43", "service": "billing"})
+
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cv.So(tri.CloseChannel(ch), cv.ShouldBeNil)
+		cv.So(tri.AnnotateChannel(ch, ann), cv.ShouldNotBeNil)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test127TricordersSharingDialConfigAreIndependent(t *testing.T) {
+	cv.Convey("Two Tricorders made from one DialConfig should each get their own config and KnownHosts, so one's TOFU connect leaves the other, and the DialConfig, untouched.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		for _, preloaded := range []bool{false, true} {
+			dc := &DialConfig{
+				ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+				Mylogin:              s.Mylogin,
+				RsaPath:              s.RsaPath,
+				TotpUrl:              s.Totp,
+				Pw:                   s.Pw,
+				Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+				Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+				TofuAddIfNotKnown:    true,
+				SkipKeepAlive:        true,
+				LazyConnect:          true,
+				Ciphers:              []string{"aes128-gcm@openssh.com"},
+				LocalNickname:        "test127",
+			}
+			var kh *KnownHosts
+			var nHosts int
+			if preloaded {
+				var err error
+				kh, err = NewKnownHosts(dc.ClientKnownHostsPath, KHSsh)
+				panicOn(err)
+				kh.NoSave = true
+				nHosts = len(kh.Hosts)
+				dc.KnownHosts = kh
+			}
+
+			tri1, err := NewTricorder(dc, s.CliCfg.Halt, "test127-1")
+			panicOn(err)
+			tri2, err := NewTricorder(dc, s.CliCfg.Halt, "test127-2")
+			panicOn(err)
+
+			cv.So(tri1.cfg, cv.ShouldNotEqual, tri2.cfg)
+			cv.So(tri1.cfg.KnownHosts, cv.ShouldNotEqual, tri2.cfg.KnownHosts)
+			cv.So(dc.KnownHosts, cv.ShouldEqual, kh)
+			tri1.cfg.Ciphers[0] = "changed"
+			cv.So(dc.Ciphers[0], cv.ShouldEqual, "aes128-gcm@openssh.com")
+			cv.So(tri2.cfg.Ciphers[0], cv.ShouldEqual, "aes128-gcm@openssh.com")
+			tri1.cfg.Ciphers[0] = "aes128-gcm@openssh.com"
+
+			// tri1 connects, spending its TOFU.
+			_, err = tri1.Cli()
+			panicOn(err)
+			cv.So(tri1.cfg.AddIfNotKnown, cv.ShouldBeFalse)
+
+			cv.So(tri2.cfg.AddIfNotKnown, cv.ShouldBeTrue)
+			cv.So(dc.TofuAddIfNotKnown, cv.ShouldBeTrue)
+			if preloaded {
+				cv.So(len(kh.Hosts), cv.ShouldEqual, nHosts)
+			}
+
+			_, err = tri2.Cli()
+			panicOn(err)
+
+			tri1.Halt.RequestStop()
+			tri2.Halt.RequestStop()
+		}
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test135TricorderRotatesAtMaxConnLifetime(t *testing.T) {
+	cv.Convey("With MaxConnLifetime set, a Tricorder should replace its connection once it is that old, connecting anew before closing the old connection and the channels on it.", t, func() {
+
+		// a sink that accepts and holds connections open.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(io.Discard, conn)
+					conn.Close()
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			MaxConnLifetime:      500 * time.Millisecond,
+			LocalNickname:        "test135",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test135")
+		panicOn(err)
+		ct := tri.StartTracing()
+
+		cli1, err := tri.Cli()
+		panicOn(err)
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		rotated := func() bool {
+			for _, ev := range ct.Events() {
+				if ev.Kind == TraceReconnect && strings.HasPrefix(ev.Detail, "max lifetime rotation") {
+					return true
+				}
+			}
+			return false
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for !rotated() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cv.So(rotated(), cv.ShouldBeTrue)
+
+		// the old connection and its channel get closed...
+		select {
+		case <-cli1.Halt.ReqStopChan():
+		case <-time.After(10 * time.Second):
+			panic("old connection was not closed after rotation")
+		}
+		readErr := make(chan error, 1)
+		go func() {
+			_, err := ch.Read(make([]byte, 1))
+			readErr <- err
+		}()
+		select {
+		case err = <-readErr:
+			cv.So(err, cv.ShouldNotBeNil)
+		case <-time.After(10 * time.Second):
+			panic("channel on the old connection was not closed")
+		}
+
+		// ...and we carry on over the new one.
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+		ch2, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		cv.So(ch2, cv.ShouldNotBeNil)
+
+		tri.StopTracing(ct)
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test165TricorderRotationFailureKeepsLoopRunning(t *testing.T) {
+	cv.Convey("A MaxConnLifetime rotation that cannot connect should neither stall the Tricorder's other requests nor stop it; the old connection stays in use.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		// relay to the esshd, so we can refuse
+		// new connections but keep the first.
+		lsn, port := GetAvailPort()
+		esshd := JoinHostPort(s.SrvCfg.EmbeddedSSHd.Host, s.SrvCfg.EmbeddedSSHd.Port)
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				var up net.Conn
+				for i := 0; i < 100; i++ {
+					up, err = net.Dial("tcp", esshd)
+					if err == nil {
+						break
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+				if err != nil {
+					conn.Close()
+					continue
+				}
+				go func() {
+					io.Copy(up, conn)
+					up.Close()
+				}()
+				go func() {
+					io.Copy(conn, up)
+					conn.Close()
+				}()
+			}
+		}()
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             "127.0.0.1",
+			Sshdport:             int64(port),
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			MaxConnLifetime:      500 * time.Millisecond,
+			LocalNickname:        "test165",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test165")
+		panicOn(err)
+		ct := tri.StartTracing()
+
+		cli1, err := tri.Cli()
+		panicOn(err)
+		lsn.Close()
+
+		failed := func() bool {
+			for _, ev := range ct.Events() {
+				if ev.Kind == TraceConnectError && strings.HasPrefix(ev.Detail, "rotation failed") {
+					return true
+				}
+			}
+			return false
+		}
+		deadline := time.Now().Add(10 * time.Second)
+		for !failed() && time.Now().Before(deadline) {
+			// the loop answers promptly while rotation is tried.
+			t0 := time.Now()
+			_, err := tri.ListChannels()
+			panicOn(err)
+			cv.So(time.Since(t0), cv.ShouldBeLessThan, 500*time.Millisecond)
+			time.Sleep(10 * time.Millisecond)
+		}
+		cv.So(failed(), cv.ShouldBeTrue)
+		cv.So(tri.Halt.IsStopRequested(), cv.ShouldBeFalse)
+
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldEqual, cli1)
+
+		tri.StopTracing(ct)
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test139TricorderReconnect(t *testing.T) {
+	cv.Convey("Tricorder.Reconnect should replace the client connection at once, even right after connecting, and close the channels opened on the old one.", t, func() {
+
+		// a sink that accepts and holds connections open.
+		lsn, port := GetAvailPort()
+		defer lsn.Close()
+		go func() {
+			for {
+				conn, err := lsn.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(io.Discard, conn)
+					conn.Close()
+				}()
+			}
+		}()
+		dest := fmt.Sprintf("127.0.0.1:%v", port)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			// a broadcast would be ignored; Reconnect must not be.
+			ReconnectDebounce: time.Hour,
+			LocalNickname:     "test139",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test139")
+		panicOn(err)
+
+		cli1, err := tri.Cli()
+		panicOn(err)
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+
+		cv.So(tri.Reconnect(), cv.ShouldBeNil)
+
+		cli2, err := tri.Cli()
+		panicOn(err)
+		cv.So(cli2, cv.ShouldNotBeNil)
+		cv.So(cli2, cv.ShouldNotEqual, cli1)
+		select {
+		case <-cli1.Halt.ReqStopChan():
+		case <-time.After(10 * time.Second):
+			panic("old client was not closed by Reconnect")
+		}
+
+		_, err = ch.Read(make([]byte, 1))
+		cv.So(err, cv.ShouldNotBeNil)
+		chans, err := tri.ListChannels()
+		panicOn(err)
+		cv.So(chans, cv.ShouldBeEmpty)
+
+		ch2, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		cv.So(ch2, cv.ShouldNotBeNil)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+		cv.So(tri.Reconnect(), cv.ShouldEqual, ErrShutdown)
+	})
+}
+
+func Test158ChannelOptsOpenData(t *testing.T) {
+	cv.Convey("ChannelOpts.OpenData should reach the sshd as the extra data of a custom channel's open request, and be refused for direct-tcpip.", t, func() {
+
+		s := MakeTestSshClientAndServer(false)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		got := make(chan []byte, 1)
+		s.SrvCfg.CustomChannelHandlers = map[string]CustomChannelHandlerCB{
+			"test-open-data": func(nc ssh.NewChannel, sshconn ssh.Conn, ca *ConnectionAlert) {
+				got <- nc.ExtraData()
+				ch, reqs, err := nc.Accept()
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(context.Background(), reqs, nil)
+				<-ch.Done()
+			},
+		}
+		s.SrvCfg.Esshd.Start(context.Background())
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test158",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test158")
+		panicOn(err)
+		tri.RegisterChannelType("test-open-data", tri.openPlainChannel)
+		ctx := context.Background()
+
+		payload := ssh.Marshal(&struct {
+			Service string
+			Version uint32
+		}{"widgets", 3})
+		ch, err := tri.SSHChannelOpts(ctx, "test-open-data", "", &ChannelOpts{OpenData: payload})
+		panicOn(err)
+		select {
+		case data := <-got:
+			cv.So(data, cv.ShouldResemble, payload)
+		case <-time.After(10 * time.Second):
+			panic("sshd never saw the channel open")
+		}
+		ch.Close()
+
+		_, err = tri.SSHChannelOpts(ctx, "direct-tcpip", "127.0.0.1:1", &ChannelOpts{OpenData: payload})
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(err.Error(), cv.ShouldContainSubstring, "OpenData")
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test162DefaultErrorClassifierOnChannelRejections(t *testing.T) {
+	cv.Convey("DefaultErrorClassifier should find Transient a channel the sshd could not connect, and Permanent one of a type the sshd does not know, as SSHChannel returns them.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test162",
+		}
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test162")
+		panicOn(err)
+		ctx := context.Background()
+
+		// nothing listens here.
+		lsn, err := net.Listen("tcp", "127.0.0.1:0")
+		panicOn(err)
+		closedAddr := lsn.Addr().String()
+		lsn.Close()
+
+		_, err = tri.SSHChannel(ctx, "direct-tcpip", closedAddr)
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(DefaultErrorClassifier(err), cv.ShouldEqual, Transient)
+
+		tri.RegisterChannelType("no-such-type", tri.openPlainChannel)
+		_, err = tri.SSHChannel(ctx, "no-such-type", "")
+		cv.So(err, cv.ShouldNotBeNil)
+		cv.So(DefaultErrorClassifier(err), cv.ShouldEqual, Permanent)
+
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,179 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// DefaultBorrowTimeout is how long a TricorderBorrowPool
+// lets a borrower keep a Tricorder, unless changed with
+// SetBorrowTimeout.
+const DefaultBorrowTimeout = time.Minute
+
+// TricorderBorrowPool keeps size connected Tricorders to
+// one sshd, and lends each to one goroutine at a time.
+// Unlike TricorderPool, which shares a single Tricorder
+// per (user, host, port), it gives each borrower a
+// connection of its own.
+//
+// A borrow not released within the borrow timeout is
+// revoked: that Tricorder is shut down, so its borrower
+// can no longer use it, and a fresh one takes its place
+// in the pool.
+type TricorderBorrowPool struct {
+	Halt *ssh.Halter
+
+	dc   DialConfig
+	size int
+	free chan *Tricorder
+
+	mut     sync.Mutex
+	timeout time.Duration
+	made    int
+	revoked int
+
+	closeAgentOnce sync.Once
+}
+
+type tricorderLoan struct {
+	tri   *Tricorder
+	timer *time.Timer
+	done  bool // released or revoked; protected by pool mut.
+}
+
+// NewTricorderBorrowPool connects size Tricorders using
+// dc. halt, if not nil, is the parent of the pool's Halter;
+// stopping the pool stops all its Tricorders.
+func NewTricorderBorrowPool(dc *DialConfig, size int, halt *ssh.Halter) (*TricorderBorrowPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("NewTricorderBorrowPool: size %d; must be at least 1", size)
+	}
+	p := &TricorderBorrowPool{
+		Halt:    ssh.NewHalter(),
+		dc:      *dc,
+		size:    size,
+		free:    make(chan *Tricorder, size),
+		timeout: DefaultBorrowTimeout,
+	}
+	for i := 0; i < size; i++ {
+		tri, err := p.newTricorder()
+		if err != nil {
+			p.Halt.RequestStop()
+			p.Halt.MarkDone()
+			return nil, err
+		}
+		p.free <- tri
+	}
+	if halt != nil {
+		halt.AddDownstream(p.Halt)
+	}
+	return p, nil
+}
+
+func (p *TricorderBorrowPool) newTricorder() (*Tricorder, error) {
+	p.mut.Lock()
+	p.made++
+	name := fmt.Sprintf("%v@%v:%v#%v", p.dc.Mylogin, p.dc.Sshdhost, p.dc.Sshdport, p.made)
+	p.mut.Unlock()
+	dc := p.dc
+	tri, err := NewTricorder(&dc, p.Halt, name)
+	if err != nil {
+		return nil, err
+	}
+	// the pool's ssh-agent connection outlives any one
+	// Tricorder; Close closes it.
+	tri.disownAgent()
+	return tri, nil
+}
+
+// SetBorrowTimeout sets how long a borrower may keep
+// a Tricorder before the borrow is revoked. It applies
+// to borrows made afterwards.
+func (p *TricorderBorrowPool) SetBorrowTimeout(d time.Duration) {
+	p.mut.Lock()
+	p.timeout = d
+	p.mut.Unlock()
+}
+
+// Revoked returns how many borrows have been revoked.
+func (p *TricorderBorrowPool) Revoked() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.revoked
+}
+
+// Borrow waits for a free Tricorder and returns it, along
+// with the function that gives it back. Call release
+// exactly once when done; calls after the first, or
+// after the borrow was revoked, do nothing.
+func (p *TricorderBorrowPool) Borrow(ctx context.Context) (tri *Tricorder, release func(), err error) {
+	select {
+	case tri = <-p.free:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-p.Halt.ReqStopChan():
+		return nil, nil, ErrShutdown
+	}
+	loan := &tricorderLoan{tri: tri}
+	p.mut.Lock()
+	loan.timer = time.AfterFunc(p.timeout, func() { p.revoke(loan) })
+	p.mut.Unlock()
+
+	release = func() {
+		p.mut.Lock()
+		if loan.done {
+			p.mut.Unlock()
+			return
+		}
+		loan.done = true
+		loan.timer.Stop()
+		p.mut.Unlock()
+		p.free <- loan.tri
+	}
+	return tri, release, nil
+}
+
+// revoke shuts down an overdue loan's Tricorder and
+// puts a new one in the pool in its place.
+func (p *TricorderBorrowPool) revoke(loan *tricorderLoan) {
+	p.mut.Lock()
+	if loan.done {
+		p.mut.Unlock()
+		return
+	}
+	loan.done = true
+	p.revoked++
+	p.mut.Unlock()
+
+	loan.tri.warn("borrow timed out; revoking")
+	loan.tri.Halt.RequestStop()
+
+	for {
+		tri, err := p.newTricorder()
+		if err == nil {
+			p.free <- tri
+			return
+		}
+		loan.tri.warn("could not replace revoked Tricorder", "err", err)
+		select {
+		case <-time.After(time.Second):
+		case <-p.Halt.ReqStopChan():
+			return
+		}
+	}
+}
+
+// Close shuts down the pool and all its Tricorders,
+// lent or not, and closes the ssh-agent connection
+// they share, if dc had one.
+func (p *TricorderBorrowPool) Close() {
+	p.Halt.RequestStop()
+	p.Halt.MarkDone()
+	p.closeAgentOnce.Do(func() {
+		p.dc.CloseAgent()
+	})
+}
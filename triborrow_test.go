@@ -0,0 +1,186 @@
+package sshego
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh/agent"
+)
+
+func Test151TricorderBorrowPool(t *testing.T) {
+	cv.Convey("A TricorderBorrowPool should lend each Tricorder to only one goroutine at a time, make Borrow wait while all are out, and revoke and replace a borrow held past the timeout.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test151",
+		}
+		pool, err := NewTricorderBorrowPool(dc, 2, s.CliCfg.Halt)
+		panicOn(err)
+		defer pool.Close()
+
+		// many goroutines borrowing and releasing at once.
+		var mut sync.Mutex
+		inUse := make(map[*Tricorder]bool)
+		doubleLent := 0
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					tri, release, err := pool.Borrow(context.Background())
+					panicOn(err)
+					mut.Lock()
+					if inUse[tri] {
+						doubleLent++
+					}
+					inUse[tri] = true
+					mut.Unlock()
+
+					_, err = tri.Cli()
+					panicOn(err)
+					time.Sleep(time.Millisecond)
+
+					mut.Lock()
+					delete(inUse, tri)
+					mut.Unlock()
+					release()
+					release() // harmless
+				}
+			}()
+		}
+		wg.Wait()
+		cv.So(doubleLent, cv.ShouldEqual, 0)
+		cv.So(pool.Revoked(), cv.ShouldEqual, 0)
+
+		// with both out, Borrow waits.
+		tri1, release1, err := pool.Borrow(context.Background())
+		panicOn(err)
+		_, release2, err := pool.Borrow(context.Background())
+		panicOn(err)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, _, err = pool.Borrow(ctx)
+		cancel()
+		cv.So(err == context.DeadlineExceeded, cv.ShouldBeTrue)
+		release1()
+		release2()
+
+		// a borrow kept too long is revoked.
+		pool.SetBorrowTimeout(200 * time.Millisecond)
+		tri1, release1, err = pool.Borrow(context.Background())
+		panicOn(err)
+		select {
+		case <-tri1.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("overdue borrow never revoked")
+		}
+		cv.So(pool.Revoked(), cv.ShouldEqual, 1)
+		release1() // too late; does nothing
+
+		// the pool is back to two, none of them tri1.
+		pool.SetBorrowTimeout(time.Minute)
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		a, releaseA, err := pool.Borrow(ctx)
+		panicOn(err)
+		b, releaseB, err := pool.Borrow(ctx)
+		panicOn(err)
+		cv.So(a, cv.ShouldNotEqual, tri1)
+		cv.So(b, cv.ShouldNotEqual, tri1)
+		_, err = a.Cli()
+		cv.So(err, cv.ShouldBeNil)
+		_, err = b.Cli()
+		cv.So(err, cv.ShouldBeNil)
+		releaseA()
+		releaseB()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test164TricorderBorrowPoolSharesAgent(t *testing.T) {
+	cv.Convey("The Tricorders of a TricorderBorrowPool made from a WithSSHAgentSocket DialConfig should share its agent connection, so revoking one borrow leaves the rest, and its replacement, able to log in again.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		pemBytes, err := ioutil.ReadFile(s.RsaPath)
+		panicOn(err)
+		key, err := ssh.ParseRawPrivateKey(pemBytes)
+		panicOn(err)
+		keyring := agent.NewKeyring()
+		panicOn(keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+		agentHalt := ssh.NewHalter()
+		defer agentHalt.RequestStop()
+		sock := startTestAgent(agentHalt, s.SrvCfg.Tempdir, keyring)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			SkipKeepAlive:        true,
+			LocalNickname:        "test164",
+		}
+		adc, err := dc.WithSSHAgentSocket(sock)
+		panicOn(err)
+
+		pool, err := NewTricorderBorrowPool(adc, 2, s.CliCfg.Halt)
+		panicOn(err)
+
+		pool.SetBorrowTimeout(200 * time.Millisecond)
+		tri1, _, err := pool.Borrow(context.Background())
+		panicOn(err)
+		select {
+		case <-tri1.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("overdue borrow never revoked")
+		}
+		cv.So(pool.Revoked(), cv.ShouldEqual, 1)
+
+		// both the survivor and the replacement still
+		// sign with the agent on a fresh login.
+		pool.SetBorrowTimeout(time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		for i := 0; i < 2; i++ {
+			tri, release, err := pool.Borrow(ctx)
+			panicOn(err)
+			defer release()
+			cv.So(tri, cv.ShouldNotEqual, tri1)
+			cv.So(tri.Reconnect(), cv.ShouldBeNil)
+			_, err = tri.Cli()
+			cv.So(err, cv.ShouldBeNil)
+		}
+
+		// the agent can still sign, until Close closes it.
+		_, err = adc.AgentSigners[0].Sign(rand.Reader, []byte("test164"))
+		cv.So(err, cv.ShouldBeNil)
+		pool.Close()
+		_, err = adc.AgentSigners[0].Sign(rand.Reader, []byte("test164"))
+		cv.So(err, cv.ShouldNotBeNil)
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
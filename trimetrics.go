@@ -0,0 +1,120 @@
+package sshego
+
+import (
+	"sync/atomic"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tricorderMetrics holds the prometheus collectors for
+// one Tricorder. They are always allocated, and only
+// become visible once RegisterPrometheusMetrics is called,
+// so the reconnect loop can update them without locking.
+type tricorderMetrics struct {
+	reconnects    prometheus.Counter
+	channelsOpen  prometheus.Counter
+	channelErrors prometheus.Counter
+	channelCount  prometheus.Gauge
+
+	// plain counters, read by MetricsSnapshot.
+	// Use atomic.
+	nReconnects int64
+	nChannels   int64
+	bytesIn     int64
+	bytesOut    int64
+}
+
+func newTricorderMetrics(name string) *tricorderMetrics {
+	labels := prometheus.Labels{"name": name}
+	return &tricorderMetrics{
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tricorder_reconnect_total",
+			Help:        "Number of times the Tricorder has reconnected to its sshd.",
+			ConstLabels: labels,
+		}),
+		channelsOpen: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tricorder_channels_open",
+			Help:        "Number of ssh channels the Tricorder has opened.",
+			ConstLabels: labels,
+		}),
+		channelErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tricorder_channel_errors_total",
+			Help:        "Number of failed attempts to open an ssh channel.",
+			ConstLabels: labels,
+		}),
+		channelCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "tricorder_channel_count",
+			Help:        "Number of ssh channels currently held by the Tricorder.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (m *tricorderMetrics) reconnected() {
+	m.reconnects.Inc()
+	atomic.AddInt64(&m.nReconnects, 1)
+}
+
+func (m *tricorderMetrics) setChannelCount(n int) {
+	m.channelCount.Set(float64(n))
+	atomic.StoreInt64(&m.nChannels, int64(n))
+}
+
+func (m *tricorderMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.reconnects,
+		m.channelsOpen,
+		m.channelErrors,
+		m.channelCount,
+	}
+}
+
+// countedChannel adds the bytes moved over an
+// ssh.Channel to bytesIn and bytesOut.
+type countedChannel struct {
+	ssh.Channel
+	m *tricorderMetrics
+}
+
+func (m *tricorderMetrics) count(ch ssh.Channel) ssh.Channel {
+	return &countedChannel{Channel: ch, m: m}
+}
+
+func (c *countedChannel) Read(data []byte) (n int, err error) {
+	n, err = c.Channel.Read(data)
+	if n > 0 {
+		atomic.AddInt64(&c.m.bytesIn, int64(n))
+	}
+	return
+}
+
+func (c *countedChannel) Write(data []byte) (n int, err error) {
+	n, err = c.Channel.Write(data)
+	if n > 0 {
+		atomic.AddInt64(&c.m.bytesOut, int64(n))
+	}
+	return
+}
+
+// RegisterPrometheusMetrics registers the Tricorder's
+// reconnect and channel counters with registerer. Each
+// metric carries a "name" label holding the name given
+// to NewTricorder, fixed then; a later SetName doesn't
+// change it. Many Tricorders may share one registerer
+// as long as those names differ.
+func (t *Tricorder) RegisterPrometheusMetrics(registerer prometheus.Registerer) error {
+	var registered []prometheus.Collector
+	for _, c := range t.metrics.collectors() {
+		err := registerer.Register(c)
+		if err != nil {
+			// leave nothing half-registered behind.
+			for _, r := range registered {
+				registerer.Unregister(r)
+			}
+			return err
+		}
+		registered = append(registered, c)
+	}
+	return nil
+}
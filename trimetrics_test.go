@@ -0,0 +1,86 @@
+package sshego
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test061TricorderPrometheusMetrics(t *testing.T) {
+	cv.Convey("Tricorder.RegisterPrometheusMetrics should export reconnect and channel counts that track the reconnect loop.", t, func() {
+
+		payloadByteCount := 50
+		confirmationPayload := RandomString(payloadByteCount)
+		confirmationReply := RandomString(payloadByteCount)
+
+		tcpSrvLsn, tcpSrvPort := GetAvailPort()
+		tcpServerMgr := ssh.NewHalter()
+		StartBackgroundTestTcpServer(
+			tcpServerMgr,
+			payloadByteCount,
+			confirmationPayload,
+			confirmationReply,
+			tcpSrvLsn,
+			nil)
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dest := fmt.Sprintf("127.0.0.1:%v", tcpSrvPort)
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			DownstreamHostPort:   dest,
+			TofuAddIfNotKnown:    true,
+			ReconnectDebounce:    time.Millisecond, // we reconnect right after connecting.
+			LocalNickname:        "test061",
+		}
+
+		tri, err := NewTricorder(dc, s.CliCfg.Halt, "test061")
+		panicOn(err)
+
+		reg := prometheus.NewRegistry()
+		cv.So(tri.RegisterPrometheusMetrics(reg), cv.ShouldBeNil)
+
+		// a second registration under the same name must fail
+		cv.So(tri.RegisterPrometheusMetrics(reg), cv.ShouldNotBeNil)
+
+		ch, err := tri.SSHChannel(context.Background(), "direct-tcpip", dest)
+		panicOn(err)
+		VerifyClientServerExchangeAcrossSshd(ch, confirmationPayload, confirmationReply, payloadByteCount)
+
+		cv.So(testutil.ToFloat64(tri.metrics.channelsOpen), cv.ShouldEqual, 1)
+		cv.So(testutil.ToFloat64(tri.metrics.channelCount), cv.ShouldEqual, 1)
+		cv.So(testutil.ToFloat64(tri.metrics.channelErrors), cv.ShouldEqual, 0)
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 0)
+
+		// force a reconnect, once past the debounce.
+		time.Sleep(10 * dc.ReconnectDebounce)
+		tri.ClientReconnectNeededTower.Broadcast(&UHP{
+			User:     s.Mylogin,
+			HostPort: tri.sshdHostPort,
+		})
+
+		deadline := time.Now().Add(10 * time.Second)
+		for testutil.ToFloat64(tri.metrics.reconnects) < 1 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		cv.So(testutil.ToFloat64(tri.metrics.reconnects), cv.ShouldEqual, 1)
+		cv.So(testutil.ToFloat64(tri.metrics.channelCount), cv.ShouldEqual, 0)
+
+		tcpServerMgr.RequestStop()
+		tri.Halt.RequestStop()
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -0,0 +1,138 @@
+package sshego
+
+import (
+	"fmt"
+	"sync"
+
+	ssh "github.com/glycerine/sshego/xendor/github.com/glycerine/xcryptossh"
+)
+
+// TricorderPool enforces the one Tricorder per
+// (username, sshdHost, sshdPort) triple rule.
+// Get hands out a shared Tricorder for the triple,
+// and the last matching Release shuts it down.
+type TricorderPool struct {
+	mut  sync.Mutex
+	halt *ssh.Halter
+	tri  map[tricorderKey]*pooledTricorder
+
+	// halted holds the Tricorders Get found halted and
+	// replaced, until their holders Release them.
+	halted map[*Tricorder]*pooledTricorder
+}
+
+type tricorderKey struct {
+	user string
+	host string
+	port int64
+}
+
+type pooledTricorder struct {
+	key  tricorderKey
+	tri  *Tricorder
+	refs int
+}
+
+// NewTricorderPool makes a new TricorderPool. Each
+// Tricorder it makes will have halt as its parent;
+// halt can be nil.
+func NewTricorderPool(halt *ssh.Halter) *TricorderPool {
+	return &TricorderPool{
+		halt:   halt,
+		tri:    make(map[tricorderKey]*pooledTricorder),
+		halted: make(map[*Tricorder]*pooledTricorder),
+	}
+}
+
+// Get returns the Tricorder for dc's (Mylogin, Sshdhost,
+// Sshdport) triple, making it on first use, or anew if
+// the pooled one has halted. Each successful Get must
+// be paired with a Release.
+func (p *TricorderPool) Get(dc *DialConfig) (*Tricorder, error) {
+	key := tricorderKey{
+		user: dc.Mylogin,
+		host: dc.Sshdhost,
+		port: dc.Sshdport,
+	}
+
+	p.mut.Lock()
+	pt, ok := p.tri[key]
+	if ok && pt.tri.Halt.IsStopRequested() {
+		// shut down, say by a permanent error.
+		p.dropHalted(pt)
+		ok = false
+	}
+	if ok {
+		pt.refs++
+		p.mut.Unlock()
+		return pt.tri, nil
+	}
+	p.mut.Unlock()
+
+	// dial without the lock, so that a slow or
+	// unreachable host doesn't stall Gets and
+	// Releases for every other triple.
+	name := fmt.Sprintf("%v@%v:%v", key.user, key.host, key.port)
+	tri, err := NewTricorder(dc, p.halt, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pt, ok = p.tri[key]
+	if ok && pt.tri.Halt.IsStopRequested() {
+		p.dropHalted(pt)
+		ok = false
+	}
+	if ok {
+		// a concurrent Get won the race; share
+		// its Tricorder and discard ours.
+		pt.refs++
+		tri.disownAgent()
+		tri.Halt.RequestStop()
+		return pt.tri, nil
+	}
+	p.tri[key] = &pooledTricorder{
+		key:  key,
+		tri:  tri,
+		refs: 1,
+	}
+	return tri, nil
+}
+
+// Release gives back a Tricorder obtained from Get.
+// When the last reference is released, the Tricorder
+// is shut down and forgotten, so a later Get will
+// make a fresh one.
+func (p *TricorderPool) Release(tri *Tricorder) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	for key, pt := range p.tri {
+		if pt.tri != tri {
+			continue
+		}
+		pt.refs--
+		if pt.refs <= 0 {
+			delete(p.tri, key)
+			tri.Halt.RequestStop()
+		}
+		return nil
+	}
+	if pt, ok := p.halted[tri]; ok {
+		pt.refs--
+		if pt.refs <= 0 {
+			delete(p.halted, tri)
+		}
+		return nil
+	}
+	return fmt.Errorf("TricorderPool.Release: Tricorder '%s' not from this pool", tri.GetName())
+}
+
+// dropHalted moves pt, whose Tricorder has halted, out
+// of the way of Get. The caller must hold p.mut.
+func (p *TricorderPool) dropHalted(pt *pooledTricorder) {
+	delete(p.tri, pt.key)
+	p.halted[pt.tri] = pt
+}
@@ -0,0 +1,121 @@
+package sshego
+
+import (
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test067TricorderPoolSharesByTriple(t *testing.T) {
+	cv.Convey("TricorderPool.Get should hand back the same Tricorder for the same (user, host, port), and only shut it down after the last Release.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test067",
+		}
+
+		pool := NewTricorderPool(s.CliCfg.Halt)
+
+		tri1, err := pool.Get(dc)
+		panicOn(err)
+		dc2 := *dc
+		tri2, err := pool.Get(&dc2)
+		panicOn(err)
+		cv.So(tri2, cv.ShouldEqual, tri1)
+
+		cv.So(pool.Release(tri1), cv.ShouldBeNil)
+		cv.So(tri1.Halt.IsStopRequested(), cv.ShouldBeFalse)
+		_, err = tri1.Cli()
+		cv.So(err, cv.ShouldBeNil)
+
+		cv.So(pool.Release(tri2), cv.ShouldBeNil)
+		select {
+		case <-tri1.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("Tricorder not shut down after last Release")
+		}
+
+		// no longer in the pool.
+		cv.So(pool.Release(tri1), cv.ShouldNotBeNil)
+
+		// concurrent first Gets dial outside the lock,
+		// but must still all end up sharing one Tricorder.
+		n := 4
+		got := make(chan *Tricorder, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				dci := *dc
+				tri, err := pool.Get(&dci)
+				panicOn(err)
+				got <- tri
+			}()
+		}
+		shared := <-got
+		for i := 1; i < n; i++ {
+			cv.So(<-got, cv.ShouldEqual, shared)
+		}
+		for i := 0; i < n; i++ {
+			cv.So(pool.Release(shared), cv.ShouldBeNil)
+		}
+		<-shared.Halt.DoneChan()
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
+
+func Test166TricorderPoolReplacesHaltedTricorder(t *testing.T) {
+	cv.Convey("TricorderPool.Get should not hand out a Tricorder that has halted, but dial a fresh one; the halted one's holders can still Release it.", t, func() {
+
+		s := MakeTestSshClientAndServer(true)
+		defer TempDirCleanup(s.SrvCfg.Origdir, s.SrvCfg.Tempdir)
+
+		dc := &DialConfig{
+			ClientKnownHostsPath: s.CliCfg.ClientKnownHostsPath,
+			Mylogin:              s.Mylogin,
+			RsaPath:              s.RsaPath,
+			TotpUrl:              s.Totp,
+			Pw:                   s.Pw,
+			Sshdhost:             s.SrvCfg.EmbeddedSSHd.Host,
+			Sshdport:             s.SrvCfg.EmbeddedSSHd.Port,
+			TofuAddIfNotKnown:    true,
+			LocalNickname:        "test166",
+		}
+
+		pool := NewTricorderPool(s.CliCfg.Halt)
+
+		tri1, err := pool.Get(dc)
+		panicOn(err)
+		tri1.Halt.RequestStop()
+		<-tri1.Halt.DoneChan()
+
+		dc2 := *dc
+		tri2, err := pool.Get(&dc2)
+		panicOn(err)
+		cv.So(tri2, cv.ShouldNotEqual, tri1)
+		cv.So(tri2.Halt.IsStopRequested(), cv.ShouldBeFalse)
+		_, err = tri2.Cli()
+		cv.So(err, cv.ShouldBeNil)
+
+		cv.So(pool.Release(tri1), cv.ShouldBeNil)
+		cv.So(pool.Release(tri1), cv.ShouldNotBeNil)
+		cv.So(pool.Release(tri2), cv.ShouldBeNil)
+		select {
+		case <-tri2.Halt.DoneChan():
+		case <-time.After(10 * time.Second):
+			panic("Tricorder not shut down after last Release")
+		}
+
+		s.SrvCfg.Esshd.Stop()
+	})
+}
@@ -10,8 +10,21 @@ type UHP struct {
 	Nickname string
 }
 
+// String returns "user@host:port(nickname)".
 func (a UHP) String() string {
-	return fmt.Sprintf("%s@%s/%s", a.User, a.HostPort, a.Nickname)
+	return fmt.Sprintf("%s@%s(%s)", a.User, a.HostPort, a.Nickname)
+}
+
+// Equals returns true iff u and other have equal
+// User, HostPort, and Nickname fields. Two nils
+// are equal; a nil and a non-nil are not.
+func (u *UHP) Equals(other *UHP) bool {
+	if u == nil || other == nil {
+		return u == other
+	}
+	return u.User == other.User &&
+		u.HostPort == other.HostPort &&
+		u.Nickname == other.Nickname
 }
 
 // UHPEqual returns true iff a and b are both
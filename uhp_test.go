@@ -0,0 +1,37 @@
+package sshego
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test090UHPEqualsAndString(t *testing.T) {
+	cv.Convey("UHP.Equals should compare User, HostPort and Nickname, and UHP.String should give user@host:port(nickname).", t, func() {
+
+		a := &UHP{User: "bob", HostPort: "10.0.0.1:22", Nickname: "gw"}
+		b := &UHP{User: "bob", HostPort: "10.0.0.1:22", Nickname: "gw"}
+		cv.So(a.Equals(b), cv.ShouldBeTrue)
+		cv.So(b.Equals(a), cv.ShouldBeTrue)
+
+		otherUser := *a
+		otherUser.User = "alice"
+		cv.So(a.Equals(&otherUser), cv.ShouldBeFalse)
+
+		otherHost := *a
+		otherHost.HostPort = "10.0.0.2:22"
+		cv.So(a.Equals(&otherHost), cv.ShouldBeFalse)
+
+		otherNick := *a
+		otherNick.Nickname = "gw2"
+		cv.So(a.Equals(&otherNick), cv.ShouldBeFalse)
+
+		var none *UHP
+		cv.So(a.Equals(none), cv.ShouldBeFalse)
+		cv.So(none.Equals(a), cv.ShouldBeFalse)
+		cv.So(none.Equals(nil), cv.ShouldBeTrue)
+
+		cv.So(a.String(), cv.ShouldEqual, "bob@10.0.0.1:22(gw)")
+		cv.So(UHP{User: "bob", HostPort: "h:2222"}.String(), cv.ShouldEqual, "bob@h:2222()")
+	})
+}
@@ -50,6 +50,7 @@ type User struct {
 	ScryptedPassword []byte
 	ClearPw          string // only on network, never on disk.
 	TOTPorig         string
+	TotpSecret       string
 	oneTime          *TOTP
 
 	FirstLoginTime time.Time
@@ -58,6 +59,14 @@ type User struct {
 	IPwhitelist    []string
 	DisabledAcct   bool
 
+	// LoginCount is how many times the user has
+	// logged in to the esshd.
+	LoginCount int64
+
+	// open connections to the esshd, for
+	// MaxConnectionsPerUser. Guarded by mut.
+	activeConns int
+
 	mut sync.Mutex
 }
 
@@ -375,6 +384,7 @@ func (h *HostDb) finishUserBuildout(user *User) (toptPath, qrPath, rsaPath strin
 		makeway(toptPath)
 
 		user.TOTPorig = w.Key.String()
+		user.TotpSecret = w.Key.Secret()
 		_, qrPath, err = w.SaveToFile(toptPath)
 		panicOn(err)
 		user.oneTime = w
@@ -455,6 +465,30 @@ func (user *User) RestoreTotp() {
 		panicOn(err)
 		user.oneTime.Key = w
 	}
+	if user.TotpSecret == "" && user.oneTime != nil && user.oneTime.Key != nil {
+		user.TotpSecret = user.oneTime.Key.Secret()
+	}
+}
+
+// ValidTotp checks the 6-digit passcode against the
+// user's stored TotpSecret, allowing TotpSkewSteps of
+// clock skew either side of now.
+func (user *User) ValidTotp(passcode string, now time.Time) bool {
+	user.RestoreTotp()
+	if user.TotpSecret == "" {
+		return false
+	}
+	valid := ValidateTotpCode(passcode, user.TotpSecret, now)
+	if valid {
+		p("Login '%s' successfully used their "+
+			"Time-based-One-Time-Password!",
+			user.MyLogin)
+	} else {
+		p("Login '%s' failed at Time-based-One-"+
+			"Time-Password attempt",
+			user.MyLogin)
+	}
+	return valid
 }
 
 // UserExists is used by sshego/cmd/gosshtun/main.go
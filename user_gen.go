@@ -1360,7 +1360,7 @@ func (z *User) DecodeMsg(dc *msgp.Reader) (err error) {
 
 	var field []byte
 	_ = field
-	const maxFields27zgensym_189e87a53e58dbf2_28 = 18
+	const maxFields27zgensym_189e87a53e58dbf2_28 = 20
 
 	// -- templateDecodeMsg starts here--
 	var totalEncodedFields27zgensym_189e87a53e58dbf2_28 uint32
@@ -1503,26 +1503,32 @@ doneWithStruct27zgensym_189e87a53e58dbf2_28:
 			if err != nil {
 				return
 			}
-		case "FirstLoginTime__tim":
+		case "TotpSecret__str":
 			found27zgensym_189e87a53e58dbf2_28[13] = true
+			z.TotpSecret, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "FirstLoginTime__tim":
+			found27zgensym_189e87a53e58dbf2_28[14] = true
 			z.FirstLoginTime, err = dc.ReadTime()
 			if err != nil {
 				return
 			}
 		case "LastLoginTime__tim":
-			found27zgensym_189e87a53e58dbf2_28[14] = true
+			found27zgensym_189e87a53e58dbf2_28[15] = true
 			z.LastLoginTime, err = dc.ReadTime()
 			if err != nil {
 				return
 			}
 		case "LastLoginAddr__str":
-			found27zgensym_189e87a53e58dbf2_28[15] = true
+			found27zgensym_189e87a53e58dbf2_28[16] = true
 			z.LastLoginAddr, err = dc.ReadString()
 			if err != nil {
 				return
 			}
 		case "IPwhitelist__slc":
-			found27zgensym_189e87a53e58dbf2_28[16] = true
+			found27zgensym_189e87a53e58dbf2_28[17] = true
 			var zgensym_189e87a53e58dbf2_30 uint32
 			zgensym_189e87a53e58dbf2_30, err = dc.ReadArrayHeader()
 			if err != nil {
@@ -1540,11 +1546,17 @@ doneWithStruct27zgensym_189e87a53e58dbf2_28:
 				}
 			}
 		case "DisabledAcct__boo":
-			found27zgensym_189e87a53e58dbf2_28[17] = true
+			found27zgensym_189e87a53e58dbf2_28[18] = true
 			z.DisabledAcct, err = dc.ReadBool()
 			if err != nil {
 				return
 			}
+		case "LoginCount__i64":
+			found27zgensym_189e87a53e58dbf2_28[19] = true
+			z.LoginCount, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
 		default:
 			err = dc.Skip()
 			if err != nil {
@@ -1568,16 +1580,16 @@ doneWithStruct27zgensym_189e87a53e58dbf2_28:
 }
 
 // fields of User
-var decodeMsgFieldOrder27zgensym_189e87a53e58dbf2_28 = []string{"MyEmail__str", "MyFullname__str", "MyLogin__str", "PublicKeyPath__str", "PrivateKeyPath__str", "TOTPpath__str", "QrPath__str", "Issuer__str", "", "SeenPubKey__map", "ScryptedPassword__bin", "ClearPw__str", "TOTPorig__str", "FirstLoginTime__tim", "LastLoginTime__tim", "LastLoginAddr__str", "IPwhitelist__slc", "DisabledAcct__boo"}
+var decodeMsgFieldOrder27zgensym_189e87a53e58dbf2_28 = []string{"MyEmail__str", "MyFullname__str", "MyLogin__str", "PublicKeyPath__str", "PrivateKeyPath__str", "TOTPpath__str", "QrPath__str", "Issuer__str", "", "SeenPubKey__map", "ScryptedPassword__bin", "ClearPw__str", "TOTPorig__str", "TotpSecret__str", "FirstLoginTime__tim", "LastLoginTime__tim", "LastLoginAddr__str", "IPwhitelist__slc", "DisabledAcct__boo", "LoginCount__i64"}
 
-var decodeMsgFieldSkip27zgensym_189e87a53e58dbf2_28 = []bool{false, false, false, false, false, false, false, false, true, false, false, false, false, false, false, false, false, false}
+var decodeMsgFieldSkip27zgensym_189e87a53e58dbf2_28 = []bool{false, false, false, false, false, false, false, false, true, false, false, false, false, false, false, false, false, false, false, false}
 
 // fieldsNotEmpty supports omitempty tags
 func (z *User) fieldsNotEmpty(isempty []bool) uint32 {
 	if len(isempty) == 0 {
-		return 17
+		return 19
 	}
-	var fieldsInUse uint32 = 17
+	var fieldsInUse uint32 = 19
 	isempty[0] = (len(z.MyEmail) == 0) // string, omitempty
 	if isempty[0] {
 		fieldsInUse--
@@ -1626,26 +1638,34 @@ func (z *User) fieldsNotEmpty(isempty []bool) uint32 {
 	if isempty[12] {
 		fieldsInUse--
 	}
-	isempty[13] = (z.FirstLoginTime.IsZero()) // time.Time, omitempty
+	isempty[13] = (len(z.TotpSecret) == 0) // string, omitempty
 	if isempty[13] {
 		fieldsInUse--
 	}
-	isempty[14] = (z.LastLoginTime.IsZero()) // time.Time, omitempty
+	isempty[14] = (z.FirstLoginTime.IsZero()) // time.Time, omitempty
 	if isempty[14] {
 		fieldsInUse--
 	}
-	isempty[15] = (len(z.LastLoginAddr) == 0) // string, omitempty
+	isempty[15] = (z.LastLoginTime.IsZero()) // time.Time, omitempty
 	if isempty[15] {
 		fieldsInUse--
 	}
-	isempty[16] = (len(z.IPwhitelist) == 0) // string, omitempty
+	isempty[16] = (len(z.LastLoginAddr) == 0) // string, omitempty
 	if isempty[16] {
 		fieldsInUse--
 	}
-	isempty[17] = (!z.DisabledAcct) // bool, omitempty
+	isempty[17] = (len(z.IPwhitelist) == 0) // string, omitempty
 	if isempty[17] {
 		fieldsInUse--
 	}
+	isempty[18] = (!z.DisabledAcct) // bool, omitempty
+	if isempty[18] {
+		fieldsInUse--
+	}
+	isempty[19] = (z.LoginCount == 0) // number, omitempty
+	if isempty[19] {
+		fieldsInUse--
+	}
 
 	return fieldsInUse
 }
@@ -1657,7 +1677,7 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 	}
 
 	// honor the omitempty tags
-	var empty_zgensym_189e87a53e58dbf2_31 [18]bool
+	var empty_zgensym_189e87a53e58dbf2_31 [20]bool
 	fieldsInUse_zgensym_189e87a53e58dbf2_32 := z.fieldsNotEmpty(empty_zgensym_189e87a53e58dbf2_31[:])
 
 	// map header
@@ -1821,6 +1841,18 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 	}
 
 	if !empty_zgensym_189e87a53e58dbf2_31[13] {
+		// write "TotpSecret__str"
+		err = en.Append(0xaf, 0x54, 0x6f, 0x74, 0x70, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x5f, 0x73, 0x74, 0x72)
+		if err != nil {
+			return err
+		}
+		err = en.WriteString(z.TotpSecret)
+		if err != nil {
+			return
+		}
+	}
+
+	if !empty_zgensym_189e87a53e58dbf2_31[14] {
 		// write "FirstLoginTime__tim"
 		err = en.Append(0xb3, 0x46, 0x69, 0x72, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x5f, 0x5f, 0x74, 0x69, 0x6d)
 		if err != nil {
@@ -1832,7 +1864,7 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
-	if !empty_zgensym_189e87a53e58dbf2_31[14] {
+	if !empty_zgensym_189e87a53e58dbf2_31[15] {
 		// write "LastLoginTime__tim"
 		err = en.Append(0xb2, 0x4c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x5f, 0x5f, 0x74, 0x69, 0x6d)
 		if err != nil {
@@ -1844,7 +1876,7 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
-	if !empty_zgensym_189e87a53e58dbf2_31[15] {
+	if !empty_zgensym_189e87a53e58dbf2_31[16] {
 		// write "LastLoginAddr__str"
 		err = en.Append(0xb2, 0x4c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x41, 0x64, 0x64, 0x72, 0x5f, 0x5f, 0x73, 0x74, 0x72)
 		if err != nil {
@@ -1856,7 +1888,7 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
-	if !empty_zgensym_189e87a53e58dbf2_31[16] {
+	if !empty_zgensym_189e87a53e58dbf2_31[17] {
 		// write "IPwhitelist__slc"
 		err = en.Append(0xb0, 0x49, 0x50, 0x77, 0x68, 0x69, 0x74, 0x65, 0x6c, 0x69, 0x73, 0x74, 0x5f, 0x5f, 0x73, 0x6c, 0x63)
 		if err != nil {
@@ -1874,7 +1906,7 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
-	if !empty_zgensym_189e87a53e58dbf2_31[17] {
+	if !empty_zgensym_189e87a53e58dbf2_31[18] {
 		// write "DisabledAcct__boo"
 		err = en.Append(0xb1, 0x44, 0x69, 0x73, 0x61, 0x62, 0x6c, 0x65, 0x64, 0x41, 0x63, 0x63, 0x74, 0x5f, 0x5f, 0x62, 0x6f, 0x6f)
 		if err != nil {
@@ -1886,6 +1918,18 @@ func (z *User) EncodeMsg(en *msgp.Writer) (err error) {
 		}
 	}
 
+	if !empty_zgensym_189e87a53e58dbf2_31[19] {
+		// write "LoginCount__i64"
+		err = en.Append(0xaf, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x5f, 0x69, 0x36, 0x34)
+		if err != nil {
+			return err
+		}
+		err = en.WriteInt64(z.LoginCount)
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -1898,7 +1942,7 @@ func (z *User) MarshalMsg(b []byte) (o []byte, err error) {
 	o = msgp.Require(b, z.Msgsize())
 
 	// honor the omitempty tags
-	var empty [18]bool
+	var empty [20]bool
 	fieldsInUse := z.fieldsNotEmpty(empty[:])
 	o = msgp.AppendMapHeader(o, fieldsInUse)
 
@@ -1982,24 +2026,30 @@ func (z *User) MarshalMsg(b []byte) (o []byte, err error) {
 	}
 
 	if !empty[13] {
+		// string "TotpSecret__str"
+		o = append(o, 0xaf, 0x54, 0x6f, 0x74, 0x70, 0x53, 0x65, 0x63, 0x72, 0x65, 0x74, 0x5f, 0x5f, 0x73, 0x74, 0x72)
+		o = msgp.AppendString(o, z.TotpSecret)
+	}
+
+	if !empty[14] {
 		// string "FirstLoginTime__tim"
 		o = append(o, 0xb3, 0x46, 0x69, 0x72, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x5f, 0x5f, 0x74, 0x69, 0x6d)
 		o = msgp.AppendTime(o, z.FirstLoginTime)
 	}
 
-	if !empty[14] {
+	if !empty[15] {
 		// string "LastLoginTime__tim"
 		o = append(o, 0xb2, 0x4c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x5f, 0x5f, 0x74, 0x69, 0x6d)
 		o = msgp.AppendTime(o, z.LastLoginTime)
 	}
 
-	if !empty[15] {
+	if !empty[16] {
 		// string "LastLoginAddr__str"
 		o = append(o, 0xb2, 0x4c, 0x61, 0x73, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x41, 0x64, 0x64, 0x72, 0x5f, 0x5f, 0x73, 0x74, 0x72)
 		o = msgp.AppendString(o, z.LastLoginAddr)
 	}
 
-	if !empty[16] {
+	if !empty[17] {
 		// string "IPwhitelist__slc"
 		o = append(o, 0xb0, 0x49, 0x50, 0x77, 0x68, 0x69, 0x74, 0x65, 0x6c, 0x69, 0x73, 0x74, 0x5f, 0x5f, 0x73, 0x6c, 0x63)
 		o = msgp.AppendArrayHeader(o, uint32(len(z.IPwhitelist)))
@@ -2008,12 +2058,18 @@ func (z *User) MarshalMsg(b []byte) (o []byte, err error) {
 		}
 	}
 
-	if !empty[17] {
+	if !empty[18] {
 		// string "DisabledAcct__boo"
 		o = append(o, 0xb1, 0x44, 0x69, 0x73, 0x61, 0x62, 0x6c, 0x65, 0x64, 0x41, 0x63, 0x63, 0x74, 0x5f, 0x5f, 0x62, 0x6f, 0x6f)
 		o = msgp.AppendBool(o, z.DisabledAcct)
 	}
 
+	if !empty[19] {
+		// string "LoginCount__i64"
+		o = append(o, 0xaf, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x5f, 0x69, 0x36, 0x34)
+		o = msgp.AppendInt64(o, z.LoginCount)
+	}
+
 	return
 }
 
@@ -2032,7 +2088,7 @@ func (z *User) UnmarshalMsgWithCfg(bts []byte, cfg *msgp.RuntimeConfig) (o []byt
 
 	var field []byte
 	_ = field
-	const maxFields33zgensym_189e87a53e58dbf2_34 = 18
+	const maxFields33zgensym_189e87a53e58dbf2_34 = 20
 
 	// -- templateUnmarshalMsg starts here--
 	var totalEncodedFields33zgensym_189e87a53e58dbf2_34 uint32
@@ -2210,29 +2266,36 @@ doneWithStruct33zgensym_189e87a53e58dbf2_34:
 			if err != nil {
 				return
 			}
-		case "FirstLoginTime__tim":
+		case "TotpSecret__str":
 			found33zgensym_189e87a53e58dbf2_34[13] = true
+			z.TotpSecret, bts, err = nbs.ReadStringBytes(bts)
+
+			if err != nil {
+				return
+			}
+		case "FirstLoginTime__tim":
+			found33zgensym_189e87a53e58dbf2_34[14] = true
 			z.FirstLoginTime, bts, err = nbs.ReadTimeBytes(bts)
 
 			if err != nil {
 				return
 			}
 		case "LastLoginTime__tim":
-			found33zgensym_189e87a53e58dbf2_34[14] = true
+			found33zgensym_189e87a53e58dbf2_34[15] = true
 			z.LastLoginTime, bts, err = nbs.ReadTimeBytes(bts)
 
 			if err != nil {
 				return
 			}
 		case "LastLoginAddr__str":
-			found33zgensym_189e87a53e58dbf2_34[15] = true
+			found33zgensym_189e87a53e58dbf2_34[16] = true
 			z.LastLoginAddr, bts, err = nbs.ReadStringBytes(bts)
 
 			if err != nil {
 				return
 			}
 		case "IPwhitelist__slc":
-			found33zgensym_189e87a53e58dbf2_34[16] = true
+			found33zgensym_189e87a53e58dbf2_34[17] = true
 			if nbs.AlwaysNil {
 				(z.IPwhitelist) = (z.IPwhitelist)[:0]
 			} else {
@@ -2256,9 +2319,16 @@ doneWithStruct33zgensym_189e87a53e58dbf2_34:
 				}
 			}
 		case "DisabledAcct__boo":
-			found33zgensym_189e87a53e58dbf2_34[17] = true
+			found33zgensym_189e87a53e58dbf2_34[18] = true
 			z.DisabledAcct, bts, err = nbs.ReadBoolBytes(bts)
 
+			if err != nil {
+				return
+			}
+		case "LoginCount__i64":
+			found33zgensym_189e87a53e58dbf2_34[19] = true
+			z.LoginCount, bts, err = nbs.ReadInt64Bytes(bts)
+
 			if err != nil {
 				return
 			}
@@ -2285,9 +2355,9 @@ doneWithStruct33zgensym_189e87a53e58dbf2_34:
 }
 
 // fields of User
-var unmarshalMsgFieldOrder33zgensym_189e87a53e58dbf2_34 = []string{"MyEmail__str", "MyFullname__str", "MyLogin__str", "PublicKeyPath__str", "PrivateKeyPath__str", "TOTPpath__str", "QrPath__str", "Issuer__str", "", "SeenPubKey__map", "ScryptedPassword__bin", "ClearPw__str", "TOTPorig__str", "FirstLoginTime__tim", "LastLoginTime__tim", "LastLoginAddr__str", "IPwhitelist__slc", "DisabledAcct__boo"}
+var unmarshalMsgFieldOrder33zgensym_189e87a53e58dbf2_34 = []string{"MyEmail__str", "MyFullname__str", "MyLogin__str", "PublicKeyPath__str", "PrivateKeyPath__str", "TOTPpath__str", "QrPath__str", "Issuer__str", "", "SeenPubKey__map", "ScryptedPassword__bin", "ClearPw__str", "TOTPorig__str", "TotpSecret__str", "FirstLoginTime__tim", "LastLoginTime__tim", "LastLoginAddr__str", "IPwhitelist__slc", "DisabledAcct__boo", "LoginCount__i64"}
 
-var unmarshalMsgFieldSkip33zgensym_189e87a53e58dbf2_34 = []bool{false, false, false, false, false, false, false, false, true, false, false, false, false, false, false, false, false, false}
+var unmarshalMsgFieldSkip33zgensym_189e87a53e58dbf2_34 = []bool{false, false, false, false, false, false, false, false, true, false, false, false, false, false, false, false, false, false, false, false}
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *User) Msgsize() (s int) {
@@ -2299,10 +2369,10 @@ func (z *User) Msgsize() (s int) {
 			s += msgp.StringPrefixSize + len(zgensym_189e87a53e58dbf2_24) + zgensym_189e87a53e58dbf2_25.Msgsize()
 		}
 	}
-	s += 22 + msgp.BytesPrefixSize + len(z.ScryptedPassword) + 13 + msgp.StringPrefixSize + len(z.ClearPw) + 14 + msgp.StringPrefixSize + len(z.TOTPorig) + 20 + msgp.TimeSize + 19 + msgp.TimeSize + 19 + msgp.StringPrefixSize + len(z.LastLoginAddr) + 17 + msgp.ArrayHeaderSize
+	s += 22 + msgp.BytesPrefixSize + len(z.ScryptedPassword) + 13 + msgp.StringPrefixSize + len(z.ClearPw) + 14 + msgp.StringPrefixSize + len(z.TOTPorig) + 16 + msgp.StringPrefixSize + len(z.TotpSecret) + 20 + msgp.TimeSize + 19 + msgp.TimeSize + 19 + msgp.StringPrefixSize + len(z.LastLoginAddr) + 17 + msgp.ArrayHeaderSize
 	for zgensym_189e87a53e58dbf2_26 := range z.IPwhitelist {
 		s += msgp.StringPrefixSize + len(z.IPwhitelist[zgensym_189e87a53e58dbf2_26])
 	}
-	s += 18 + msgp.BoolSize
+	s += 18 + msgp.BoolSize + 16 + msgp.Int64Size
 	return
 }
@@ -24,6 +24,11 @@ const (
 	channelMaxPacket = 1 << 15
 	// We follow OpenSSH here.
 	channelWindowSize = 64 * channelMaxPacket
+
+	// MaxChannelMaxPacket is the largest Config.ChannelMaxPacket
+	// we honor; a data packet that size, plus its header,
+	// must fit within the transport's maxPacket.
+	MaxChannelMaxPacket = 128 * 1024
 )
 
 // verify interface satisfied.
@@ -224,6 +229,24 @@ func (r RejectionReason) String() string {
 	return fmt.Sprintf("unknown reason %d", int(r))
 }
 
+// IsTemporary reports whether a channel refused for
+// reason r might be opened if asked again later:
+// ConnectionFailed and ResourceShortage.
+func (r RejectionReason) IsTemporary() bool {
+	switch r {
+	case ConnectionFailed, ResourceShortage:
+		return true
+	}
+	return false
+}
+
+// IsPermanent reports whether asking again for a channel
+// refused for reason r is pointless. It is the opposite
+// of IsTemporary, so reasons we don't know are permanent.
+func (r RejectionReason) IsPermanent() bool {
+	return !r.IsTemporary()
+}
+
 func min(a uint32, b int) uint32 {
 	if a < uint32(b) {
 		return a
@@ -629,8 +652,8 @@ func (c *channel) handlePacket(packet []byte) error {
 func (m *mux) newChannel(chanType string, direction channelDirection, extraData []byte) *channel {
 	idleR, idleW := NewIdleTimer(nil, 0), NewIdleTimer(nil, 0)
 	ch := &channel{
-		remoteWin:        window{Cond: newCond(), idle: idleR},
-		myWindow:         channelWindowSize,
+		remoteWin:        window{Cond: newCond(), idle: idleW},
+		myWindow:         m.windowSize,
 		pending:          newBuffer(idleR),
 		extPending:       newBuffer(idleR),
 		direction:        direction,
@@ -670,7 +693,7 @@ func (c *channel) Accept() (Channel, <-chan *Request, error) {
 	if c.decided {
 		return nil, nil, errDecidedAlready
 	}
-	c.maxIncomingPayload = channelMaxPacket
+	c.maxIncomingPayload = c.mux.maxPacket
 	confirm := channelOpenConfirmMsg{
 		PeersId:       c.remoteId,
 		MyId:          c.localId,
@@ -0,0 +1,27 @@
+package ssh
+
+import "testing"
+
+func TestRejectionReasonStringAndClass(t *testing.T) {
+	for _, tc := range []struct {
+		r         RejectionReason
+		str       string
+		temporary bool
+	}{
+		{Prohibited, "administratively prohibited", false},
+		{ConnectionFailed, "connect failed", true},
+		{UnknownChannelType, "unknown channel type", false},
+		{ResourceShortage, "resource shortage", true},
+		{RejectionReason(99), "unknown reason 99", false},
+	} {
+		if got := tc.r.String(); got != tc.str {
+			t.Errorf("RejectionReason(%d).String() = %q, want %q", uint32(tc.r), got, tc.str)
+		}
+		if got := tc.r.IsTemporary(); got != tc.temporary {
+			t.Errorf("%v: IsTemporary() = %v, want %v", tc.r, got, tc.temporary)
+		}
+		if got := tc.r.IsPermanent(); got != !tc.temporary {
+			t.Errorf("%v: IsPermanent() = %v, want %v", tc.r, got, !tc.temporary)
+		}
+	}
+}
@@ -127,6 +127,12 @@ var cipherModes = map[string]*streamCipherMode{
 	tripledescbcID: {24, des.BlockSize, 0, nil},
 }
 
+// IsKnownCipher reports whether name is a cipher
+// this package can negotiate, if asked to in Config.Ciphers.
+func IsKnownCipher(name string) bool {
+	return cipherModes[name] != nil
+}
+
 // prefixLen is the length of the packet prefix that contains the packet length
 // and number of padding bytes.
 const prefixLen = 5
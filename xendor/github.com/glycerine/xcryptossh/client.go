@@ -92,7 +92,7 @@ func NewClientConn(ctx context.Context, c net.Conn, addr string, config *ClientC
 		return nil, nil, nil, fmt.Errorf("ssh: handshake failed: %v", err)
 	}
 
-	conn.mux = newMux(ctx, conn.transport, conn.halt)
+	conn.mux = newMux(ctx, conn.transport, conn.halt, &fullConf.Config)
 	return conn, conn.mux.incomingChannels, conn.mux.incomingRequests, nil
 }
 
@@ -176,7 +176,11 @@ func (c *Client) HandleChannelOpens(ctx context.Context, in <-chan NewChannel) {
 			return
 		case <-ctx.Done():
 			return
-		case ch := <-in:
+		case ch, ok := <-in:
+			if !ok {
+				// the mux has shut down.
+				return
+			}
 			if ch != nil {
 				c.Mu.Lock()
 				handler := c.ChannelHandlers[ch.ChannelType()]
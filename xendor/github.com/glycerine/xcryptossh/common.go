@@ -11,6 +11,7 @@ import (
 	"io"
 	"math"
 	"sync"
+	"time"
 
 	_ "crypto/sha1"
 	_ "crypto/sha256"
@@ -34,7 +35,7 @@ var supportedCiphers = []string{
 // supportedKexAlgos specifies the supported key-exchange algorithms in
 // preference order.
 var supportedKexAlgos = []string{
-	kexAlgoCurve25519SHA256,
+	kexAlgoCurve25519SHA256RFC, kexAlgoCurve25519SHA256,
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
@@ -60,6 +61,10 @@ var supportedMACs = []string{
 	"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
 }
 
+// supportedCompressions specifies the default compression
+// algorithms. Compression costs CPU and mostly helps on slow
+// links, so zlib@openssh.com must be asked for in
+// Config.Compressions.
 var supportedCompressions = []string{compressionNone}
 
 // hashFuncs keeps the mapping of supported algorithms to their respective
@@ -190,6 +195,22 @@ type Config struct {
 	// unspecified, a size suitable for the chosen cipher is used.
 	RekeyThreshold uint64
 
+	// RekeyTimeThreshold, if > 0, is how long after a key
+	// exchange completes that a new key is negotiated,
+	// however little data has passed.
+	RekeyTimeThreshold time.Duration
+
+	// ChannelWindowSize, if > 0, is the flow-control window
+	// we offer the peer on each channel, in bytes. Larger
+	// windows keep high-latency links busy. The default
+	// is 2MB.
+	ChannelWindowSize uint32
+
+	// ChannelMaxPacket, if > 0, is the largest data packet
+	// we let the peer send us on a channel. The default is
+	// 32KB; at most MaxChannelMaxPacket.
+	ChannelMaxPacket uint32
+
 	// The allowed key exchanges algorithms. If unspecified then a
 	// default set of algorithms is used.
 	KeyExchanges []string
@@ -202,6 +223,15 @@ type Config struct {
 	// is used.
 	MACs []string
 
+	// The allowed compression algorithms, in preference order. If
+	// unspecified, only "none" is allowed; list "zlib@openssh.com"
+	// to allow compression.
+	Compressions []string
+
+	// CompressionLevel is the zlib level used when we compress,
+	// from 1 (fastest) to 9 (best). Zero means zlib's default.
+	CompressionLevel int
+
 	// Halt is for shutdown
 	Halt *Halter
 }
@@ -233,6 +263,17 @@ func (c *Config) SetDefaults() {
 		c.MACs = supportedMACs
 	}
 
+	if c.Compressions == nil {
+		c.Compressions = supportedCompressions
+	}
+	var compressions []string
+	for _, c := range c.Compressions {
+		if c == compressionNone || c == compressionZlibOpenSSH {
+			compressions = append(compressions, c)
+		}
+	}
+	c.Compressions = compressions
+
 	if c.RekeyThreshold == 0 {
 		// cipher specific default
 	} else if c.RekeyThreshold < minRekeyThreshold {
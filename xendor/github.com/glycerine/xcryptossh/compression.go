@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// compressionZlibOpenSSH is "delayed" zlib compression, which
+// only begins once user authentication has succeeded. See
+// openssh-portable/PROTOCOL, section 1.3.
+const compressionZlibOpenSSH = "zlib@openssh.com"
+
+// zlibState is the compression stream for one direction
+// of a transport. The stream outlives rekeying, so the
+// same zlibState is handed to each new packetCipher.
+type zlibState struct {
+	// active is set, atomically, once user authentication
+	// succeeds; until then packets pass through untouched.
+	active int32
+	level  int
+
+	// writing side
+	w    *zlib.Writer
+	wbuf bytes.Buffer
+
+	// reading side
+	src *bytes.Reader
+	r   io.ReadCloser
+	buf []byte
+	out []byte
+}
+
+func newZlibState(level int) *zlibState {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	return &zlibState{
+		level: level,
+		src:   bytes.NewReader(nil),
+	}
+}
+
+func (z *zlibState) activate() {
+	atomic.StoreInt32(&z.active, 1)
+}
+
+func (z *zlibState) isActive() bool {
+	return atomic.LoadInt32(&z.active) == 1
+}
+
+// compress returns p as one sync-flushed chunk of the stream.
+func (z *zlibState) compress(p []byte) ([]byte, error) {
+	z.wbuf.Reset()
+	if z.w == nil {
+		w, err := zlib.NewWriterLevel(&z.wbuf, z.level)
+		if err != nil {
+			return nil, err
+		}
+		z.w = w
+	}
+	if _, err := z.w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := z.w.Flush(); err != nil {
+		return nil, err
+	}
+	return z.wbuf.Bytes(), nil
+}
+
+// decompress inflates one packet's worth of the stream. Each
+// packet ends in a sync flush, so once its bytes are consumed
+// the inflater has handed us everything they encode. We must
+// not call Read again with no input: the inflater would hit
+// the end of src, and its errors are sticky.
+func (z *zlibState) decompress(p []byte) ([]byte, error) {
+	z.src.Reset(p)
+	if z.r == nil {
+		r, err := zlib.NewReader(z.src)
+		if err != nil {
+			return nil, err
+		}
+		z.r = r
+		// larger than the inflate window, so one Read
+		// always drains what the final sync flush releases.
+		z.buf = make([]byte, 64*1024)
+	}
+	z.out = z.out[:0]
+	for z.src.Len() > 0 {
+		n, err := z.r.Read(z.buf)
+		z.out = append(z.out, z.buf[:n]...)
+		if err != nil {
+			return nil, err
+		}
+		if len(z.out) > maxPacket {
+			return nil, errors.New("ssh: decompressed packet too large")
+		}
+	}
+	return z.out, nil
+}
+
+// compressedPacketCipher adds compression to a packetCipher.
+type compressedPacketCipher struct {
+	packetCipher
+	z *zlibState
+}
+
+func (c *compressedPacketCipher) writePacket(seqnum uint32, w io.Writer, rand io.Reader, packet []byte) error {
+	if c.z.isActive() {
+		var err error
+		packet, err = c.z.compress(packet)
+		if err != nil {
+			return err
+		}
+	}
+	return c.packetCipher.writePacket(seqnum, w, rand, packet)
+}
+
+func (c *compressedPacketCipher) readPacket(seqnum uint32, r io.Reader) ([]byte, error) {
+	packet, err := c.packetCipher.readPacket(seqnum, r)
+	if err != nil || !c.z.isActive() {
+		return packet, err
+	}
+	return c.z.decompress(packet)
+}
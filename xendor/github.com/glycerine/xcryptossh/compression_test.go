@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// compressionPipe connects a client and server that
+// offer the given compression algorithms, and has the
+// server echo back everything sent on an "echo" channel.
+func compressionPipe(t *testing.T, halt *Halter, cli, srv []string) (Conn, Conn) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	ctx := context.Background()
+	clientConf := ClientConfig{
+		User:            "user",
+		HostKeyCallback: InsecureIgnoreHostKey(),
+		Config: Config{
+			Compressions: cli,
+			Halt:         halt,
+		},
+	}
+	serverConf := ServerConfig{
+		NoClientAuth: true,
+		Config: Config{
+			Compressions: srv,
+			Halt:         halt,
+		},
+	}
+	serverConf.AddHostKey(testSigners["ecdsa"])
+
+	done := make(chan Conn, 1)
+	go func() {
+		server, chans, reqs, err := NewServerConn(ctx, c2, &serverConf)
+		if err != nil {
+			t.Errorf("NewServerConn: %v", err)
+			done <- nil
+			return
+		}
+		go DiscardRequests(ctx, reqs, nil)
+		done <- server
+		for nc := range chans {
+			ch, in, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go DiscardRequests(ctx, in, nil)
+			go func() {
+				io.Copy(ch, ch)
+				ch.CloseWrite()
+			}()
+		}
+	}()
+
+	client, _, reqs, err := NewClientConn(ctx, c1, "", &clientConf)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	go DiscardRequests(ctx, reqs, nil)
+	server := <-done
+	if server == nil {
+		t.FailNow()
+	}
+	return client, server
+}
+
+func echoThrough(t *testing.T, conn Conn, data []byte) {
+	ctx := context.Background()
+	ch, in, err := conn.OpenChannel(ctx, "echo", nil, nil)
+	if err != nil {
+		t.Fatalf("OpenChannel: %v", err)
+	}
+	go DiscardRequests(ctx, in, nil)
+	go func() {
+		ch.Write(data)
+		ch.CloseWrite()
+	}()
+	back := make([]byte, len(data))
+	if _, err := io.ReadFull(ch, back); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Fatalf("echoed data differs")
+	}
+	ch.Close()
+}
+
+func negotiatedCompression(c Conn) (w, r string) {
+	algs := c.NegotiatedAlgorithms()
+	return algs.CompressionClientServer, algs.CompressionServerClient
+}
+
+func TestCompressionZlibNegotiated(t *testing.T) {
+	defer xtestend(xtestbegin(t))
+	halt := NewHalter()
+	defer halt.RequestStop()
+
+	client, server := compressionPipe(t, halt,
+		[]string{compressionZlibOpenSSH, compressionNone},
+		[]string{compressionNone, compressionZlibOpenSSH})
+	defer client.Close()
+	defer server.Close()
+
+	data := bytes.Repeat([]byte("compressible "), 100000)
+	echoThrough(t, client, data)
+
+	for _, c := range []Conn{client, server} {
+		w, r := negotiatedCompression(c)
+		if w != compressionZlibOpenSSH || r != compressionZlibOpenSSH {
+			t.Errorf("want %s both ways, got %s and %s", compressionZlibOpenSSH, w, r)
+		}
+	}
+}
+
+func TestCompressionDefaultIsNone(t *testing.T) {
+	defer xtestend(xtestbegin(t))
+	halt := NewHalter()
+	defer halt.RequestStop()
+
+	// the client asks for zlib, but the server
+	// only allows it when told to.
+	client, server := compressionPipe(t, halt,
+		[]string{compressionZlibOpenSSH, compressionNone}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	echoThrough(t, client, []byte("hello"))
+
+	w, r := negotiatedCompression(client)
+	if w != compressionNone || r != compressionNone {
+		t.Errorf("want %s both ways, got %s and %s", compressionNone, w, r)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
 // OpenChannelError is returned if the other side rejects an
@@ -43,6 +44,29 @@ type ConnMetadata interface {
 
 	// LocalAddr returns the local address for this connection.
 	LocalAddr() net.Addr
+
+	// NegotiatedAlgorithms returns the algorithms agreed
+	// in the most recent key exchange.
+	NegotiatedAlgorithms() AlgorithmSet
+}
+
+// AlgorithmSet names the algorithms agreed in a key exchange.
+type AlgorithmSet struct {
+	KeyExchange string
+	HostKey     string
+
+	CipherClientServer string
+	CipherServerClient string
+
+	MACClientServer string
+	MACServerClient string
+
+	CompressionClientServer string
+	CompressionServerClient string
+
+	// KeyExchanges counts the key exchanges completed on
+	// the connection so far, the initial one included.
+	KeyExchanges int
 }
 
 // Conn represents an SSH connection for both server and client roles.
@@ -57,6 +81,11 @@ type Conn interface {
 	// and payload. See also RFC4254, section 4.
 	SendRequest(ctx context.Context, name string, wantReply bool, payload []byte) (bool, []byte, error)
 
+	// SendRequestTimeout is SendRequest without the
+	// context boilerplate: it gives up after timeout,
+	// returning context.DeadlineExceeded.
+	SendRequestTimeout(name string, wantReply bool, payload []byte, timeout time.Duration) (bool, []byte, error)
+
 	// OpenChannel tries to open an channel. If the request is
 	// rejected, it returns *OpenChannelError. On success it returns
 	// the SSH Channel and a Go channel for incoming, out-of-band
@@ -71,6 +100,10 @@ type Conn interface {
 	// error causing the shutdown.
 	Wait() error
 
+	// WaitContext is like Wait, but gives up and
+	// returns ctx.Err() once ctx is done.
+	WaitContext(ctx context.Context) error
+
 	// Done can be used to await connection shutdown. The
 	// returned channel will be closed when the Conn is
 	// shutting down.
@@ -95,8 +128,11 @@ func DiscardRequests(ctx context.Context, in <-chan *Request, halt *Halter) {
 	}
 	for {
 		select {
-		case req := <-in:
-			if req != nil && req.WantReply {
+		case req, ok := <-in:
+			if !ok {
+				return
+			}
+			if req.WantReply {
 				req.Reply(false, nil)
 			}
 		case <-reqStop:
@@ -150,6 +186,13 @@ func (c *connection) Done() <-chan struct{} {
 	return c.halt.ReqStopChan()
 }
 
+func (c *connection) NegotiatedAlgorithms() AlgorithmSet {
+	if c.transport == nil {
+		return AlgorithmSet{}
+	}
+	return c.transport.negotiatedAlgorithms()
+}
+
 // sshconn provides net.Conn metadata, but disallows direct reads and
 // writes.
 type sshConn struct {
@@ -13,6 +13,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 )
 
 // debugHandshake, if set, prints messages sent and received.  Key
@@ -64,6 +65,7 @@ type handshakeTransport struct {
 	sentInitPacket []byte
 	sentInitMsg    *kexInitMsg
 	pendingPackets [][]byte // Used when a key exchange is in progress.
+	agreed         AlgorithmSet
 
 	// If the read loop wants to schedule a kex, it pings this
 	// channel, and the write loop will send out a kex
@@ -90,6 +92,10 @@ type handshakeTransport struct {
 
 	// The session ID or nil if first kex did not complete yet.
 	sessionID []byte
+
+	// rekeyTimer requests a kex once config.RekeyTimeThreshold
+	// has passed since the last one. Guarded by mu.
+	rekeyTimer *time.Timer
 }
 
 type pendingKex struct {
@@ -284,6 +290,13 @@ func (t *handshakeTransport) resetWriteThresholds() {
 }
 
 func (t *handshakeTransport) kexLoop(ctx context.Context) {
+	defer func() {
+		t.mu.Lock()
+		if t.rekeyTimer != nil {
+			t.rekeyTimer.Stop()
+		}
+		t.mu.Unlock()
+	}()
 
 write:
 	for t.getWriteError() == nil {
@@ -343,6 +356,12 @@ write:
 		t.sentInitMsg = nil
 
 		t.resetWriteThresholds()
+		if err == nil && t.config.RekeyTimeThreshold > 0 {
+			if t.rekeyTimer != nil {
+				t.rekeyTimer.Stop()
+			}
+			t.rekeyTimer = time.AfterFunc(t.config.RekeyTimeThreshold, t.requestKeyExchange)
+		}
 
 		// we have completed the key exchange. Since the
 		// reader is still blocked, it is safe to clear out
@@ -390,9 +409,16 @@ write:
 		defer func() {
 			t.config.Halt.MarkDone()
 		}()
+		startKex := t.startKex
 		for {
 			select {
-			case init := <-t.startKex:
+			case init, ok := <-startKex:
+				if !ok {
+					// closed: nothing more to drain, but keep
+					// the MarkDone tied to the halter.
+					startKex = nil
+					continue
+				}
 				if init != nil {
 					select {
 					case init.done <- t.writeError:
@@ -524,8 +550,8 @@ func (t *handshakeTransport) sendKexInit() error {
 		CiphersServerClient:     t.config.Ciphers,
 		MACsClientServer:        t.config.MACs,
 		MACsServerClient:        t.config.MACs,
-		CompressionClientServer: supportedCompressions,
-		CompressionServerClient: supportedCompressions,
+		CompressionClientServer: t.config.Compressions,
+		CompressionServerClient: t.config.Compressions,
 	}
 	io.ReadFull(rand.Reader, msg.Cookie[:])
 
@@ -681,9 +707,32 @@ func (t *handshakeTransport) enterKeyExchange(ctx context.Context, otherInitPack
 		return unexpectedMessageError(msgNewKeys, packet[0])
 	}
 
+	algs := t.algorithms
+	t.mu.Lock()
+	t.agreed = AlgorithmSet{
+		KeyExchange:             algs.kex,
+		HostKey:                 algs.hostKey,
+		CipherClientServer:      algs.w.Cipher,
+		CipherServerClient:      algs.r.Cipher,
+		MACClientServer:         algs.w.MAC,
+		MACServerClient:         algs.r.MAC,
+		CompressionClientServer: algs.w.Compression,
+		CompressionServerClient: algs.r.Compression,
+		KeyExchanges:            t.agreed.KeyExchanges + 1,
+	}
+	t.mu.Unlock()
 	return nil
 }
 
+// negotiatedAlgorithms returns what the last completed key
+// exchange agreed on. findAgreedAlgorithms always fills in
+// w as client to server, and r as server to client.
+func (t *handshakeTransport) negotiatedAlgorithms() AlgorithmSet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.agreed
+}
+
 func (t *handshakeTransport) server(ctx context.Context, kex kexAlgorithm, algs *algorithms, magics *handshakeMagics) (*kexResult, error) {
 	var hostKey Signer
 	for _, k := range t.hostKeys {
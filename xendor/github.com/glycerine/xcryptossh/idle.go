@@ -202,7 +202,10 @@ func (t *IdleTimer) IdleStatus() (lastStart, lastOK, mnow, todur int64, timedout
 // only need to use this call.
 //
 func (t *IdleTimer) SetIdleTimeout(dur time.Duration) error {
-	tk := newSetTimeoutTicket(dur)
+	return t.setIdleTimeout(newSetTimeoutTicket(dur))
+}
+
+func (t *IdleTimer) setIdleTimeout(tk *setTimeoutTicket) error {
 	select {
 	case t.setIdleTimeoutCh <- tk:
 	case <-t.Halt.ReqStopChan():
@@ -214,9 +217,14 @@ func (t *IdleTimer) SetIdleTimeout(dur time.Duration) error {
 	return nil
 }
 
+// SetOneshotIdleTimeout is SetIdleTimeout for deadlines:
+// a Read already in progress counts against dur from now,
+// rather than being exempt until the next one begins.
 func (t *IdleTimer) SetOneshotIdleTimeout(dur time.Duration) {
 	atomic.StoreInt32(&t.isOneshot, 1)
-	t.SetIdleTimeout(dur)
+	tk := newSetTimeoutTicket(dur)
+	tk.keepAttempt = true
+	t.setIdleTimeout(tk)
 }
 
 // GetIdleTimeout returns the current idle timeout duration in use.
@@ -241,6 +249,10 @@ func (t *IdleTimer) Stop() {
 type setTimeoutTicket struct {
 	newdur time.Duration
 	done   chan struct{}
+
+	// keepAttempt leaves an attempt in progress
+	// subject to the new timeout.
+	keepAttempt bool
 }
 
 func newSetTimeoutTicket(dur time.Duration) *setTimeoutTicket {
@@ -252,6 +264,31 @@ func newSetTimeoutTicket(dur time.Duration) *setTimeoutTicket {
 
 const factor = 10
 
+// heartbeatEvery gives the sampling interval for dur,
+// never less than a millisecond, so that a deadline
+// already past doesn't spin the heartbeat.
+func heartbeatEvery(dur time.Duration) time.Duration {
+	if every := dur / factor; every > time.Millisecond {
+		return every
+	}
+	return time.Millisecond
+}
+
+// resetStart restarts the idle clock when a new timeout
+// is set. lastStart == -1 means there has been no Read
+// started since; with keepAttempt, a Read in progress
+// is treated as having started just now.
+func (t *IdleTimer) resetStart(tk *setTimeoutTicket) {
+	if tk.keepAttempt {
+		lastStart := atomic.LoadInt64(&t.lastStart)
+		if lastStart > 0 && lastStart > atomic.LoadInt64(&t.lastOK) {
+			atomic.StoreInt64(&t.lastStart, monoNow())
+			return
+		}
+	}
+	atomic.StoreInt64(&t.lastStart, -1)
+}
+
 func (t *IdleTimer) backgroundStart(dur time.Duration) {
 	//pp("IdleTimer.backgroundStart(dur=%v) called.", dur)
 	atomic.StoreInt64(&t.atomicdur, int64(dur))
@@ -266,7 +303,7 @@ func (t *IdleTimer) backgroundStart(dur time.Duration) {
 			// we go with dur/factor. This also allows for
 			// some play/some slop in the sampling, which
 			// we empirically observe.
-			heartbeat = time.NewTicker(dur / factor)
+			heartbeat = time.NewTicker(heartbeatEvery(dur))
 			heartch = heartbeat.C
 		}
 		defer func() {
@@ -295,9 +332,7 @@ func (t *IdleTimer) backgroundStart(dur time.Duration) {
 			case tk := <-t.setIdleTimeoutCh:
 				/* change state, maybe */
 				t.timeOutRaised = ""
-				// lastStart == -1 means there has been no
-				// Read started since we set the idle timeout.
-				atomic.StoreInt64(&t.lastStart, -1) // Reset
+				t.resetStart(tk)
 
 				if dur > 0 {
 					// timeouts active currently
@@ -325,9 +360,8 @@ func (t *IdleTimer) backgroundStart(dur time.Duration) {
 					dur = tk.newdur
 					atomic.StoreInt64(&t.atomicdur, int64(dur))
 
-					heartbeat = time.NewTicker(dur / factor)
+					heartbeat = time.NewTicker(heartbeatEvery(dur))
 					heartch = heartbeat.C
-					atomic.StoreInt64(&t.lastStart, -1) // Reset
 					close(tk.done)
 					continue
 				} else {
@@ -344,9 +378,8 @@ func (t *IdleTimer) backgroundStart(dur time.Duration) {
 					dur = tk.newdur
 					atomic.StoreInt64(&t.atomicdur, int64(dur))
 
-					heartbeat = time.NewTicker(dur / factor)
+					heartbeat = time.NewTicker(heartbeatEvery(dur))
 					heartch = heartbeat.C
-					atomic.StoreInt64(&t.lastStart, -1) // Reset
 					close(tk.done)
 					continue
 				}
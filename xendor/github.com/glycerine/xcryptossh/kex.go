@@ -25,6 +25,10 @@ const (
 	kexAlgoECDH384          = "ecdh-sha2-nistp384"
 	kexAlgoECDH521          = "ecdh-sha2-nistp521"
 	kexAlgoCurve25519SHA256 = "curve25519-sha256@libssh.org"
+
+	// kexAlgoCurve25519SHA256RFC is the RFC 8731 name for
+	// the same key exchange.
+	kexAlgoCurve25519SHA256RFC = "curve25519-sha256"
 )
 
 // kexResult captures the outcome of a key exchange.
@@ -403,6 +407,13 @@ func init() {
 	kexAlgoMap[kexAlgoECDH384] = &ecdh{elliptic.P384()}
 	kexAlgoMap[kexAlgoECDH256] = &ecdh{elliptic.P256()}
 	kexAlgoMap[kexAlgoCurve25519SHA256] = &curve25519sha256{}
+	kexAlgoMap[kexAlgoCurve25519SHA256RFC] = &curve25519sha256{}
+}
+
+// IsKnownKeyExchange reports whether name is a key exchange
+// this package can negotiate, if asked to in Config.KeyExchanges.
+func IsKnownKeyExchange(name string) bool {
+	return kexAlgoMap[name] != nil
 }
 
 // curve25519sha256 implements the curve25519-sha256@libssh.org key
@@ -59,3 +59,9 @@ var macModes = map[string]*macMode{
 		return truncatingMAC{12, hmac.New(sha1.New, key)}
 	}},
 }
+
+// IsKnownMAC reports whether name is a MAC algorithm
+// this package can negotiate, if asked to in Config.MACs.
+func IsKnownMAC(name string) bool {
+	return macModes[name] != nil
+}
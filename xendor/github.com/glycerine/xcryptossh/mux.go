@@ -13,6 +13,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // debugMux, if set, causes messages in the connection protocol to be
@@ -97,10 +98,25 @@ type mux struct {
 	globalResponses  chan interface{}
 	incomingRequests chan *Request
 
+	// globalAbandoned counts replies still due to global
+	// requests whose ctx expired first. Those replies
+	// arrive in order, ahead of ours, and are dropped
+	// by the read loop. Protected by globalRespMu.
+	globalAbandoned int
+	globalRespMu    sync.Mutex
+
 	errCond *sync.Cond
 	err     error
 
+	// dead is closed once err is set.
+	dead chan struct{}
+
 	halt *Halter
+
+	// windowSize and maxPacket are what we offer
+	// the peer on each new channel.
+	windowSize uint32
+	maxPacket  uint32
 }
 
 // When debugging, each new chanList instantiation has a different
@@ -116,8 +132,21 @@ func (m *mux) Wait() error {
 	return m.err
 }
 
+// WaitContext is Wait, except that it returns ctx.Err()
+// if ctx is done before the connection shuts down.
+func (m *mux) WaitContext(ctx context.Context) error {
+	select {
+	case <-m.dead:
+		return m.Wait()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // newMux returns a mux that runs over the given connection.
-func newMux(ctx context.Context, p packetConn, halt *Halter) *mux {
+// newMux starts a mux on p. config, which may be nil,
+// supplies the channel window and packet sizes.
+func newMux(ctx context.Context, p packetConn, halt *Halter, config *Config) *mux {
 	// idle is nil on server
 	m := &mux{
 		conn:             p,
@@ -125,7 +154,24 @@ func newMux(ctx context.Context, p packetConn, halt *Halter) *mux {
 		globalResponses:  make(chan interface{}, 1),
 		incomingRequests: make(chan *Request, chanSize),
 		errCond:          newCond(),
+		dead:             make(chan struct{}),
 		halt:             halt,
+		windowSize:       channelWindowSize,
+		maxPacket:        channelMaxPacket,
+	}
+	if config != nil {
+		if config.ChannelMaxPacket > 0 {
+			m.maxPacket = config.ChannelMaxPacket
+			if m.maxPacket > MaxChannelMaxPacket {
+				m.maxPacket = MaxChannelMaxPacket
+			}
+		}
+		if config.ChannelWindowSize > 0 {
+			m.windowSize = config.ChannelWindowSize
+		}
+	}
+	if m.windowSize < m.maxPacket {
+		m.windowSize = m.maxPacket
 	}
 
 	if debugMux {
@@ -183,10 +229,31 @@ func (m *mux) SendRequest(ctx context.Context, name string, wantReply bool, payl
 	case <-m.halt.ReqStopChan():
 		return false, nil, io.EOF
 	case <-ctx.Done():
+		m.globalRespMu.Lock()
+		select {
+		case <-m.globalResponses:
+			// our reply beat us to the lock; drop it.
+		default:
+			m.globalAbandoned++
+		}
+		m.globalRespMu.Unlock()
 		return false, nil, io.EOF
 	}
 }
 
+// SendRequestTimeout is SendRequest with a context
+// that expires after timeout. If it does, the error
+// is context.DeadlineExceeded.
+func (m *mux) SendRequestTimeout(name string, wantReply bool, payload []byte, timeout time.Duration) (bool, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ok, data, err := m.SendRequest(ctx, name, wantReply, payload)
+	if err == io.EOF && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return ok, data, err
+}
+
 // ackRequest must be called after processing a global request that
 // has WantReply set.
 func (m *mux) ackRequest(ok bool, data []byte) error {
@@ -231,6 +298,7 @@ func (m *mux) loop(ctx context.Context) {
 	m.err = err
 	m.errCond.Broadcast()
 	m.errCond.L.Unlock()
+	close(m.dead)
 
 	if debugMux {
 		log.Println("loop exit", err)
@@ -295,13 +363,19 @@ func (m *mux) handleGlobalPacket(ctx context.Context, packet []byte) error {
 			return io.EOF
 		}
 	case *globalRequestSuccessMsg, *globalRequestFailureMsg:
-		select {
-		case m.globalResponses <- msg:
-		case <-m.halt.ReqStopChan():
-			return io.EOF
-		case <-ctx.Done():
-			return io.EOF
+		// never block here: that would stall the read
+		// loop, and with it every channel.
+		m.globalRespMu.Lock()
+		if m.globalAbandoned > 0 {
+			m.globalAbandoned--
+		} else {
+			select {
+			case m.globalResponses <- msg:
+			default:
+				// nobody asked for this reply.
+			}
 		}
+		m.globalRespMu.Unlock()
 	default:
 		panic(fmt.Sprintf("not a global message %#v", msg))
 	}
@@ -352,7 +426,7 @@ func (m *mux) OpenChannel(ctx context.Context, chanType string, extra []byte, pa
 func (m *mux) openChannel(ctx context.Context, chanType string, extra []byte, parentHalt *Halter) (*channel, error) {
 	ch := m.newChannel(chanType, channelOutbound, extra)
 
-	ch.maxIncomingPayload = channelMaxPacket
+	ch.maxIncomingPayload = m.maxPacket
 
 	open := channelOpenMsg{
 		ChanType:         chanType,
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"sync"
 	"testing"
+	"time"
 )
 
 func muxPair(halt *Halter) (*mux, *mux) {
@@ -17,8 +18,8 @@ func muxPair(halt *Halter) (*mux, *mux) {
 
 	ctx := context.Background()
 
-	s := newMux(ctx, a, halt)
-	c := newMux(ctx, b, halt)
+	s := newMux(ctx, a, halt, nil)
+	c := newMux(ctx, b, halt, nil)
 
 	return s, c
 }
@@ -401,6 +402,116 @@ func TestMuxGlobalRequest(t *testing.T) {
 	}
 }
 
+func TestMuxGlobalRequestTimeout(t *testing.T) {
+	defer xtestend(xtestbegin(t))
+
+	halt := NewHalter()
+	defer halt.RequestStop()
+
+	clientMux, serverMux := muxPair(halt)
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	go func() {
+		for r := range serverMux.incomingRequests {
+			if r.Type == "slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			if r.WantReply {
+				r.Reply(true, []byte(r.Type))
+			}
+		}
+	}()
+
+	_, _, err := clientMux.SendRequestTimeout("slow", true, nil, 20*time.Millisecond)
+	if err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+
+	// the late reply to "slow" must not be taken as ours.
+	ok, data, err := clientMux.SendRequestTimeout("fast", true, nil, 5*time.Second)
+	if !ok || string(data) != "fast" || err != nil {
+		t.Errorf("SendRequestTimeout(\"fast\"): %v %q %v", ok, data, err)
+	}
+}
+
+func TestMuxAbandonedGlobalRepliesDontStall(t *testing.T) {
+	defer xtestend(xtestbegin(t))
+
+	halt := NewHalter()
+	defer halt.RequestStop()
+
+	clientMux, serverMux := muxPair(halt)
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	replied := make(chan bool, 3)
+	go func() {
+		for r := range serverMux.incomingRequests {
+			time.Sleep(50 * time.Millisecond)
+			r.Reply(true, nil)
+			replied <- true
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		clientMux.SendRequestTimeout("slow", true, nil, time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		<-replied
+	}
+
+	// with no request waiting on them, the late replies
+	// must not have stopped the read loop.
+	go func() {
+		ch, ok := <-serverMux.incomingChannels
+		if ok {
+			ch.Reject(Prohibited, "no")
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := clientMux.openChannel(ctx, "ch", nil, nil)
+	if _, ok := err.(*OpenChannelError); !ok {
+		t.Errorf("want *OpenChannelError, got %v", err)
+	}
+
+	ok, _, err := clientMux.SendRequestTimeout("fast", true, nil, 5*time.Second)
+	if !ok || err != nil {
+		t.Errorf("SendRequestTimeout(\"fast\"): %v %v", ok, err)
+	}
+}
+
+func TestMuxWaitContext(t *testing.T) {
+	defer xtestend(xtestbegin(t))
+	halt := NewHalter()
+	defer halt.RequestStop()
+
+	clientMux, serverMux := muxPair(halt)
+	defer serverMux.Close()
+	defer clientMux.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clientMux.WaitContext(ctx); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+
+	// still up.
+	go func() {
+		for r := range serverMux.incomingRequests {
+			r.Reply(true, nil)
+		}
+	}()
+	if ok, _, err := clientMux.SendRequest(context.Background(), "ping", true, nil); !ok || err != nil {
+		t.Errorf("SendRequest after WaitContext: %v %v", ok, err)
+	}
+
+	serverMux.conn.Close()
+	if err := clientMux.WaitContext(context.Background()); err != io.EOF {
+		t.Errorf("want io.EOF, got %v", err)
+	}
+}
+
 func TestMuxGlobalRequestUnblock(t *testing.T) {
 	defer xtestend(xtestbegin(t))
 	halt := NewHalter()
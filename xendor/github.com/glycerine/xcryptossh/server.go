@@ -181,6 +181,29 @@ func NewServerConn(ctx context.Context, c net.Conn, config *ServerConfig) (*Serv
 	return &ServerConn{s, perms}, s.mux.incomingChannels, s.mux.incomingRequests, nil
 }
 
+// DisconnectTooManyConnections is the RFC 4253 reason
+// code for turning away a client with too many
+// connections open already.
+const DisconnectTooManyConnections uint32 = 12
+
+// Disconnect sends the client a disconnect message with
+// reason and message, which its Wait returns as the
+// error, then closes the network connection. Unlike
+// Close, Disconnect leaves Config.Halt running, so it
+// can turn away one connection of many sharing a Config.
+func (c *ServerConn) Disconnect(reason uint32, message string) error {
+	conn, ok := c.Conn.(*connection)
+	if !ok {
+		return c.Conn.Close()
+	}
+	err := conn.transport.writePacket(Marshal(&disconnectMsg{
+		Reason:  reason,
+		Message: message,
+	}))
+	conn.sshConn.Close()
+	return err
+}
+
 // signAndMarshal signs the data with the appropriate algorithm,
 // and serializes the result in SSH wire format.
 func signAndMarshal(k Signer, rand io.Reader, data []byte) ([]byte, error) {
@@ -248,7 +271,7 @@ func (s *connection) serverHandshake(ctx context.Context, config *ServerConfig)
 	if err != nil {
 		return nil, err
 	}
-	s.mux = newMux(ctx, s.transport, config.Halt)
+	s.mux = newMux(ctx, s.transport, config.Halt, &config.Config)
 	return perms, err
 }
 
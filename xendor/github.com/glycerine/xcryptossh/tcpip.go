@@ -133,10 +133,65 @@ func (c *Client) ListenTCP(ctx context.Context, laddr *net.TCPAddr) (*tcpListene
 	ch := c.Forwards.add(laddr)
 
 	return &tcpListener{
-		laddr:  laddr,
-		conn:   c,
-		in:     ch,
-		TmpCtx: c.TmpCtx}, nil
+		laddr:    laddr,
+		bindHost: m.addr,
+		bindPort: uint32(laddr.Port),
+		conn:     c,
+		in:       ch,
+		TmpCtx:   c.TmpCtx}, nil
+}
+
+// ListenTCPHost is like ListenTCP, but host is sent
+// to the remote peer as is, rather than resolved
+// locally. So host can be a name like "localhost"
+// that only the peer can resolve, or "" to have
+// the peer listen on all its addresses. If port is
+// 0, the peer picks one.
+func (c *Client) ListenTCPHost(ctx context.Context, host string, port int) (net.Listener, error) {
+	m := channelForwardMsg{
+		host,
+		uint32(port),
+	}
+	ok, resp, err := c.SendRequest(ctx, "tcpip-forward", true, Marshal(&m))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("ssh: tcpip-forward request denied by peer")
+	}
+	if port == 0 {
+		var p struct {
+			Port uint32
+		}
+		if err := Unmarshal(resp, &p); err != nil {
+			return nil, err
+		}
+		port = int(p.Port)
+	}
+
+	laddr := &hostAddr{host: host, port: port}
+	ch := c.Forwards.add(laddr)
+
+	return &tcpListener{
+		laddr:    laddr,
+		bindHost: host,
+		bindPort: uint32(port),
+		conn:     c,
+		in:       ch,
+		TmpCtx:   c.TmpCtx}, nil
+}
+
+// hostAddr is the address of a forward made by
+// ListenTCPHost, whose host may not be an IP.
+type hostAddr struct {
+	host string
+	port int
+}
+
+func (a *hostAddr) Network() string { return "tcp" }
+
+func (a *hostAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.Itoa(a.port))
 }
 
 // forwardList stores a mapping between remote
@@ -221,8 +276,13 @@ func (l *ForwardList) HandleChannels(ctx context.Context, in <-chan NewChannel,
 				// otherwise.
 				laddr, err = parseTCPAddr(payload.Addr, payload.Port)
 				if err != nil {
-					ch.Reject(ConnectionFailed, err.Error())
-					continue
+					if payload.Port == 0 || payload.Port > 65535 {
+						ch.Reject(ConnectionFailed, err.Error())
+						continue
+					}
+					// not an IP; it may still match a
+					// forward made by ListenTCPHost.
+					laddr = &hostAddr{host: payload.Addr, port: int(payload.Port)}
 				}
 				raddr, err = parseTCPAddr(payload.OriginAddr, payload.OriginPort)
 				if err != nil {
@@ -304,7 +364,12 @@ func (l *ForwardList) Forward(ctx context.Context, laddr, raddr net.Addr, ch New
 }
 
 type tcpListener struct {
-	laddr *net.TCPAddr
+	laddr net.Addr
+
+	// bindHost and bindPort are what we asked the
+	// peer to listen on, for cancel-tcpip-forward.
+	bindHost string
+	bindPort uint32
 
 	conn *Client
 	in   <-chan forward
@@ -343,8 +408,8 @@ func (l *tcpListener) Accept() (net.Conn, error) {
 // Close closes the listener.
 func (l *tcpListener) Close() error {
 	m := channelForwardMsg{
-		l.laddr.IP.String(),
-		uint32(l.laddr.Port),
+		l.bindHost,
+		l.bindPort,
 	}
 
 	// this also closes the listener.
@@ -503,18 +568,15 @@ func (t *chanConn) SetDeadline(deadline time.Time) error {
 	return t.SetWriteDeadline(deadline)
 }
 
-// SetReadDeadline sets the read deadline.
+// SetReadDeadline sets the read deadline on the underlying Channel.
 // A zero value for t means Read will not time out.
 // After the deadline, the error from Read will implement net.Error
 // with Timeout() == true.
 func (t *chanConn) SetReadDeadline(deadline time.Time) error {
-	// for compatibility with previous version,
-	// the error message contains "tcpChan"
-	return errors.New("ssh: tcpChan: deadline not supported")
+	return t.Channel.SetReadDeadline(deadline)
 }
 
-// SetWriteDeadline exists to satisfy the net.Conn interface
-// but is not implemented by this type.  It always returns an error.
+// SetWriteDeadline sets the write deadline on the underlying Channel.
 func (t *chanConn) SetWriteDeadline(deadline time.Time) error {
-	return errors.New("ssh: tcpChan: deadline not supported")
+	return t.Channel.SetWriteDeadline(deadline)
 }
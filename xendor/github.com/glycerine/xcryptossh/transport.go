@@ -50,6 +50,11 @@ type transport struct {
 	io.Closer
 
 	config *Config
+
+	// compression streams, used only if
+	// compression is negotiated.
+	readZ  *zlibState
+	writeZ *zlibState
 }
 
 // packetCipher represents a combination of SSH encryption/MAC
@@ -83,6 +88,9 @@ func (t *transport) prepareKeyChange(ctx context.Context, algs *algorithms, kexR
 	if ciph, err := newPacketCipher(t.reader.dir, algs.r, kexResult); err != nil {
 		return err
 	} else {
+		if algs.r.Compression == compressionZlibOpenSSH {
+			ciph = &compressedPacketCipher{packetCipher: ciph, z: t.readZ}
+		}
 		select {
 		case t.reader.pendingKeyChange <- ciph:
 		case <-config.Halt.ReqStopChan():
@@ -95,6 +103,9 @@ func (t *transport) prepareKeyChange(ctx context.Context, algs *algorithms, kexR
 	if ciph, err := newPacketCipher(t.writer.dir, algs.w, kexResult); err != nil {
 		return err
 	} else {
+		if algs.w.Compression == compressionZlibOpenSSH {
+			ciph = &compressedPacketCipher{packetCipher: ciph, z: t.writeZ}
+		}
 		select {
 		case t.writer.pendingKeyChange <- ciph:
 		case <-config.Halt.ReqStopChan():
@@ -134,6 +145,11 @@ func (t *transport) readPacket(ctx context.Context) (p []byte, err error) {
 			break
 		}
 	}
+	if t.isClient && len(p) > 0 && p[0] == msgUserAuthSuccess {
+		// delayed compression starts now, both ways.
+		t.readZ.activate()
+		t.writeZ.activate()
+	}
 	if debugTransport {
 		t.printPacket(p, false)
 	}
@@ -184,6 +200,15 @@ func (t *transport) writePacket(packet []byte) error {
 	if debugTransport {
 		t.printPacket(packet, true)
 	}
+	if !t.isClient && len(packet) > 0 && packet[0] == msgUserAuthSuccess {
+		// delayed compression: the client compresses
+		// everything after our success message, and
+		// so do we.
+		t.readZ.activate()
+		err := t.writer.writePacket(t.bufWriter, t.rand, packet)
+		t.writeZ.activate()
+		return err
+	}
 	return t.writer.writePacket(t.bufWriter, t.rand, packet)
 }
 
@@ -211,6 +236,10 @@ func (s *connectionState) writePacket(w *bufio.Writer, rand io.Reader, packet []
 
 func newTransport(rwc io.ReadWriteCloser, rand io.Reader, isClient bool,
 	config *Config) *transport {
+	level := 0
+	if config != nil {
+		level = config.CompressionLevel
+	}
 	t := &transport{
 		bufReader: bufio.NewReader(rwc),
 		bufWriter: bufio.NewWriter(rwc),
@@ -225,6 +254,8 @@ func newTransport(rwc io.ReadWriteCloser, rand io.Reader, isClient bool,
 		},
 		Closer: rwc,
 		config: config,
+		readZ:  newZlibState(level),
+		writeZ: newZlibState(level),
 	}
 	t.isClient = isClient
 